@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	goutils "github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils"
+	loadgen "github.com/mlarkin00/mslarkin/go-mslarkin-utils/loadgen"
+)
+
+// Supported background load profiles.
+//   - constant: hold TargetCpuPct for as long as the load is enabled.
+//   - sine: oscillate smoothly between 0 and TargetCpuPct once every PeriodS.
+//   - square: alternate between TargetCpuPct and 0 every half PeriodS.
+const (
+	profileConstant = "constant"
+	profileSine     = "sine"
+	profileSquare   = "square"
+)
+
+// bgProfileStep is how often a non-constant profile re-computes its load level.
+const bgProfileStep = 5 * time.Second
+
+// configCollection is the Firestore collection shared with loadgenConfig and
+// requestLoadgen, which holds the load generation configurations.
+const configCollection = "loadgen-configs"
+
+// bgState describes the desired background load. It is the payload of the
+// GET/PUT /admin/background API.
+type bgState struct {
+	// Enabled turns the background load on or off.
+	Enabled bool `json:"enabled"`
+	// TargetCpuPct is the (peak) CPU % to generate.
+	TargetCpuPct float64 `json:"targetCpuPct"`
+	// Profile is the shape of the load over time (constant, sine, square).
+	Profile string `json:"profile"`
+	// PeriodS is the length of one sine/square cycle in seconds.
+	PeriodS int `json:"periodS"`
+	// FollowConfig takes Enabled and TargetCpuPct from the loadgen config store.
+	FollowConfig bool `json:"followConfig"`
+	// ConfigID is the Firestore document ID to follow when FollowConfig is set.
+	ConfigID string `json:"configId,omitempty"`
+}
+
+// bgStateUpdate is a partial update to bgState. Only the fields present in
+// the PUT body are applied.
+type bgStateUpdate struct {
+	Enabled      *bool    `json:"enabled"`
+	TargetCpuPct *float64 `json:"targetCpuPct"`
+	Profile      *string  `json:"profile"`
+	PeriodS      *int     `json:"periodS"`
+	FollowConfig *bool    `json:"followConfig"`
+	ConfigID     *string  `json:"configId"`
+}
+
+// configParams is the subset of the shared loadgen configuration document
+// that drives the background load in follow mode.
+type configParams struct {
+	// TargetCPU is the target CPU utilization percentage.
+	TargetCPU int `firestore:"targetCpu,omitempty"`
+	// Active determines if the configuration is currently enabled.
+	Active bool `firestore:"active"`
+}
+
+// bgLoadController owns the background CPU load and lets it be changed at
+// runtime. All methods are safe for concurrent use.
+type bgLoadController struct {
+	mu     sync.Mutex
+	state  bgState
+	cancel context.CancelFunc
+
+	// Follow mode settings and state.
+	projectID    string
+	firestoreDB  string
+	pollRate     time.Duration
+	followCancel context.CancelFunc
+	// followDone is closed when the current follower has returned.
+	followDone chan struct{}
+	fsClient   *firestore.Client
+}
+
+// bgEnv is the background load configuration read from the environment.
+type bgEnv struct {
+	Enabled      bool    `env:"BG_LOAD"`
+	TargetCpuPct float64 `env:"LOAD_CPU_PCT" default:"25" min:"0" max:"100"`
+	Profile      string  `env:"BG_PROFILE" default:"constant" oneof:"constant sine square"`
+	PeriodS      int     `env:"BG_PERIOD_S" default:"300" min:"1"`
+	FollowConfig bool    `env:"BG_FOLLOW_CONFIG"`
+	ConfigID     string  `env:"BG_CONFIG_ID"`
+	PollRateS    int     `env:"POLL_RATE_S" default:"30" min:"1"`
+	ProjectID    string  `env:"GOOGLE_CLOUD_PROJECT" default:"mslarkin-ext"`
+	FirestoreDB  string  `env:"FIRESTORE_DB" default:"loadgen-target-config"`
+}
+
+// newBgLoadController builds a controller from the BG_* environment variables,
+// failing if any is invalid. The load is not started until apply is called.
+func newBgLoadController() (*bgLoadController, bgState, error) {
+	var env bgEnv
+	if err := goutils.LoadConfig(&env); err != nil {
+		return nil, bgState{}, err
+	}
+
+	initial := bgState{
+		Enabled:      env.Enabled,
+		TargetCpuPct: env.TargetCpuPct,
+		Profile:      env.Profile,
+		PeriodS:      env.PeriodS,
+		FollowConfig: env.FollowConfig,
+		ConfigID:     env.ConfigID,
+	}
+	if err := initial.validate(); err != nil {
+		return nil, bgState{}, err
+	}
+
+	b := &bgLoadController{
+		state:       bgState{Profile: profileConstant, PeriodS: 300},
+		projectID:   env.ProjectID,
+		firestoreDB: env.FirestoreDB,
+		pollRate:    time.Duration(env.PollRateS) * time.Second,
+	}
+	return b, initial, nil
+}
+
+// validate checks that a state can be applied.
+func (s bgState) validate() error {
+	if s.TargetCpuPct < 0 || s.TargetCpuPct > 100 {
+		return fmt.Errorf("targetCpuPct must be between 0 and 100, got %v", s.TargetCpuPct)
+	}
+	switch s.Profile {
+	case profileConstant, profileSine, profileSquare:
+	default:
+		return fmt.Errorf("unknown profile %q (want %s, %s or %s)", s.Profile, profileConstant, profileSine, profileSquare)
+	}
+	if s.Profile != profileConstant && s.PeriodS <= 0 {
+		return fmt.Errorf("periodS must be positive for the %s profile", s.Profile)
+	}
+	if s.FollowConfig && s.ConfigID == "" {
+		return fmt.Errorf("configId is required when followConfig is set")
+	}
+	return nil
+}
+
+// pctAt returns the CPU % the profile calls for at elapsed time t.
+func (s bgState) pctAt(t time.Duration) float64 {
+	period := time.Duration(s.PeriodS) * time.Second
+	switch s.Profile {
+	case profileSine:
+		phase := 2 * math.Pi * float64(t%period) / float64(period)
+		return s.TargetCpuPct * (1 - math.Cos(phase)) / 2
+	case profileSquare:
+		if t%period < period/2 {
+			return s.TargetCpuPct
+		}
+		return 0
+	default:
+		return s.TargetCpuPct
+	}
+}
+
+// merge returns s with the fields present in u applied.
+func (s bgState) merge(u bgStateUpdate) bgState {
+	if u.Enabled != nil {
+		s.Enabled = *u.Enabled
+	}
+	if u.TargetCpuPct != nil {
+		s.TargetCpuPct = *u.TargetCpuPct
+	}
+	if u.Profile != nil {
+		s.Profile = *u.Profile
+	}
+	if u.PeriodS != nil {
+		s.PeriodS = *u.PeriodS
+	}
+	if u.FollowConfig != nil {
+		s.FollowConfig = *u.FollowConfig
+	}
+	if u.ConfigID != nil {
+		s.ConfigID = *u.ConfigID
+	}
+	return s
+}
+
+// State returns a copy of the current background load state.
+func (b *bgLoadController) State() bgState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// apply replaces the background load state, restarting the load generator
+// and the config follower as needed.
+func (b *bgLoadController) apply(s bgState) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.applyLocked(s)
+}
+
+// update applies a partial update on top of the current state, and returns
+// the new state.
+func (b *bgLoadController) update(u bgStateUpdate) (bgState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.state.merge(u)
+	if err := b.applyLocked(s); err != nil {
+		return b.state, err
+	}
+	return s, nil
+}
+
+func (b *bgLoadController) applyLocked(s bgState) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	prev := b.state
+	b.state = s
+
+	// Restart the follower if follow mode was toggled or pointed at another document.
+	if s.FollowConfig != prev.FollowConfig || s.ConfigID != prev.ConfigID || b.followCancel == nil {
+		b.stopFollowLocked()
+		if s.FollowConfig {
+			followCtx, followCancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			b.followCancel = followCancel
+			b.followDone = done
+			go func() {
+				defer close(done)
+				b.follow(followCtx, s.ConfigID)
+			}()
+		}
+	}
+
+	// Only restart the load when the load-shaping fields changed.
+	if b.cancel != nil && s.Enabled == prev.Enabled && s.TargetCpuPct == prev.TargetCpuPct &&
+		s.Profile == prev.Profile && s.PeriodS == prev.PeriodS {
+		return nil
+	}
+	b.stopLoadLocked()
+	if s.Enabled && s.TargetCpuPct > 0 {
+		log.Printf("Starting background CPU loadgen (Pct: %v%%, Profile: %s)", s.TargetCpuPct, s.Profile)
+		loadCtx, loadCtxCancel := context.WithCancel(context.Background())
+		b.cancel = loadCtxCancel
+		go runBgLoad(loadCtx, s)
+	} else {
+		log.Println("Background CPU loadgen stopped")
+	}
+	return nil
+}
+
+// Stop ends the background load and the config follower, and closes the
+// Firestore client once the follower has returned.
+func (b *bgLoadController) Stop() {
+	b.mu.Lock()
+	b.stopLoadLocked()
+	b.stopFollowLocked()
+	done := b.followDone
+	b.mu.Unlock()
+
+	// The follower applies what it reads, which takes the lock.
+	if done != nil {
+		<-done
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fsClient != nil {
+		b.fsClient.Close()
+		b.fsClient = nil
+	}
+}
+
+func (b *bgLoadController) stopLoadLocked() {
+	if b.cancel != nil {
+		b.cancel()
+		b.cancel = nil
+	}
+}
+
+func (b *bgLoadController) stopFollowLocked() {
+	if b.followCancel != nil {
+		b.followCancel()
+		b.followCancel = nil
+	}
+}
+
+// runBgLoad generates load following the profile in s until ctx is cancelled.
+func runBgLoad(ctx context.Context, s bgState) {
+	if s.Profile == profileConstant {
+		loadgen.CpuLoadGen(ctx, s.TargetCpuPct, false)
+		return
+	}
+
+	// Re-evaluate the profile every bgProfileStep, running the load
+	// generator at the computed level for that step.
+	start := time.Now()
+	for ctx.Err() == nil {
+		pct := s.pctAt(time.Since(start))
+		stepCtx, stepCancel := context.WithTimeout(ctx, bgProfileStep)
+		if pct > 0 {
+			loadgen.CpuLoadGen(stepCtx, pct, false)
+		} else {
+			<-stepCtx.Done()
+		}
+		stepCancel()
+	}
+}
+
+// follow polls the loadgen config store and applies the configured level
+// until ctx is cancelled.
+func (b *bgLoadController) follow(ctx context.Context, configID string) {
+	log.Printf("Following loadgen config %s for background load", configID)
+	ticker := time.NewTicker(b.pollRate)
+	defer ticker.Stop()
+
+	for {
+		if err := b.syncConfig(ctx, configID); err != nil && ctx.Err() == nil {
+			log.Printf("Error reading loadgen config %s: %v", configID, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncConfig reads one config document and applies its level to the load.
+func (b *bgLoadController) syncConfig(ctx context.Context, configID string) error {
+	client, err := b.firestoreClient(ctx)
+	if err != nil {
+		return err
+	}
+	doc, err := client.Collection(configCollection).Doc(configID).Get(ctx)
+	if err != nil {
+		return err
+	}
+	var config configParams
+	if err := doc.DataTo(&config); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.state
+
+	// Ignore results from a follower that has been replaced in the meantime.
+	if ctx.Err() != nil || !s.FollowConfig || s.ConfigID != configID {
+		return nil
+	}
+	if s.Enabled == config.Active && s.TargetCpuPct == float64(config.TargetCPU) {
+		return nil
+	}
+	s.Enabled = config.Active
+	s.TargetCpuPct = float64(config.TargetCPU)
+	log.Printf("Loadgen config %s changed (Active: %v, TargetCPU: %v%%)", configID, config.Active, config.TargetCPU)
+	return b.applyLocked(s)
+}
+
+// firestoreClient lazily creates the Firestore client used in follow mode.
+// It dials without holding the lock, so that handlers are not held up.
+func (b *bgLoadController) firestoreClient(ctx context.Context) (*firestore.Client, error) {
+	b.mu.Lock()
+	client := b.fsClient
+	b.mu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := firestore.NewClientWithDatabase(ctx, b.projectID, b.firestoreDB)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// A follower stopped while dialing must not leave a client behind, and
+	// another may have created one in the meantime.
+	if err := ctx.Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if b.fsClient != nil {
+		client.Close()
+		return b.fsClient, nil
+	}
+	b.fsClient = client
+	return client, nil
+}
+
+// requireAdminToken serves next only to requests with an
+// "Authorization: Bearer <token>" header.
+func requireAdminToken(token string, next http.HandlerFunc) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// backgroundHandler serves the /admin/background API, on the admin listener
+// or behind requireAdminToken on the ingress.
+// GET returns the current state; PUT applies a partial update, e.g.
+//
+//	{"enabled": true, "targetCpuPct": 40, "profile": "sine", "periodS": 600}
+func (b *bgLoadController) backgroundHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var update bgStateUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := b.update(update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Only GET and PUT methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.State()); err != nil {
+		log.Printf("Error encoding background state: %v", err)
+	}
+}
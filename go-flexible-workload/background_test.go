@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPctAt(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		t       time.Duration
+		want    float64
+	}{
+		{"constant", profileConstant, 42 * time.Second, 40},
+		{"sine at start", profileSine, 0, 0},
+		{"sine at quarter period", profileSine, 25 * time.Second, 20},
+		{"sine at half period", profileSine, 50 * time.Second, 40},
+		{"sine wraps around", profileSine, 150 * time.Second, 40},
+		{"square first half", profileSquare, 10 * time.Second, 40},
+		{"square second half", profileSquare, 60 * time.Second, 0},
+		{"square wraps around", profileSquare, 110 * time.Second, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := bgState{TargetCpuPct: 40, Profile: tt.profile, PeriodS: 100}
+			if got := s.pctAt(tt.t); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("pctAt(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   bgState
+		wantErr bool
+	}{
+		{"constant", bgState{TargetCpuPct: 50, Profile: profileConstant}, false},
+		{"constant without period", bgState{TargetCpuPct: 50, Profile: profileConstant, PeriodS: 0}, false},
+		{"sine", bgState{TargetCpuPct: 50, Profile: profileSine, PeriodS: 60}, false},
+		{"negative pct", bgState{TargetCpuPct: -1, Profile: profileConstant}, true},
+		{"pct over 100", bgState{TargetCpuPct: 101, Profile: profileConstant}, true},
+		{"unknown profile", bgState{TargetCpuPct: 50, Profile: "sawtooth", PeriodS: 60}, true},
+		{"square without period", bgState{TargetCpuPct: 50, Profile: profileSquare}, true},
+		{"follow without config", bgState{Profile: profileConstant, FollowConfig: true}, true},
+		{"follow", bgState{Profile: profileConstant, FollowConfig: true, ConfigID: "cfg"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.state.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdateMergesPartialState(t *testing.T) {
+	pct := func(v float64) *float64 { return &v }
+	str := func(v string) *string { return &v }
+	period := func(v int) *int { return &v }
+
+	initial := bgState{TargetCpuPct: 25, Profile: profileConstant, PeriodS: 300}
+	tests := []struct {
+		name    string
+		update  bgStateUpdate
+		want    bgState
+		wantErr bool
+	}{
+		{"empty update", bgStateUpdate{}, initial, false},
+		{"target only", bgStateUpdate{TargetCpuPct: pct(40)}, bgState{TargetCpuPct: 40, Profile: profileConstant, PeriodS: 300}, false},
+		{"profile and period", bgStateUpdate{Profile: str(profileSine), PeriodS: period(60)}, bgState{TargetCpuPct: 25, Profile: profileSine, PeriodS: 60}, false},
+		{"config id without follow", bgStateUpdate{ConfigID: str("cfg")}, bgState{TargetCpuPct: 25, Profile: profileConstant, PeriodS: 300, ConfigID: "cfg"}, false},
+		{"invalid update keeps the state", bgStateUpdate{TargetCpuPct: pct(200)}, initial, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Disabled and not following, so nothing is started.
+			b := &bgLoadController{state: initial}
+			defer b.Stop()
+			got, err := b.update(tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || b.State() != tt.want {
+				t.Errorf("update() = %+v, state %+v, want %+v", got, b.State(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	h := requireAdminToken("secret", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	tests := []struct {
+		name   string
+		auth   string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"token without scheme", "secret", http.StatusUnauthorized},
+		{"token", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/background", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestNewBgLoadControllerRejectsInvalidEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"unparsable pct", map[string]string{"LOAD_CPU_PCT": "abc"}},
+		{"pct out of range", map[string]string{"LOAD_CPU_PCT": "150"}},
+		{"negative period", map[string]string{"BG_PERIOD_S": "-5"}},
+		{"unknown profile", map[string]string{"BG_PROFILE": "zigzag"}},
+		{"follow without config id", map[string]string{"BG_FOLLOW_CONFIG": "True"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, _, err := newBgLoadController(); err == nil {
+				t.Error("newBgLoadController() error = nil")
+			}
+		})
+	}
+
+	t.Setenv("LOAD_CPU_PCT", "40")
+	t.Setenv("BG_PROFILE", profileSine)
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	b, initial, err := newBgLoadController()
+	if err != nil {
+		t.Fatalf("newBgLoadController() error = %v", err)
+	}
+	if initial.TargetCpuPct != 40 || initial.Profile != profileSine || initial.PeriodS != 300 || b.pollRate != 30*time.Second {
+		t.Errorf("newBgLoadController() = %+v, poll rate %v", initial, b.pollRate)
+	}
+	if b.projectID != "mslarkin-ext" {
+		t.Errorf("projectID = %q, want the mslarkin-ext fallback", b.projectID)
+	}
+
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "my-project")
+	if b, _, err = newBgLoadController(); err != nil {
+		t.Fatalf("newBgLoadController() error = %v", err)
+	}
+	if b.projectID != "my-project" {
+		t.Errorf("projectID = %q, want my-project", b.projectID)
+	}
+}
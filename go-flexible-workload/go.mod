@@ -3,6 +3,40 @@ module go-flexible-workload
 go 1.21.0
 
 require (
-	github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils v0.0.0-20250624193554-1b66ac6dc36c
-	github.com/mlarkin00/mslarkin/go-mslarkin-utils/loadgen v0.0.0-20250624193554-1b66ac6dc36c
+	cloud.google.com/go/firestore v1.18.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils v0.0.0-20261018223156-33f459b92bf0
+	github.com/mlarkin00/mslarkin/go-mslarkin-utils/loadgen v0.0.0-20261018223212-b4c3773acbb0
+	golang.org/x/net v0.33.0
+	google.golang.org/grpc v1.67.3
+	google.golang.org/protobuf v1.35.2
+)
+
+require (
+	cloud.google.com/go v0.117.0 // indirect
+	cloud.google.com/go/auth v0.13.0 // indirect
+	cloud.google.com/go/auth/oauth2adapt v0.2.6 // indirect
+	cloud.google.com/go/compute/metadata v0.6.0 // indirect
+	cloud.google.com/go/longrunning v0.6.2 // indirect
+	github.com/felixge/httpsnoop v1.0.4 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/s2a-go v0.1.8 // indirect
+	github.com/googleapis/enterprise-certificate-proxy v0.3.4 // indirect
+	github.com/googleapis/gax-go/v2 v2.14.0 // indirect
+	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.54.0 // indirect
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.54.0 // indirect
+	go.opentelemetry.io/otel v1.29.0 // indirect
+	go.opentelemetry.io/otel/metric v1.29.0 // indirect
+	go.opentelemetry.io/otel/trace v1.29.0 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
+	golang.org/x/oauth2 v0.24.0 // indirect
+	golang.org/x/sync v0.10.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/time v0.8.0 // indirect
+	google.golang.org/api v0.214.0 // indirect
+	google.golang.org/genproto v0.0.0-20241118233622-e639e219e697 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20241118233622-e639e219e697 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241209162323-e6fa225c2576 // indirect
 )
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	workloadpb "go-flexible-workload/proto"
+
+	goutils "github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils"
+	loadgen "github.com/mlarkin00/mslarkin/go-mslarkin-utils/loadgen"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// workloadServer implements the Workload service of proto/workload.proto,
+// the gRPC equivalent of the hello and loadgen HTTP handlers.
+type workloadServer struct {
+	workloadpb.UnimplementedWorkloadServer
+}
+
+// Hello returns the same greeting as the /hello handler.
+func (workloadServer) Hello(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.StringValue, error) {
+	return wrapperspb.String("Hello"), nil
+}
+
+// Load generates CPU load like the /loadgen handler, or like /loadgen-async
+// when the async field is set.
+func (workloadServer) Load(ctx context.Context, req *workloadpb.LoadRequest) (*wrapperspb.StringValue, error) {
+	targetCpuPct := 5.0
+	if req.TargetCpuPct != nil {
+		targetCpuPct = req.GetTargetCpuPct()
+	}
+	durationS := 1.0
+	if req.DurationS != nil {
+		durationS = req.GetDurationS()
+	}
+	async := req.GetAsync()
+
+	// The same bounds as the HTTP handlers; Range also rejects NaN, and
+	// +Inf durations that would overflow the timeout.
+	if err := goutils.Range(0.0, 100.0)(targetCpuPct); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "targetCpuPct %v, got %v", err, targetCpuPct)
+	}
+	if err := goutils.Range(1.0, 3600.0)(durationS); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "durationS %v, got %v", err, durationS)
+	}
+
+	log.Println("Starting gRPC Request Load - Pct:", targetCpuPct, " Duration (s):", durationS, " Async:", async)
+
+	duration := time.Duration(durationS * float64(time.Second))
+	if async {
+		// Use background context so async load outlives the RPC
+		loadCtx, loadCtxCancel := context.WithTimeout(context.Background(), duration)
+		go func() {
+			defer loadCtxCancel()
+			loadgen.CpuLoadGen(loadCtx, targetCpuPct, true)
+		}()
+		return wrapperspb.String("Request Load triggered"), nil
+	}
+	// Synchronous load stops when the RPC is cancelled or times out.
+	loadCtx, loadCtxCancel := context.WithTimeout(ctx, duration)
+	defer loadCtxCancel()
+	loadgen.CpuLoadGen(loadCtx, targetCpuPct, true)
+	return wrapperspb.String("Request Load complete"), nil
+}
+
+// newGrpcServer creates a gRPC server with the Workload and grpc.health.v1
+// services registered. Reflection is registered too, so clients such as
+// grpcurl can discover the services.
+func newGrpcServer(opts ...grpc.ServerOption) (*grpc.Server, *health.Server) {
+	grpcServer := grpc.NewServer(opts...)
+	workloadpb.RegisterWorkloadServer(grpcServer, workloadServer{})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(workloadpb.Workload_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	reflection.Register(grpcServer)
+
+	return grpcServer, healthServer
+}
+
+// serveGrpc serves gRPC on a dedicated port.
+func serveGrpc(grpcServer *grpc.Server, port string) {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("Failed to listen on gRPC port %s: %v", port, err)
+	}
+	log.Printf("Serving gRPC on port %s", port)
+	if err := grpcServer.Serve(lis); err != nil {
+		log.Printf("gRPC server stopped: %v", err)
+	}
+}
+
+// grpcMux serves gRPC and HTTP on the same port. gRPC requests are detected
+// by their content type; cleartext HTTP/2 (h2c) is accepted so this works
+// behind Cloud Run with end-to-end HTTP/2 enabled.
+func grpcMux(grpcServer *grpc.Server, next http.Handler) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), &http2.Server{})
+}
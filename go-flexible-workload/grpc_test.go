@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	workloadpb "go-flexible-workload/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// startGrpc serves newGrpcServer over an in-memory listener and returns a
+// client connection to it.
+func startGrpc(t *testing.T, opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	grpcServer, healthServer := newGrpcServer(opts...)
+	go grpcServer.Serve(lis)
+	t.Cleanup(func() {
+		healthServer.Shutdown()
+		grpcServer.Stop()
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGrpcLoadRejectsInvalidArguments(t *testing.T) {
+	conn := startGrpc(t)
+	client := workloadpb.NewWorkloadClient(conn)
+
+	tests := []struct {
+		name string
+		req  *workloadpb.LoadRequest
+	}{
+		{"pct above 100", &workloadpb.LoadRequest{TargetCpuPct: proto.Float64(150)}},
+		{"negative pct", &workloadpb.LoadRequest{TargetCpuPct: proto.Float64(-1)}},
+		{"NaN pct", &workloadpb.LoadRequest{TargetCpuPct: proto.Float64(math.NaN())}},
+		{"zero duration", &workloadpb.LoadRequest{DurationS: proto.Float64(0)}},
+		{"duration above an hour", &workloadpb.LoadRequest{DurationS: proto.Float64(1e12)}},
+		{"infinite duration", &workloadpb.LoadRequest{DurationS: proto.Float64(math.Inf(1))}},
+		{"NaN duration", &workloadpb.LoadRequest{DurationS: proto.Float64(math.NaN())}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Load(context.Background(), tt.req)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Load() error = %v, want InvalidArgument", err)
+			}
+		})
+	}
+}
+
+func TestGrpcLoadAsync(t *testing.T) {
+	conn := startGrpc(t)
+	client := workloadpb.NewWorkloadClient(conn)
+
+	start := time.Now()
+	resp, err := client.Load(context.Background(), &workloadpb.LoadRequest{TargetCpuPct: proto.Float64(1), Async: true})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if resp.GetValue() != "Request Load triggered" || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Load() = %q after %v, want an immediate trigger", resp.GetValue(), time.Since(start))
+	}
+}
+
+func TestGrpcLoadStopsWhenCancelled(t *testing.T) {
+	// Signal when the Load handler returns on the server.
+	handlerDone := make(chan struct{})
+	conn := startGrpc(t, grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		defer close(handlerDone)
+		return handler(ctx, req)
+	}))
+	client := workloadpb.NewWorkloadClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.Load(ctx, &workloadpb.LoadRequest{TargetCpuPct: proto.Float64(1), DurationS: proto.Float64(3600)})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Load() error = %v, want DeadlineExceeded", err)
+	}
+	select {
+	case <-handlerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Load kept running after the RPC was cancelled")
+	}
+}
+
+func TestGrpcHealth(t *testing.T) {
+	conn := startGrpc(t)
+	client := healthpb.NewHealthClient(conn)
+
+	for _, service := range []string{"", workloadpb.Workload_ServiceDesc.ServiceName} {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Check(%q) error = %v", service, err)
+		}
+		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Check(%q) = %v, want SERVING", service, resp.GetStatus())
+		}
+	}
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Check(unknown) error = %v, want NotFound", err)
+	}
+}
@@ -15,9 +15,6 @@ import (
 	loadgen "github.com/mlarkin00/mslarkin/go-mslarkin-utils/loadgen"
 )
 
-// Create channel to listen for signals.
-var signalChan chan (os.Signal) = make(chan os.Signal, 1)
-
 func main() {
 
 	startDelay, _ := strconv.Atoi(goutils.GetEnv("COLD_START_DELAY_S", "0"))
@@ -36,7 +33,8 @@ func main() {
 
 	// SIGINT handles Ctrl+C locally.
 	// SIGTERM handles Cloud Run termination signal.
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	// Set up ingress handlers
 	entrypointMux = http.NewServeMux()
@@ -47,24 +45,69 @@ func main() {
 	entrypointMux.HandleFunc("/startupcheck", startupCheckHandler)
 	entrypointMux.HandleFunc("/healthcheck", healthCheckHandler)
 
-	go http.ListenAndServe(":"+ingressPort, entrypointMux)
+	// Background load is configured from the environment at startup and can
+	// be changed at runtime through the admin API. The ingress serves it when
+	// ADMIN_TOKEN is set, to requests bearing that token. ADMIN_PORT serves it
+	// without a token on a separate listener, for local use or sidecars,
+	// since Cloud Run only routes traffic to PORT.
+	bgLoad, bgInitial, err := newBgLoadController()
+	if err != nil {
+		log.Fatalf("Invalid background load configuration: %v", err)
+	}
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		entrypointMux.Handle("/admin/background", requireAdminToken(adminToken, bgLoad.backgroundHandler))
+	}
+	adminDone := make(chan struct{})
+	if adminPort := os.Getenv("ADMIN_PORT"); adminPort != "" {
+		adminMux := http.NewServeMux()
+		adminMux.HandleFunc("/admin/background", bgLoad.backgroundHandler)
+		log.Printf("Serving the admin API on port %s", adminPort)
+		go func() {
+			defer close(adminDone)
+			opts := goutils.ServeOptions{Addr: ":" + adminPort, ReadinessPath: "-"}
+			if err := goutils.Serve(ctx, adminMux, opts); err != nil {
+				log.Fatalf("Admin API server failed: %v", err)
+			}
+		}()
+	} else {
+		close(adminDone)
+	}
 
-	// Start background load, if configured
-	if goutils.GetEnv("BG_LOAD", "False") == "True" {
-		loadCpuPct, _ := strconv.ParseFloat(goutils.GetEnv("LOAD_CPU_PCT", "25"), 64)
-		// configCpus, _ := strconv.Atoi(goutils.GetEnv("NUM_CPU", "1"))
-
-		if loadCpuPct > 0 {
-			// if configCpus > 0 && loadCpuPct > 0 {
-			log.Printf("Starting background CPU loadgen (Pct: %v%%)", loadCpuPct)
-			loadCtx, loadCtxCancel := context.WithCancel(context.Background())
-			defer loadCtxCancel()
-			go loadgen.CpuLoadGen(loadCtx, loadCpuPct, false)
+	// Long-lived connections for exercising concurrency-based scaling
+	if err := goutils.LoadConfig(&streamDefaults); err != nil {
+		log.Fatalf("Invalid stream configuration: %v", err)
+	}
+	entrypointMux.HandleFunc("/ws", wsHandler)
+	entrypointMux.HandleFunc("/sse", sseHandler)
+
+	// Optionally serve gRPC, either on a dedicated GRPC_PORT or multiplexed
+	// with HTTP on the ingress port (h2c)
+	var ingressHandler http.Handler = entrypointMux
+	if goutils.GetEnv("GRPC", "False") == "True" {
+		grpcServer, healthServer := newGrpcServer()
+		defer grpcServer.Stop()
+		defer healthServer.Shutdown()
+		if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+			go serveGrpc(grpcServer, grpcPort)
+		} else {
+			log.Printf("Serving gRPC on port %s", ingressPort)
+			ingressHandler = grpcMux(grpcServer, entrypointMux)
 		}
 	}
 
-	sig := <-signalChan
-	log.Printf("%s signal caught", sig)
+	// Start background load, if configured
+	if err := bgLoad.apply(bgInitial); err != nil {
+		log.Fatalf("Invalid background load configuration: %v", err)
+	}
+	defer bgLoad.Stop()
+
+	// Serve until SIGINT or SIGTERM, then drain in-flight requests. The
+	// ingress answers /readyz with 503 while it drains.
+	if err := goutils.Serve(ctx, ingressHandler, goutils.ServeOptions{Addr: ":" + ingressPort}); err != nil {
+		log.Fatalf("Ingress server failed: %v", err)
+	}
+	stop()
+	<-adminDone
 }
 
 func helloHandler(w http.ResponseWriter, r *http.Request) {
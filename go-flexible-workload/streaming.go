@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	goutils "github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils"
+)
+
+// openStreams counts the WebSocket and SSE connections currently held open.
+var openStreams atomic.Int64
+
+// upgrader accepts WebSocket connections from any origin, since this is a
+// test workload that is driven by load generators.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamParams controls how a long-lived connection behaves.
+type streamParams struct {
+	// hold is how long the connection is held open.
+	hold time.Duration
+	// interval is how often the server sends a message.
+	interval time.Duration
+	// work is the CPU time spent producing each message.
+	work time.Duration
+}
+
+// streamDefaults are the stream settings used when a request does not set
+// them, loaded from the STREAM_* environment variables at startup.
+var streamDefaults = struct {
+	HoldS      int `env:"STREAM_HOLD_S" default:"60" min:"1" max:"3600"`
+	IntervalMs int `env:"STREAM_INTERVAL_MS" default:"1000" min:"1" max:"60000"`
+	WorkMs     int `env:"STREAM_WORK_MS" default:"10" min:"0" max:"60000"`
+}{HoldS: 60, IntervalMs: 1000, WorkMs: 10}
+
+// getStreamParams reads the stream settings from the request, falling back to
+// streamDefaults. Invalid params are returned as goutils.ParamErrors.
+// Request params
+// holdS - how long to hold the connection open (1-3600)
+// intervalMs - how often to send a message (1-60000)
+// workMs - CPU busy time per message (0-60000)
+func getStreamParams(r *http.Request) (streamParams, error) {
+	var errs goutils.ParamErrors
+	holdS, err := goutils.Param(r, "holdS", streamDefaults.HoldS, goutils.Range(1, 3600))
+	errs.Add(err)
+	intervalMs, err := goutils.Param(r, "intervalMs", streamDefaults.IntervalMs, goutils.Range(1, 60000))
+	errs.Add(err)
+	workMs, err := goutils.Param(r, "workMs", streamDefaults.WorkMs, goutils.Range(0, 60000))
+	errs.Add(err)
+	return streamParams{
+		hold:     time.Duration(holdS) * time.Second,
+		interval: time.Duration(intervalMs) * time.Millisecond,
+		work:     time.Duration(workMs) * time.Millisecond,
+	}, errs.Err()
+}
+
+// busyWork keeps one CPU busy for d.
+func busyWork(d time.Duration) {
+	end := time.Now().Add(d)
+	for time.Now().Before(end) {
+	}
+}
+
+// sseHandler holds a Server-Sent Events stream open, sending one event per
+// interval, each after doing the configured amount of work.
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	p, err := getStreamParams(r)
+	if err != nil {
+		goutils.WriteParamError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	log.Printf("SSE stream opened (hold: %v, interval: %v, work: %v, open: %d)", p.hold, p.interval, p.work, openStreams.Add(1))
+	defer func() { log.Printf("SSE stream closed (open: %d)", openStreams.Add(-1)) }()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	holdTimer := time.NewTimer(p.hold)
+	defer holdTimer.Stop()
+
+	for seq := 1; ; seq++ {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-holdTimer.C:
+			fmt.Fprintf(w, "event: done\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case <-ticker.C:
+			busyWork(p.work)
+			fmt.Fprintf(w, "id: %d\ndata: {\"seq\": %d, \"ts\": %q}\n\n", seq, seq, time.Now().Format(time.RFC3339Nano))
+			flusher.Flush()
+		}
+	}
+}
+
+// wsHandler holds a WebSocket open, sending one message per interval and
+// echoing every client message. Each message costs the configured work.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := getStreamParams(r)
+	if err != nil {
+		goutils.WriteParamError(w, err)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("WebSocket opened (hold: %v, interval: %v, work: %v, open: %d)", p.hold, p.interval, p.work, openStreams.Add(1))
+	defer func() { log.Printf("WebSocket closed (open: %d)", openStreams.Add(-1)) }()
+
+	// Read client messages in the background; gorilla/websocket allows one
+	// concurrent reader and one concurrent writer, so all writes stay in the
+	// loop below.
+	incoming := make(chan []byte)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case incoming <- msg:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	holdTimer := time.NewTimer(p.hold)
+	defer holdTimer.Stop()
+
+	seq := 0
+	for {
+		var out string
+		select {
+		case <-readDone:
+			return
+		case <-holdTimer.C:
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "hold time elapsed"))
+			return
+		case msg := <-incoming:
+			busyWork(p.work)
+			out = "echo: " + string(msg)
+		case <-ticker.C:
+			seq++
+			busyWork(p.work)
+			out = fmt.Sprintf("{\"seq\": %d, \"ts\": %q}", seq, time.Now().Format(time.RFC3339Nano))
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(out)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetStreamParams(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/sse?holdS=5&workMs=0", nil)
+	p, err := getStreamParams(r)
+	if err != nil {
+		t.Fatalf("getStreamParams() error = %v", err)
+	}
+	if p.hold != 5*time.Second || p.interval != time.Second || p.work != 0 {
+		t.Errorf("getStreamParams() = %+v", p)
+	}
+
+	for _, query := range []string{"holdS=abc", "holdS=0", "workMs=-10", "intervalMs=0"} {
+		r := httptest.NewRequest(http.MethodGet, "/sse?"+query, nil)
+		if _, err := getStreamParams(r); err == nil {
+			t.Errorf("getStreamParams(%s) error = nil", query)
+		}
+	}
+}
+
+func TestSSEHandlerRejectsInvalidParams(t *testing.T) {
+	w := httptest.NewRecorder()
+	sseHandler(w, httptest.NewRequest(http.MethodGet, "/sse?holdS=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,202 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	container "cloud.google.com/go/container/apiv1"
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	run "cloud.google.com/go/run/apiv2"
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// Clients lazily creates Google API clients on first use and shares them for
+// the life of the process, so repeated calls reuse the same connections.
+// All methods are safe for concurrent use.
+type Clients struct {
+	opts []option.ClientOption
+
+	runServices  lazyClient[*run.ServicesClient]
+	runRevisions lazyClient[*run.RevisionsClient]
+	runJobs      lazyClient[*run.JobsClient]
+	runExecs     lazyClient[*run.ExecutionsClient]
+	runTasks     lazyClient[*run.TasksClient]
+	monitoring   lazyClient[*monitoring.MetricClient]
+	container    lazyClient[*container.ClusterManagerClient]
+
+	// promQLHTTP is an authorized HTTP client for the PromQL API, which has
+	// no gRPC client library.
+	promQLMu   sync.Mutex
+	promQLHTTP *http.Client
+}
+
+// NewClients returns a Clients that creates its clients with opts.
+func NewClients(opts ...option.ClientOption) *Clients {
+	return &Clients{opts: opts}
+}
+
+// defaultClients backs the package-level helpers.
+var defaultClients = NewClients()
+
+// DefaultClients returns the process-wide Clients used by the package-level helpers.
+func DefaultClients() *Clients {
+	return defaultClients
+}
+
+// lazyClient holds a client that is created on first use.
+type lazyClient[T interface{ Close() error }] struct {
+	mu     sync.Mutex
+	client T
+	ok     bool
+}
+
+// get returns the client, creating it with newFn if needed. A failed
+// creation is not cached.
+func (l *lazyClient[T]) get(ctx context.Context, newFn func(context.Context, ...option.ClientOption) (T, error), opts []option.ClientOption) (T, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.ok {
+		// The client outlives this call, so don't tie it to the caller's cancellation.
+		client, err := newFn(context.WithoutCancel(ctx), opts...)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		l.client, l.ok = client, true
+	}
+	return l.client, nil
+}
+
+// close closes the client if it was created.
+func (l *lazyClient[T]) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.ok {
+		return nil
+	}
+	var zero T
+	err := l.client.Close()
+	l.client, l.ok = zero, false
+	return err
+}
+
+// RunServices returns the shared Cloud Run services client.
+func (c *Clients) RunServices(ctx context.Context) (*run.ServicesClient, error) {
+	return c.runServices.get(ctx, run.NewServicesClient, c.opts)
+}
+
+// RunRevisions returns the shared Cloud Run revisions client.
+func (c *Clients) RunRevisions(ctx context.Context) (*run.RevisionsClient, error) {
+	return c.runRevisions.get(ctx, run.NewRevisionsClient, c.opts)
+}
+
+// RunJobs returns the shared Cloud Run jobs client.
+func (c *Clients) RunJobs(ctx context.Context) (*run.JobsClient, error) {
+	return c.runJobs.get(ctx, run.NewJobsClient, c.opts)
+}
+
+// RunExecutions returns the shared Cloud Run job executions client.
+func (c *Clients) RunExecutions(ctx context.Context) (*run.ExecutionsClient, error) {
+	return c.runExecs.get(ctx, run.NewExecutionsClient, c.opts)
+}
+
+// RunTasks returns the shared Cloud Run job tasks client.
+func (c *Clients) RunTasks(ctx context.Context) (*run.TasksClient, error) {
+	return c.runTasks.get(ctx, run.NewTasksClient, c.opts)
+}
+
+// Monitoring returns the shared Cloud Monitoring metric client.
+func (c *Clients) Monitoring(ctx context.Context) (*monitoring.MetricClient, error) {
+	return c.monitoring.get(ctx, monitoring.NewMetricClient, c.opts)
+}
+
+// Container returns the shared GKE cluster manager client.
+func (c *Clients) Container(ctx context.Context) (*container.ClusterManagerClient, error) {
+	return c.container.get(ctx, container.NewClusterManagerClient, c.opts)
+}
+
+// PromQLHTTP returns the shared HTTP client for Cloud Monitoring's PromQL API.
+func (c *Clients) PromQLHTTP(ctx context.Context) (*http.Client, error) {
+	c.promQLMu.Lock()
+	defer c.promQLMu.Unlock()
+	if c.promQLHTTP == nil {
+		opts := append([]option.ClientOption{option.WithScopes("https://www.googleapis.com/auth/monitoring.read")}, c.opts...)
+		client, _, err := htransport.NewClient(context.WithoutCancel(ctx), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create PromQL client: %w", err)
+		}
+		c.promQLHTTP = client
+	}
+	return c.promQLHTTP, nil
+}
+
+// Close closes every client that has been created. The Clients can be used
+// again afterwards; clients are re-created on demand.
+func (c *Clients) Close() error {
+	c.promQLMu.Lock()
+	if c.promQLHTTP != nil {
+		c.promQLHTTP.CloseIdleConnections()
+		c.promQLHTTP = nil
+	}
+	c.promQLMu.Unlock()
+	return errors.Join(
+		c.runServices.close(),
+		c.runRevisions.close(),
+		c.runJobs.close(),
+		c.runExecs.close(),
+		c.runTasks.close(),
+		c.monitoring.close(),
+		c.container.close(),
+	)
+}
+
+// RunServiceInfo is the commonly used subset of a Cloud Run service.
+type RunServiceInfo struct {
+	// URL is the main URI the service is served at.
+	URL string
+	// LatestRevision is the name of the latest revision that is ready to serve.
+	LatestRevision string
+	// UpdateTime is when the service was last modified, in local time.
+	UpdateTime time.Time
+}
+
+// GetRunService fetches a Cloud Run service.
+func (c *Clients) GetRunService(ctx context.Context, service string, projectId string, region string) (*runpb.Service, error) {
+	client, err := c.RunServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := &runpb.GetServiceRequest{Name: RunServiceName(service, projectId, region)}
+	return retryValue(ctx, "run.GetService", func(ctx context.Context) (*runpb.Service, error) {
+		return client.GetService(ctx, req, noGaxRetry)
+	})
+}
+
+// GetRunServiceInfo fetches the URL, latest revision and update time of a
+// Cloud Run service with a single API call.
+func (c *Clients) GetRunServiceInfo(ctx context.Context, service string, projectId string, region string) (RunServiceInfo, error) {
+	runService, err := c.GetRunService(ctx, service, projectId, region)
+	if err != nil {
+		return RunServiceInfo{}, err
+	}
+	info := RunServiceInfo{
+		URL:        runService.Uri,
+		UpdateTime: runService.UpdateTime.AsTime().Local(),
+	}
+	if runService.LatestReadyRevision != "" {
+		info.LatestRevision = parsePath(runService.LatestReadyRevision)
+	}
+	return info, nil
+}
+
+// GetRunServiceInfo fetches the URL, latest revision and update time of a
+// Cloud Run service with a single API call, using the default clients.
+func GetRunServiceInfo(ctx context.Context, service string, projectId string, region string) (RunServiceInfo, error) {
+	return defaultClients.GetRunServiceInfo(ctx, service, projectId, region)
+}
@@ -0,0 +1,118 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+// fakeClient counts how often it is closed.
+type fakeClient struct{ closed int }
+
+func (f *fakeClient) Close() error {
+	f.closed++
+	return nil
+}
+
+func TestLazyClientSharesClient(t *testing.T) {
+	var l lazyClient[*fakeClient]
+	created := 0
+	newFn := func(ctx context.Context, opts ...option.ClientOption) (*fakeClient, error) {
+		created++
+		return &fakeClient{}, nil
+	}
+
+	// Concurrent callers all get the same client.
+	var wg sync.WaitGroup
+	clients := make([]*fakeClient, 10)
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i], _ = l.get(context.Background(), newFn, nil)
+		}(i)
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Fatalf("created %d clients, want 1", created)
+	}
+	for _, c := range clients {
+		if c != clients[0] {
+			t.Fatal("callers got different clients")
+		}
+	}
+
+	// Close closes the client, and the next get creates a new one.
+	if err := l.close(); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+	if clients[0].closed != 1 {
+		t.Errorf("client closed %d times, want 1", clients[0].closed)
+	}
+	if c, _ := l.get(context.Background(), newFn, nil); c == clients[0] || created != 2 {
+		t.Error("get() after close() did not create a new client")
+	}
+}
+
+func TestLazyClientErrorNotCached(t *testing.T) {
+	var l lazyClient[*fakeClient]
+	fail := true
+	newFn := func(ctx context.Context, opts ...option.ClientOption) (*fakeClient, error) {
+		if fail {
+			return nil, errors.New("dial failed")
+		}
+		return &fakeClient{}, nil
+	}
+
+	if _, err := l.get(context.Background(), newFn, nil); err == nil {
+		t.Fatal("get() error = nil, want dial error")
+	}
+	fail = false
+	if c, err := l.get(context.Background(), newFn, nil); err != nil || c == nil {
+		t.Fatalf("get() = %v, %v after recovery", c, err)
+	}
+}
+
+func TestLazyClientIgnoresCallerCancellation(t *testing.T) {
+	var l lazyClient[*fakeClient]
+	ctx, cancel := context.WithCancel(context.Background())
+	var dialCtx context.Context
+	newFn := func(ctx context.Context, opts ...option.ClientOption) (*fakeClient, error) {
+		dialCtx = ctx
+		return &fakeClient{}, nil
+	}
+	if _, err := l.get(ctx, newFn, nil); err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	cancel()
+	if dialCtx.Err() != nil {
+		t.Error("client context was cancelled with the caller's context")
+	}
+}
+
+func TestClosePromQLHTTP(t *testing.T) {
+	clients := NewClients(option.WithoutAuthentication())
+	ctx := context.Background()
+	first, err := clients.PromQLHTTP(ctx)
+	if err != nil {
+		t.Fatalf("PromQLHTTP() error = %v", err)
+	}
+	if again, _ := clients.PromQLHTTP(ctx); again != first {
+		t.Error("PromQLHTTP() created a second client")
+	}
+
+	// Close drops the client; the next call creates a new one.
+	if err := clients.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	next, err := clients.PromQLHTTP(ctx)
+	if err != nil {
+		t.Fatalf("PromQLHTTP() after Close error = %v", err)
+	}
+	if next == first {
+		t.Error("PromQLHTTP() after Close returned the closed client")
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
-	"regexp"
 	"strings"
 	"time"
 
@@ -16,11 +15,8 @@ import (
 	"golang.org/x/oauth2/google"
 
 	// "google.golang.org/api/pubsub/v1"
-	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	monitoringpb "cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
-	run "cloud.google.com/go/run/apiv2"
 	runpb "cloud.google.com/go/run/apiv2/runpb"
-	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"google.golang.org/api/iterator"
 )
@@ -29,20 +25,33 @@ func Helloworld(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Hello World!\n")
 }
 
+// parsePath returns the last segment of a resource path, or the path itself
+// if it is a bare name such as a revision ID.
 func parsePath(resource_path string) string {
-	r, _ := regexp.Compile(".*/(.*)$")
-	return r.FindStringSubmatch(resource_path)[1]
+	return resource_path[strings.LastIndex(resource_path, "/")+1:]
 }
 
-// GetIDToken fetches an OIDC ID token for the given audience.
+// GetIDToken returns an OIDC ID token for the given audience. Tokens are
+// cached per audience until shortly before they expire; see IDTokenSourceFor.
+func GetIDToken(ctx context.Context, audience string) (string, error) {
+	token, err := IDTokenSourceFor(audience).TokenContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// fetchIDToken fetches a new OIDC ID token for the given audience.
 // It tries to use the metadata server first (when running on GCE/GKE),
 // falling back to ADC (Application Default Credentials) if running locally.
-func GetIDToken(ctx context.Context, audience string) (string, error) {
-	if metadata.OnGCE() {
+func fetchIDToken(ctx context.Context, audience string) (string, error) {
+	if onGCE() {
 		// Use metadata server client
 		c := metadata.NewClient(nil)
 		// Encode audience to be safe
-		token, err := c.GetWithContext(ctx, "instance/service-accounts/default/identity?audience="+url.QueryEscape(audience)+"&format=full")
+		token, err := retryValue(ctx, "metadata.Get", func(ctx context.Context) (string, error) {
+			return c.GetWithContext(ctx, "instance/service-accounts/default/identity?audience="+url.QueryEscape(audience)+"&format=full")
+		})
 		if err != nil {
 			return "", fmt.Errorf("failed to get token from metadata: %w", err)
 		}
@@ -97,9 +106,9 @@ func GetProjectId(ctx context.Context) (string, error) {
 	}
 
 	// Try Metadata
-	if metadata.OnGCE() {
+	if onGCE() {
 		c := metadata.NewClient(nil)
-		pid, err := c.ProjectIDWithContext(ctx)
+		pid, err := retryValue(ctx, "metadata.ProjectID", c.ProjectIDWithContext)
 		if err == nil && pid != "" {
 			return pid, nil
 		}
@@ -114,88 +123,109 @@ func GetProjectId(ctx context.Context) (string, error) {
 	return "", fmt.Errorf("failed to determine project ID")
 }
 
+// GetProjectNumber returns the numeric project ID from the metadata server.
 func GetProjectNumber(ctx context.Context) (string, error) {
-    if !metadata.OnGCE() {
-        return "", fmt.Errorf("not running on GCE/GKE")
-    }
-    c := metadata.NewClient(nil)
-    return c.NumericProjectIDWithContext(ctx)
+	p, err := onGCPPlatform(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.ProjectNumber, nil
 }
 
+// GetRegion returns the region the process is running in, e.g. us-central1.
 func GetRegion(ctx context.Context) (string, error) {
-    if !metadata.OnGCE() {
-        return "", fmt.Errorf("not running on GCE/GKE")
-    }
-    c := metadata.NewClient(nil)
-    // There is no direct Region method, usually it is inferred from Zone or explicit metadata
-    // "instance/region" return the region URL e.g. projects/123/regions/us-central1
-    val, err := c.GetWithContext(ctx, "instance/region")
-    if err != nil {
-        return "", err
-    }
-    return parsePath(val), nil
+	p, err := onGCPPlatform(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.Region, nil
 }
 
+// GetInstanceId returns the ID of the instance the process is running on.
 func GetInstanceId(ctx context.Context) (string, error) {
-     if !metadata.OnGCE() {
-        return "", fmt.Errorf("not running on GCE/GKE")
-    }
-    c := metadata.NewClient(nil)
-    return c.InstanceIDWithContext(ctx)
+	p, err := onGCPPlatform(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.InstanceID, nil
+}
+
+// onGCPPlatform returns the detected platform, or an error when running
+// off-cloud or when the metadata server is unreachable. The serverless
+// platforms are detected from the environment alone, so K_SERVICE or
+// GAE_SERVICE being set does not mean the metadata values were read.
+func onGCPPlatform(ctx context.Context) (Platform, error) {
+	p, err := DetectPlatform(ctx)
+	if err != nil {
+		return Platform{}, err
+	}
+	if !p.OnGCP() {
+		return Platform{}, fmt.Errorf("not running on GCE/GKE")
+	}
+	if !onGCE() {
+		return Platform{}, fmt.Errorf("running on %s but the metadata server is unreachable", p.Kind)
+	}
+	return p, nil
 }
 
+// GetRunServiceId returns the Cloud Run service (or App Engine service) name.
 func GetRunServiceId() string {
-	if os.Getenv("K_SERVICE") == "" {
+	if os.Getenv("K_SERVICE") != "" {
 		return os.Getenv("K_SERVICE")
 	} else {
 		return os.Getenv("GAE_SERVICE")
 	}
 }
 
+// GetRunRevisionId returns the Cloud Run revision (or App Engine version) name.
 func GetRunRevisionId() string {
-	if os.Getenv("K_REVISION") == "" {
+	if os.Getenv("K_REVISION") != "" {
 		return os.Getenv("K_REVISION")
 	} else {
 		return os.Getenv("GAE_VERSION")
 	}
 }
 
+// GetRunService fetches a Cloud Run service using the shared default clients.
 func GetRunService(ctx context.Context, service string, projectId string, region string) (*runpb.Service, error) {
-	c, err := run.NewServicesClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error getting client: %w", err)
-	}
-	defer c.Close()
-
-	serviceId := "projects/" + projectId + "/locations/" + region + "/services/" + service
-
-	req := &runpb.GetServiceRequest{Name: serviceId}
-	return c.GetService(ctx, req)
+	return defaultClients.GetRunService(ctx, service, projectId, region)
 }
 
+// GetRunServiceUrl returns the URL of a Cloud Run service.
+//
+// Deprecated: use GetRunServiceInfo, which returns the URL, latest revision
+// and update time with a single API call.
 func GetRunServiceUrl(ctx context.Context, service string, projectId string, region string) (string, error) {
-	runService, err := GetRunService(ctx, service, projectId, region)
+	info, err := GetRunServiceInfo(ctx, service, projectId, region)
 	if err != nil {
 		return "", err
 	}
-	return runService.Uri, nil
+	return info.URL, nil
 }
 
+// GetRunLatestRevision returns the latest ready revision of a Cloud Run service.
+//
+// Deprecated: use GetRunServiceInfo, which returns the URL, latest revision
+// and update time with a single API call.
 func GetRunLatestRevision(ctx context.Context, service string, projectId string, region string) (string, error) {
-	runService, err := GetRunService(ctx, service, projectId, region)
-    if err != nil {
-        return "", err
-    }
-	return parsePath(runService.LatestReadyRevision), nil
+	info, err := GetRunServiceInfo(ctx, service, projectId, region)
+	if err != nil {
+		return "", err
+	}
+	return info.LatestRevision, nil
 }
 
+// GetLastUpdateTs returns the time a Cloud Run service was last updated, in
+// local time.
+//
+// Deprecated: use GetRunServiceInfo, which returns the URL, latest revision
+// and update time with a single API call.
 func GetLastUpdateTs(ctx context.Context, service string, projectId string, region string) (time.Time, error) {
-	runService, err := GetRunService(ctx, service, projectId, region)
-    if err != nil {
-        return time.Time{}, err
-    }
-	updateTime := runService.UpdateTime.AsTime()
-	return updateTime.Local(), nil
+	info, err := GetRunServiceInfo(ctx, service, projectId, region)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.UpdateTime, nil
 }
 
 func GetMetricInterval(intervalSeconds int) *monitoringpb.TimeInterval {
@@ -228,117 +258,87 @@ func GetRunRevisionFilter(monitoringMetric string, revision string, region strin
 	return metricFilter
 }
 
+// GetMetricMean returns every point of a metric aligned with ALIGN_MEAN and
+// summed across series.
+//
+// Deprecated: use NewMetricQuery, which supports any aligner and reducer
+// and keeps series labels.
 func GetMetricMean(ctx context.Context, monitoringMetric string,
 	resourceFilter string,
 	intervalSeconds int,
 	aggregationSeconds int,
 	groupBy []string,
 	projectId string) ([]*monitoringpb.Point, error) {
-	client, err := monitoring.NewMetricClient(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer client.Close()
-
-	aggregationStruct := &monitoringpb.Aggregation{
-		CrossSeriesReducer: monitoringpb.Aggregation_REDUCE_SUM,
-		PerSeriesAligner:   monitoringpb.Aggregation_ALIGN_MEAN,
-		GroupByFields:      groupBy,
-		AlignmentPeriod: &duration.Duration{
-			Seconds: int64(aggregationSeconds),
-		},
-	}
-
-	req := &monitoringpb.ListTimeSeriesRequest{
-		Name:        "projects/" + projectId,
-		Filter:      resourceFilter,
-		Interval:    GetMetricInterval(intervalSeconds),
-		Aggregation: aggregationStruct,
-	}
-
-	// Get the time series data.
-	it := client.ListTimeSeries(ctx, req)
-	var data []*monitoringpb.Point
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		// Use resp.
-		data = append(data, resp.GetPoints()...)
-	}
-	return data, nil
+	return getMetricPoints(ctx, monitoringpb.Aggregation_ALIGN_MEAN, resourceFilter, intervalSeconds, aggregationSeconds, groupBy, projectId)
 }
 
+// GetMetricRate returns every point of a metric aligned with ALIGN_RATE and
+// summed across series.
+//
+// Deprecated: use NewMetricQuery, which supports any aligner and reducer
+// and keeps series labels.
 func GetMetricRate(ctx context.Context, monitoringMetric string,
 	resourceFilter string,
 	intervalSeconds int,
 	aggregationSeconds int,
 	groupBy []string,
 	projectId string) ([]*monitoringpb.Point, error) {
-	client, err := monitoring.NewMetricClient(ctx)
+	return getMetricPoints(ctx, monitoringpb.Aggregation_ALIGN_RATE, resourceFilter, intervalSeconds, aggregationSeconds, groupBy, projectId)
+}
+
+// getMetricPoints runs a REDUCE_SUM query with a raw filter and flattens the
+// points of every series, as GetMetricMean and GetMetricRate always have.
+func getMetricPoints(ctx context.Context, aligner monitoringpb.Aggregation_Aligner,
+	resourceFilter string,
+	intervalSeconds int,
+	aggregationSeconds int,
+	groupBy []string,
+	projectId string) ([]*monitoringpb.Point, error) {
+	client, err := defaultClients.Monitoring(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer client.Close()
-
-	aggregationStruct := &monitoringpb.Aggregation{
-		CrossSeriesReducer: monitoringpb.Aggregation_REDUCE_SUM,
-		PerSeriesAligner:   monitoringpb.Aggregation_ALIGN_RATE,
-		GroupByFields:      groupBy,
-		AlignmentPeriod: &duration.Duration{
-			Seconds: int64(aggregationSeconds),
-		},
-	}
 
-	req := &monitoringpb.ListTimeSeriesRequest{
-		Name:        "projects/" + projectId,
-		Filter:      resourceFilter,
-		Interval:    GetMetricInterval(intervalSeconds),
-		Aggregation: aggregationStruct,
+	q := &MetricQuery{
+		ProjectID:       projectId,
+		Filters:         []string{resourceFilter},
+		Aligner:         aligner,
+		AlignmentPeriod: time.Duration(aggregationSeconds) * time.Second,
+		Reducer:         monitoringpb.Aggregation_REDUCE_SUM,
+		GroupBy:         groupBy,
+		Lookback:        time.Duration(intervalSeconds) * time.Second,
 	}
 
 	// Get the time series data.
-	it := client.ListTimeSeries(ctx, req)
-	var data []*monitoringpb.Point
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
+	req := q.request(time.Now())
+	return retryValue(ctx, "monitoring.ListTimeSeries", func(ctx context.Context) ([]*monitoringpb.Point, error) {
+		it := client.ListTimeSeries(ctx, req, noGaxRetry)
+		var data []*monitoringpb.Point
+		for {
+			resp, err := it.Next()
+			if err == iterator.Done {
+				return data, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, resp.GetPoints()...)
 		}
-		if err != nil {
-			return nil, err
-		}
-		// Use resp.
-		data = append(data, resp.GetPoints()...)
-	}
-	return data, nil
+	})
 }
 
+// GetRunInstanceCount returns the latest instance count of a Cloud Run service.
 func GetRunInstanceCount(ctx context.Context, service string, projectId string, region string) (int, error) {
-	monitoringMetric := "run.googleapis.com/container/instance_count"
-	aggregationSeconds := 60
-	intervalSeconds := 240
-	groupBy := []string{"resource.labels.service_name"}
-
-	metricFilter := GetRunServiceFilter(monitoringMetric, service, region)
-
-	metricData, err := GetMetricMean(ctx, monitoringMetric,
-		metricFilter,
-		intervalSeconds,
-		aggregationSeconds,
-		groupBy,
-		projectId)
-    if err != nil {
-        return 0, err
-    }
-
-    if len(metricData) == 0 {
-        return 0, nil
-    }
-
-	return int(metricData[0].GetValue().GetDoubleValue()), nil
+	series, err := RunInstanceCount(projectId, service, region).Last(4 * time.Minute).Run(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(series) == 0 {
+		return 0, nil
+	}
+	point, ok := series[0].Latest()
+	if !ok {
+		return 0, nil
+	}
+	return int(point.Value.Float()), nil
 }
@@ -0,0 +1,133 @@
+package gcputils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// idTokenEarlyExpiry is how long before its exp claim a cached ID token is
+// refreshed, so requests never go out with a token that is about to expire.
+const idTokenEarlyExpiry = time.Minute
+
+// IDTokenSource is an oauth2.TokenSource for OIDC ID tokens with a fixed
+// audience. Tokens are cached until shortly before the exp claim in the JWT;
+// concurrent callers share a single refresh.
+type IDTokenSource struct {
+	audience string
+	// fetch gets a new ID token; GetIDToken in production.
+	fetch func(ctx context.Context, audience string) (string, error)
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+var (
+	idTokenSourcesMu sync.Mutex
+	idTokenSources   = map[string]*IDTokenSource{}
+)
+
+// IDTokenSourceFor returns the process-wide ID token source for an audience,
+// so every caller with the same audience shares one cached token.
+func IDTokenSourceFor(audience string) *IDTokenSource {
+	idTokenSourcesMu.Lock()
+	defer idTokenSourcesMu.Unlock()
+	ts, ok := idTokenSources[audience]
+	if !ok {
+		ts = &IDTokenSource{audience: audience, fetch: fetchIDToken}
+		idTokenSources[audience] = ts
+	}
+	return ts
+}
+
+// Token returns a valid ID token, implementing oauth2.TokenSource.
+func (s *IDTokenSource) Token() (*oauth2.Token, error) {
+	return s.TokenContext(context.Background())
+}
+
+// TokenContext returns a valid ID token, fetching a new one if the cached
+// token is missing or about to expire.
+func (s *IDTokenSource) TokenContext(ctx context.Context) (*oauth2.Token, error) {
+	// Holding the lock across the fetch makes concurrent callers wait for
+	// one refresh instead of each fetching their own token.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && time.Now().Add(idTokenEarlyExpiry).Before(s.token.Expiry) {
+		return s.token, nil
+	}
+
+	raw, err := s.fetch(ctx, s.audience)
+	if err != nil {
+		return nil, err
+	}
+	token := &oauth2.Token{AccessToken: raw, TokenType: "Bearer"}
+	if exp, err := jwtExpiry(raw); err == nil {
+		token.Expiry = exp
+		s.token = token
+	} else {
+		// Without an expiry the token can't be cached safely.
+		s.token = nil
+	}
+	return token, nil
+}
+
+// jwtExpiry reads the exp claim of a JWT without verifying its signature.
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("malformed JWT: %d segments", len(parts))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed JWT payload: %w", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("malformed JWT claims: %w", err)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("JWT has no exp claim")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// IDTokenTransport is an http.RoundTripper that adds a cached OIDC ID token
+// to every request.
+type IDTokenTransport struct {
+	Source *IDTokenSource
+	// Base is the transport used to send the request; http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+// NewIDTokenTransport returns a transport that authenticates requests with
+// ID tokens for audience, sending them through base.
+func NewIDTokenTransport(audience string, base http.RoundTripper) *IDTokenTransport {
+	return &IDTokenTransport{Source: IDTokenSourceFor(audience), Base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *IDTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Source.TokenContext(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ID token: %w", err)
+	}
+
+	req2 := req.Clone(req.Context())
+	token.SetAuthHeader(req2)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req2)
+}
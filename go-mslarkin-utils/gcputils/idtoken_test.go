@@ -0,0 +1,182 @@
+package gcputils
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makeJWT builds an unsigned JWT with the given expiry.
+func makeJWT(exp time.Time) string {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	payload := enc.EncodeToString([]byte(fmt.Sprintf(`{"aud":"test","exp":%d}`, exp.Unix())))
+	return header + "." + payload + ".sig"
+}
+
+// resetIDTokenSources empties the process-wide ID token source cache, so a
+// test does not see tokens cached by another test or an earlier run.
+func resetIDTokenSources(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		idTokenSourcesMu.Lock()
+		idTokenSources = map[string]*IDTokenSource{}
+		idTokenSourcesMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// countingSource returns an IDTokenSource whose fetches are counted.
+func countingSource(exp time.Duration) (*IDTokenSource, *atomic.Int64) {
+	var fetches atomic.Int64
+	ts := &IDTokenSource{
+		audience: "test",
+		fetch: func(ctx context.Context, audience string) (string, error) {
+			fetches.Add(1)
+			return makeJWT(time.Now().Add(exp)), nil
+		},
+	}
+	return ts, &fetches
+}
+
+func TestIDTokenSourceCaches(t *testing.T) {
+	ts, fetches := countingSource(time.Hour)
+
+	first, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	second, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("fetched %d tokens, want 1", fetches.Load())
+	}
+	if first.AccessToken != second.AccessToken {
+		t.Error("cached token changed")
+	}
+	if d := time.Until(first.Expiry); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Expiry in %v, want ~1h from the exp claim", d)
+	}
+}
+
+func TestIDTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	// Tokens that expire within idTokenEarlyExpiry are refreshed every time.
+	ts, fetches := countingSource(idTokenEarlyExpiry / 2)
+	for i := 0; i < 3; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+	}
+	if fetches.Load() != 3 {
+		t.Errorf("fetched %d tokens, want 3", fetches.Load())
+	}
+}
+
+func TestIDTokenSourceSingleRefresh(t *testing.T) {
+	ts, fetches := countingSource(time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ts.Token(); err != nil {
+				t.Errorf("Token() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches.Load() != 1 {
+		t.Errorf("fetched %d tokens concurrently, want 1", fetches.Load())
+	}
+}
+
+func TestIDTokenSourceErrors(t *testing.T) {
+	ts := &IDTokenSource{
+		audience: "test",
+		fetch: func(ctx context.Context, audience string) (string, error) {
+			return "", errors.New("metadata unavailable")
+		},
+	}
+	if _, err := ts.Token(); err == nil {
+		t.Error("Token() error = nil, want fetch error")
+	}
+
+	// Tokens without a readable exp claim are returned but not cached.
+	var fetches int
+	ts.fetch = func(ctx context.Context, audience string) (string, error) {
+		fetches++
+		return "opaque-token", nil
+	}
+	ts.Token()
+	tok, err := ts.Token()
+	if err != nil || tok.AccessToken != "opaque-token" {
+		t.Fatalf("Token() = %v, %v", tok, err)
+	}
+	if fetches != 2 {
+		t.Errorf("fetched %d opaque tokens, want 2", fetches)
+	}
+}
+
+func TestIDTokenSourceForSharesByAudience(t *testing.T) {
+	resetIDTokenSources(t)
+	if IDTokenSourceFor("a") != IDTokenSourceFor("a") {
+		t.Error("same audience returned different sources")
+	}
+	if IDTokenSourceFor("a") == IDTokenSourceFor("b") {
+		t.Error("different audiences returned the same source")
+	}
+}
+
+func TestIDTokenTransport(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	ts, fetches := countingSource(time.Hour)
+	client := &http.Client{Transport: &IDTokenTransport{Source: ts}}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+	tok, _ := ts.Token()
+	if gotAuth != "Bearer "+tok.AccessToken {
+		t.Errorf("Authorization = %q, want the cached token", gotAuth)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("fetched %d tokens for 3 requests, want 1", fetches.Load())
+	}
+}
+
+func TestGetIDTokenOnGCE(t *testing.T) {
+	resetIDTokenSources(t)
+	md := fakeMetadata(t, nil)
+	audience := "https://gce-path.example.com"
+
+	for i := 0; i < 2; i++ {
+		token, err := GetIDToken(context.Background(), audience)
+		if err != nil {
+			t.Fatalf("GetIDToken() error = %v", err)
+		}
+		if exp, err := jwtExpiry(token); err != nil || time.Until(exp) < 59*time.Minute {
+			t.Errorf("token expiry = %v, %v; want ~1h", exp, err)
+		}
+	}
+	if got := md.Requests("instance/service-accounts/default/identity"); got != 1 {
+		t.Errorf("identity requests = %d, want 1 (cached)", got)
+	}
+}
@@ -0,0 +1,266 @@
+// Package metadatatest provides an in-process fake of the GCE metadata
+// server, so code that depends on cloud.google.com/go/compute/metadata (and
+// gcputils) can be tested off-cloud.
+//
+// Start the server in a test and every metadata client in the process talks
+// to it, because GCE_METADATA_HOST is pointed at it:
+//
+//	srv := metadatatest.Start(t, metadatatest.Config{ProjectID: "my-project"})
+//	platform, err := gcputils.DetectPlatform(ctx)
+package metadatatest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MetadataHostEnv is the environment variable metadata clients read the
+// metadata server address from.
+const MetadataHostEnv = "GCE_METADATA_HOST"
+
+// Config is the metadata served by a Server. Empty fields get the defaults
+// below; set Region only for serverless platforms, as GCE does not serve it.
+type Config struct {
+	ProjectID     string // default "test-project"
+	ProjectNumber string // default "123456789012"
+	Zone          string // default "us-central1-a"
+	Region        string // served as instance/region when set
+	InstanceID    string // default "1234567890123456789"
+	InstanceName  string // default "test-instance"
+
+	// ServiceAccountEmail is the default service account; default
+	// "default@<ProjectID>.iam.gserviceaccount.com".
+	ServiceAccountEmail string
+	// AccessToken is returned by the token endpoint; default "test-access-token".
+	AccessToken string
+	// IdentityToken returns the ID token for an audience. By default it
+	// returns an unsigned JWT for the audience that expires in one hour.
+	IdentityToken func(audience string) string
+
+	// Attributes are the instance attributes, e.g. cluster-name on GKE.
+	Attributes map[string]string
+}
+
+// Server is a fake metadata server.
+type Server struct {
+	// Host is the host:port of the server, as set in GCE_METADATA_HOST.
+	Host string
+
+	srv *httptest.Server
+	cfg Config
+
+	mu        sync.Mutex
+	overrides map[string]*string
+	requests  map[string]int
+}
+
+// NewServer starts a fake metadata server. It does not set
+// GCE_METADATA_HOST; use Start in tests, or set it from Host.
+func NewServer(cfg Config) *Server {
+	cfg.setDefaults()
+	s := &Server{
+		cfg:       cfg,
+		overrides: map[string]*string{},
+		requests:  map[string]int{},
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.Host = strings.TrimPrefix(s.srv.URL, "http://")
+	return s
+}
+
+// Start starts a fake metadata server for the duration of a test and points
+// GCE_METADATA_HOST at it.
+func Start(t testing.TB, cfg Config) *Server {
+	t.Helper()
+	s := NewServer(cfg)
+	t.Cleanup(s.Close)
+	t.Setenv(MetadataHostEnv, s.Host)
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Set overrides the value served for a metadata path (relative to
+// /computeMetadata/v1/, e.g. "instance/attributes/cluster-name").
+func (s *Server) Set(path string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[path] = &value
+}
+
+// Unset makes a metadata path return 404, as undefined values do.
+func (s *Server) Unset(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[path] = nil
+}
+
+// Requests returns how many times a metadata path has been requested.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (c *Config) setDefaults() {
+	if c.ProjectID == "" {
+		c.ProjectID = "test-project"
+	}
+	if c.ProjectNumber == "" {
+		c.ProjectNumber = "123456789012"
+	}
+	if c.Zone == "" {
+		c.Zone = "us-central1-a"
+	}
+	if c.InstanceID == "" {
+		c.InstanceID = "1234567890123456789"
+	}
+	if c.InstanceName == "" {
+		c.InstanceName = "test-instance"
+	}
+	if c.ServiceAccountEmail == "" {
+		c.ServiceAccountEmail = "default@" + c.ProjectID + ".iam.gserviceaccount.com"
+	}
+	if c.AccessToken == "" {
+		c.AccessToken = "test-access-token"
+	}
+	if c.IdentityToken == nil {
+		email := c.ServiceAccountEmail
+		c.IdentityToken = func(audience string) string {
+			return FakeIDToken(audience, email, time.Now().Add(time.Hour))
+		}
+	}
+}
+
+// FakeIDToken builds an unsigned JWT with the claims of a Google-issued ID token.
+func FakeIDToken(audience string, email string, exp time.Time) string {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   "https://accounts.google.com",
+		"aud":   audience,
+		"azp":   email,
+		"email": email,
+		"sub":   "1234567890",
+		"iat":   time.Now().Unix(),
+		"exp":   exp.Unix(),
+	})
+	return enc.EncodeToString(header) + "." + enc.EncodeToString(claims) + ".fake-signature"
+}
+
+// serveHTTP implements the metadata API, including its header checks.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Metadata-Flavor", "Google")
+	if r.URL.Path == "/" {
+		// Root probe used by metadata.OnGCE.
+		return
+	}
+	if r.Header.Get("Metadata-Flavor") != "Google" {
+		http.Error(w, "Missing Metadata-Flavor:Google header", http.StatusForbidden)
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/computeMetadata/v1/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[path]++
+	override, overridden := s.overrides[path]
+	s.mu.Unlock()
+
+	if overridden {
+		if override == nil {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, *override)
+		return
+	}
+
+	value, ok := s.lookup(path, r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if strings.HasSuffix(path, "/token") {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	fmt.Fprint(w, value)
+}
+
+// lookup returns the configured value for a metadata path.
+func (s *Server) lookup(path string, r *http.Request) (string, bool) {
+	cfg := s.cfg
+	switch path {
+	case "project/project-id":
+		return cfg.ProjectID, true
+	case "project/numeric-project-id":
+		return cfg.ProjectNumber, true
+	case "instance/id":
+		return cfg.InstanceID, true
+	case "instance/name":
+		return cfg.InstanceName, true
+	case "instance/hostname":
+		return cfg.InstanceName + "." + cfg.Zone + ".c." + cfg.ProjectID + ".internal", true
+	case "instance/zone":
+		return "projects/" + cfg.ProjectNumber + "/zones/" + cfg.Zone, true
+	case "instance/region":
+		if cfg.Region == "" {
+			return "", false
+		}
+		return "projects/" + cfg.ProjectNumber + "/regions/" + cfg.Region, true
+	case "instance/attributes/":
+		keys := make([]string, 0, len(cfg.Attributes))
+		for k := range cfg.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, "\n"), true
+	case "instance/service-accounts/":
+		return "default/\n" + cfg.ServiceAccountEmail + "/\n", true
+	}
+
+	if attr, ok := strings.CutPrefix(path, "instance/attributes/"); ok {
+		v, ok := cfg.Attributes[attr]
+		return v, ok
+	}
+	if rest, ok := strings.CutPrefix(path, "instance/service-accounts/"); ok {
+		account, endpoint, _ := strings.Cut(rest, "/")
+		if account != "default" && account != cfg.ServiceAccountEmail {
+			return "", false
+		}
+		switch endpoint {
+		case "email":
+			return cfg.ServiceAccountEmail, true
+		case "scopes":
+			return "https://www.googleapis.com/auth/cloud-platform", true
+		case "token":
+			token, _ := json.Marshal(map[string]any{
+				"access_token": cfg.AccessToken,
+				"expires_in":   3599,
+				"token_type":   "Bearer",
+			})
+			return string(token), true
+		case "identity":
+			audience := r.URL.Query().Get("audience")
+			if audience == "" {
+				return "", false
+			}
+			return cfg.IdentityToken(audience), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,99 @@
+package metadatatest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+func TestServerDefaults(t *testing.T) {
+	srv := Start(t, Config{Region: "us-central1", Attributes: map[string]string{"cluster-name": "demo"}})
+	ctx := context.Background()
+	c := metadata.NewClient(nil)
+
+	tests := map[string]string{
+		"project/project-id":                      "test-project",
+		"project/numeric-project-id":              "123456789012",
+		"instance/id":                             "1234567890123456789",
+		"instance/zone":                           "projects/123456789012/zones/us-central1-a",
+		"instance/region":                         "projects/123456789012/regions/us-central1",
+		"instance/attributes/cluster-name":        "demo",
+		"instance/service-accounts/default/email": "default@test-project.iam.gserviceaccount.com",
+	}
+	for path, want := range tests {
+		got, err := c.GetWithContext(ctx, path)
+		if err != nil || got != want {
+			t.Errorf("Get(%q) = %q, %v; want %q", path, got, err, want)
+		}
+	}
+	if srv.Requests("project/project-id") != 1 {
+		t.Errorf("Requests(project-id) = %d, want 1", srv.Requests("project/project-id"))
+	}
+
+	// Undefined values are 404s, which the client reports as NotDefinedError.
+	var notDefined metadata.NotDefinedError
+	if _, err := c.GetWithContext(ctx, "instance/attributes/missing"); !errors.As(err, &notDefined) {
+		t.Errorf("Get(missing) error = %v, want NotDefinedError", err)
+	}
+}
+
+func TestServerIdentityToken(t *testing.T) {
+	Start(t, Config{ProjectID: "p"})
+	token, err := metadata.NewClient(nil).GetWithContext(context.Background(),
+		"instance/service-accounts/default/identity?audience=https://svc.example.com&format=full")
+	if err != nil {
+		t.Fatalf("identity error = %v", err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d segments, want 3", len(parts))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	var claims struct {
+		Aud   string `json:"aud"`
+		Email string `json:"email"`
+		Exp   int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("decoding claims: %v", err)
+	}
+	if claims.Aud != "https://svc.example.com" || claims.Email != "default@p.iam.gserviceaccount.com" || claims.Exp == 0 {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestServerOverrides(t *testing.T) {
+	srv := Start(t, Config{})
+	c := metadata.NewClient(nil)
+	ctx := context.Background()
+
+	srv.Set("instance/attributes/cluster-name", "late")
+	if got, _ := c.GetWithContext(ctx, "instance/attributes/cluster-name"); got != "late" {
+		t.Errorf("overridden value = %q, want late", got)
+	}
+	srv.Unset("project/project-id")
+	if _, err := c.GetWithContext(ctx, "project/project-id"); err == nil {
+		t.Error("unset value returned no error")
+	}
+}
+
+func TestServerRequiresFlavorHeader(t *testing.T) {
+	srv := Start(t, Config{})
+	resp, err := http.Get("http://" + srv.Host + "/computeMetadata/v1/project/project-id")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d without Metadata-Flavor, want 403", resp.StatusCode)
+	}
+}
@@ -0,0 +1,679 @@
+package gcputils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	monitoringpb "cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	distributionpb "google.golang.org/genproto/googleapis/api/distribution"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MetricQuery describes a Cloud Monitoring time series query. Build one with
+// NewMetricQuery and the chainable setters, then call Run:
+//
+//	series, err := gcputils.NewMetricQuery(projectId, "run.googleapis.com/request_count").
+//		WithResourceLabel("service_name", service).
+//		Align(monitoringpb.Aggregation_ALIGN_RATE, time.Minute).
+//		Reduce(monitoringpb.Aggregation_REDUCE_SUM, "resource.labels.service_name").
+//		Last(5 * time.Minute).
+//		Run(ctx)
+type MetricQuery struct {
+	ProjectID    string
+	MetricType   string
+	ResourceType string
+	// ResourceLabels and MetricLabels are matched exactly.
+	ResourceLabels map[string]string
+	MetricLabels   map[string]string
+	// Filters are extra raw filter clauses, ANDed with the rest.
+	Filters []string
+
+	Aligner         monitoringpb.Aggregation_Aligner
+	AlignmentPeriod time.Duration
+	Reducer         monitoringpb.Aggregation_Reducer
+	GroupBy         []string
+
+	// Lookback is used when Start/End are not set: the query covers the
+	// Lookback before the time it is run.
+	Lookback   time.Duration
+	Start, End time.Time
+
+	// PageSize limits the series returned per page by RunPage
+	// (default defaultMetricPageSize).
+	PageSize int
+
+	// PromQL, when set, runs the query through Cloud Monitoring's PromQL API
+	// instead; only ProjectID, the interval and AlignmentPeriod (as the
+	// step) are used.
+	PromQL string
+}
+
+// defaultMetricPageSize is the RunPage page size when none is set.
+const defaultMetricPageSize = 100
+
+// NewMetricQuery starts a query for one metric type over the last 5 minutes.
+func NewMetricQuery(projectId string, metricType string) *MetricQuery {
+	return &MetricQuery{
+		ProjectID:  projectId,
+		MetricType: metricType,
+		Lookback:   5 * time.Minute,
+	}
+}
+
+// NewPromQLQuery starts a PromQL query over the last 5 minutes, with one
+// point per minute.
+func NewPromQLQuery(projectId string, promql string) *MetricQuery {
+	return &MetricQuery{
+		ProjectID:       projectId,
+		PromQL:          promql,
+		Lookback:        5 * time.Minute,
+		AlignmentPeriod: time.Minute,
+	}
+}
+
+// WithResourceType restricts the query to one monitored resource type.
+func (q *MetricQuery) WithResourceType(resourceType string) *MetricQuery {
+	q.ResourceType = resourceType
+	return q
+}
+
+// WithResourceLabel adds an exact match on a resource label.
+func (q *MetricQuery) WithResourceLabel(key, value string) *MetricQuery {
+	if q.ResourceLabels == nil {
+		q.ResourceLabels = map[string]string{}
+	}
+	q.ResourceLabels[key] = value
+	return q
+}
+
+// WithMetricLabel adds an exact match on a metric label.
+func (q *MetricQuery) WithMetricLabel(key, value string) *MetricQuery {
+	if q.MetricLabels == nil {
+		q.MetricLabels = map[string]string{}
+	}
+	q.MetricLabels[key] = value
+	return q
+}
+
+// WithFilter adds a raw filter clause, e.g. `resource.labels.location = starts_with("us-")`.
+func (q *MetricQuery) WithFilter(filter string) *MetricQuery {
+	q.Filters = append(q.Filters, filter)
+	return q
+}
+
+// Align sets the per-series aligner and alignment period.
+func (q *MetricQuery) Align(aligner monitoringpb.Aggregation_Aligner, period time.Duration) *MetricQuery {
+	q.Aligner = aligner
+	q.AlignmentPeriod = period
+	return q
+}
+
+// Reduce sets the cross-series reducer and the fields to group by.
+func (q *MetricQuery) Reduce(reducer monitoringpb.Aggregation_Reducer, groupBy ...string) *MetricQuery {
+	q.Reducer = reducer
+	q.GroupBy = groupBy
+	return q
+}
+
+// Last makes the query cover the given duration before it is run.
+func (q *MetricQuery) Last(d time.Duration) *MetricQuery {
+	q.Lookback = d
+	q.Start, q.End = time.Time{}, time.Time{}
+	return q
+}
+
+// Between makes the query cover a fixed interval.
+func (q *MetricQuery) Between(start, end time.Time) *MetricQuery {
+	q.Start, q.End = start, end
+	return q
+}
+
+// WithPageSize sets the number of series returned per page by RunPage.
+func (q *MetricQuery) WithPageSize(n int) *MetricQuery {
+	q.PageSize = n
+	return q
+}
+
+// Filter returns the Cloud Monitoring filter string for the query. The
+// metric type clause is omitted when MetricType is empty, for callers that
+// pass a complete filter through Filters.
+func (q *MetricQuery) Filter() string {
+	var clauses []string
+	if q.MetricType != "" {
+		clauses = append(clauses, fmt.Sprintf("metric.type = %q", q.MetricType))
+	}
+	if q.ResourceType != "" {
+		clauses = append(clauses, fmt.Sprintf("resource.type = %q", q.ResourceType))
+	}
+	clauses = append(clauses, labelClauses("resource.labels", q.ResourceLabels)...)
+	clauses = append(clauses, labelClauses("metric.labels", q.MetricLabels)...)
+	clauses = append(clauses, q.Filters...)
+	return strings.Join(clauses, " AND ")
+}
+
+// labelClauses renders exact label matches in a stable order.
+func labelClauses(prefix string, labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	clauses := make([]string, 0, len(keys))
+	for _, k := range keys {
+		clauses = append(clauses, fmt.Sprintf("%s.%s = %q", prefix, k, labels[k]))
+	}
+	return clauses
+}
+
+// interval resolves the query interval at time now.
+func (q *MetricQuery) interval(now time.Time) (time.Time, time.Time) {
+	if !q.Start.IsZero() {
+		end := q.End
+		if end.IsZero() {
+			end = now
+		}
+		return q.Start, end
+	}
+	return now.Add(-q.Lookback), now
+}
+
+// request builds the ListTimeSeries request for the query.
+func (q *MetricQuery) request(now time.Time) *monitoringpb.ListTimeSeriesRequest {
+	start, end := q.interval(now)
+	req := &monitoringpb.ListTimeSeriesRequest{
+		Name:   "projects/" + q.ProjectID,
+		Filter: q.Filter(),
+		Interval: &monitoringpb.TimeInterval{
+			StartTime: timestamppb.New(start),
+			EndTime:   timestamppb.New(end),
+		},
+		View:     monitoringpb.ListTimeSeriesRequest_FULL,
+		PageSize: int32(q.PageSize),
+	}
+	if q.Aligner != monitoringpb.Aggregation_ALIGN_NONE || q.Reducer != monitoringpb.Aggregation_REDUCE_NONE {
+		req.Aggregation = &monitoringpb.Aggregation{
+			PerSeriesAligner:   q.Aligner,
+			CrossSeriesReducer: q.Reducer,
+			GroupByFields:      q.GroupBy,
+		}
+		if q.AlignmentPeriod > 0 {
+			req.Aggregation.AlignmentPeriod = durationpb.New(q.AlignmentPeriod)
+		}
+	}
+	return req
+}
+
+// Run executes the query with the default clients, returning every page.
+func (q *MetricQuery) Run(ctx context.Context) ([]TimeSeries, error) {
+	return defaultClients.QueryTimeSeries(ctx, q)
+}
+
+// RunPage executes the query with the default clients, returning one page
+// of results and the token for the next page ("" on the last page).
+func (q *MetricQuery) RunPage(ctx context.Context, pageToken string) ([]TimeSeries, string, error) {
+	return defaultClients.QueryTimeSeriesPage(ctx, q, pageToken)
+}
+
+// QueryTimeSeries executes a query, returning every page.
+func (c *Clients) QueryTimeSeries(ctx context.Context, q *MetricQuery) ([]TimeSeries, error) {
+	if q.PromQL != "" {
+		return c.queryPromQL(ctx, q)
+	}
+	client, err := c.Monitoring(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req := q.request(time.Now())
+	// A failed page restarts the listing, so a retry never returns duplicates.
+	return retryValue(ctx, "monitoring.ListTimeSeries", func(ctx context.Context) ([]TimeSeries, error) {
+		it := client.ListTimeSeries(ctx, req, noGaxRetry)
+		var series []TimeSeries
+		for {
+			resp, err := it.Next()
+			if err == iterator.Done {
+				return series, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			series = append(series, newTimeSeries(resp))
+		}
+	})
+}
+
+// QueryTimeSeriesPage executes a query, returning one page of results and
+// the token for the next page ("" on the last page). PromQL queries are not
+// paginated and always return everything.
+func (c *Clients) QueryTimeSeriesPage(ctx context.Context, q *MetricQuery, pageToken string) ([]TimeSeries, string, error) {
+	if q.PromQL != "" {
+		series, err := c.queryPromQL(ctx, q)
+		return series, "", err
+	}
+	client, err := c.Monitoring(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pageSize := q.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultMetricPageSize
+	}
+	req := q.request(time.Now())
+
+	var page []*monitoringpb.TimeSeries
+	nextToken, err := retryValue(ctx, "monitoring.ListTimeSeries", func(ctx context.Context) (string, error) {
+		page = nil
+		it := client.ListTimeSeries(ctx, req, noGaxRetry)
+		return iterator.NewPager(it, pageSize, pageToken).NextPage(&page)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	series := make([]TimeSeries, 0, len(page))
+	for _, ts := range page {
+		series = append(series, newTimeSeries(ts))
+	}
+	return series, nextToken, nil
+}
+
+// TimeSeries is one labeled series of points returned by a query.
+type TimeSeries struct {
+	MetricType     string            `json:"metricType"`
+	MetricLabels   map[string]string `json:"metricLabels,omitempty"`
+	ResourceType   string            `json:"resourceType,omitempty"`
+	ResourceLabels map[string]string `json:"resourceLabels,omitempty"`
+	// Points are ordered newest first, as returned by Cloud Monitoring.
+	Points []Point `json:"points"`
+}
+
+// Latest returns the newest point in the series.
+func (ts TimeSeries) Latest() (Point, bool) {
+	if len(ts.Points) == 0 {
+		return Point{}, false
+	}
+	return ts.Points[0], true
+}
+
+// Point is one value of a time series.
+type Point struct {
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end"`
+	Value Value     `json:"value"`
+}
+
+// ValueKind identifies which field of a Value is set.
+type ValueKind string
+
+const (
+	ValueInt64        ValueKind = "int64"
+	ValueDouble       ValueKind = "double"
+	ValueDistribution ValueKind = "distribution"
+	ValueBool         ValueKind = "bool"
+	ValueString       ValueKind = "string"
+)
+
+// Value is a typed metric value.
+type Value struct {
+	Kind         ValueKind     `json:"kind"`
+	Int64        int64         `json:"int64,omitempty"`
+	Double       float64       `json:"double,omitempty"`
+	Distribution *Distribution `json:"distribution,omitempty"`
+	Bool         bool          `json:"bool,omitempty"`
+	String       string        `json:"string,omitempty"`
+}
+
+// Float returns a numeric view of the value: the number itself, the mean of
+// a distribution, or 1/0 for a bool.
+func (v Value) Float() float64 {
+	switch v.Kind {
+	case ValueInt64:
+		return float64(v.Int64)
+	case ValueDouble:
+		return v.Double
+	case ValueDistribution:
+		return v.Distribution.Mean
+	case ValueBool:
+		if v.Bool {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Distribution is a histogram value, e.g. request latencies.
+type Distribution struct {
+	Count int64   `json:"count"`
+	Mean  float64 `json:"mean"`
+	// BucketBounds are the upper bounds of every bucket but the last, which
+	// is unbounded. BucketCounts has one more entry than BucketBounds, but
+	// trailing empty buckets may be omitted.
+	BucketBounds []float64 `json:"bucketBounds,omitempty"`
+	BucketCounts []int64   `json:"bucketCounts,omitempty"`
+}
+
+// Percentile estimates the p-th percentile (0-100) by interpolating within
+// the bucket that contains it.
+func (d *Distribution) Percentile(p float64) float64 {
+	if d == nil || d.Count == 0 || len(d.BucketCounts) == 0 {
+		return 0
+	}
+	target := p / 100 * float64(d.Count)
+	var seen float64
+	for i, n := range d.BucketCounts {
+		if n == 0 || seen+float64(n) < target {
+			seen += float64(n)
+			continue
+		}
+		switch {
+		case len(d.BucketBounds) == 0:
+			return d.Mean
+		case i == 0:
+			// Underflow bucket: everything is below the first bound.
+			return d.BucketBounds[0]
+		case i >= len(d.BucketBounds):
+			// Overflow bucket: everything is above the last bound.
+			return d.BucketBounds[len(d.BucketBounds)-1]
+		}
+		lower, upper := d.BucketBounds[i-1], d.BucketBounds[i]
+		return lower + (upper-lower)*(target-seen)/float64(n)
+	}
+	return d.BucketBounds[len(d.BucketBounds)-1]
+}
+
+// newTimeSeries converts a Cloud Monitoring series.
+func newTimeSeries(ts *monitoringpb.TimeSeries) TimeSeries {
+	series := TimeSeries{
+		MetricType:     ts.GetMetric().GetType(),
+		MetricLabels:   ts.GetMetric().GetLabels(),
+		ResourceType:   ts.GetResource().GetType(),
+		ResourceLabels: ts.GetResource().GetLabels(),
+		Points:         make([]Point, 0, len(ts.GetPoints())),
+	}
+	for _, p := range ts.GetPoints() {
+		point := Point{
+			End:   p.GetInterval().GetEndTime().AsTime(),
+			Value: newValue(p.GetValue()),
+		}
+		if start := p.GetInterval().GetStartTime(); start != nil {
+			point.Start = start.AsTime()
+		}
+		series.Points = append(series.Points, point)
+	}
+	return series
+}
+
+// newValue converts a Cloud Monitoring typed value.
+func newValue(v *monitoringpb.TypedValue) Value {
+	switch x := v.GetValue().(type) {
+	case *monitoringpb.TypedValue_Int64Value:
+		return Value{Kind: ValueInt64, Int64: x.Int64Value}
+	case *monitoringpb.TypedValue_DoubleValue:
+		return Value{Kind: ValueDouble, Double: x.DoubleValue}
+	case *monitoringpb.TypedValue_DistributionValue:
+		return Value{Kind: ValueDistribution, Distribution: newDistribution(x.DistributionValue)}
+	case *monitoringpb.TypedValue_BoolValue:
+		return Value{Kind: ValueBool, Bool: x.BoolValue}
+	case *monitoringpb.TypedValue_StringValue:
+		return Value{Kind: ValueString, String: x.StringValue}
+	}
+	return Value{}
+}
+
+// newDistribution converts a distribution, expanding its bucket options into
+// explicit bounds.
+func newDistribution(d *distributionpb.Distribution) *Distribution {
+	dist := &Distribution{
+		Count:        d.GetCount(),
+		Mean:         d.GetMean(),
+		BucketCounts: d.GetBucketCounts(),
+	}
+	opts := d.GetBucketOptions()
+	switch {
+	case opts.GetLinearBuckets() != nil:
+		b := opts.GetLinearBuckets()
+		for i := int32(0); i <= b.GetNumFiniteBuckets(); i++ {
+			dist.BucketBounds = append(dist.BucketBounds, b.GetOffset()+b.GetWidth()*float64(i))
+		}
+	case opts.GetExponentialBuckets() != nil:
+		b := opts.GetExponentialBuckets()
+		for i := int32(0); i <= b.GetNumFiniteBuckets(); i++ {
+			dist.BucketBounds = append(dist.BucketBounds, b.GetScale()*math.Pow(b.GetGrowthFactor(), float64(i)))
+		}
+	case opts.GetExplicitBuckets() != nil:
+		dist.BucketBounds = opts.GetExplicitBuckets().GetBounds()
+	}
+	return dist
+}
+
+// promQLEndpoint is the Prometheus-compatible query API of Cloud Monitoring.
+var promQLEndpoint = "https://monitoring.googleapis.com/v1/projects/%s/location/global/prometheus/api/v1/query_range"
+
+// promQLResponse is the Prometheus query_range response format.
+type promQLResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]any          `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// queryPromQL runs a PromQL range query. Every returned series has double values.
+func (c *Clients) queryPromQL(ctx context.Context, q *MetricQuery) ([]TimeSeries, error) {
+	client, err := c.PromQLHTTP(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := q.interval(time.Now())
+	step := q.AlignmentPeriod
+	if step <= 0 {
+		step = time.Minute
+	}
+	form := url.Values{
+		"query": {q.PromQL},
+		"start": {strconv.FormatInt(start.Unix(), 10)},
+		"end":   {strconv.FormatInt(end.Unix(), 10)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64) + "s"},
+	}
+	return retryValue(ctx, "monitoring.QueryPromQL", func(ctx context.Context) ([]TimeSeries, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(promQLEndpoint, q.ProjectID), strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		series, err := parsePromQLResponse(resp)
+		if err != nil && resp.StatusCode >= 400 {
+			// Keep the status code so failures can be classified for retry.
+			return nil, &googleapi.Error{Code: resp.StatusCode, Message: err.Error()}
+		}
+		return series, err
+	})
+}
+
+// parsePromQLResponse converts a query_range matrix into time series.
+func parsePromQLResponse(resp *http.Response) ([]TimeSeries, error) {
+	var body promQLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode PromQL response (%s): %w", resp.Status, err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("PromQL query failed (%s): %s: %s", resp.Status, body.ErrorType, body.Error)
+	}
+
+	series := make([]TimeSeries, 0, len(body.Data.Result))
+	for _, r := range body.Data.Result {
+		ts := TimeSeries{
+			MetricType:   r.Metric["__name__"],
+			MetricLabels: r.Metric,
+			Points:       make([]Point, 0, len(r.Values)),
+		}
+		// Prometheus returns oldest first; match Cloud Monitoring's newest first.
+		for i := len(r.Values) - 1; i >= 0; i-- {
+			secs, _ := r.Values[i][0].(float64)
+			raw, _ := r.Values[i][1].(string)
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid PromQL sample %q: %w", raw, err)
+			}
+			ts.Points = append(ts.Points, Point{
+				End:   time.Unix(0, int64(secs*float64(time.Second))).UTC(),
+				Value: Value{Kind: ValueDouble, Double: v},
+			})
+		}
+		series = append(series, ts)
+	}
+	return series, nil
+}
+
+// Cloud Run and GKE query helpers. Each returns a query over the last 5
+// minutes with 1 minute alignment, which can be adjusted before running.
+
+// RunCPUUtilization queries the CPU utilization of a Cloud Run service's
+// containers as the given percentile (e.g. 99) across instances.
+func RunCPUUtilization(projectId string, service string, region string, percentile int) *MetricQuery {
+	return runServiceQuery(projectId, "run.googleapis.com/container/cpu/utilizations", service, region).
+		Align(percentileAligner(percentile), time.Minute)
+}
+
+// RunMemoryUtilization queries the memory utilization of a Cloud Run
+// service's containers as the given percentile across instances.
+func RunMemoryUtilization(projectId string, service string, region string, percentile int) *MetricQuery {
+	return runServiceQuery(projectId, "run.googleapis.com/container/memory/utilizations", service, region).
+		Align(percentileAligner(percentile), time.Minute)
+}
+
+// RunRequestRate queries the requests per second served by a Cloud Run
+// service, one series per response code class.
+func RunRequestRate(projectId string, service string, region string) *MetricQuery {
+	return runServiceQuery(projectId, "run.googleapis.com/request_count", service, region).
+		Align(monitoringpb.Aggregation_ALIGN_RATE, time.Minute).
+		Reduce(monitoringpb.Aggregation_REDUCE_SUM, "resource.labels.service_name", "metric.labels.response_code_class")
+}
+
+// RunRequestLatency queries the request latency (ms) of a Cloud Run
+// service at the given percentile.
+func RunRequestLatency(projectId string, service string, region string, percentile int) *MetricQuery {
+	return runServiceQuery(projectId, "run.googleapis.com/request_latencies", service, region).
+		Align(monitoringpb.Aggregation_ALIGN_DELTA, time.Minute).
+		Reduce(percentileReducer(percentile), "resource.labels.service_name")
+}
+
+// RunInstanceCount queries the number of instances of a Cloud Run service.
+func RunInstanceCount(projectId string, service string, region string) *MetricQuery {
+	return runServiceQuery(projectId, "run.googleapis.com/container/instance_count", service, region).
+		Align(monitoringpb.Aggregation_ALIGN_MEAN, time.Minute).
+		Reduce(monitoringpb.Aggregation_REDUCE_SUM, "resource.labels.service_name")
+}
+
+func runServiceQuery(projectId string, metricType string, service string, region string) *MetricQuery {
+	return NewMetricQuery(projectId, metricType).
+		WithResourceType("cloud_run_revision").
+		WithResourceLabel("service_name", service).
+		WithResourceLabel("location", region)
+}
+
+// GKEContainerCPU queries the CPU cores used by the containers of a GKE
+// workload, one series per pod. podPrefix selects the workload's pods.
+func GKEContainerCPU(projectId string, cluster string, namespace string, podPrefix string) *MetricQuery {
+	return gkeContainerQuery(projectId, "kubernetes.io/container/cpu/core_usage_time", cluster, namespace, podPrefix).
+		Align(monitoringpb.Aggregation_ALIGN_RATE, time.Minute).
+		Reduce(monitoringpb.Aggregation_REDUCE_SUM, "resource.labels.pod_name")
+}
+
+// GKEContainerMemory queries the memory bytes used by the containers of a
+// GKE workload, one series per pod.
+func GKEContainerMemory(projectId string, cluster string, namespace string, podPrefix string) *MetricQuery {
+	return gkeContainerQuery(projectId, "kubernetes.io/container/memory/used_bytes", cluster, namespace, podPrefix).
+		WithMetricLabel("memory_type", "non-evictable").
+		Align(monitoringpb.Aggregation_ALIGN_MEAN, time.Minute).
+		Reduce(monitoringpb.Aggregation_REDUCE_SUM, "resource.labels.pod_name")
+}
+
+// GKERequestRate queries the requests per second served by a service in a
+// GKE namespace, one series per response code. GKE has no built-in request
+// metrics, so this relies on the Istio metrics exported by Cloud Service
+// Mesh, which are reported per canonical service rather than per container.
+func GKERequestRate(projectId string, namespace string, service string) *MetricQuery {
+	return meshServiceQuery(projectId, "istio.io/service/server/request_count", namespace, service).
+		Align(monitoringpb.Aggregation_ALIGN_RATE, time.Minute).
+		Reduce(monitoringpb.Aggregation_REDUCE_SUM, "resource.labels.canonical_service_name", "metric.labels.response_code")
+}
+
+// GKERequestLatency queries the request latency (ms) of a service in a GKE
+// namespace at the given percentile, from Cloud Service Mesh metrics.
+func GKERequestLatency(projectId string, namespace string, service string, percentile int) *MetricQuery {
+	return meshServiceQuery(projectId, "istio.io/service/server/response_latencies", namespace, service).
+		Align(monitoringpb.Aggregation_ALIGN_DELTA, time.Minute).
+		Reduce(percentileReducer(percentile), "resource.labels.canonical_service_name")
+}
+
+func meshServiceQuery(projectId string, metricType string, namespace string, service string) *MetricQuery {
+	return NewMetricQuery(projectId, metricType).
+		WithResourceType("istio_canonical_service").
+		WithResourceLabel("namespace_name", namespace).
+		WithResourceLabel("canonical_service_name", service)
+}
+
+func gkeContainerQuery(projectId string, metricType string, cluster string, namespace string, podPrefix string) *MetricQuery {
+	q := NewMetricQuery(projectId, metricType).
+		WithResourceType("k8s_container").
+		WithResourceLabel("cluster_name", cluster).
+		WithResourceLabel("namespace_name", namespace)
+	if podPrefix != "" {
+		q.WithFilter(fmt.Sprintf("resource.labels.pod_name = starts_with(%q)", podPrefix))
+	}
+	return q
+}
+
+// percentileAligner maps a percentile to the closest supported aligner
+// (5, 50, 95 or 99).
+func percentileAligner(percentile int) monitoringpb.Aggregation_Aligner {
+	switch {
+	case percentile >= 97:
+		return monitoringpb.Aggregation_ALIGN_PERCENTILE_99
+	case percentile >= 73:
+		return monitoringpb.Aggregation_ALIGN_PERCENTILE_95
+	case percentile >= 28:
+		return monitoringpb.Aggregation_ALIGN_PERCENTILE_50
+	default:
+		return monitoringpb.Aggregation_ALIGN_PERCENTILE_05
+	}
+}
+
+// percentileReducer maps a percentile to the closest supported reducer
+// (5, 50, 95 or 99).
+func percentileReducer(percentile int) monitoringpb.Aggregation_Reducer {
+	switch {
+	case percentile >= 97:
+		return monitoringpb.Aggregation_REDUCE_PERCENTILE_99
+	case percentile >= 73:
+		return monitoringpb.Aggregation_REDUCE_PERCENTILE_95
+	case percentile >= 28:
+		return monitoringpb.Aggregation_REDUCE_PERCENTILE_50
+	default:
+		return monitoringpb.Aggregation_REDUCE_PERCENTILE_05
+	}
+}
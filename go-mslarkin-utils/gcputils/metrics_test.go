@@ -0,0 +1,327 @@
+package gcputils
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	monitoringpb "cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/api/option"
+	distributionpb "google.golang.org/genproto/googleapis/api/distribution"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMetricQueryFilter(t *testing.T) {
+	q := NewMetricQuery("p", "run.googleapis.com/request_count").
+		WithResourceType("cloud_run_revision").
+		WithResourceLabel("service_name", "hello").
+		WithResourceLabel("location", "us-central1").
+		WithMetricLabel("response_code_class", "5xx").
+		WithFilter(`resource.labels.revision_name = starts_with("hello-")`)
+
+	want := `metric.type = "run.googleapis.com/request_count"` +
+		` AND resource.type = "cloud_run_revision"` +
+		` AND resource.labels.location = "us-central1"` +
+		` AND resource.labels.service_name = "hello"` +
+		` AND metric.labels.response_code_class = "5xx"` +
+		` AND resource.labels.revision_name = starts_with("hello-")`
+	if got := q.Filter(); got != want {
+		t.Errorf("Filter() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGKERequestRateFilter(t *testing.T) {
+	want := `metric.type = "istio.io/service/server/request_count"` +
+		` AND resource.type = "istio_canonical_service"` +
+		` AND resource.labels.canonical_service_name = "frontend"` +
+		` AND resource.labels.namespace_name = "shop"`
+	if got := GKERequestRate("p", "shop", "frontend").Filter(); got != want {
+		t.Errorf("Filter() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMetricQueryRequest(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	q := NewMetricQuery("p", "m").
+		Align(monitoringpb.Aggregation_ALIGN_PERCENTILE_99, 2*time.Minute).
+		Reduce(monitoringpb.Aggregation_REDUCE_MAX, "resource.labels.service_name").
+		Last(10 * time.Minute)
+	req := q.request(now)
+	if req.Name != "projects/p" {
+		t.Errorf("Name = %q, want projects/p", req.Name)
+	}
+	if got := req.Interval.StartTime.AsTime(); !got.Equal(now.Add(-10 * time.Minute)) {
+		t.Errorf("StartTime = %v, want 10m before %v", got, now)
+	}
+	agg := req.Aggregation
+	if agg.PerSeriesAligner != monitoringpb.Aggregation_ALIGN_PERCENTILE_99 ||
+		agg.CrossSeriesReducer != monitoringpb.Aggregation_REDUCE_MAX ||
+		agg.AlignmentPeriod.AsDuration() != 2*time.Minute ||
+		len(agg.GroupByFields) != 1 {
+		t.Errorf("Aggregation = %v", agg)
+	}
+
+	// Without an aligner or reducer the raw points are requested.
+	start := now.Add(-time.Hour)
+	raw := NewMetricQuery("p", "m").Between(start, now).request(now)
+	if raw.Aggregation != nil {
+		t.Errorf("Aggregation = %v, want nil", raw.Aggregation)
+	}
+	if !raw.Interval.StartTime.AsTime().Equal(start) {
+		t.Errorf("StartTime = %v, want %v", raw.Interval.StartTime.AsTime(), start)
+	}
+}
+
+func TestNewValue(t *testing.T) {
+	tests := []struct {
+		in        *monitoringpb.TypedValue
+		wantKind  ValueKind
+		wantFloat float64
+	}{
+		{&monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: 7}}, ValueInt64, 7},
+		{&monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DoubleValue{DoubleValue: 0.5}}, ValueDouble, 0.5},
+		{&monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_BoolValue{BoolValue: true}}, ValueBool, 1},
+		{&monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DistributionValue{
+			DistributionValue: &distributionpb.Distribution{Count: 4, Mean: 12.5},
+		}}, ValueDistribution, 12.5},
+	}
+	for _, tt := range tests {
+		got := newValue(tt.in)
+		if got.Kind != tt.wantKind || got.Float() != tt.wantFloat {
+			t.Errorf("newValue(%v) = %v (%v), want %v (%v)", tt.in, got.Kind, got.Float(), tt.wantKind, tt.wantFloat)
+		}
+	}
+}
+
+func TestDistributionBuckets(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *distributionpb.Distribution_BucketOptions
+		want []float64
+	}{
+		{
+			name: "linear",
+			opts: &distributionpb.Distribution_BucketOptions{Options: &distributionpb.Distribution_BucketOptions_LinearBuckets{
+				LinearBuckets: &distributionpb.Distribution_BucketOptions_Linear{NumFiniteBuckets: 3, Width: 10, Offset: 5},
+			}},
+			want: []float64{5, 15, 25, 35},
+		},
+		{
+			name: "exponential",
+			opts: &distributionpb.Distribution_BucketOptions{Options: &distributionpb.Distribution_BucketOptions_ExponentialBuckets{
+				ExponentialBuckets: &distributionpb.Distribution_BucketOptions_Exponential{NumFiniteBuckets: 2, GrowthFactor: 2, Scale: 1},
+			}},
+			want: []float64{1, 2, 4},
+		},
+		{
+			name: "explicit",
+			opts: &distributionpb.Distribution_BucketOptions{Options: &distributionpb.Distribution_BucketOptions_ExplicitBuckets{
+				ExplicitBuckets: &distributionpb.Distribution_BucketOptions_Explicit{Bounds: []float64{0, 100, 1000}},
+			}},
+			want: []float64{0, 100, 1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newDistribution(&distributionpb.Distribution{BucketOptions: tt.opts}).BucketBounds
+			if len(got) != len(tt.want) {
+				t.Fatalf("BucketBounds = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("BucketBounds = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDistributionPercentile(t *testing.T) {
+	// 100 samples: 50 in [0,100), 40 in [100,200), 10 in [200,400).
+	d := &Distribution{
+		Count:        100,
+		BucketBounds: []float64{0, 100, 200, 400},
+		BucketCounts: []int64{0, 50, 40, 10, 0},
+	}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{50, 100},
+		{25, 50},
+		{70, 150},
+		{95, 300},
+	}
+	for _, tt := range tests {
+		if got := d.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := (&Distribution{}).Percentile(50); got != 0 {
+		t.Errorf("empty Percentile(50) = %v, want 0", got)
+	}
+}
+
+func TestParsePromQLResponse(t *testing.T) {
+	body := `{"status":"success","data":{"resultType":"matrix","result":[
+		{"metric":{"__name__":"up","job":"a"},"values":[[1700000000,"1"],[1700000060,"0.5"]]}
+	]}}`
+	resp := &http.Response{Status: "200 OK", Body: io.NopCloser(strings.NewReader(body))}
+	series, err := parsePromQLResponse(resp)
+	if err != nil {
+		t.Fatalf("parsePromQLResponse() error = %v", err)
+	}
+	if len(series) != 1 || series[0].MetricType != "up" || series[0].MetricLabels["job"] != "a" {
+		t.Fatalf("series = %+v", series)
+	}
+	latest, _ := series[0].Latest()
+	if latest.Value.Double != 0.5 || latest.End.Unix() != 1700000060 {
+		t.Errorf("Latest() = %+v, want 0.5 at 1700000060", latest)
+	}
+
+	errBody := `{"status":"error","errorType":"bad_data","error":"parse error"}`
+	resp = &http.Response{Status: "400 Bad Request", Body: io.NopCloser(strings.NewReader(errBody))}
+	if _, err := parsePromQLResponse(resp); err == nil || !strings.Contains(err.Error(), "parse error") {
+		t.Errorf("parsePromQLResponse() error = %v, want parse error", err)
+	}
+}
+
+func TestPercentileAligner(t *testing.T) {
+	tests := map[int]monitoringpb.Aggregation_Aligner{
+		99: monitoringpb.Aggregation_ALIGN_PERCENTILE_99,
+		95: monitoringpb.Aggregation_ALIGN_PERCENTILE_95,
+		90: monitoringpb.Aggregation_ALIGN_PERCENTILE_95,
+		50: monitoringpb.Aggregation_ALIGN_PERCENTILE_50,
+		5:  monitoringpb.Aggregation_ALIGN_PERCENTILE_05,
+	}
+	for p, want := range tests {
+		if got := percentileAligner(p); got != want {
+			t.Errorf("percentileAligner(%d) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+// fakeMetricServer serves numbered series in pages.
+type fakeMetricServer struct {
+	monitoringpb.UnimplementedMetricServiceServer
+	series int
+	reqs   []*monitoringpb.ListTimeSeriesRequest
+	// err, if set, fails every request.
+	err error
+}
+
+func (f *fakeMetricServer) ListTimeSeries(ctx context.Context, req *monitoringpb.ListTimeSeriesRequest) (*monitoringpb.ListTimeSeriesResponse, error) {
+	f.reqs = append(f.reqs, req)
+	if f.err != nil {
+		return nil, f.err
+	}
+	start, _ := strconv.Atoi(req.PageToken)
+	end := start + int(req.PageSize)
+	if req.PageSize == 0 || end > f.series {
+		end = f.series
+	}
+	resp := &monitoringpb.ListTimeSeriesResponse{}
+	for i := start; i < end; i++ {
+		resp.TimeSeries = append(resp.TimeSeries, &monitoringpb.TimeSeries{
+			Metric:   &metricpb.Metric{Type: req.Filter, Labels: map[string]string{"i": strconv.Itoa(i)}},
+			Resource: &monitoredrespb.MonitoredResource{Type: "cloud_run_revision"},
+			Points: []*monitoringpb.Point{{
+				Interval: &monitoringpb.TimeInterval{EndTime: timestamppb.Now()},
+				Value:    &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: int64(i)}},
+			}},
+		})
+	}
+	if end < f.series {
+		resp.NextPageToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+// newFakeMonitoring starts a fake Monitoring API and returns Clients that talk to it.
+func newFakeMonitoring(t *testing.T, fake *fakeMetricServer) *Clients {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	monitoringpb.RegisterMetricServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	clients := NewClients(
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	t.Cleanup(func() { clients.Close() })
+	return clients
+}
+
+func TestQueryTimeSeries(t *testing.T) {
+	fake := &fakeMetricServer{series: 5}
+	clients := newFakeMonitoring(t, fake)
+	ctx := context.Background()
+	q := NewMetricQuery("p", "m").WithPageSize(2)
+
+	// Run follows every page.
+	all, err := clients.QueryTimeSeries(ctx, q)
+	if err != nil {
+		t.Fatalf("QueryTimeSeries() error = %v", err)
+	}
+	if len(all) != 5 || all[4].MetricLabels["i"] != "4" || all[4].Points[0].Value.Int64 != 4 {
+		t.Fatalf("QueryTimeSeries() = %+v, want 5 labeled series", all)
+	}
+	if all[0].MetricType != `metric.type = "m"` {
+		t.Errorf("request filter = %q", all[0].MetricType)
+	}
+
+	// RunPage returns one page at a time.
+	var pages int
+	token := ""
+	for {
+		page, next, err := clients.QueryTimeSeriesPage(ctx, q, token)
+		if err != nil {
+			t.Fatalf("QueryTimeSeriesPage() error = %v", err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("page has %d series, want at most 2", len(page))
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if pages != 3 {
+		t.Errorf("got %d pages, want 3", pages)
+	}
+}
+
+func TestQueryTimeSeriesRetriesOnlyByPolicy(t *testing.T) {
+	fake := &fakeMetricServer{err: status.Error(codes.Unavailable, "down")}
+	clients := newFakeMonitoring(t, fake)
+	var counter RetryCounter
+	ctx := WithRetryPolicy(context.Background(), fastPolicy(&counter))
+
+	if _, err := clients.QueryTimeSeries(ctx, NewMetricQuery("p", "m")); err == nil {
+		t.Fatal("QueryTimeSeries() error = nil")
+	}
+	// The client's own retries of UNAVAILABLE are off, so each attempt of
+	// the policy is a single request.
+	if len(fake.reqs) != 4 {
+		t.Errorf("server got %d requests, want 4", len(fake.reqs))
+	}
+}
@@ -0,0 +1,172 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+// PlatformKind identifies the compute platform the process is running on.
+type PlatformKind string
+
+const (
+	PlatformLocal           PlatformKind = "local"
+	PlatformCloudRunService PlatformKind = "cloud-run-service"
+	PlatformCloudRunJob     PlatformKind = "cloud-run-job"
+	PlatformGKE             PlatformKind = "gke"
+	PlatformGCE             PlatformKind = "gce"
+	PlatformAppEngine       PlatformKind = "app-engine"
+)
+
+// Platform describes where the process is running. Fields that do not apply
+// to the detected platform are left empty.
+type Platform struct {
+	Kind          PlatformKind `json:"kind"`
+	ProjectID     string       `json:"projectId,omitempty"`
+	ProjectNumber string       `json:"projectNumber,omitempty"`
+	Region        string       `json:"region,omitempty"`
+	Zone          string       `json:"zone,omitempty"`
+	InstanceID    string       `json:"instanceId,omitempty"`
+
+	// Service and Revision are set for Cloud Run services (K_SERVICE, K_REVISION)
+	// and App Engine (GAE_SERVICE, GAE_VERSION).
+	Service  string `json:"service,omitempty"`
+	Revision string `json:"revision,omitempty"`
+
+	// Job and Execution are set for Cloud Run jobs.
+	Job       string `json:"job,omitempty"`
+	Execution string `json:"execution,omitempty"`
+
+	// ClusterName and ClusterLocation are set for GKE.
+	ClusterName     string `json:"clusterName,omitempty"`
+	ClusterLocation string `json:"clusterLocation,omitempty"`
+}
+
+// OnGCP reports whether the platform is a Google Cloud compute platform.
+func (p Platform) OnGCP() bool {
+	return p.Kind != PlatformLocal
+}
+
+// onGCE reports whether a metadata server is available. Setting
+// GCE_METADATA_HOST short-circuits the probe, which lets tests point the
+// metadata client at a fake server such as metadatatest.Server.
+var onGCE = func() bool {
+	return os.Getenv("GCE_METADATA_HOST") != "" || metadata.OnGCE()
+}
+
+var (
+	platformMu sync.Mutex
+	platform   *Platform
+)
+
+// DetectPlatform works out which platform the process is running on, from
+// the environment and the metadata server. The result is computed once and
+// cached for the life of the process; a failed detection is not cached, so
+// it is retried on the next call.
+func DetectPlatform(ctx context.Context) (Platform, error) {
+	platformMu.Lock()
+	defer platformMu.Unlock()
+
+	if platform != nil {
+		return *platform, nil
+	}
+	p, err := detectPlatform(ctx)
+	if err != nil {
+		return Platform{}, err
+	}
+	platform = &p
+	return p, nil
+}
+
+func detectPlatform(ctx context.Context) (Platform, error) {
+	// The serverless platforms identify themselves through the environment.
+	p := Platform{Kind: PlatformLocal}
+	switch {
+	case os.Getenv("CLOUD_RUN_JOB") != "":
+		p.Kind = PlatformCloudRunJob
+		p.Job = os.Getenv("CLOUD_RUN_JOB")
+		p.Execution = os.Getenv("CLOUD_RUN_EXECUTION")
+	case os.Getenv("K_SERVICE") != "":
+		p.Kind = PlatformCloudRunService
+		p.Service = os.Getenv("K_SERVICE")
+		p.Revision = os.Getenv("K_REVISION")
+	case os.Getenv("GAE_SERVICE") != "":
+		p.Kind = PlatformAppEngine
+		p.Service = os.Getenv("GAE_SERVICE")
+		p.Revision = os.Getenv("GAE_VERSION")
+	}
+
+	if !onGCE() {
+		// Without a metadata server the best we can do is the environment.
+		p.ProjectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+		return p, nil
+	}
+
+	c := metadata.NewClient(nil)
+	var err error
+	if p.ProjectID, err = getMetadata(ctx, c, "project/project-id"); err != nil {
+		return Platform{}, err
+	}
+	if p.ProjectNumber, err = getMetadata(ctx, c, "project/numeric-project-id"); err != nil {
+		return Platform{}, err
+	}
+	if p.InstanceID, err = getMetadata(ctx, c, "instance/id"); err != nil {
+		return Platform{}, err
+	}
+	// Zone is "projects/<number>/zones/<zone>"; region is only served on the
+	// serverless platforms, as "projects/<number>/regions/<region>".
+	zone, err := getMetadata(ctx, c, "instance/zone")
+	if err != nil {
+		return Platform{}, err
+	}
+	region, err := getMetadata(ctx, c, "instance/region")
+	if err != nil {
+		return Platform{}, err
+	}
+	if zone != "" {
+		p.Zone = parsePath(zone)
+	}
+	if region != "" {
+		p.Region = parsePath(region)
+	} else if i := strings.LastIndex(p.Zone, "-"); i > 0 {
+		p.Region = p.Zone[:i]
+	}
+
+	if p.Kind != PlatformLocal {
+		return p, nil
+	}
+
+	// GKE nodes carry the cluster name in their instance attributes.
+	if p.ClusterName, err = getMetadata(ctx, c, "instance/attributes/cluster-name"); err != nil {
+		return Platform{}, err
+	}
+	if p.ClusterLocation, err = getMetadata(ctx, c, "instance/attributes/cluster-location"); err != nil {
+		return Platform{}, err
+	}
+	if p.ClusterName != "" || os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		p.Kind = PlatformGKE
+	} else {
+		p.Kind = PlatformGCE
+	}
+	return p, nil
+}
+
+// getMetadata reads a metadata value, treating undefined values as empty.
+func getMetadata(ctx context.Context, c *metadata.Client, suffix string) (string, error) {
+	v, err := retryValue(ctx, "metadata.Get", func(ctx context.Context) (string, error) {
+		return c.GetWithContext(ctx, suffix)
+	})
+	var notDefined metadata.NotDefinedError
+	if errors.As(err, &notDefined) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read metadata %q: %w", suffix, err)
+	}
+	return strings.TrimSpace(v), nil
+}
@@ -0,0 +1,238 @@
+package gcputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mlarkin00/mslarkin/go-mslarkin-utils/gcputils/metadatatest"
+)
+
+// fakeMetadata starts a fake metadata server with the metadata common to
+// every GCP platform, plus any changes made by configure.
+func fakeMetadata(t *testing.T, configure func(*metadatatest.Config)) *metadatatest.Server {
+	t.Helper()
+	cfg := metadatatest.Config{
+		ProjectID:     "my-project",
+		ProjectNumber: "123456",
+		InstanceID:    "987654321",
+		Zone:          "us-central1-a",
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+	return metadatatest.Start(t, cfg)
+}
+
+// resetPlatform clears the platform environment variables and cache.
+func resetPlatform(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{
+		"CLOUD_RUN_JOB", "CLOUD_RUN_EXECUTION", "K_SERVICE", "K_REVISION",
+		"GAE_SERVICE", "GAE_VERSION", "KUBERNETES_SERVICE_HOST",
+		"GOOGLE_CLOUD_PROJECT", "GCE_METADATA_HOST",
+	} {
+		t.Setenv(env, "")
+	}
+	platform = nil
+	t.Cleanup(func() { platform = nil })
+}
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		metadata func(*metadatatest.Config)
+		want     Platform
+	}{
+		{
+			name:     "cloud run service",
+			env:      map[string]string{"K_SERVICE": "hello", "K_REVISION": "hello-00001-abc"},
+			metadata: func(c *metadatatest.Config) { c.Region = "us-central1" },
+			want: Platform{
+				Kind: PlatformCloudRunService, Service: "hello", Revision: "hello-00001-abc",
+				Region: "us-central1",
+			},
+		},
+		{
+			name:     "cloud run job",
+			env:      map[string]string{"CLOUD_RUN_JOB": "burst", "CLOUD_RUN_EXECUTION": "burst-x7k2p"},
+			metadata: func(c *metadatatest.Config) { c.Region = "us-west1" },
+			want: Platform{
+				Kind: PlatformCloudRunJob, Job: "burst", Execution: "burst-x7k2p",
+				Region: "us-west1",
+			},
+		},
+		{
+			name: "app engine",
+			env:  map[string]string{"GAE_SERVICE": "default", "GAE_VERSION": "v1"},
+			want: Platform{
+				Kind: PlatformAppEngine, Service: "default", Revision: "v1",
+				Region: "us-central1",
+			},
+		},
+		{
+			name: "gke",
+			metadata: func(c *metadatatest.Config) {
+				c.Attributes = map[string]string{
+					"cluster-name":     "demo",
+					"cluster-location": "us-central1",
+				}
+			},
+			want: Platform{
+				Kind: PlatformGKE, ClusterName: "demo", ClusterLocation: "us-central1",
+				Region: "us-central1",
+			},
+		},
+		{
+			name: "gce",
+			want: Platform{Kind: PlatformGCE, Region: "us-central1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetPlatform(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fakeMetadata(t, tt.metadata)
+
+			got, err := DetectPlatform(context.Background())
+			if err != nil {
+				t.Fatalf("DetectPlatform() error = %v", err)
+			}
+
+			// Every GCP platform picks up the common metadata.
+			want := tt.want
+			want.ProjectID = "my-project"
+			want.ProjectNumber = "123456"
+			want.InstanceID = "987654321"
+			want.Zone = "us-central1-a"
+			if got != want {
+				t.Errorf("DetectPlatform() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDetectPlatformLocal(t *testing.T) {
+	resetPlatform(t)
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "local-project")
+	origOnGCE := onGCE
+	onGCE = func() bool { return false }
+	t.Cleanup(func() { onGCE = origOnGCE })
+
+	got, err := DetectPlatform(context.Background())
+	if err != nil {
+		t.Fatalf("DetectPlatform() error = %v", err)
+	}
+	want := Platform{Kind: PlatformLocal, ProjectID: "local-project"}
+	if got != want {
+		t.Errorf("DetectPlatform() = %+v, want %+v", got, want)
+	}
+	if got.OnGCP() {
+		t.Error("OnGCP() = true for local platform")
+	}
+	if _, err := GetRegion(context.Background()); err == nil {
+		t.Error("GetRegion() returned no error off-cloud")
+	}
+}
+
+func TestOnGCPPlatformWithoutMetadata(t *testing.T) {
+	resetPlatform(t)
+	t.Setenv("K_SERVICE", "hello")
+	origOnGCE := onGCE
+	onGCE = func() bool { return false }
+	t.Cleanup(func() { onGCE = origOnGCE })
+
+	// The service is still detected from the environment...
+	got, err := DetectPlatform(context.Background())
+	if err != nil {
+		t.Fatalf("DetectPlatform() error = %v", err)
+	}
+	if got.Kind != PlatformCloudRunService || got.Service != "hello" {
+		t.Errorf("DetectPlatform() = %+v, want the Cloud Run service", got)
+	}
+	// ...but the metadata values are unknown rather than empty.
+	if v, err := GetProjectNumber(context.Background()); err == nil {
+		t.Errorf("GetProjectNumber() = %q, want an error", v)
+	}
+	if v, err := GetRegion(context.Background()); err == nil {
+		t.Errorf("GetRegion() = %q, want an error", v)
+	}
+	if v, err := GetInstanceId(context.Background()); err == nil {
+		t.Errorf("GetInstanceId() = %q, want an error", v)
+	}
+}
+
+func TestDetectPlatformCached(t *testing.T) {
+	resetPlatform(t)
+	t.Setenv("K_SERVICE", "hello")
+	md := fakeMetadata(t, nil)
+
+	first, err := DetectPlatform(context.Background())
+	if err != nil {
+		t.Fatalf("DetectPlatform() error = %v", err)
+	}
+	served := md.Requests("project/project-id")
+
+	// Later calls are served from the cache, even if the environment changes.
+	t.Setenv("K_SERVICE", "other")
+	second, err := DetectPlatform(context.Background())
+	if err != nil {
+		t.Fatalf("DetectPlatform() error = %v", err)
+	}
+	if second != first {
+		t.Errorf("cached DetectPlatform() = %+v, want %+v", second, first)
+	}
+	if got := md.Requests("project/project-id"); got != served {
+		t.Errorf("project-id requests = %d after cached call, want %d", got, served)
+	}
+
+	region, err := GetRegion(context.Background())
+	if err != nil || region != "us-central1" {
+		t.Errorf("GetRegion() = %q, %v; want us-central1", region, err)
+	}
+}
+
+func TestDetectPlatformErrorNotCached(t *testing.T) {
+	resetPlatform(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusBadRequest)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+
+	if _, err := DetectPlatform(context.Background()); err == nil {
+		t.Fatal("DetectPlatform() error = nil, want metadata error")
+	}
+	if platform != nil {
+		t.Error("failed detection was cached")
+	}
+}
+
+func TestGetRunServiceId(t *testing.T) {
+	resetPlatform(t)
+	t.Setenv("K_SERVICE", "hello")
+	t.Setenv("K_REVISION", "hello-00001-abc")
+	t.Setenv("GAE_SERVICE", "default")
+	t.Setenv("GAE_VERSION", "v1")
+	if got := GetRunServiceId(); got != "hello" {
+		t.Errorf("GetRunServiceId() = %q, want hello", got)
+	}
+	if got := GetRunRevisionId(); got != "hello-00001-abc" {
+		t.Errorf("GetRunRevisionId() = %q, want hello-00001-abc", got)
+	}
+
+	t.Setenv("K_SERVICE", "")
+	t.Setenv("K_REVISION", "")
+	if got := GetRunServiceId(); got != "default" {
+		t.Errorf("GetRunServiceId() = %q, want default", got)
+	}
+	if got := GetRunRevisionId(); got != "v1" {
+		t.Errorf("GetRunRevisionId() = %q, want v1", got)
+	}
+}
@@ -0,0 +1,246 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy retries failed calls with jittered exponential backoff.
+// Zero fields take their value from DefaultRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// InitialBackoff is the backoff before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the backoff between attempts.
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after each retry.
+	Multiplier float64
+	// Retryable decides whether an error is worth retrying; IsRetryable if nil.
+	Retryable func(error) bool
+	// OnRetry, if set, is called before each retry, e.g. to count retries.
+	OnRetry func(RetryEvent)
+}
+
+// RetryEvent describes a retry about to happen.
+type RetryEvent struct {
+	// Op names the call being retried, e.g. "run.GetService".
+	Op string
+	// Attempt is the number of the attempt that failed, from 1.
+	Attempt int
+	Err     error
+	// Delay is how long until the next attempt.
+	Delay time.Duration
+}
+
+// DefaultRetryPolicy is used by every gcputils API call unless the context
+// carries another policy (see WithRetryPolicy). Set it during initialization,
+// e.g. to add an OnRetry hook for the whole process.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     8 * time.Second,
+	Multiplier:     2,
+}
+
+// NoRetry makes a single attempt.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// retryRand returns the jitter fraction; replaced in tests.
+var retryRand = rand.Float64
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a context that makes gcputils calls made with it
+// use p instead of DefaultRetryPolicy.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, p)
+}
+
+// RetryPolicyFrom returns the retry policy for calls made with ctx.
+func RetryPolicyFrom(ctx context.Context) RetryPolicy {
+	if p, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return p
+	}
+	return DefaultRetryPolicy
+}
+
+// Do calls fn until it succeeds, returns an error that is not retryable, or
+// runs out of attempts, and returns the last error. It does not start a
+// backoff that would end after ctx's deadline.
+func (p RetryPolicy) Do(ctx context.Context, op string, fn func(context.Context) error) error {
+	p = p.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !p.Retryable(err) {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(RetryEvent{Op: op, Attempt: attempt, Err: err, Delay: delay})
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// RetryValue is RetryPolicy.Do for calls that return a value.
+func RetryValue[T any](ctx context.Context, p RetryPolicy, op string, fn func(context.Context) (T, error)) (T, error) {
+	var v T
+	err := p.Do(ctx, op, func(ctx context.Context) error {
+		var err error
+		v, err = fn(ctx)
+		return err
+	})
+	return v, err
+}
+
+// retry runs fn under the retry policy of ctx.
+func retry(ctx context.Context, op string, fn func(context.Context) error) error {
+	return RetryPolicyFrom(ctx).Do(ctx, op, fn)
+}
+
+// retryValue runs fn under the retry policy of ctx.
+//
+// Calls to gax clients made by fn must pass noGaxRetry, or the retries of
+// the client and of the policy multiply.
+func retryValue[T any](ctx context.Context, op string, fn func(context.Context) (T, error)) (T, error) {
+	return RetryValue(ctx, RetryPolicyFrom(ctx), op, fn)
+}
+
+// noGaxRetry turns off the default retries of a gax client call, for calls
+// already retried by retryValue.
+var noGaxRetry = gax.WithRetry(nil)
+
+// withDefaults fills zero fields from DefaultRetryPolicy.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	d := DefaultRetryPolicy
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = max(d.MaxAttempts, 1)
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = d.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = d.MaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = max(d.Multiplier, 1)
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+	return p
+}
+
+// backoff returns the delay after a failed attempt: exponential growth
+// capped at MaxBackoff, with "full jitter" so that clients retrying the
+// same failure spread out instead of retrying in lockstep.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	d = min(d, float64(p.MaxBackoff))
+	return time.Duration(d * retryRand())
+}
+
+// IsRetryable reports whether err is a transient failure: gRPC UNAVAILABLE,
+// RESOURCE_EXHAUSTED, ABORTED or DEADLINE_EXCEEDED, HTTP 408, 429 or 5xx
+// (except 501), or a network timeout or reset. Context cancellation is not
+// retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPCode() > 0 {
+		return RetryableHTTPStatus(apiErr.HTTPCode())
+	}
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		return RetryableHTTPStatus(gErr.Code)
+	}
+	var mdErr *metadata.Error
+	if errors.As(err, &mdErr) {
+		return RetryableHTTPStatus(mdErr.Code)
+	}
+	if s, ok := status.FromError(err); ok {
+		return RetryableGRPCCode(s.Code())
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RetryableGRPCCode reports whether a gRPC status code is transient.
+func RetryableGRPCCode(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// RetryableHTTPStatus reports whether an HTTP status code is transient.
+func RetryableHTTPStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented:
+		return false
+	}
+	return code >= 500 && code <= 599
+}
+
+// RetryCounter counts retries per operation. Use its Hook as OnRetry.
+type RetryCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// Hook records a retry; it has the signature of RetryPolicy.OnRetry.
+func (c *RetryCounter) Hook(e RetryEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = map[string]int64{}
+	}
+	c.counts[e.Op]++
+}
+
+// Counts returns the number of retries per operation so far.
+func (c *RetryCounter) Counts() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(c.counts))
+	for op, n := range c.counts {
+		counts[op] = n
+	}
+	return counts
+}
@@ -0,0 +1,182 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fastPolicy retries quickly and counts retries.
+func fastPolicy(counter *RetryCounter) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     4 * time.Millisecond,
+		OnRetry:        counter.Hook,
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	wrappedAPIErr, _ := apierror.FromError(status.Error(codes.Unavailable, "try again"))
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.ResourceExhausted, ""), true},
+		{status.Error(codes.PermissionDenied, ""), false},
+		{status.Error(codes.InvalidArgument, ""), false},
+		{fmt.Errorf("wrapped: %w", status.Error(codes.Aborted, "")), true},
+		{wrappedAPIErr, true},
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{&googleapi.Error{Code: http.StatusNotImplemented}, false},
+		{&googleapi.Error{Code: http.StatusNotFound}, false},
+		{&metadata.Error{Code: http.StatusInternalServerError}, true},
+		{&metadata.Error{Code: http.StatusBadRequest}, false},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	var counter RetryCounter
+	p := fastPolicy(&counter)
+
+	calls := 0
+	err := p.Do(context.Background(), "op", func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return status.Error(codes.Unavailable, "flaky")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Do() = %v after %d calls, want success after 3", err, calls)
+	}
+	if got := counter.Counts()["op"]; got != 2 {
+		t.Errorf("retries counted = %d, want 2", got)
+	}
+
+	// Attempts are capped and the last error is returned.
+	calls = 0
+	err = p.Do(context.Background(), "op", func(ctx context.Context) error {
+		calls++
+		return status.Error(codes.Unavailable, fmt.Sprint("attempt ", calls))
+	})
+	if calls != 4 || status.Convert(err).Message() != "attempt 4" {
+		t.Errorf("Do() = %v after %d calls, want the 4th error", err, calls)
+	}
+
+	// Permanent errors are not retried.
+	calls = 0
+	p.Do(context.Background(), "op", func(ctx context.Context) error {
+		calls++
+		return status.Error(codes.NotFound, "")
+	})
+	if calls != 1 {
+		t.Errorf("permanent error attempted %d times, want 1", calls)
+	}
+}
+
+func TestRetryPolicyDeadline(t *testing.T) {
+	// A backoff that would outlast the deadline is not started.
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	defer func(orig func() float64) { retryRand = orig }(retryRand)
+	retryRand = func() float64 { return 1 }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	calls := 0
+	start := time.Now()
+	err := p.Do(ctx, "op", func(ctx context.Context) error {
+		calls++
+		return status.Error(codes.Unavailable, "")
+	})
+	if calls != 1 || err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Do() = %v after %d calls in %v, want 1 quick attempt", err, calls, time.Since(start))
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	defer func(orig func() float64) { retryRand = orig }(retryRand)
+	retryRand = func() float64 { return 1 }
+
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}.withDefaults()
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w*time.Millisecond)
+		}
+	}
+
+	// Jitter scales the backoff down.
+	retryRand = func() float64 { return 0.25 }
+	if got := p.backoff(3); got != 100*time.Millisecond {
+		t.Errorf("jittered backoff(3) = %v, want 100ms", got)
+	}
+}
+
+func TestWithRetryPolicy(t *testing.T) {
+	if p := RetryPolicyFrom(context.Background()); p.MaxAttempts != DefaultRetryPolicy.MaxAttempts {
+		t.Errorf("RetryPolicyFrom(background) = %+v, want the default", p)
+	}
+	ctx := WithRetryPolicy(context.Background(), NoRetry)
+	calls := 0
+	retry(ctx, "op", func(ctx context.Context) error {
+		calls++
+		return status.Error(codes.Unavailable, "")
+	})
+	if calls != 1 {
+		t.Errorf("NoRetry attempted %d times, want 1", calls)
+	}
+}
+
+func TestAPICallsRetry(t *testing.T) {
+	var counter RetryCounter
+	ctx := WithRetryPolicy(context.Background(), fastPolicy(&counter))
+
+	// The first two attempts to list tasks fail transiently.
+	api := &fakeJobsAPI{
+		tasks: []*runpb.Task{{Index: 0}},
+		listTasksErrs: []error{
+			status.Error(codes.Unavailable, "connection reset"),
+			status.Error(codes.ResourceExhausted, "quota"),
+		},
+	}
+	results, err := (&JobRunner{API: api}).TaskResults(ctx, testExecutionName)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("TaskResults() = %v, %v; want success after retries", results, err)
+	}
+	if got := counter.Counts()["run.ListTasks"]; got != 2 {
+		t.Errorf("run.ListTasks retries = %d, want 2", got)
+	}
+
+	// RunJob is never retried.
+	api.runErr = status.Error(codes.Unavailable, "")
+	if _, err := (&JobRunner{API: api}).RunJob(ctx, "burst", "p", "us-central1", nil); err == nil {
+		t.Fatal("RunJob() error = nil")
+	}
+	if api.runs != 1 {
+		t.Errorf("RunJob attempted %d times, want 1", api.runs)
+	}
+}
@@ -0,0 +1,488 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	run "cloud.google.com/go/run/apiv2"
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"google.golang.org/api/iterator"
+)
+
+// defaultRunPollInterval is how often RunAdmin polls operations and services.
+const defaultRunPollInterval = 2 * time.Second
+
+// RunServicesAPI is the subset of the Cloud Run Admin API used by RunAdmin.
+// Clients.RunAdmin implements it with the shared Cloud Run clients; tests
+// can substitute a fake.
+type RunServicesAPI interface {
+	// GetService fetches a service by its full resource name.
+	GetService(ctx context.Context, name string) (*runpb.Service, error)
+	// UpdateService starts updating a service and returns the long-running operation.
+	UpdateService(ctx context.Context, service *runpb.Service) (RunOperation, error)
+	// ListRevisions lists the revisions of a service, by full service name.
+	ListRevisions(ctx context.Context, service string) ([]*runpb.Revision, error)
+}
+
+// RunOperation is a long-running Cloud Run service operation.
+type RunOperation interface {
+	Name() string
+	Done() bool
+	// Poll fetches the latest state of the operation. It returns the
+	// service once the operation is done, and nil before that.
+	Poll(ctx context.Context) (*runpb.Service, error)
+}
+
+// RunAdmin changes the configuration of Cloud Run services: scaling, CPU
+// allocation and traffic. Each change is a read-modify-write of the service
+// that waits for the resulting operation to finish.
+type RunAdmin struct {
+	API RunServicesAPI
+	// PollInterval is how often operations and services are polled; 2s if zero.
+	PollInterval time.Duration
+}
+
+// NewRunAdmin returns a RunAdmin backed by api.
+func NewRunAdmin(api RunServicesAPI) *RunAdmin {
+	return &RunAdmin{API: api}
+}
+
+// RunAdmin returns a RunAdmin backed by the shared Cloud Run clients.
+func (c *Clients) RunAdmin() *RunAdmin {
+	return NewRunAdmin(clientsRunAPI{c})
+}
+
+// RunServiceName returns the full resource name of a Cloud Run service.
+func RunServiceName(service string, projectId string, region string) string {
+	return "projects/" + projectId + "/locations/" + region + "/services/" + service
+}
+
+// RunScaling is a scaling change; nil fields are left unchanged.
+type RunScaling struct {
+	MinInstances *int32
+	MaxInstances *int32
+	// Concurrency is the maximum number of concurrent requests per instance.
+	Concurrency *int32
+}
+
+// RunTrafficTarget sends a percentage of traffic to a revision. Set Latest
+// instead of Revision to follow the latest ready revision. A target with
+// a Tag is also reachable at its own tagged URL, even at 0 percent.
+type RunTrafficTarget struct {
+	Revision string
+	Latest   bool
+	Percent  int32
+	Tag      string
+}
+
+// UpdateService applies mutate to the current service, submits the update and
+// waits for the operation to finish. The update carries the etag of the read,
+// so it fails rather than overwriting a concurrent change.
+func (a *RunAdmin) UpdateService(ctx context.Context, service string, projectId string, region string, mutate func(*runpb.Service) error) (*runpb.Service, error) {
+	name := RunServiceName(service, projectId, region)
+	svc, err := a.getService(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service %s: %w", service, err)
+	}
+	if err := mutate(svc); err != nil {
+		return nil, err
+	}
+	op, err := a.API.UpdateService(ctx, svc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update service %s: %w", service, err)
+	}
+	updated, err := a.waitOperation(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("update of service %s failed: %w", service, err)
+	}
+	return updated, nil
+}
+
+// SetScaling changes the instance limits and concurrency of a service's
+// revision template, rolling out a new revision.
+func (a *RunAdmin) SetScaling(ctx context.Context, service string, projectId string, region string, scaling RunScaling) (*runpb.Service, error) {
+	if scaling.MinInstances != nil && scaling.MaxInstances != nil && *scaling.MaxInstances > 0 && *scaling.MinInstances > *scaling.MaxInstances {
+		return nil, fmt.Errorf("min instances %d exceeds max instances %d", *scaling.MinInstances, *scaling.MaxInstances)
+	}
+	return a.UpdateService(ctx, service, projectId, region, func(svc *runpb.Service) error {
+		template := revisionTemplate(svc)
+		if template.Scaling == nil {
+			template.Scaling = &runpb.RevisionScaling{}
+		}
+		if scaling.MinInstances != nil {
+			template.Scaling.MinInstanceCount = *scaling.MinInstances
+		}
+		if scaling.MaxInstances != nil {
+			template.Scaling.MaxInstanceCount = *scaling.MaxInstances
+		}
+		if scaling.Concurrency != nil {
+			template.MaxInstanceRequestConcurrency = *scaling.Concurrency
+		}
+		return nil
+	})
+}
+
+// SetCPUAlwaysAllocated sets whether every container of a service keeps its
+// CPU allocated outside of requests, rolling out a new revision.
+func (a *RunAdmin) SetCPUAlwaysAllocated(ctx context.Context, service string, projectId string, region string, always bool) (*runpb.Service, error) {
+	return a.UpdateService(ctx, service, projectId, region, func(svc *runpb.Service) error {
+		template := revisionTemplate(svc)
+		if len(template.Containers) == 0 {
+			return fmt.Errorf("service %s has no containers", service)
+		}
+		for _, container := range template.Containers {
+			if container.Resources == nil {
+				container.Resources = &runpb.ResourceRequirements{}
+			}
+			container.Resources.CpuIdle = !always
+		}
+		return nil
+	})
+}
+
+// SplitTraffic replaces the traffic split of a service. Percentages must
+// add up to 100.
+func (a *RunAdmin) SplitTraffic(ctx context.Context, service string, projectId string, region string, targets []RunTrafficTarget) (*runpb.Service, error) {
+	traffic, err := trafficTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+	return a.UpdateService(ctx, service, projectId, region, func(svc *runpb.Service) error {
+		svc.Traffic = traffic
+		return nil
+	})
+}
+
+// SplitTrafficByTag sets the percentage of traffic for tagged targets of a
+// service, e.g. {"blue": 90, "green": 10}. Every tag must already exist;
+// untagged targets are dropped and other tags keep 0 percent.
+func (a *RunAdmin) SplitTrafficByTag(ctx context.Context, service string, projectId string, region string, percents map[string]int32) (*runpb.Service, error) {
+	var total int32
+	for _, p := range percents {
+		total += p
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("traffic percentages add up to %d, want 100", total)
+	}
+	return a.UpdateService(ctx, service, projectId, region, func(svc *runpb.Service) error {
+		var traffic []*runpb.TrafficTarget
+		found := map[string]bool{}
+		for _, t := range svc.Traffic {
+			if t.Tag == "" {
+				continue
+			}
+			found[t.Tag] = true
+			t.Percent = percents[t.Tag]
+			traffic = append(traffic, t)
+		}
+		for tag := range percents {
+			if !found[tag] {
+				return fmt.Errorf("service %s has no traffic tag %q", service, tag)
+			}
+		}
+		svc.Traffic = traffic
+		return nil
+	})
+}
+
+// TagRevision adds a traffic tag to a revision of a service without changing
+// the traffic split, so the revision can be reached at its tagged URL.
+func (a *RunAdmin) TagRevision(ctx context.Context, service string, projectId string, region string, revision string, tag string) (*runpb.Service, error) {
+	return a.UpdateService(ctx, service, projectId, region, func(svc *runpb.Service) error {
+		// Move the tag if it is already in use, dropping targets that are
+		// left with neither a tag nor traffic.
+		traffic := svc.Traffic[:0]
+		for _, t := range svc.Traffic {
+			if t.Tag == tag {
+				t.Tag = ""
+			}
+			if t.Tag != "" || t.Percent > 0 {
+				traffic = append(traffic, t)
+			}
+		}
+		svc.Traffic = traffic
+		for _, t := range svc.Traffic {
+			if t.Type == runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_REVISION && parsePath(t.Revision) == revision && t.Tag == "" {
+				t.Tag = tag
+				return nil
+			}
+		}
+		svc.Traffic = append(svc.Traffic, &runpb.TrafficTarget{
+			Type:     runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_REVISION,
+			Revision: revision,
+			Tag:      tag,
+		})
+		return nil
+	})
+}
+
+// RollBack sends all traffic of a service to revision. If revision is empty,
+// it rolls back to the newest ready revision older than the one currently
+// serving the most traffic.
+func (a *RunAdmin) RollBack(ctx context.Context, service string, projectId string, region string, revision string) (*runpb.Service, error) {
+	return a.UpdateService(ctx, service, projectId, region, func(svc *runpb.Service) error {
+		// Pick the target from the same read the update is based on, so a
+		// concurrent rollout fails the update instead of being skipped.
+		target := revision
+		if target == "" {
+			var err error
+			if target, err = a.previousRevision(ctx, svc); err != nil {
+				return err
+			}
+		}
+		// Keep tags pointing at their revisions so tagged URLs survive the
+		// rollback. A tagged target for the revision itself takes the
+		// traffic rather than getting a duplicate untagged target.
+		var rollback *runpb.TrafficTarget
+		var tagged []*runpb.TrafficTarget
+		for _, t := range svc.Traffic {
+			if t.Tag == "" {
+				continue
+			}
+			t.Percent = 0
+			if rollback == nil && t.Type == runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_REVISION && parsePath(t.Revision) == target {
+				t.Percent = 100
+				rollback = t
+				continue
+			}
+			tagged = append(tagged, t)
+		}
+		if rollback == nil {
+			rollback = &runpb.TrafficTarget{
+				Type:     runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_REVISION,
+				Revision: target,
+				Percent:  100,
+			}
+		}
+		svc.Traffic = append([]*runpb.TrafficTarget{rollback}, tagged...)
+		return nil
+	})
+}
+
+// previousRevision finds the rollback target for a service.
+func (a *RunAdmin) previousRevision(ctx context.Context, svc *runpb.Service) (string, error) {
+	service := parsePath(svc.Name)
+	current := servingRevision(svc)
+	if current == "" {
+		return "", fmt.Errorf("service %s has no serving revision", service)
+	}
+
+	revisions, err := retryValue(ctx, "run.ListRevisions", func(ctx context.Context) ([]*runpb.Revision, error) {
+		return a.API.ListRevisions(ctx, svc.Name)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list revisions of %s: %w", service, err)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].CreateTime.AsTime().After(revisions[j].CreateTime.AsTime())
+	})
+	older := false
+	for _, rev := range revisions {
+		revName := parsePath(rev.Name)
+		if revName == current {
+			older = true
+			continue
+		}
+		if older && revisionReady(rev) {
+			return revName, nil
+		}
+	}
+	return "", fmt.Errorf("service %s has no ready revision older than %s", service, current)
+}
+
+// WaitServiceReady polls a service until its latest configuration is ready,
+// returning an error if it fails or ctx is done.
+func (a *RunAdmin) WaitServiceReady(ctx context.Context, service string, projectId string, region string) (*runpb.Service, error) {
+	name := RunServiceName(service, projectId, region)
+	for {
+		svc, err := a.getService(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get service %s: %w", service, err)
+		}
+		ready, err := serviceReady(svc)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service, err)
+		}
+		if ready {
+			return svc, nil
+		}
+		if err := a.sleep(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// getService reads a service, retrying transient failures. Updates are
+// not retried: a retried update could apply twice.
+func (a *RunAdmin) getService(ctx context.Context, name string) (*runpb.Service, error) {
+	return retryValue(ctx, "run.GetService", func(ctx context.Context) (*runpb.Service, error) {
+		return a.API.GetService(ctx, name)
+	})
+}
+
+// waitOperation polls op until it is done.
+func (a *RunAdmin) waitOperation(ctx context.Context, op RunOperation) (*runpb.Service, error) {
+	for {
+		svc, err := retryValue(ctx, "run.PollOperation", op.Poll)
+		if err != nil {
+			return nil, err
+		}
+		if op.Done() {
+			return svc, nil
+		}
+		if err := a.sleep(ctx); err != nil {
+			return nil, fmt.Errorf("waiting for operation %s: %w", op.Name(), err)
+		}
+	}
+}
+
+// sleep waits for the poll interval or until ctx is done.
+func (a *RunAdmin) sleep(ctx context.Context) error {
+	return pollSleep(ctx, a.PollInterval)
+}
+
+// revisionTemplate returns the service's revision template, creating it if needed.
+func revisionTemplate(svc *runpb.Service) *runpb.RevisionTemplate {
+	if svc.Template == nil {
+		svc.Template = &runpb.RevisionTemplate{}
+	}
+	// Revision names must be unique; clear any explicit name so the update
+	// gets a generated one.
+	svc.Template.Revision = ""
+	return svc.Template
+}
+
+// trafficTargets converts and validates a traffic split.
+func trafficTargets(targets []RunTrafficTarget) ([]*runpb.TrafficTarget, error) {
+	var total int32
+	traffic := make([]*runpb.TrafficTarget, 0, len(targets))
+	for _, t := range targets {
+		if t.Percent < 0 || t.Percent > 100 {
+			return nil, fmt.Errorf("traffic percent %d out of range", t.Percent)
+		}
+		if t.Latest == (t.Revision != "") {
+			return nil, errors.New("traffic target needs exactly one of Revision or Latest")
+		}
+		total += t.Percent
+		target := &runpb.TrafficTarget{
+			Type:     runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_REVISION,
+			Revision: t.Revision,
+			Percent:  t.Percent,
+			Tag:      t.Tag,
+		}
+		if t.Latest {
+			target.Type = runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_LATEST
+		}
+		traffic = append(traffic, target)
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("traffic percentages add up to %d, want 100", total)
+	}
+	return traffic, nil
+}
+
+// servingRevision returns the revision receiving the most traffic.
+func servingRevision(svc *runpb.Service) string {
+	var best string
+	var bestPercent int32
+	for _, t := range svc.TrafficStatuses {
+		if t.Percent <= bestPercent {
+			continue
+		}
+		rev := t.Revision
+		if t.Type == runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_LATEST && rev == "" {
+			rev = svc.LatestReadyRevision
+		}
+		if rev != "" {
+			best, bestPercent = parsePath(rev), t.Percent
+		}
+	}
+	return best
+}
+
+// revisionReady reports whether a revision finished deploying successfully.
+func revisionReady(rev *runpb.Revision) bool {
+	for _, c := range rev.Conditions {
+		if c.Type == "Ready" {
+			return c.State == runpb.Condition_CONDITION_SUCCEEDED
+		}
+	}
+	return false
+}
+
+// serviceReady reports whether a service has finished reconciling its
+// latest configuration, returning an error if that failed.
+func serviceReady(svc *runpb.Service) (bool, error) {
+	if svc.Reconciling || svc.ObservedGeneration < svc.Generation || svc.TerminalCondition == nil {
+		return false, nil
+	}
+	switch svc.TerminalCondition.State {
+	case runpb.Condition_CONDITION_SUCCEEDED:
+		return true, nil
+	case runpb.Condition_CONDITION_FAILED:
+		return false, fmt.Errorf("not ready: %s", svc.TerminalCondition.Message)
+	}
+	return false, nil
+}
+
+// clientsRunAPI implements RunServicesAPI with the shared Cloud Run clients.
+type clientsRunAPI struct {
+	c *Clients
+}
+
+func (a clientsRunAPI) GetService(ctx context.Context, name string) (*runpb.Service, error) {
+	client, err := a.c.RunServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetService(ctx, &runpb.GetServiceRequest{Name: name}, noGaxRetry)
+}
+
+func (a clientsRunAPI) UpdateService(ctx context.Context, service *runpb.Service) (RunOperation, error) {
+	client, err := a.c.RunServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	op, err := client.UpdateService(ctx, &runpb.UpdateServiceRequest{Service: service})
+	if err != nil {
+		return nil, err
+	}
+	return runServiceOperation{op}, nil
+}
+
+func (a clientsRunAPI) ListRevisions(ctx context.Context, service string) ([]*runpb.Revision, error) {
+	client, err := a.c.RunRevisions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return collect(client.ListRevisions(ctx, &runpb.ListRevisionsRequest{Parent: service}, noGaxRetry).Next)
+}
+
+// collect drains a Google API iterator into a slice.
+func collect[T any](next func() (T, error)) ([]T, error) {
+	var items []T
+	for {
+		item, err := next()
+		if err == iterator.Done {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// runServiceOperation adapts *run.UpdateServiceOperation to RunOperation.
+type runServiceOperation struct {
+	op *run.UpdateServiceOperation
+}
+
+func (o runServiceOperation) Name() string { return o.op.Name() }
+func (o runServiceOperation) Done() bool   { return o.op.Done() }
+func (o runServiceOperation) Poll(ctx context.Context) (*runpb.Service, error) {
+	return o.op.Poll(ctx, noGaxRetry)
+}
@@ -0,0 +1,284 @@
+package gcputils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const testServiceName = "projects/p/locations/us-central1/services/hello"
+
+// fakeRunAPI is an in-memory RunServicesAPI holding a single service.
+type fakeRunAPI struct {
+	service   *runpb.Service
+	revisions []*runpb.Revision
+	// opPolls is how many polls an update takes to finish.
+	opPolls int
+	updates []*runpb.Service
+	gets    int
+	// onGet, if set, can change the service before each read returns.
+	onGet func(*runpb.Service)
+}
+
+func (f *fakeRunAPI) GetService(ctx context.Context, name string) (*runpb.Service, error) {
+	f.gets++
+	if f.onGet != nil {
+		f.onGet(f.service)
+	}
+	return proto.Clone(f.service).(*runpb.Service), nil
+}
+
+func (f *fakeRunAPI) UpdateService(ctx context.Context, service *runpb.Service) (RunOperation, error) {
+	f.updates = append(f.updates, service)
+	f.service = proto.Clone(service).(*runpb.Service)
+	return &fakeRunOperation{service: service, remaining: f.opPolls}, nil
+}
+
+func (f *fakeRunAPI) ListRevisions(ctx context.Context, service string) ([]*runpb.Revision, error) {
+	return f.revisions, nil
+}
+
+// fakeRunOperation completes after a fixed number of polls.
+type fakeRunOperation struct {
+	service   *runpb.Service
+	remaining int
+	polls     int
+}
+
+func (o *fakeRunOperation) Name() string { return "operations/fake" }
+func (o *fakeRunOperation) Done() bool   { return o.remaining <= 0 }
+func (o *fakeRunOperation) Poll(ctx context.Context) (*runpb.Service, error) {
+	o.polls++
+	o.remaining--
+	if o.Done() {
+		return o.service, nil
+	}
+	return nil, nil
+}
+
+func newFakeRunAdmin() (*RunAdmin, *fakeRunAPI) {
+	api := &fakeRunAPI{
+		service: &runpb.Service{
+			Name: testServiceName,
+			Template: &runpb.RevisionTemplate{
+				Revision:   "hello-v1",
+				Containers: []*runpb.Container{{Image: "gcr.io/p/hello"}},
+			},
+			Traffic: []*runpb.TrafficTarget{{
+				Type:    runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_LATEST,
+				Percent: 100,
+			}},
+		},
+		opPolls: 3,
+	}
+	return &RunAdmin{API: api, PollInterval: time.Millisecond}, api
+}
+
+func int32p(v int32) *int32 { return &v }
+
+func TestRunAdminSetScaling(t *testing.T) {
+	admin, api := newFakeRunAdmin()
+	svc, err := admin.SetScaling(context.Background(), "hello", "p", "us-central1", RunScaling{
+		MinInstances: int32p(1),
+		Concurrency:  int32p(20),
+	})
+	if err != nil {
+		t.Fatalf("SetScaling() error = %v", err)
+	}
+	if svc == nil || len(api.updates) != 1 {
+		t.Fatalf("SetScaling() = %v with %d updates, want the updated service", svc, len(api.updates))
+	}
+	template := api.updates[0].Template
+	if template.Scaling.MinInstanceCount != 1 || template.Scaling.MaxInstanceCount != 0 || template.MaxInstanceRequestConcurrency != 20 {
+		t.Errorf("template = %v", template)
+	}
+	if template.Revision != "" {
+		t.Errorf("Revision = %q, want cleared so a new name is generated", template.Revision)
+	}
+
+	if _, err := admin.SetScaling(context.Background(), "hello", "p", "us-central1", RunScaling{
+		MinInstances: int32p(5), MaxInstances: int32p(2),
+	}); err == nil {
+		t.Error("SetScaling(min > max) error = nil")
+	}
+}
+
+func TestRunAdminSetCPUAlwaysAllocated(t *testing.T) {
+	admin, api := newFakeRunAdmin()
+	if _, err := admin.SetCPUAlwaysAllocated(context.Background(), "hello", "p", "us-central1", true); err != nil {
+		t.Fatalf("SetCPUAlwaysAllocated() error = %v", err)
+	}
+	if res := api.service.Template.Containers[0].Resources; res == nil || res.CpuIdle {
+		t.Errorf("Resources = %v, want CpuIdle false", res)
+	}
+}
+
+func TestRunAdminSplitTraffic(t *testing.T) {
+	admin, api := newFakeRunAdmin()
+	_, err := admin.SplitTraffic(context.Background(), "hello", "p", "us-central1", []RunTrafficTarget{
+		{Revision: "hello-v1", Percent: 90, Tag: "blue"},
+		{Latest: true, Percent: 10, Tag: "green"},
+	})
+	if err != nil {
+		t.Fatalf("SplitTraffic() error = %v", err)
+	}
+	if len(api.service.Traffic) != 2 || api.service.Traffic[1].Type != runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_LATEST {
+		t.Errorf("Traffic = %v", api.service.Traffic)
+	}
+
+	// Shift traffic between the tags.
+	if _, err := admin.SplitTrafficByTag(context.Background(), "hello", "p", "us-central1", map[string]int32{"blue": 0, "green": 100}); err != nil {
+		t.Fatalf("SplitTrafficByTag() error = %v", err)
+	}
+	if api.service.Traffic[0].Percent != 0 || api.service.Traffic[1].Percent != 100 {
+		t.Errorf("Traffic = %v after shift", api.service.Traffic)
+	}
+
+	invalid := [][]RunTrafficTarget{
+		{{Revision: "a", Percent: 50}},
+		{{Revision: "a", Latest: true, Percent: 100}},
+		{{Percent: 100}},
+		{{Revision: "a", Percent: 120}, {Revision: "b", Percent: -20}},
+	}
+	for _, targets := range invalid {
+		if _, err := admin.SplitTraffic(context.Background(), "hello", "p", "us-central1", targets); err == nil {
+			t.Errorf("SplitTraffic(%v) error = nil", targets)
+		}
+	}
+	if _, err := admin.SplitTrafficByTag(context.Background(), "hello", "p", "us-central1", map[string]int32{"missing": 100}); err == nil {
+		t.Error("SplitTrafficByTag(unknown tag) error = nil")
+	}
+}
+
+func TestRunAdminTagRevision(t *testing.T) {
+	admin, api := newFakeRunAdmin()
+	for _, rev := range []string{"hello-v1", "hello-v2"} {
+		if _, err := admin.TagRevision(context.Background(), "hello", "p", "us-central1", rev, "canary"); err != nil {
+			t.Fatalf("TagRevision() error = %v", err)
+		}
+	}
+	// The tag moved to hello-v2; the untagged 0% target for hello-v1 is gone.
+	traffic := api.service.Traffic
+	if len(traffic) != 2 || traffic[1].Revision != "hello-v2" || traffic[1].Tag != "canary" || traffic[1].Percent != 0 {
+		t.Errorf("Traffic = %v", traffic)
+	}
+}
+
+func TestRunAdminRollBack(t *testing.T) {
+	admin, api := newFakeRunAdmin()
+	api.service.LatestReadyRevision = testServiceName + "/revisions/hello-v3"
+	api.service.TrafficStatuses = []*runpb.TrafficTargetStatus{{
+		Type:    runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_LATEST,
+		Percent: 100,
+	}}
+	api.service.Traffic = append(api.service.Traffic, &runpb.TrafficTarget{
+		Type: runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_REVISION, Revision: "hello-v3", Tag: "latest",
+	})
+	now := time.Now()
+	revision := func(name string, age time.Duration, ready bool) *runpb.Revision {
+		state := runpb.Condition_CONDITION_FAILED
+		if ready {
+			state = runpb.Condition_CONDITION_SUCCEEDED
+		}
+		return &runpb.Revision{
+			Name:       testServiceName + "/revisions/" + name,
+			CreateTime: timestamppb.New(now.Add(-age)),
+			Conditions: []*runpb.Condition{{Type: "Ready", State: state}},
+		}
+	}
+	api.revisions = []*runpb.Revision{
+		revision("hello-v1", 3*time.Hour, true),
+		revision("hello-v4", 0, true),
+		revision("hello-v3", time.Hour, true),
+		revision("hello-v2", 2*time.Hour, false),
+	}
+
+	if _, err := admin.RollBack(context.Background(), "hello", "p", "us-central1", ""); err != nil {
+		t.Fatalf("RollBack() error = %v", err)
+	}
+	// hello-v2 never became ready, so the rollback skips it.
+	traffic := api.service.Traffic
+	if len(traffic) != 2 || traffic[0].Revision != "hello-v1" || traffic[0].Percent != 100 {
+		t.Errorf("Traffic = %v, want 100%% to hello-v1", traffic)
+	}
+	if traffic[1].Tag != "latest" || traffic[1].Percent != 0 {
+		t.Errorf("tagged target = %v, want kept at 0%%", traffic[1])
+	}
+	// The target is picked from the read the update is based on.
+	if api.gets != 1 {
+		t.Errorf("read the service %d times, want 1", api.gets)
+	}
+}
+
+func TestRunAdminRollBackToTaggedRevision(t *testing.T) {
+	admin, api := newFakeRunAdmin()
+	api.service.Traffic = append(api.service.Traffic,
+		&runpb.TrafficTarget{Type: runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_REVISION, Revision: "hello-v1", Tag: "stable"},
+		&runpb.TrafficTarget{Type: runpb.TrafficTargetAllocationType_TRAFFIC_TARGET_ALLOCATION_TYPE_REVISION, Revision: "hello-v2", Tag: "canary", Percent: 10},
+	)
+
+	if _, err := admin.RollBack(context.Background(), "hello", "p", "us-central1", "hello-v1"); err != nil {
+		t.Fatalf("RollBack() error = %v", err)
+	}
+	// The tagged target for hello-v1 takes the traffic; no second target is added.
+	traffic := api.service.Traffic
+	if len(traffic) != 2 {
+		t.Fatalf("Traffic = %v, want 2 targets", traffic)
+	}
+	if traffic[0].Revision != "hello-v1" || traffic[0].Tag != "stable" || traffic[0].Percent != 100 {
+		t.Errorf("rollback target = %v, want 100%% to the stable tag", traffic[0])
+	}
+	if traffic[1].Tag != "canary" || traffic[1].Percent != 0 {
+		t.Errorf("tagged target = %v, want kept at 0%%", traffic[1])
+	}
+}
+
+func TestRunAdminWaitServiceReady(t *testing.T) {
+	admin, api := newFakeRunAdmin()
+	api.service.Generation = 2
+	api.service.ObservedGeneration = 1
+	api.service.Reconciling = true
+	// The service finishes reconciling on the third read.
+	api.onGet = func(svc *runpb.Service) {
+		if api.gets == 3 {
+			svc.Reconciling = false
+			svc.ObservedGeneration = 2
+			svc.TerminalCondition = &runpb.Condition{State: runpb.Condition_CONDITION_SUCCEEDED}
+		}
+	}
+	if _, err := admin.WaitServiceReady(context.Background(), "hello", "p", "us-central1"); err != nil {
+		t.Fatalf("WaitServiceReady() error = %v", err)
+	}
+	if api.gets != 3 {
+		t.Errorf("polled %d times, want 3", api.gets)
+	}
+
+	api.service.TerminalCondition = &runpb.Condition{State: runpb.Condition_CONDITION_FAILED, Message: "image not found"}
+	if _, err := admin.WaitServiceReady(context.Background(), "hello", "p", "us-central1"); err == nil || !strings.Contains(err.Error(), "image not found") {
+		t.Errorf("WaitServiceReady() error = %v, want the failure message", err)
+	}
+
+	api.service.Reconciling = true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := admin.WaitServiceReady(ctx, "hello", "p", "us-central1"); err == nil {
+		t.Error("WaitServiceReady() error = nil after deadline")
+	}
+}
+
+func TestRunAdminWaitsForOperation(t *testing.T) {
+	admin, api := newFakeRunAdmin()
+	api.opPolls = 5
+	op, _ := api.UpdateService(context.Background(), api.service)
+	if _, err := admin.waitOperation(context.Background(), op); err != nil {
+		t.Fatalf("waitOperation() error = %v", err)
+	}
+	if polls := op.(*fakeRunOperation).polls; polls != 5 {
+		t.Errorf("polled %d times, want 5", polls)
+	}
+}
@@ -0,0 +1,347 @@
+package gcputils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	run "cloud.google.com/go/run/apiv2"
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RunJobsAPI is the subset of the Cloud Run Admin API used by JobRunner.
+// Clients.JobRunner implements it with the shared Cloud Run clients; tests
+// can substitute a fake.
+type RunJobsAPI interface {
+	// ListJobs lists the jobs in a projects/*/locations/* parent.
+	ListJobs(ctx context.Context, parent string) ([]*runpb.Job, error)
+	// RunJob starts an execution and returns it without waiting for it to finish.
+	RunJob(ctx context.Context, req *runpb.RunJobRequest) (*runpb.Execution, error)
+	// GetExecution fetches an execution by its full resource name.
+	GetExecution(ctx context.Context, name string) (*runpb.Execution, error)
+	// ListTasks lists the tasks of an execution, by full execution name.
+	ListTasks(ctx context.Context, execution string) ([]*runpb.Task, error)
+}
+
+// JobRunner starts and monitors Cloud Run job executions.
+type JobRunner struct {
+	API RunJobsAPI
+	// PollInterval is how often executions are polled; 2s if zero.
+	PollInterval time.Duration
+}
+
+// NewJobRunner returns a JobRunner backed by api.
+func NewJobRunner(api RunJobsAPI) *JobRunner {
+	return &JobRunner{API: api}
+}
+
+// JobRunner returns a JobRunner backed by the shared Cloud Run clients.
+func (c *Clients) JobRunner() *JobRunner {
+	return NewJobRunner(clientsJobsAPI{c})
+}
+
+// RunJobName returns the full resource name of a Cloud Run job.
+func RunJobName(job string, projectId string, region string) string {
+	return "projects/" + projectId + "/locations/" + region + "/jobs/" + job
+}
+
+// RunJobOverrides changes the configuration of a single execution.
+// Zero fields keep the job's configuration.
+type RunJobOverrides struct {
+	// Container is the container the args and env apply to; the job's
+	// only container if empty.
+	Container string
+	// Args replaces the container arguments.
+	Args []string
+	// Env adds to or replaces the container environment variables.
+	Env map[string]string
+	// TaskCount is the number of tasks to run.
+	TaskCount int32
+	// Timeout is the per-task timeout.
+	Timeout time.Duration
+}
+
+// RunExecutionStatus is a snapshot of the progress of an execution.
+type RunExecutionStatus struct {
+	Name      string
+	TaskCount int32
+	Running   int32
+	Succeeded int32
+	Failed    int32
+	Cancelled int32
+	Retried   int32
+	// Done is set once the execution has completed, successfully or not.
+	Done           bool
+	CompletionTime time.Time
+	// Err is set on the last status sent by WatchExecution if polling failed.
+	Err error
+}
+
+// Success reports whether the execution completed with every task succeeding.
+func (s RunExecutionStatus) Success() bool {
+	return s.Done && s.Failed == 0 && s.Cancelled == 0 && s.Succeeded == s.TaskCount
+}
+
+// RunTaskResult is the outcome of the last attempt of a task.
+type RunTaskResult struct {
+	Index int32
+	// Done is false if the task has not finished an attempt yet.
+	Done     bool
+	ExitCode int32
+	// Message explains a failure, e.g. a timeout or a signal.
+	Message string
+	Retried int32
+}
+
+// ListJobs lists the Cloud Run jobs in a project and region.
+func (r *JobRunner) ListJobs(ctx context.Context, projectId string, region string) ([]*runpb.Job, error) {
+	parent := "projects/" + projectId + "/locations/" + region
+	jobs, err := retryValue(ctx, "run.ListJobs", func(ctx context.Context) ([]*runpb.Job, error) {
+		return r.API.ListJobs(ctx, parent)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// RunJob starts an execution of a job, optionally with overrides, and
+// returns it as soon as it has been created. It is not retried, as a retry
+// could start a second execution.
+func (r *JobRunner) RunJob(ctx context.Context, job string, projectId string, region string, overrides *RunJobOverrides) (*runpb.Execution, error) {
+	req := &runpb.RunJobRequest{Name: RunJobName(job, projectId, region)}
+	if overrides != nil {
+		req.Overrides = overrides.proto()
+	}
+	exec, err := r.API.RunJob(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run job %s: %w", job, err)
+	}
+	return exec, nil
+}
+
+// WatchExecution polls an execution and sends its status whenever it
+// changes. The channel is closed once the execution is done, when polling
+// fails (the last status then has Err set) or when ctx is done.
+func (r *JobRunner) WatchExecution(ctx context.Context, execution string) <-chan RunExecutionStatus {
+	ch := make(chan RunExecutionStatus)
+	go func() {
+		defer close(ch)
+		var last *RunExecutionStatus
+		for {
+			exec, err := retryValue(ctx, "run.GetExecution", func(ctx context.Context) (*runpb.Execution, error) {
+				return r.API.GetExecution(ctx, execution)
+			})
+			var status RunExecutionStatus
+			if err != nil {
+				status = RunExecutionStatus{Name: execution, Err: fmt.Errorf("failed to get execution: %w", err)}
+			} else {
+				status = newRunExecutionStatus(exec)
+			}
+			if last == nil || status != *last {
+				select {
+				case ch <- status:
+				case <-ctx.Done():
+					return
+				}
+				last = &status
+			}
+			if status.Done || status.Err != nil {
+				return
+			}
+			if pollSleep(ctx, r.PollInterval) != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// WaitExecution waits for an execution to complete and returns its final status.
+func (r *JobRunner) WaitExecution(ctx context.Context, execution string) (RunExecutionStatus, error) {
+	var last RunExecutionStatus
+	for status := range r.WatchExecution(ctx, execution) {
+		last = status
+	}
+	if last.Err != nil {
+		return last, last.Err
+	}
+	if !last.Done {
+		return last, ctx.Err()
+	}
+	return last, nil
+}
+
+// TaskResults returns the result of every task of an execution, ordered by
+// task index.
+func (r *JobRunner) TaskResults(ctx context.Context, execution string) ([]RunTaskResult, error) {
+	tasks, err := retryValue(ctx, "run.ListTasks", func(ctx context.Context) ([]*runpb.Task, error) {
+		return r.API.ListTasks(ctx, execution)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	results := make([]RunTaskResult, 0, len(tasks))
+	for _, task := range tasks {
+		result := RunTaskResult{Index: task.Index, Retried: task.Retried}
+		if attempt := task.LastAttemptResult; attempt != nil {
+			result.Done = true
+			result.ExitCode = attempt.ExitCode
+			if attempt.Status != nil {
+				result.Message = attempt.Status.Message
+			}
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	return results, nil
+}
+
+// proto converts the overrides to their API representation.
+func (o *RunJobOverrides) proto() *runpb.RunJobRequest_Overrides {
+	overrides := &runpb.RunJobRequest_Overrides{TaskCount: o.TaskCount}
+	if o.Timeout > 0 {
+		overrides.Timeout = durationpb.New(o.Timeout)
+	}
+	if len(o.Args) > 0 || len(o.Env) > 0 {
+		container := &runpb.RunJobRequest_Overrides_ContainerOverride{Name: o.Container, Args: o.Args}
+		names := make([]string, 0, len(o.Env))
+		for name := range o.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			container.Env = append(container.Env, &runpb.EnvVar{
+				Name:   name,
+				Values: &runpb.EnvVar_Value{Value: o.Env[name]},
+			})
+		}
+		overrides.ContainerOverrides = []*runpb.RunJobRequest_Overrides_ContainerOverride{container}
+	}
+	return overrides
+}
+
+// newRunExecutionStatus summarizes an execution.
+func newRunExecutionStatus(exec *runpb.Execution) RunExecutionStatus {
+	status := RunExecutionStatus{
+		Name:      exec.Name,
+		TaskCount: exec.TaskCount,
+		Running:   exec.RunningCount,
+		Succeeded: exec.SucceededCount,
+		Failed:    exec.FailedCount,
+		Cancelled: exec.CancelledCount,
+		Retried:   exec.RetriedCount,
+	}
+	if exec.CompletionTime != nil {
+		status.Done = true
+		status.CompletionTime = exec.CompletionTime.AsTime().Local()
+	}
+	return status
+}
+
+// pollSleep waits for interval (2s if zero) or until ctx is done.
+func pollSleep(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultRunPollInterval
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(interval):
+		return nil
+	}
+}
+
+// RunJobTask identifies the Cloud Run job task the process is running as.
+type RunJobTask struct {
+	Job       string
+	Execution string
+	// Index is the index of this task, from 0 to Count-1.
+	Index int
+	Count int
+	// Attempt is the number of times this task has been retried, from 0.
+	Attempt int
+}
+
+// GetRunJobTask returns the Cloud Run job task the process is running as,
+// and false if it isn't running in a Cloud Run job.
+func GetRunJobTask() (RunJobTask, bool) {
+	job := os.Getenv("CLOUD_RUN_JOB")
+	if job == "" {
+		return RunJobTask{}, false
+	}
+	task := RunJobTask{Job: job, Execution: os.Getenv("CLOUD_RUN_EXECUTION"), Count: 1}
+	task.Index, _ = strconv.Atoi(os.Getenv("CLOUD_RUN_TASK_INDEX"))
+	if count, err := strconv.Atoi(os.Getenv("CLOUD_RUN_TASK_COUNT")); err == nil {
+		task.Count = count
+	}
+	task.Attempt, _ = strconv.Atoi(os.Getenv("CLOUD_RUN_TASK_ATTEMPT"))
+	return task, true
+}
+
+// clientsJobsAPI implements RunJobsAPI with the shared Cloud Run clients.
+type clientsJobsAPI struct {
+	c *Clients
+}
+
+func (a clientsJobsAPI) ListJobs(ctx context.Context, parent string) ([]*runpb.Job, error) {
+	client, err := a.c.RunJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return collect(client.ListJobs(ctx, &runpb.ListJobsRequest{Parent: parent}, noGaxRetry).Next)
+}
+
+func (a clientsJobsAPI) RunJob(ctx context.Context, req *runpb.RunJobRequest) (*runpb.Execution, error) {
+	client, err := a.c.RunJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	op, err := client.RunJob(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return startedExecution(ctx, op)
+}
+
+// startedExecution returns the execution created by a RunJob operation,
+// which is in the operation metadata long before the operation is done.
+func startedExecution(ctx context.Context, op *run.RunJobOperation) (*runpb.Execution, error) {
+	for {
+		exec, err := op.Metadata()
+		if err == nil && exec != nil && exec.Name != "" {
+			return exec, nil
+		}
+		done, err := op.Poll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if op.Done() {
+			// The execution finished before we saw the metadata.
+			return done, nil
+		}
+		if err := pollSleep(ctx, time.Second); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (a clientsJobsAPI) GetExecution(ctx context.Context, name string) (*runpb.Execution, error) {
+	client, err := a.c.RunExecutions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetExecution(ctx, &runpb.GetExecutionRequest{Name: name}, noGaxRetry)
+}
+
+func (a clientsJobsAPI) ListTasks(ctx context.Context, execution string) ([]*runpb.Task, error) {
+	client, err := a.c.RunTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return collect(client.ListTasks(ctx, &runpb.ListTasksRequest{Parent: execution}, noGaxRetry).Next)
+}
@@ -0,0 +1,189 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const testExecutionName = "projects/p/locations/us-central1/jobs/burst/executions/burst-x7k2p"
+
+// fakeJobsAPI is an in-memory RunJobsAPI. GetExecution returns the next of
+// a scripted sequence of executions, repeating the last one.
+type fakeJobsAPI struct {
+	runReq     *runpb.RunJobRequest
+	executions []*runpb.Execution
+	getErr     error
+	tasks      []*runpb.Task
+	gets       int
+	runs       int
+	runErr     error
+	// listTasksErrs are returned by the first calls to ListTasks.
+	listTasksErrs []error
+}
+
+func (f *fakeJobsAPI) ListJobs(ctx context.Context, parent string) ([]*runpb.Job, error) {
+	return []*runpb.Job{{Name: parent + "/jobs/burst"}}, nil
+}
+
+func (f *fakeJobsAPI) RunJob(ctx context.Context, req *runpb.RunJobRequest) (*runpb.Execution, error) {
+	f.runReq = req
+	f.runs++
+	if f.runErr != nil {
+		return nil, f.runErr
+	}
+	return &runpb.Execution{Name: testExecutionName}, nil
+}
+
+func (f *fakeJobsAPI) GetExecution(ctx context.Context, name string) (*runpb.Execution, error) {
+	f.gets++
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	i := min(f.gets, len(f.executions)) - 1
+	return f.executions[i], nil
+}
+
+func (f *fakeJobsAPI) ListTasks(ctx context.Context, execution string) ([]*runpb.Task, error) {
+	if len(f.listTasksErrs) > 0 {
+		err := f.listTasksErrs[0]
+		f.listTasksErrs = f.listTasksErrs[1:]
+		return nil, err
+	}
+	return f.tasks, nil
+}
+
+func TestJobRunnerRunJob(t *testing.T) {
+	api := &fakeJobsAPI{}
+	runner := &JobRunner{API: api}
+	exec, err := runner.RunJob(context.Background(), "burst", "p", "us-central1", &RunJobOverrides{
+		Args:      []string{"--rps", "500"},
+		Env:       map[string]string{"TARGET_URL": "https://svc", "DURATION_S": "60"},
+		TaskCount: 4,
+		Timeout:   10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("RunJob() error = %v", err)
+	}
+	if exec.Name != testExecutionName {
+		t.Errorf("execution = %q", exec.Name)
+	}
+
+	req := api.runReq
+	if req.Name != "projects/p/locations/us-central1/jobs/burst" {
+		t.Errorf("job name = %q", req.Name)
+	}
+	if req.Overrides.TaskCount != 4 || req.Overrides.Timeout.AsDuration() != 10*time.Minute {
+		t.Errorf("overrides = %v", req.Overrides)
+	}
+	container := req.Overrides.ContainerOverrides[0]
+	if len(container.Args) != 2 || len(container.Env) != 2 || container.Env[0].Name != "DURATION_S" || container.Env[0].GetValue() != "60" {
+		t.Errorf("container override = %v", container)
+	}
+
+	// Without overrides the job runs as configured.
+	if _, err := runner.RunJob(context.Background(), "burst", "p", "us-central1", nil); err != nil {
+		t.Fatalf("RunJob() error = %v", err)
+	}
+	if api.runReq.Overrides != nil {
+		t.Errorf("overrides = %v, want nil", api.runReq.Overrides)
+	}
+}
+
+func TestJobRunnerWatchExecution(t *testing.T) {
+	running := &runpb.Execution{Name: testExecutionName, TaskCount: 2, RunningCount: 2}
+	api := &fakeJobsAPI{executions: []*runpb.Execution{
+		running,
+		running,
+		{Name: testExecutionName, TaskCount: 2, RunningCount: 1, SucceededCount: 1},
+		{Name: testExecutionName, TaskCount: 2, SucceededCount: 2, CompletionTime: timestamppb.Now()},
+	}}
+	runner := &JobRunner{API: api, PollInterval: time.Millisecond}
+
+	var statuses []RunExecutionStatus
+	for status := range runner.WatchExecution(context.Background(), testExecutionName) {
+		statuses = append(statuses, status)
+	}
+	// The unchanged second poll is not sent.
+	if len(statuses) != 3 {
+		t.Fatalf("got %d statuses, want 3: %+v", len(statuses), statuses)
+	}
+	final := statuses[2]
+	if !final.Done || !final.Success() || final.CompletionTime.IsZero() {
+		t.Errorf("final status = %+v, want successful completion", final)
+	}
+	if statuses[0].Success() {
+		t.Error("running execution reported success")
+	}
+}
+
+func TestJobRunnerWaitExecution(t *testing.T) {
+	api := &fakeJobsAPI{executions: []*runpb.Execution{
+		{Name: testExecutionName, TaskCount: 2, SucceededCount: 1, FailedCount: 1, CompletionTime: timestamppb.Now()},
+	}}
+	runner := &JobRunner{API: api, PollInterval: time.Millisecond}
+	status, err := runner.WaitExecution(context.Background(), testExecutionName)
+	if err != nil || !status.Done || status.Success() {
+		t.Errorf("WaitExecution() = %+v, %v; want a failed completion", status, err)
+	}
+
+	api.getErr = errors.New("permission denied")
+	if _, err := runner.WaitExecution(context.Background(), testExecutionName); err == nil {
+		t.Error("WaitExecution() error = nil, want the polling error")
+	}
+
+	api.getErr = nil
+	api.executions = []*runpb.Execution{{Name: testExecutionName, TaskCount: 1, RunningCount: 1}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := runner.WaitExecution(ctx, testExecutionName); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitExecution() error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestJobRunnerTaskResults(t *testing.T) {
+	api := &fakeJobsAPI{tasks: []*runpb.Task{
+		{Index: 2},
+		{Index: 1, Retried: 3, LastAttemptResult: &runpb.TaskAttemptResult{
+			ExitCode: 137, Status: &status.Status{Code: 4, Message: "Task timed out"},
+		}},
+		{Index: 0, LastAttemptResult: &runpb.TaskAttemptResult{Status: &status.Status{}}},
+	}}
+	results, err := (&JobRunner{API: api}).TaskResults(context.Background(), testExecutionName)
+	if err != nil {
+		t.Fatalf("TaskResults() error = %v", err)
+	}
+	want := []RunTaskResult{
+		{Index: 0, Done: true},
+		{Index: 1, Done: true, ExitCode: 137, Message: "Task timed out", Retried: 3},
+		{Index: 2},
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("results[%d] = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+}
+
+func TestGetRunJobTask(t *testing.T) {
+	t.Setenv("CLOUD_RUN_JOB", "")
+	if _, ok := GetRunJobTask(); ok {
+		t.Error("GetRunJobTask() ok outside a job")
+	}
+
+	t.Setenv("CLOUD_RUN_JOB", "burst")
+	t.Setenv("CLOUD_RUN_EXECUTION", "burst-x7k2p")
+	t.Setenv("CLOUD_RUN_TASK_INDEX", "3")
+	t.Setenv("CLOUD_RUN_TASK_COUNT", "10")
+	t.Setenv("CLOUD_RUN_TASK_ATTEMPT", "1")
+	task, ok := GetRunJobTask()
+	want := RunJobTask{Job: "burst", Execution: "burst-x7k2p", Index: 3, Count: 10, Attempt: 1}
+	if !ok || task != want {
+		t.Errorf("GetRunJobTask() = %+v, %v; want %+v", task, ok, want)
+	}
+}
@@ -0,0 +1,123 @@
+// Package gkeutils lists GKE clusters across projects and builds client-go
+// configurations for them.
+package gkeutils
+
+import (
+	"strings"
+	"time"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+)
+
+// Cluster is a GKE cluster, with what is needed to describe it and to connect to it.
+type Cluster struct {
+	ProjectID string `json:"projectId"`
+	Name      string `json:"name"`
+	// Location is the region or zone of the cluster.
+	Location string `json:"location"`
+	Status   string `json:"status"`
+	// StatusMessage explains the status, e.g. while the cluster is degraded.
+	StatusMessage string `json:"statusMessage,omitempty"`
+
+	// Endpoint is the IP address of the control plane.
+	Endpoint string `json:"endpoint"`
+	// DNSEndpoint is the DNS-based control plane endpoint, if enabled.
+	DNSEndpoint string `json:"dnsEndpoint,omitempty"`
+	// CACertificate is the base64-encoded cluster CA certificate.
+	CACertificate string `json:"-"`
+
+	MasterVersion  string `json:"masterVersion"`
+	NodeVersion    string `json:"nodeVersion,omitempty"`
+	Autopilot      bool   `json:"autopilot"`
+	ReleaseChannel string `json:"releaseChannel,omitempty"`
+	NodeCount      int32  `json:"nodeCount"`
+
+	Network    string            `json:"network,omitempty"`
+	Subnetwork string            `json:"subnetwork,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	NodePools  []NodePool        `json:"nodePools,omitempty"`
+	CreateTime time.Time         `json:"createTime"`
+}
+
+// NodePool is a node pool of a GKE cluster.
+type NodePool struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Version     string `json:"version"`
+	MachineType string `json:"machineType"`
+	// Spot is set for Spot and legacy preemptible VMs.
+	Spot bool `json:"spot"`
+	// InitialNodeCount is the node count per zone the pool was created with.
+	InitialNodeCount int32    `json:"initialNodeCount"`
+	Autoscaling      bool     `json:"autoscaling"`
+	MinNodes         int32    `json:"minNodes,omitempty"`
+	MaxNodes         int32    `json:"maxNodes,omitempty"`
+	Locations        []string `json:"locations,omitempty"`
+}
+
+// ID returns a key that identifies the cluster across projects:
+// projects/<project>/locations/<location>/clusters/<name>.
+func (c Cluster) ID() string {
+	return "projects/" + c.ProjectID + "/locations/" + c.Location + "/clusters/" + c.Name
+}
+
+// Running reports whether the cluster is serving.
+func (c Cluster) Running() bool {
+	return c.Status == containerpb.Cluster_RUNNING.String()
+}
+
+// newCluster converts a cluster from the GKE API.
+func newCluster(projectID string, c *containerpb.Cluster) Cluster {
+	cluster := Cluster{
+		ProjectID:     projectID,
+		Name:          c.Name,
+		Location:      c.Location,
+		Status:        c.Status.String(),
+		StatusMessage: c.StatusMessage,
+		Endpoint:      c.Endpoint,
+		MasterVersion: c.CurrentMasterVersion,
+		NodeVersion:   c.CurrentNodeVersion,
+		Autopilot:     c.Autopilot.GetEnabled(),
+		NodeCount:     c.CurrentNodeCount,
+		Network:       c.Network,
+		Subnetwork:    c.Subnetwork,
+		Labels:        c.ResourceLabels,
+	}
+	if c.MasterAuth != nil {
+		cluster.CACertificate = c.MasterAuth.ClusterCaCertificate
+	}
+	if dns := c.ControlPlaneEndpointsConfig.GetDnsEndpointConfig(); dns != nil {
+		cluster.DNSEndpoint = dns.Endpoint
+	}
+	if ch := c.ReleaseChannel.GetChannel(); ch != containerpb.ReleaseChannel_UNSPECIFIED {
+		cluster.ReleaseChannel = strings.ToLower(ch.String())
+	}
+	if t, err := time.Parse(time.RFC3339, c.CreateTime); err == nil {
+		cluster.CreateTime = t
+	}
+	for _, np := range c.NodePools {
+		cluster.NodePools = append(cluster.NodePools, newNodePool(np))
+	}
+	return cluster
+}
+
+// newNodePool converts a node pool from the GKE API.
+func newNodePool(np *containerpb.NodePool) NodePool {
+	pool := NodePool{
+		Name:             np.Name,
+		Status:           np.Status.String(),
+		Version:          np.Version,
+		MachineType:      np.Config.GetMachineType(),
+		Spot:             np.Config.GetSpot() || np.Config.GetPreemptible(),
+		InitialNodeCount: np.InitialNodeCount,
+		Locations:        np.Locations,
+	}
+	if as := np.Autoscaling; as != nil && as.Enabled {
+		pool.Autoscaling = true
+		pool.MinNodes, pool.MaxNodes = as.MinNodeCount, as.MaxNodeCount
+		if as.TotalMaxNodeCount > 0 {
+			pool.MinNodes, pool.MaxNodes = as.TotalMinNodeCount, as.TotalMaxNodeCount
+		}
+	}
+	return pool
+}
@@ -0,0 +1,260 @@
+package gkeutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	container "cloud.google.com/go/container/apiv1"
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/option"
+)
+
+const (
+	// DefaultTTL is how long a project's cluster list is cached by default.
+	DefaultTTL = 5 * time.Minute
+	// DefaultConcurrency is how many projects are listed at once by default.
+	DefaultConcurrency = 8
+)
+
+// ClusterLister lists GKE clusters. *container.ClusterManagerClient
+// implements it; tests can substitute a fake.
+type ClusterLister interface {
+	ListClusters(ctx context.Context, req *containerpb.ListClustersRequest, opts ...gax.CallOption) (*containerpb.ListClustersResponse, error)
+}
+
+// Options configures an Inventory.
+type Options struct {
+	// TTL is how long a project's clusters are cached; DefaultTTL if zero,
+	// and caching is disabled if negative.
+	TTL time.Duration
+	// Concurrency is how many projects are listed at once; DefaultConcurrency if zero.
+	Concurrency int
+}
+
+// Inventory lists GKE clusters across projects, caching each project's
+// clusters for a TTL. It is safe for concurrent use.
+type Inventory struct {
+	lister      ClusterLister
+	closer      func() error
+	ttl         time.Duration
+	concurrency int
+	now         func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// cacheEntry is a project's cached cluster list.
+type cacheEntry struct {
+	clusters  []Cluster
+	fetchedAt time.Time
+}
+
+// Result is the outcome of listing clusters in several projects.
+type Result struct {
+	// Clusters are the clusters found, ordered by project, location and name.
+	Clusters []Cluster
+	// Errors holds the error for each project that could not be listed
+	// completely. Clusters from the other projects are still returned.
+	Errors map[string]error
+}
+
+// Err returns the per-project errors joined into one, or nil.
+func (r Result) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	projects := make([]string, 0, len(r.Errors))
+	for p := range r.Errors {
+		projects = append(projects, p)
+	}
+	sort.Strings(projects)
+	errs := make([]error, 0, len(projects))
+	for _, p := range projects {
+		errs = append(errs, r.Errors[p])
+	}
+	return errors.Join(errs...)
+}
+
+// New returns an Inventory backed by a new GKE cluster manager client
+// created with clientOpts. Close it when done.
+func New(ctx context.Context, opts Options, clientOpts ...option.ClientOption) (*Inventory, error) {
+	client, err := container.NewClusterManagerClient(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster manager client: %w", err)
+	}
+	inv := NewWithLister(client, opts)
+	inv.closer = client.Close
+	return inv, nil
+}
+
+// NewWithLister returns an Inventory backed by lister, which the caller owns.
+func NewWithLister(lister ClusterLister, opts Options) *Inventory {
+	if opts.TTL == 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	return &Inventory{
+		lister:      lister,
+		ttl:         opts.TTL,
+		concurrency: opts.Concurrency,
+		now:         time.Now,
+		cache:       map[string]cacheEntry{},
+	}
+}
+
+// Close releases the client created by New.
+func (inv *Inventory) Close() error {
+	if inv.closer == nil {
+		return nil
+	}
+	return inv.closer()
+}
+
+// List returns the clusters in every project, listing projects in parallel.
+// Projects that fail are reported in Result.Errors rather than failing the
+// whole listing.
+func (inv *Inventory) List(ctx context.Context, projectIDs []string) Result {
+	type projectResult struct {
+		projectID string
+		clusters  []Cluster
+		err       error
+	}
+	results := make(chan projectResult, len(projectIDs))
+	sem := make(chan struct{}, inv.concurrency)
+	var wg sync.WaitGroup
+	for _, pid := range dedupe(projectIDs) {
+		wg.Add(1)
+		go func(pid string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			clusters, err := inv.ListProject(ctx, pid)
+			results <- projectResult{pid, clusters, err}
+		}(pid)
+	}
+	wg.Wait()
+	close(results)
+
+	res := Result{Errors: map[string]error{}}
+	for r := range results {
+		res.Clusters = append(res.Clusters, r.clusters...)
+		if r.err != nil {
+			res.Errors[r.projectID] = r.err
+		}
+	}
+	sortClusters(res.Clusters)
+	return res
+}
+
+// ListProject returns the clusters in a project, from the cache if it is fresh.
+// If some locations could not be reached it returns the clusters found
+// together with an error, and does not cache them.
+func (inv *Inventory) ListProject(ctx context.Context, projectID string) ([]Cluster, error) {
+	if clusters, ok := inv.cached(projectID); ok {
+		return clusters, nil
+	}
+
+	resp, err := inv.lister.ListClusters(ctx, &containerpb.ListClustersRequest{
+		Parent: "projects/" + projectID + "/locations/-",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clusters in project %s: %w", projectID, err)
+	}
+	clusters := make([]Cluster, 0, len(resp.Clusters))
+	for _, c := range resp.Clusters {
+		clusters = append(clusters, newCluster(projectID, c))
+	}
+	sortClusters(clusters)
+
+	if len(resp.MissingZones) > 0 {
+		return clusters, fmt.Errorf("project %s: clusters in %s could not be listed", projectID, strings.Join(resp.MissingZones, ", "))
+	}
+	inv.store(projectID, clusters)
+	return clusters, nil
+}
+
+// Get returns a cluster by project, location and name.
+func (inv *Inventory) Get(ctx context.Context, projectID string, location string, name string) (Cluster, error) {
+	clusters, err := inv.ListProject(ctx, projectID)
+	for _, c := range clusters {
+		if c.Location == location && c.Name == name {
+			return c, nil
+		}
+	}
+	if err != nil {
+		return Cluster{}, err
+	}
+	return Cluster{}, fmt.Errorf("cluster %s not found in project %s location %s", name, projectID, location)
+}
+
+// Invalidate drops the cached clusters of the given projects, or of every
+// project if none are given.
+func (inv *Inventory) Invalidate(projectIDs ...string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if len(projectIDs) == 0 {
+		inv.cache = map[string]cacheEntry{}
+		return
+	}
+	for _, pid := range projectIDs {
+		delete(inv.cache, pid)
+	}
+}
+
+// cached returns a project's clusters if they are cached and fresh.
+func (inv *Inventory) cached(projectID string) ([]Cluster, bool) {
+	if inv.ttl < 0 {
+		return nil, false
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	entry, ok := inv.cache[projectID]
+	if !ok || inv.now().Sub(entry.fetchedAt) >= inv.ttl {
+		return nil, false
+	}
+	// Callers may modify the slice, so hand out a copy.
+	return append([]Cluster(nil), entry.clusters...), true
+}
+
+// store caches a project's clusters.
+func (inv *Inventory) store(projectID string, clusters []Cluster) {
+	if inv.ttl < 0 {
+		return
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.cache[projectID] = cacheEntry{
+		clusters:  append([]Cluster(nil), clusters...),
+		fetchedAt: inv.now(),
+	}
+}
+
+// sortClusters orders clusters by project, location and name.
+func sortClusters(clusters []Cluster) {
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].ID() < clusters[j].ID()
+	})
+}
+
+// dedupe removes empty and repeated project IDs, keeping the first occurrence.
+func dedupe(ids []string) []string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	return out
+}
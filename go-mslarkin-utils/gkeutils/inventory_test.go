@@ -0,0 +1,236 @@
+package gkeutils
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/oauth2"
+)
+
+// fakeLister serves clusters per project and counts calls.
+type fakeLister struct {
+	mu       sync.Mutex
+	projects map[string]*containerpb.ListClustersResponse
+	calls    map[string]int
+	// delay makes each call take a while, to observe concurrency.
+	delay    time.Duration
+	inFlight int
+	maxIn    int
+}
+
+func (f *fakeLister) ListClusters(ctx context.Context, req *containerpb.ListClustersRequest, opts ...gax.CallOption) (*containerpb.ListClustersResponse, error) {
+	pid := strings.Split(req.Parent, "/")[1]
+	f.mu.Lock()
+	f.calls[pid]++
+	f.inFlight++
+	f.maxIn = max(f.maxIn, f.inFlight)
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight--
+	resp, ok := f.projects[pid]
+	if !ok {
+		return nil, errors.New("permission denied")
+	}
+	return resp, nil
+}
+
+func newFakeLister() *fakeLister {
+	return &fakeLister{
+		calls: map[string]int{},
+		projects: map[string]*containerpb.ListClustersResponse{
+			"alpha": {Clusters: []*containerpb.Cluster{
+				{Name: "zeta", Location: "us-central1", Status: containerpb.Cluster_RUNNING},
+				{
+					Name:                 "autopilot",
+					Location:             "us-central1",
+					Status:               containerpb.Cluster_RUNNING,
+					Endpoint:             "10.0.0.1",
+					MasterAuth:           &containerpb.MasterAuth{ClusterCaCertificate: base64.StdEncoding.EncodeToString([]byte("ca"))},
+					CurrentMasterVersion: "1.30.5-gke.1000",
+					Autopilot:            &containerpb.Autopilot{Enabled: true},
+					ReleaseChannel:       &containerpb.ReleaseChannel{Channel: containerpb.ReleaseChannel_REGULAR},
+					ResourceLabels:       map[string]string{"env": "demo"},
+					CreateTime:           "2025-01-02T03:04:05+00:00",
+					NodePools: []*containerpb.NodePool{{
+						Name:        "pool-1",
+						Status:      containerpb.NodePool_RUNNING,
+						Version:     "1.30.5-gke.1000",
+						Config:      &containerpb.NodeConfig{MachineType: "e2-standard-4", Spot: true},
+						Autoscaling: &containerpb.NodePoolAutoscaling{Enabled: true, TotalMinNodeCount: 1, TotalMaxNodeCount: 6},
+					}},
+				},
+			}},
+			"beta": {
+				Clusters:     []*containerpb.Cluster{{Name: "standard", Location: "europe-west1-b", Status: containerpb.Cluster_DEGRADED}},
+				MissingZones: []string{"asia-east1-a"},
+			},
+		},
+	}
+}
+
+func TestInventoryList(t *testing.T) {
+	lister := newFakeLister()
+	inv := NewWithLister(lister, Options{})
+
+	res := inv.List(context.Background(), []string{"beta", "alpha", "gamma", "alpha", ""})
+	if len(res.Clusters) != 3 {
+		t.Fatalf("got %d clusters, want 3: %+v", len(res.Clusters), res.Clusters)
+	}
+	// Ordered by project, location and name.
+	var names []string
+	for _, c := range res.Clusters {
+		names = append(names, c.ProjectID+"/"+c.Name)
+	}
+	if got := strings.Join(names, ","); got != "alpha/autopilot,alpha/zeta,beta/standard" {
+		t.Errorf("clusters = %s", got)
+	}
+
+	// gamma fails outright; beta is partial but its clusters are kept.
+	if len(res.Errors) != 2 || res.Errors["gamma"] == nil || res.Errors["beta"] == nil {
+		t.Errorf("Errors = %v, want gamma and beta", res.Errors)
+	}
+	if err := res.Err(); err == nil || !strings.Contains(err.Error(), "asia-east1-a") {
+		t.Errorf("Err() = %v", err)
+	}
+	if lister.calls["alpha"] != 1 {
+		t.Errorf("alpha listed %d times, want 1 (deduplicated)", lister.calls["alpha"])
+	}
+}
+
+func TestInventoryClusterModel(t *testing.T) {
+	inv := NewWithLister(newFakeLister(), Options{})
+	c, err := inv.Get(context.Background(), "alpha", "us-central1", "autopilot")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !c.Autopilot || c.ReleaseChannel != "regular" || c.MasterVersion != "1.30.5-gke.1000" || c.Labels["env"] != "demo" || !c.Running() {
+		t.Errorf("cluster = %+v", c)
+	}
+	if c.CreateTime.Year() != 2025 {
+		t.Errorf("CreateTime = %v", c.CreateTime)
+	}
+	want := NodePool{Name: "pool-1", Status: "RUNNING", Version: "1.30.5-gke.1000", MachineType: "e2-standard-4", Spot: true, Autoscaling: true, MinNodes: 1, MaxNodes: 6}
+	if len(c.NodePools) != 1 || !reflect.DeepEqual(c.NodePools[0], want) {
+		t.Errorf("NodePools = %+v, want %+v", c.NodePools, want)
+	}
+	if c.ID() != "projects/alpha/locations/us-central1/clusters/autopilot" {
+		t.Errorf("ID() = %q", c.ID())
+	}
+
+	if _, err := inv.Get(context.Background(), "alpha", "us-central1", "missing"); err == nil {
+		t.Error("Get(missing) error = nil")
+	}
+}
+
+func TestInventoryCache(t *testing.T) {
+	lister := newFakeLister()
+	inv := NewWithLister(lister, Options{TTL: time.Minute})
+	now := time.Now()
+	inv.now = func() time.Time { return now }
+
+	list := func() {
+		if _, err := inv.ListProject(context.Background(), "alpha"); err != nil {
+			t.Fatalf("ListProject() error = %v", err)
+		}
+	}
+	list()
+	list()
+	if lister.calls["alpha"] != 1 {
+		t.Errorf("listed %d times within TTL, want 1", lister.calls["alpha"])
+	}
+
+	now = now.Add(time.Minute)
+	list()
+	if lister.calls["alpha"] != 2 {
+		t.Errorf("listed %d times after TTL, want 2", lister.calls["alpha"])
+	}
+
+	inv.Invalidate("alpha")
+	list()
+	if lister.calls["alpha"] != 3 {
+		t.Errorf("listed %d times after Invalidate, want 3", lister.calls["alpha"])
+	}
+
+	// Partial results are not cached.
+	inv.ListProject(context.Background(), "beta")
+	inv.ListProject(context.Background(), "beta")
+	if lister.calls["beta"] != 2 {
+		t.Errorf("partial project listed %d times, want 2", lister.calls["beta"])
+	}
+
+	// A negative TTL disables caching.
+	uncached := NewWithLister(lister, Options{TTL: -1})
+	uncached.ListProject(context.Background(), "alpha")
+	uncached.ListProject(context.Background(), "alpha")
+	if lister.calls["alpha"] != 5 {
+		t.Errorf("listed %d times without cache, want 5", lister.calls["alpha"])
+	}
+}
+
+func TestInventoryConcurrency(t *testing.T) {
+	lister := newFakeLister()
+	lister.delay = 20 * time.Millisecond
+	var projects []string
+	for i := 0; i < 6; i++ {
+		pid := "p" + string(rune('a'+i))
+		lister.projects[pid] = &containerpb.ListClustersResponse{}
+		projects = append(projects, pid)
+	}
+
+	NewWithLister(lister, Options{Concurrency: 2}).List(context.Background(), projects)
+	if lister.maxIn != 2 {
+		t.Errorf("max concurrent calls = %d, want 2", lister.maxIn)
+	}
+}
+
+func TestRESTConfig(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	c := Cluster{Name: "demo", Endpoint: "10.0.0.1", CACertificate: base64.StdEncoding.EncodeToString([]byte("ca"))}
+	config, err := c.RESTConfigWithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}))
+	if err != nil {
+		t.Fatalf("RESTConfigWithTokenSource() error = %v", err)
+	}
+	if config.Host != "https://10.0.0.1" || string(config.TLSClientConfig.CAData) != "ca" {
+		t.Errorf("config = %+v", config)
+	}
+
+	// The wrapped transport adds the bearer token.
+	client := &http.Client{Transport: config.WrapTransport(srv.Client().Transport)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if gotAuth != "Bearer tok" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+
+	dns := Cluster{Name: "dns", DNSEndpoint: "gke-abc.us-central1.gke.goog"}
+	if config, err := dns.RESTConfigWithTokenSource(nil); err != nil || config.Host != "https://gke-abc.us-central1.gke.goog" {
+		t.Errorf("DNS endpoint config = %+v, %v", config, err)
+	}
+	for _, bad := range []Cluster{{Name: "none"}, {Name: "noca", Endpoint: "10.0.0.2"}, {Name: "badca", Endpoint: "10.0.0.3", CACertificate: "%%"}} {
+		if _, err := bad.RESTConfigWithTokenSource(nil); err == nil {
+			t.Errorf("RESTConfigWithTokenSource(%s) error = nil", bad.Name)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package gkeutils
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"k8s.io/client-go/rest"
+)
+
+// cloudPlatformScope is the OAuth scope GKE accepts for cluster access.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// RESTConfig returns a client-go configuration for the cluster that
+// authenticates with Application Default Credentials.
+func (c Cluster) RESTConfig(ctx context.Context) (*rest.Config, error) {
+	ts, err := google.DefaultTokenSource(ctx, cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get google token source: %w", err)
+	}
+	return c.RESTConfigWithTokenSource(ts)
+}
+
+// RESTConfigWithTokenSource returns a client-go configuration for the
+// cluster that authenticates with tokens from ts. It connects to the
+// DNS endpoint if the cluster has no IP endpoint.
+func (c Cluster) RESTConfigWithTokenSource(ts oauth2.TokenSource) (*rest.Config, error) {
+	config := &rest.Config{
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return &oauth2.Transport{Source: ts, Base: rt}
+		},
+	}
+	switch {
+	case c.Endpoint != "":
+		caData, err := base64.StdEncoding.DecodeString(c.CACertificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode CA certificate of cluster %s: %w", c.Name, err)
+		}
+		if len(caData) == 0 {
+			return nil, fmt.Errorf("cluster %s has no CA certificate", c.Name)
+		}
+		config.Host = "https://" + c.Endpoint
+		config.TLSClientConfig.CAData = caData
+	case c.DNSEndpoint != "":
+		// The DNS endpoint serves a publicly trusted certificate.
+		config.Host = "https://" + c.DNSEndpoint
+	default:
+		return nil, fmt.Errorf("cluster %s has no endpoint", c.Name)
+	}
+	return config, nil
+}
@@ -0,0 +1,132 @@
+package goutils
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ConfigError describes an invalid configuration variable.
+type ConfigError struct {
+	Env    string
+	Value  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%s %s", e.Env, e.Reason)
+	}
+	return fmt.Sprintf("%s %s (got %q)", e.Env, e.Reason, e.Value)
+}
+
+// ConfigErrors lists every invalid variable found by LoadConfig.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// LoadConfig fills the struct cfg points to from environment variables.
+// Fields are bound by their tags:
+//
+//	env:"PROJECT_ID"        the variable name; untagged fields are skipped
+//	default:"mslarkin-ext"  the value used when the variable is unset or empty
+//	required:"true"         the variable must be set
+//	min:"1" max:"3600"      an inclusive range, for numbers and durations
+//	oneof:"debug info"      the allowed values, separated by spaces
+//
+// Nested structs are loaded recursively. Supported field types are strings,
+// booleans, integers, floats, time.Duration and comma-separated []string.
+// Every problem is reported at once in the returned ConfigErrors, so a
+// binary can fail fast at startup:
+//
+//	var cfg struct {
+//		ProjectID string        `env:"PROJECT_ID" default:"mslarkin-ext"`
+//		Port      int           `env:"PORT" default:"8080" min:"1" max:"65535"`
+//		PollRate  time.Duration `env:"POLL_RATE" default:"30s"`
+//	}
+//	if err := goutils.LoadConfig(&cfg); err != nil {
+//		log.Fatal(err)
+//	}
+func LoadConfig(cfg any) error {
+	rv := reflect.ValueOf(cfg)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("goutils: LoadConfig needs a pointer to a struct, got %T", cfg)
+	}
+	var errs ConfigErrors
+	if err := loadConfig(rv.Elem(), &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// loadConfig loads the fields of struct v, collecting invalid variables in
+// errs. It returns an error for mistakes in the struct itself.
+func loadConfig(v reflect.Value, errs *ConfigErrors) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		fv := v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Tag.Get("env")
+		if name == "" && fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			if err := loadConfig(fv, errs); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		isList := fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String
+		if !isList && !paramSupported(fv.Type()) {
+			return fmt.Errorf("goutils: %s.%s has unsupported type %s", v.Type().Name(), field.Name, fv.Type())
+		}
+
+		raw := os.Getenv(name)
+		if raw == "" {
+			if field.Tag.Get("required") == "true" {
+				*errs = append(*errs, &ConfigError{Env: name, Reason: "is required"})
+				continue
+			}
+			def, ok := field.Tag.Lookup("default")
+			if !ok {
+				continue
+			}
+			raw = def
+		}
+
+		if isList {
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			fv.Set(reflect.ValueOf(items).Convert(fv.Type()))
+			continue
+		}
+		if reason := parseParam(raw, fv); reason != "" {
+			*errs = append(*errs, &ConfigError{Env: name, Value: raw, Reason: reason})
+			continue
+		}
+		reason, err := checkTags(field, fv)
+		if err != nil {
+			return fmt.Errorf("goutils: %s.%s: %w", v.Type().Name(), field.Name, err)
+		}
+		if reason != "" {
+			*errs = append(*errs, &ConfigError{Env: name, Value: raw, Reason: reason})
+		}
+	}
+	return nil
+}
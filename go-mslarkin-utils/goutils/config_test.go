@@ -0,0 +1,82 @@
+package goutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	ProjectID string        `env:"PROJECT_ID" default:"mslarkin-ext"`
+	Port      int           `env:"PORT" default:"8080" min:"1" max:"65535"`
+	PollRate  time.Duration `env:"POLL_RATE" default:"30s" min:"1s"`
+	Projects  []string      `env:"PROJECTS"`
+	Database  struct {
+		Name string `env:"FIRESTORE_DB" required:"true"`
+	}
+	LogLevel string `env:"LOG_LEVEL" default:"info" oneof:"debug info warn error"`
+	Verbose  bool   `env:"VERBOSE"`
+	internal string
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("PORT", "9090")
+	t.Setenv("PROJECTS", "a, b,,c")
+	t.Setenv("FIRESTORE_DB", "demo")
+	t.Setenv("VERBOSE", "true")
+
+	var cfg testConfig
+	if err := LoadConfig(&cfg); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	want := testConfig{ProjectID: "mslarkin-ext", Port: 9090, PollRate: 30 * time.Second, Projects: []string{"a", "b", "c"}, LogLevel: "info", Verbose: true}
+	want.Database.Name = "demo"
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadConfig() = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Setenv("PORT", "http")
+	t.Setenv("POLL_RATE", "10ms")
+	t.Setenv("LOG_LEVEL", "trace")
+	t.Setenv("FIRESTORE_DB", "")
+
+	err := LoadConfig(&testConfig{})
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadConfig() error = %v, want ConfigErrors", err)
+	}
+	got := map[string]string{}
+	for _, e := range errs {
+		got[e.Env] = e.Reason
+	}
+	want := map[string]string{
+		"PORT":         "must be an integer",
+		"POLL_RATE":    "must be at least 1s",
+		"FIRESTORE_DB": "is required",
+		"LOG_LEVEL":    "must be one of debug, info, warn, error",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problems = %v, want %v", got, want)
+	}
+
+	var ratio struct {
+		Ratio float64 `env:"RATIO" max:"1"`
+	}
+	t.Setenv("RATIO", "NaN")
+	if err := LoadConfig(&ratio); !errors.As(err, &errs) || errs[0].Reason != "must be at most 1" {
+		t.Errorf("LoadConfig(RATIO=NaN) error = %v, want must be at most 1", err)
+	}
+
+	if err := LoadConfig(testConfig{}); err == nil {
+		t.Error("LoadConfig(non-pointer) error = nil")
+	}
+	var bad struct {
+		Ports map[string]int `env:"PORTS"`
+	}
+	if err := LoadConfig(&bad); err == nil || errors.As(err, &errs) {
+		t.Errorf("LoadConfig(unsupported field) error = %v, want a non-ConfigErrors error", err)
+	}
+}
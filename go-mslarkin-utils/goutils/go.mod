@@ -1,3 +1,14 @@
 module github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils
 
 go 1.21.0
+
+require (
+	go.opentelemetry.io/otel v1.29.0
+	go.opentelemetry.io/otel/trace v1.29.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/otel/metric v1.29.0 // indirect
+)
@@ -0,0 +1,201 @@
+package goutils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cloud Logging special fields, see
+// https://cloud.google.com/logging/docs/structured-logging.
+const (
+	logFieldSeverity       = "severity"
+	logFieldMessage        = "message"
+	logFieldSourceLocation = "logging.googleapis.com/sourceLocation"
+	logFieldTrace          = "logging.googleapis.com/trace"
+	logFieldSpanID         = "logging.googleapis.com/spanId"
+	logFieldTraceSampled   = "logging.googleapis.com/trace_sampled"
+)
+
+// LevelCritical is a slog level above Error, logged with CRITICAL severity.
+const LevelCritical = slog.Level(12)
+
+// CloudLoggingOptions configures a Cloud Logging handler.
+type CloudLoggingOptions struct {
+	// Level is the minimum level logged; Info if nil.
+	Level slog.Leveler
+	// ProjectID is the project traces belong to. If empty it is read from
+	// the GOOGLE_CLOUD_PROJECT or PROJECT_ID environment variable. Without
+	// it, trace IDs are logged but not linked to Cloud Trace.
+	ProjectID string
+	// NoSource omits the source file and line from log entries.
+	NoSource bool
+}
+
+// cloudLoggingHandler writes slog records as Cloud Logging structured JSON,
+// adding the trace context of the record's context.
+type cloudLoggingHandler struct {
+	// base has no attributes or groups, so trace fields can be added at the
+	// top level before the logger's own attributes and groups are replayed.
+	base      slog.Handler
+	handler   slog.Handler
+	ops       []func(slog.Handler) slog.Handler
+	projectID string
+}
+
+// NewCloudLoggingHandler returns a slog handler that writes entries in
+// Cloud Logging's structured JSON format to w, with severity, message,
+// sourceLocation and the trace of the context (see TraceMiddleware).
+func NewCloudLoggingHandler(w io.Writer, opts *CloudLoggingOptions) slog.Handler {
+	if opts == nil {
+		opts = &CloudLoggingOptions{}
+	}
+	projectID := opts.ProjectID
+	if projectID == "" {
+		projectID = GetEnv("GOOGLE_CLOUD_PROJECT", os.Getenv("PROJECT_ID"))
+	}
+	base := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       opts.Level,
+		AddSource:   !opts.NoSource,
+		ReplaceAttr: replaceCloudLoggingAttr,
+	})
+	return &cloudLoggingHandler{base: base, handler: base, projectID: projectID}
+}
+
+// NewCloudLogger returns a logger that writes Cloud Logging structured JSON
+// to stdout, where Cloud Run and GKE pick it up.
+func NewCloudLogger(opts *CloudLoggingOptions) *slog.Logger {
+	return slog.New(NewCloudLoggingHandler(os.Stdout, opts))
+}
+
+// SetupCloudLogging makes a Cloud Logging logger the default slog logger.
+// Output from the standard log package is routed through it as well.
+func SetupCloudLogging(opts *CloudLoggingOptions) *slog.Logger {
+	logger := NewCloudLogger(opts)
+	slog.SetDefault(logger)
+	return logger
+}
+
+func (h *cloudLoggingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *cloudLoggingHandler) Handle(ctx context.Context, r slog.Record) error {
+	tc, ok := TraceContextFrom(ctx)
+	if !ok {
+		return h.handler.Handle(ctx, r)
+	}
+
+	trace := tc.TraceID
+	if h.projectID != "" {
+		trace = "projects/" + h.projectID + "/traces/" + tc.TraceID
+	}
+	attrs := []slog.Attr{slog.String(logFieldTrace, trace), slog.Bool(logFieldTraceSampled, tc.Sampled)}
+	if tc.SpanID != "" {
+		attrs = append(attrs, slog.String(logFieldSpanID, tc.SpanID))
+	}
+	handler := h.base.WithAttrs(attrs)
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+	return handler.Handle(ctx, r)
+}
+
+func (h *cloudLoggingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *cloudLoggingHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+// with returns a copy of the handler with op applied and recorded for replay.
+func (h *cloudLoggingHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &cloudLoggingHandler{
+		base:      h.base,
+		handler:   op(h.handler),
+		ops:       append(ops, op),
+		projectID: h.projectID,
+	}
+}
+
+// replaceCloudLoggingAttr renames slog's built-in fields to their Cloud
+// Logging equivalents.
+func replaceCloudLoggingAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.LevelKey:
+		level, _ := a.Value.Any().(slog.Level)
+		return slog.String(logFieldSeverity, severity(level))
+	case slog.MessageKey:
+		a.Key = logFieldMessage
+	case slog.SourceKey:
+		if src, ok := a.Value.Any().(*slog.Source); ok {
+			return slog.Group(logFieldSourceLocation,
+				slog.String("file", src.File),
+				slog.String("line", strconv.Itoa(src.Line)),
+				slog.String("function", src.Function),
+			)
+		}
+	}
+	return a
+}
+
+// severity maps a slog level to a Cloud Logging severity.
+func severity(level slog.Level) string {
+	switch {
+	case level >= LevelCritical:
+		return "CRITICAL"
+	case level >= slog.LevelError:
+		return "ERROR"
+	case level >= slog.LevelWarn:
+		return "WARNING"
+	case level >= slog.LevelInfo:
+		return "INFO"
+	}
+	return "DEBUG"
+}
+
+// HTTPRequestAttr returns the Cloud Logging httpRequest field for a served
+// request, so the entry shows up as a request in Logs Explorer.
+func HTTPRequestAttr(r *http.Request, status int, responseSize int64, latency time.Duration) slog.Attr {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return slog.Group("httpRequest",
+		slog.String("requestMethod", r.Method),
+		slog.String("requestUrl", scheme+"://"+r.Host+r.URL.RequestURI()),
+		slog.Int("status", status),
+		slog.String("responseSize", strconv.FormatInt(responseSize, 10)),
+		slog.String("userAgent", r.UserAgent()),
+		slog.String("remoteIp", clientIP(r)),
+		slog.String("referer", r.Referer()),
+		slog.String("protocol", r.Proto),
+		// Cloud Logging durations are seconds with a trailing "s".
+		slog.String("latency", fmt.Sprintf("%.9fs", latency.Seconds())),
+	)
+}
+
+// clientIP returns the client address, preferring the first
+// X-Forwarded-For entry set by Google's load balancers.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		ip, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(ip)
+	}
+	return r.RemoteAddr
+}
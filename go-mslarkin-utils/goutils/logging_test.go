@@ -0,0 +1,140 @@
+package goutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// decode returns the JSON entries written to buf.
+func decode(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e map[string]any
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("invalid JSON log entry: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestCloudLoggingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewCloudLoggingHandler(&buf, &CloudLoggingOptions{ProjectID: "my-project", Level: slog.LevelDebug}))
+
+	logger.Debug("debug")
+	logger.Warn("warn", "k", "v")
+	logger.Log(context.Background(), LevelCritical, "critical")
+
+	entries := decode(t, &buf)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	for i, want := range []string{"DEBUG", "WARNING", "CRITICAL"} {
+		if got := entries[i]["severity"]; got != want {
+			t.Errorf("entry %d severity = %v, want %s", i, got, want)
+		}
+	}
+	e := entries[1]
+	if e["message"] != "warn" || e["k"] != "v" || e["level"] != nil || e["msg"] != nil {
+		t.Errorf("entry = %v", e)
+	}
+	src, _ := e[logFieldSourceLocation].(map[string]any)
+	if src == nil || src["file"] == "" || src["line"] == "" {
+		t.Errorf("sourceLocation = %v", e[logFieldSourceLocation])
+	}
+	if _, ok := e[logFieldTrace]; ok {
+		t.Errorf("entry without trace context has trace %v", e[logFieldTrace])
+	}
+}
+
+func TestCloudLoggingHandlerTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewCloudLoggingHandler(&buf, &CloudLoggingOptions{ProjectID: "my-project", NoSource: true}))
+
+	// Trace fields stay at the top level even inside a group.
+	handler := TraceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.With("a", 1).WithGroup("req").InfoContext(r.Context(), "handled", "path", r.URL.Path)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	e := decode(t, &buf)[0]
+	if got := e[logFieldTrace]; got != "projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace = %v", got)
+	}
+	if e[logFieldSpanID] != "00f067aa0ba902b7" || e[logFieldTraceSampled] != true {
+		t.Errorf("spanId = %v, trace_sampled = %v", e[logFieldSpanID], e[logFieldTraceSampled])
+	}
+	group, _ := e["req"].(map[string]any)
+	if e["a"] != float64(1) || group["path"] != "/x" {
+		t.Errorf("entry = %v", e)
+	}
+	if _, ok := e[logFieldSourceLocation]; ok {
+		t.Error("NoSource entry has sourceLocation")
+	}
+}
+
+func TestParseTraceHeaders(t *testing.T) {
+	tests := []struct {
+		traceparent, cloud string
+		want               TraceContext
+		ok                 bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", TraceContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true}, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "", TraceContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false}, true},
+		{"", "105445aa7843bc8bf206b12000100000/1;o=1", TraceContext{"105445aa7843bc8bf206b12000100000", "0000000000000001", true}, true},
+		{"", "105445aa7843bc8bf206b12000100000", TraceContext{TraceID: "105445aa7843bc8bf206b12000100000"}, true},
+		// An invalid traceparent falls back to X-Cloud-Trace-Context.
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "105445aa7843bc8bf206b12000100000/255;o=0", TraceContext{"105445aa7843bc8bf206b12000100000", "00000000000000ff", false}, true},
+		{"garbage", "", TraceContext{}, false},
+		{"", "short/1", TraceContext{}, false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.traceparent != "" {
+			h.Set("traceparent", tt.traceparent)
+		}
+		if tt.cloud != "" {
+			h.Set("X-Cloud-Trace-Context", tt.cloud)
+		}
+		got, ok := ParseTraceHeaders(h)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseTraceHeaders(%q, %q) = %+v, %v; want %+v, %v", tt.traceparent, tt.cloud, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHTTPRequestAttr(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewCloudLoggingHandler(&buf, &CloudLoggingOptions{NoSource: true}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api?q=1", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	logger.Info("request", HTTPRequestAttr(req, http.StatusCreated, 42, 1500*time.Millisecond))
+
+	got, _ := decode(t, &buf)[0]["httpRequest"].(map[string]any)
+	want := map[string]any{
+		"requestMethod": "POST",
+		"requestUrl":    "https://example.com/api?q=1",
+		"status":        float64(201),
+		"responseSize":  "42",
+		"remoteIp":      "203.0.113.7",
+		"latency":       "1.500000000s",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("httpRequest.%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
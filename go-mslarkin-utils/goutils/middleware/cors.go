@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures cross-origin resource sharing.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to call the server, e.g.
+	// "https://example.com". "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders defaults to Content-Type.
+	AllowedHeaders []string
+	// ExposedHeaders lists response headers readable by the browser.
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and authorization headers. The
+	// request's origin is then echoed instead of "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight results.
+	MaxAge time.Duration
+}
+
+// CORS answers preflight requests and adds CORS headers to responses for
+// allowed origins. Requests from other origins are served without CORS
+// headers, so browsers block them.
+func CORS(opts CORSOptions) Middleware {
+	methods := strings.Join(orDefault(opts.AllowedMethods, http.MethodGet, http.MethodHead, http.MethodPost), ", ")
+	headers := strings.Join(orDefault(opts.AllowedHeaders, "Content-Type"), ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	anyOrigin := false
+	origins := map[string]bool{}
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins[strings.TrimSuffix(o, "/")] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if origin == "" || !(anyOrigin || origins[origin]) {
+				if preflight {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if anyOrigin && !opts.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if opts.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if preflight {
+				h.Set("Access-Control-Allow-Methods", methods)
+				h.Set("Access-Control-Allow-Headers", headers)
+				if opts.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if exposed != "" {
+				h.Set("Access-Control-Expose-Headers", exposed)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func orDefault(values []string, defaults ...string) []string {
+	if len(values) == 0 {
+		return defaults
+	}
+	return values
+}
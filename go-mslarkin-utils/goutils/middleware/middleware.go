@@ -0,0 +1,227 @@
+// Package middleware provides composable net/http middleware shared by the
+// services in this repo: request IDs, access logging, panic recovery, CORS,
+// timeouts, body size limits and OpenTelemetry server spans.
+//
+// A typical server wraps its mux once:
+//
+//	handler := middleware.Chain(
+//		middleware.Defaults(logger),
+//		middleware.CORS(middleware.CORSOptions{AllowedOrigins: []string{"*"}}),
+//	)(mux)
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils"
+)
+
+// Middleware wraps a handler with additional behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// Chain combines middleware into one. The first middleware is outermost,
+// so it sees the request first and the response last.
+func Chain(mws ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
+// Defaults is the chain every server should start with: request IDs, trace
+// context for log correlation, an OpenTelemetry span, access logs and panic
+// recovery. A nil logger uses slog.Default.
+func Defaults(logger *slog.Logger) Middleware {
+	return Chain(
+		RequestID,
+		goutils.TraceMiddleware,
+		Tracing(nil, ""),
+		AccessLog(logger),
+		Recover(logger),
+	)
+}
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// RequestID keeps the X-Request-Id of incoming requests, or generates one,
+// stores it in the request context and echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFrom returns the request ID stored by RequestID, or "".
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs every request once it completes, with Cloud Logging's
+// httpRequest field (method, URL, status, size and latency) and the request
+// ID. 5xx responses are logged as errors and 4xx as warnings. A nil logger
+// uses slog.Default.
+func AccessLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			level := slog.LevelInfo
+			switch {
+			case rec.status >= 500:
+				level = slog.LevelError
+			case rec.status >= 400:
+				level = slog.LevelWarn
+			}
+			attrs := []slog.Attr{goutils.HTTPRequestAttr(r, rec.status, rec.size, time.Since(start))}
+			if id := RequestIDFrom(r.Context()); id != "" {
+				attrs = append(attrs, slog.String("requestId", id))
+			}
+			loggerOrDefault(logger).LogAttrs(r.Context(), level, r.Method+" "+r.URL.Path, attrs...)
+		})
+	}
+}
+
+// Recover turns panics in handlers into 500 responses and logs them with
+// their stack trace. A nil logger uses slog.Default.
+func Recover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := newResponseRecorder(w)
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				// ErrAbortHandler deliberately aborts the response; let
+				// net/http handle it.
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				loggerOrDefault(logger).ErrorContext(r.Context(), "panic serving "+r.Method+" "+r.URL.Path,
+					"panic", fmt.Sprint(p),
+					"stack", string(debug.Stack()),
+					"requestId", RequestIDFrom(r.Context()),
+				)
+				if !rec.wroteHeader {
+					http.Error(rec, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// Timeout limits the time a handler has to respond. Requests that take
+// longer get 503 Service Unavailable and their context is cancelled.
+// Responses are buffered, so do not use it for streaming routes.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, d, http.StatusText(http.StatusServiceUnavailable))
+	}
+}
+
+// BodyLimit rejects request bodies larger than n bytes with 413 Request
+// Entity Too Large. Bodies without a Content-Length fail to read past n.
+func BodyLimit(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// responseRecorder records the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+// newResponseRecorder wraps w, reusing w if it already is a recorder.
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	if rec, ok := w.(*responseRecorder); ok {
+		return rec
+	}
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the recorder.
+func (r *responseRecorder) Flush() {
+	r.wroteHeader = true
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack keeps websocket upgrades working through the recorder. The
+// hijacked connection is logged as 101 Switching Protocols unless a status
+// was already written.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && !r.wroteHeader {
+		r.status = http.StatusSwitchingProtocols
+		r.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,274 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(mw("a"), mw("b"), mw("c"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+	serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := strings.Join(order, ","); got != "a,b,c,handler" {
+		t.Errorf("order = %s, want a,b,c,handler", got)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFrom(r.Context())
+	}))
+
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(seen) != 32 || w.Header().Get(RequestIDHeader) != seen {
+		t.Errorf("generated ID = %q, response header = %q", seen, w.Header().Get(RequestIDHeader))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(RequestIDHeader, "abc")
+	if w := serve(h, r); seen != "abc" || w.Header().Get(RequestIDHeader) != "abc" {
+		t.Errorf("propagated ID = %q, want abc", seen)
+	}
+}
+
+func TestAccessLogAndRecover(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(goutils.NewCloudLoggingHandler(&buf, &goutils.CloudLoggingOptions{NoSource: true}))
+	h := Chain(RequestID, AccessLog(logger), Recover(logger))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "short and stout")
+	}))
+
+	serve(h, httptest.NewRequest(http.MethodGet, "/tea", nil))
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("panic response = %d, want 500", w.Code)
+	}
+
+	var entries []map[string]any
+	for dec := json.NewDecoder(&buf); dec.More(); {
+		var e map[string]any
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	// The access log, then the panic and its access log.
+	if len(entries) != 3 {
+		t.Fatalf("got %d log entries, want 3", len(entries))
+	}
+	req, _ := entries[0]["httpRequest"].(map[string]any)
+	if entries[0]["severity"] != "WARNING" || req["status"] != float64(418) || req["responseSize"] != "15" || entries[0]["requestId"] == "" {
+		t.Errorf("access log = %v", entries[0])
+	}
+	if entries[1]["severity"] != "ERROR" || entries[1]["panic"] != "boom" || !strings.Contains(entries[1]["stack"].(string), "goroutine") {
+		t.Errorf("panic log = %v", entries[1])
+	}
+	if req, _ := entries[2]["httpRequest"].(map[string]any); entries[2]["severity"] != "ERROR" || req["status"] != float64(500) {
+		t.Errorf("panic access log = %v", entries[2])
+	}
+}
+
+func TestHijackThroughRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(goutils.NewCloudLoggingHandler(&buf, &goutils.CloudLoggingOptions{NoSource: true}))
+	h := Chain(AccessLog(logger), Recover(logger))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("ResponseWriter is not an http.Hijacker")
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	}))
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hijacked" {
+		t.Errorf("body = %q, want hijacked", body)
+	}
+
+	<-done // The access log is written once the handler returns.
+	var entry map[string]any
+	if err := json.NewDecoder(&buf).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if req, _ := entry["httpRequest"].(map[string]any); req["status"] != float64(http.StatusSwitchingProtocols) {
+		t.Errorf("access log = %v, want status 101", entry)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := CORS(CORSOptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET"},
+		ExposedHeaders: []string{RequestIDHeader},
+		MaxAge:         time.Hour,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	r := httptest.NewRequest(http.MethodOptions, "/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	w := serve(h, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET" || w.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("preflight = %d %v", w.Code, w.Header())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	if w := serve(h, r); w.Body.String() != "ok" || w.Header().Get("Access-Control-Expose-Headers") != RequestIDHeader {
+		t.Errorf("simple request = %q %v", w.Body.String(), w.Header())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	if w := serve(h, r); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disallowed origin got CORS headers: %v", w.Header())
+	}
+
+	wildcard := CORS(CORSOptions{AllowedOrigins: []string{"*"}})(h)
+	if w := serve(wildcard, r); w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("wildcard Allow-Origin = %q", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestTimeoutAndBodyLimit(t *testing.T) {
+	slow := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	if w := serve(slow, httptest.NewRequest(http.MethodGet, "/", nil)); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("slow handler = %d, want 503", w.Code)
+	}
+
+	echo := BodyLimit(4)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+	if w := serve(echo, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("ok"))); w.Code != http.StatusOK {
+		t.Errorf("small body = %d, want 200", w.Code)
+	}
+	if w := serve(echo, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too long"))); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body = %d, want 413", w.Code)
+	}
+	// Without a Content-Length the limit applies while reading.
+	r := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader("too long")))
+	r.ContentLength = -1
+	if w := serve(echo, r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unsized large body = %d, want 413", w.Code)
+	}
+}
+
+// recordingTracer records the spans it starts.
+type recordingTracer struct {
+	noop.Tracer
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	noop.Span
+	name   string
+	sc     trace.SpanContext
+	attrs  []attribute.KeyValue
+	status codes.Code
+	ended  bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+	s := &recordingSpan{name: name, attrs: cfg.Attributes(), sc: parent.WithSpanID(trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8})}
+	t.spans = append(t.spans, s)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+func (s *recordingSpan) SpanContext() trace.SpanContext         { return s.sc }
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue) { s.attrs = append(s.attrs, kv...) }
+func (s *recordingSpan) SetStatus(code codes.Code, desc string) { s.status = code }
+func (s *recordingSpan) End(options ...trace.SpanEndOption)     { s.ended = true }
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	var tc goutils.TraceContext
+	h := Tracing(tracer, "/api/status")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc, _ = goutils.TraceContextFrom(r.Context())
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	serve(h, r)
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("started %d spans, want 1", len(tracer.spans))
+	}
+	s := tracer.spans[0]
+	if s.name != "GET /api/status" || !s.ended || s.status != codes.Error {
+		t.Errorf("span = %+v", s)
+	}
+	attrs := attribute.NewSet(s.attrs...)
+	if v, _ := attrs.Value("http.response.status_code"); v.AsInt64() != 502 {
+		t.Errorf("http.response.status_code = %v", v.Emit())
+	}
+	if v, _ := attrs.Value("http.route"); v.AsString() != "/api/status" {
+		t.Errorf("http.route = %v", v.Emit())
+	}
+	// The remote trace continues and is exposed for log correlation.
+	want := goutils.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "0102030405060708", Sampled: true}
+	if tc != want {
+		t.Errorf("trace context = %+v, want %+v", tc, want)
+	}
+}
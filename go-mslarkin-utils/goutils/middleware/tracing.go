@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils/middleware"
+
+// propagator returns the global propagator, or W3C trace context if none
+// has been configured.
+func propagator() propagation.TextMapPropagator {
+	if p := otel.GetTextMapPropagator(); len(p.Fields()) > 0 {
+		return p
+	}
+	return propagation.TraceContext{}
+}
+
+// Tracing starts an OpenTelemetry server span for each request, continuing
+// the trace of its traceparent header. The span is named after the method
+// and route, e.g. "GET /api/status"; pass "" as the route for the method
+// alone. A nil tracer uses the global tracer provider.
+//
+// The span's trace context is also stored for goutils' Cloud Logging
+// handler, so request logs link to the trace.
+func Tracing(tracer trace.Tracer, route string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t := tracer
+			if t == nil {
+				t = otel.Tracer(tracerName)
+			}
+			name := r.Method
+			if route != "" {
+				name += " " + route
+			}
+
+			ctx := propagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := t.Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+					semconv.ServerAddress(r.Host),
+					semconv.UserAgentOriginal(r.UserAgent()),
+				),
+			)
+			defer span.End()
+			if route != "" {
+				span.SetAttributes(semconv.HTTPRoute(route))
+			}
+			if sc := span.SpanContext(); sc.IsValid() {
+				ctx = goutils.WithTraceContext(ctx, goutils.TraceContext{
+					TraceID: sc.TraceID().String(),
+					SpanID:  sc.SpanID().String(),
+					Sampled: sc.IsSampled(),
+				})
+			}
+
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+			// Server spans only count 5xx responses as errors.
+			if rec.status >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(rec.status))
+			}
+		})
+	}
+}
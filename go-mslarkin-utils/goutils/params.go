@@ -0,0 +1,348 @@
+package goutils
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParamValue is a type a request parameter can be parsed into. Durations
+// are time.Duration values, parsed with time.ParseDuration.
+type ParamValue interface {
+	~string | ~bool | ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~float32 | ~float64
+}
+
+// ParamRule validates a parsed parameter, returning why it is invalid.
+type ParamRule[T any] func(T) error
+
+// Range requires a parameter to be between lo and hi inclusive. NaN is
+// never in range.
+func Range[T cmp.Ordered](lo, hi T) ParamRule[T] {
+	return func(v T) error {
+		if !(v >= lo && v <= hi) {
+			return fmt.Errorf("must be between %v and %v", lo, hi)
+		}
+		return nil
+	}
+}
+
+// OneOf requires a parameter to be one of the allowed values, e.g. an enum.
+func OneOf[T comparable](allowed ...T) ParamRule[T] {
+	return func(v T) error {
+		for _, a := range allowed {
+			if v == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", joinValues(allowed))
+	}
+}
+
+// ParamError describes an invalid request parameter.
+type ParamError struct {
+	Param  string `json:"param"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e *ParamError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("parameter %q %s", e.Param, e.Reason)
+	}
+	return fmt.Sprintf("parameter %q %s (got %q)", e.Param, e.Reason, e.Value)
+}
+
+// ParamErrors collects every invalid parameter of a request, so that all of
+// them can be reported at once.
+type ParamErrors []*ParamError
+
+func (e ParamErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Add records err if it is a *ParamError or ParamErrors; nil is ignored.
+// Other errors are recorded without a parameter name.
+func (e *ParamErrors) Add(err error) {
+	var pe *ParamError
+	var pes ParamErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &pes):
+		*e = append(*e, pes...)
+	case errors.As(err, &pe):
+		*e = append(*e, pe)
+	default:
+		*e = append(*e, &ParamError{Reason: err.Error()})
+	}
+}
+
+// Err returns the collected errors, or nil if there are none.
+func (e ParamErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// WriteParamError writes err as a 400 Bad Request with a JSON body listing
+// the invalid parameters:
+//
+//	{"error": "...", "params": [{"param": "durationS", "value": "0", "reason": "must be between 1 and 3600"}]}
+func WriteParamError(w http.ResponseWriter, err error) {
+	body := struct {
+		Error  string        `json:"error"`
+		Params []*ParamError `json:"params,omitempty"`
+	}{Error: err.Error()}
+	var pe *ParamError
+	var pes ParamErrors
+	if errors.As(err, &pes) {
+		body.Params = pes
+	} else if errors.As(err, &pe) {
+		body.Params = []*ParamError{pe}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Param returns the query parameter name of r parsed as a T, or def if it
+// is missing or empty. A value that does not parse or breaks one of the
+// rules returns def and a *ParamError.
+//
+//	pct, err := goutils.Param(r, "targetCpuPct", 5.0, goutils.Range(0.0, 100.0))
+func Param[T ParamValue](r *http.Request, name string, def T, rules ...ParamRule[T]) (T, error) {
+	params, _ := url.ParseQuery(r.URL.RawQuery)
+	raw := params.Get(name)
+	if raw == "" {
+		return def, nil
+	}
+
+	var v T
+	if reason := parseParam(raw, reflect.ValueOf(&v).Elem()); reason != "" {
+		return def, &ParamError{Param: name, Value: raw, Reason: reason}
+	}
+	for _, rule := range rules {
+		if err := rule(v); err != nil {
+			return def, &ParamError{Param: name, Value: raw, Reason: err.Error()}
+		}
+	}
+	return v, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parseParam parses raw into v, returning why it could not.
+func parseParam(raw string, v reflect.Value) string {
+	raw = strings.TrimSpace(raw)
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return "must be a duration such as 30s or 1m30s"
+		}
+		v.SetInt(int64(d))
+		return ""
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "must be true or false"
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return numReason(err, "must be an integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return numReason(err, "must be a non-negative integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return numReason(err, "must be a number")
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Sprintf("has unsupported type %s", v.Type())
+	}
+	return ""
+}
+
+// paramSupported reports whether parseParam can parse into type t.
+func paramSupported(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// numReason explains a number parse error: reason for malformed values,
+// or that the value does not fit the parameter's type.
+func numReason(err error, reason string) string {
+	if errors.Is(err, strconv.ErrRange) {
+		return "is out of range"
+	}
+	return reason
+}
+
+// BindParams fills the fields of the struct dst points to from the query
+// parameters of r. Fields are bound by their tags:
+//
+//	param:"durationS"     the query parameter name; untagged fields are skipped
+//	default:"1"           the value used when the parameter is missing
+//	min:"1" max:"3600"    an inclusive range, for numbers and durations
+//	oneof:"low mid high"  the allowed values, separated by spaces
+//	required:"true"       the parameter must be present
+//
+// Supported field types are strings, booleans, integers, floats and
+// time.Duration. Every invalid parameter is reported in the returned
+// ParamErrors, which WriteParamError writes as a 400 response.
+func BindParams(r *http.Request, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("goutils: BindParams needs a pointer to a struct, got %T", dst)
+	}
+	rv = rv.Elem()
+	params, _ := url.ParseQuery(r.URL.RawQuery)
+
+	var errs ParamErrors
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		name := field.Tag.Get("param")
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		if !paramSupported(fv.Type()) {
+			return fmt.Errorf("goutils: %s.%s has unsupported type %s", rv.Type().Name(), field.Name, fv.Type())
+		}
+
+		raw := params.Get(name)
+		if raw == "" {
+			if field.Tag.Get("required") == "true" {
+				errs = append(errs, &ParamError{Param: name, Reason: "is required"})
+				continue
+			}
+			def, ok := field.Tag.Lookup("default")
+			if !ok {
+				continue
+			}
+			// Defaults are trusted and not range checked.
+			if reason := parseParam(def, fv); reason != "" {
+				return fmt.Errorf("goutils: invalid default for %s.%s: %s", rv.Type().Name(), field.Name, reason)
+			}
+			continue
+		}
+
+		if reason := parseParam(raw, fv); reason != "" {
+			errs = append(errs, &ParamError{Param: name, Value: raw, Reason: reason})
+			continue
+		}
+		reason, err := checkTags(field, fv)
+		if err != nil {
+			return fmt.Errorf("goutils: %s.%s: %w", rv.Type().Name(), field.Name, err)
+		}
+		if reason != "" {
+			errs = append(errs, &ParamError{Param: name, Value: raw, Reason: reason})
+		}
+	}
+	return errs.Err()
+}
+
+// checkTags applies the min, max and oneof tags of field to its value v.
+// It returns why v is invalid, or an error if the tags themselves are.
+func checkTags(field reflect.StructField, v reflect.Value) (string, error) {
+	if oneof, ok := field.Tag.Lookup("oneof"); ok {
+		allowed := strings.Fields(oneof)
+		got := fmt.Sprint(v.Interface())
+		for _, a := range allowed {
+			if a == got {
+				return "", nil
+			}
+		}
+		return "must be one of " + strings.Join(allowed, ", "), nil
+	}
+
+	lo, hasMin := field.Tag.Lookup("min")
+	hi, hasMax := field.Tag.Lookup("max")
+	if !hasMin && !hasMax {
+		return "", nil
+	}
+	below, err := compareTag(v, lo, hasMin, -1)
+	if err != nil {
+		return "", err
+	}
+	above, err := compareTag(v, hi, hasMax, 1)
+	if err != nil {
+		return "", err
+	}
+	// cmp.Compare sorts NaN below every number, so judge it here: NaN is
+	// outside any bound that is set.
+	if (v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64) && math.IsNaN(v.Float()) {
+		below, above = hasMin, hasMax
+	}
+	switch {
+	case (below || above) && hasMin && hasMax:
+		return fmt.Sprintf("must be between %s and %s", lo, hi), nil
+	case below:
+		return "must be at least " + lo, nil
+	case above:
+		return "must be at most " + hi, nil
+	}
+	return "", nil
+}
+
+// compareTag reports whether v compares to the bound parsed from tag as
+// want (-1 for below, 1 for above).
+func compareTag(v reflect.Value, tag string, ok bool, want int) (bool, error) {
+	if !ok {
+		return false, nil
+	}
+	bound := reflect.New(v.Type()).Elem()
+	if reason := parseParam(tag, bound); reason != "" {
+		return false, fmt.Errorf("invalid bound %q: %s", tag, reason)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(v.Int(), bound.Int()) == want, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(v.Uint(), bound.Uint()) == want, nil
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(v.Float(), bound.Float()) == want, nil
+	}
+	return false, fmt.Errorf("min and max do not apply to %s", v.Type())
+}
+
+func joinValues[T any](values []T) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,142 @@
+package goutils
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParam(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?pct=42.5&nan=NaN&n=7&on=true&d=90s&mode=fast&bad=abc&big=300", nil)
+
+	if got, err := Param(r, "pct", 5.0, Range(0.0, 100.0)); got != 42.5 || err != nil {
+		t.Errorf("Param(pct) = %v, %v", got, err)
+	}
+	if got, err := Param(r, "n", 1); got != 7 || err != nil {
+		t.Errorf("Param(n) = %v, %v", got, err)
+	}
+	if got, err := Param(r, "on", false); !got || err != nil {
+		t.Errorf("Param(on) = %v, %v", got, err)
+	}
+	if got, err := Param(r, "d", time.Second); got != 90*time.Second || err != nil {
+		t.Errorf("Param(d) = %v, %v", got, err)
+	}
+	if got, err := Param(r, "mode", "slow", OneOf("slow", "fast")); got != "fast" || err != nil {
+		t.Errorf("Param(mode) = %v, %v", got, err)
+	}
+	if got, err := Param(r, "missing", 3); got != 3 || err != nil {
+		t.Errorf("Param(missing) = %v, %v; want the default", got, err)
+	}
+
+	// Invalid values return the default and an error.
+	var pe *ParamError
+	if got, err := Param(r, "bad", 1); got != 1 || !errors.As(err, &pe) || pe.Reason != "must be an integer" {
+		t.Errorf("Param(bad) = %v, %v", got, err)
+	}
+	if _, err := Param(r, "big", uint8(0)); err == nil || err.(*ParamError).Reason != "is out of range" {
+		t.Errorf("Param(big) error = %v", err)
+	}
+	if _, err := Param(r, "n", 1, Range(10, 20)); err == nil || err.Error() != `parameter "n" must be between 10 and 20 (got "7")` {
+		t.Errorf("Param(n) out of range error = %v", err)
+	}
+	if got, err := Param(r, "nan", 5.0, Range(0.0, 100.0)); got != 5 || err == nil {
+		t.Errorf("Param(nan) = %v, %v; want the default and an error", got, err)
+	}
+	if _, err := Param(r, "mode", "a", OneOf("a", "b")); err == nil {
+		t.Error("Param(mode) not in enum error = nil")
+	}
+}
+
+type loadParams struct {
+	TargetCPUPct float64       `param:"targetCpuPct" default:"5" min:"0" max:"100"`
+	DurationS    int           `param:"durationS" default:"1" min:"1" max:"3600"`
+	Mode         string        `param:"mode" default:"steady" oneof:"steady burst"`
+	Wait         time.Duration `param:"wait" default:"0s" max:"1m"`
+	Async        bool          `param:"async"`
+	Name         string        `param:"name" required:"true"`
+	Ignored      string
+}
+
+func TestBindParams(t *testing.T) {
+	var p loadParams
+	r := httptest.NewRequest(http.MethodGet, "/?targetCpuPct=50&wait=30s&async=1&name=x", nil)
+	if err := BindParams(r, &p); err != nil {
+		t.Fatalf("BindParams() error = %v", err)
+	}
+	want := loadParams{TargetCPUPct: 50, DurationS: 1, Mode: "steady", Wait: 30 * time.Second, Async: true, Name: "x"}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("BindParams() = %+v, want %+v", p, want)
+	}
+
+	// Every invalid parameter is reported.
+	r = httptest.NewRequest(http.MethodGet, "/?targetCpuPct=abc&durationS=0&mode=spiky&wait=2m&async=maybe", nil)
+	err := BindParams(r, &loadParams{})
+	var errs ParamErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("BindParams() error = %v, want ParamErrors", err)
+	}
+	got := map[string]string{}
+	for _, e := range errs {
+		got[e.Param] = e.Reason
+	}
+	wantReasons := map[string]string{
+		"targetCpuPct": "must be a number",
+		"durationS":    "must be between 1 and 3600",
+		"mode":         "must be one of steady, burst",
+		"wait":         "must be at most 1m",
+		"async":        "must be true or false",
+		"name":         "is required",
+	}
+	if !reflect.DeepEqual(got, wantReasons) {
+		t.Errorf("reasons = %v, want %v", got, wantReasons)
+	}
+
+	// NaN is outside a max-only bound too.
+	var ratio struct {
+		Ratio float64 `param:"ratio" max:"1"`
+	}
+	r = httptest.NewRequest(http.MethodGet, "/?ratio=NaN", nil)
+	if err := BindParams(r, &ratio); !errors.As(err, &errs) || errs[0].Reason != "must be at most 1" {
+		t.Errorf("BindParams(ratio=NaN) error = %v, want must be at most 1", err)
+	}
+
+	if err := BindParams(r, loadParams{}); err == nil {
+		t.Error("BindParams(non-pointer) error = nil")
+	}
+	var bad struct {
+		Tags []string `param:"tags"`
+	}
+	if err := BindParams(r, &bad); err == nil {
+		t.Error("BindParams(unsupported field) error = nil")
+	}
+}
+
+func TestWriteParamError(t *testing.T) {
+	var errs ParamErrors
+	errs.Add(nil)
+	if errs.Err() != nil {
+		t.Fatalf("Err() = %v, want nil", errs.Err())
+	}
+	errs.Add(&ParamError{Param: "durationS", Value: "0", Reason: "must be at least 1"})
+	errs.Add(ParamErrors{{Param: "name", Reason: "is required"}})
+
+	w := httptest.NewRecorder()
+	WriteParamError(w, errs.Err())
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("response = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error  string
+		Params []ParamError
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Params) != 2 || body.Params[0].Param != "durationS" || body.Params[1].Reason != "is required" {
+		t.Errorf("body = %+v", body)
+	}
+}
@@ -0,0 +1,127 @@
+package goutils
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ServeOptions configures Serve. The zero value is ready to use.
+type ServeOptions struct {
+	// Addr is the address to listen on; ":" + $PORT, or ":8080", if empty.
+	Addr string
+	// ReadinessPath serves 200 while the server accepts traffic and 503
+	// once it is shutting down; "/readyz" if empty, "-" to disable.
+	ReadinessPath string
+	// Ready, if set, must also report true for the readiness check to
+	// pass, e.g. until caches are warm.
+	Ready func() bool
+	// ShutdownDelay keeps serving after readiness turns to 503, so load
+	// balancers and Kubernetes endpoints stop routing before connections
+	// are closed.
+	ShutdownDelay time.Duration
+	// DrainTimeout bounds how long in-flight requests may take to finish
+	// after shutdown begins; 10 seconds if zero. Remaining connections are
+	// then closed.
+	DrainTimeout time.Duration
+	// ReadHeaderTimeout bounds reading request headers; 10 seconds if zero.
+	ReadHeaderTimeout time.Duration
+	// OnListen, if set, is called with the listening address once the
+	// server accepts connections.
+	OnListen func(net.Addr)
+	// Logger logs startup and shutdown; slog.Default if nil.
+	Logger *slog.Logger
+}
+
+// Serve serves handler until ctx is cancelled or the process receives
+// SIGINT or SIGTERM, then shuts down gracefully: readiness flips to 503,
+// the server keeps serving for ShutdownDelay, and in-flight requests get
+// DrainTimeout to complete. It returns nil after a clean shutdown.
+func Serve(ctx context.Context, handler http.Handler, opts ServeOptions) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	addr := opts.Addr
+	if addr == "" {
+		addr = ":" + GetEnv("PORT", "8080")
+	}
+	drain := opts.DrainTimeout
+	if drain <= 0 {
+		drain = 10 * time.Second
+	}
+	readHeader := opts.ReadHeaderTimeout
+	if readHeader <= 0 {
+		readHeader = 10 * time.Second
+	}
+
+	var shuttingDown atomic.Bool
+	if path := opts.ReadinessPath; path != "-" {
+		if path == "" {
+			path = "/readyz"
+		}
+		handler = readinessHandler(path, handler, func() bool {
+			return !shuttingDown.Load() && (opts.Ready == nil || opts.Ready())
+		})
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: readHeader}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	logger.Info("server listening", "addr", ln.Addr().String())
+	if opts.OnListen != nil {
+		opts.OnListen(ln.Addr())
+	}
+
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-sigCtx.Done():
+	}
+
+	shuttingDown.Store(true)
+	logger.Info("server shutting down", "shutdownDelay", opts.ShutdownDelay, "drainTimeout", drain)
+	time.Sleep(opts.ShutdownDelay)
+
+	// The parent context is done, so draining runs on a fresh one.
+	drainCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		logger.Warn("server drain timed out, closing connections", "error", err)
+		srv.Close()
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("server stopped")
+	return nil
+}
+
+// readinessHandler answers path with the result of ready and passes other
+// requests to next.
+func readinessHandler(path string, next http.Handler, ready func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ready() {
+			http.Error(w, "shutting down or not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
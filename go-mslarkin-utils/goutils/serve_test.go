@@ -0,0 +1,109 @@
+package goutils
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		io.WriteString(w, "hello")
+	})
+	var warm atomic.Bool
+	addrc := make(chan net.Addr, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, handler, ServeOptions{
+			Addr:          "127.0.0.1:0",
+			Ready:         warm.Load,
+			ShutdownDelay: 100 * time.Millisecond,
+			OnListen:      func(a net.Addr) { addrc <- a },
+		})
+	}()
+	base := "http://" + (<-addrc).String()
+
+	get := func(path string) int {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := get("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("readyz before warm = %d, want 503", got)
+	}
+	warm.Store(true)
+	if got := get("/readyz"); got != http.StatusOK {
+		t.Errorf("readyz = %d, want 200", got)
+	}
+
+	// An in-flight request survives shutdown.
+	slow := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	// Readiness flips while the server keeps serving for ShutdownDelay.
+	time.Sleep(20 * time.Millisecond)
+	if got := get("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("readyz while shutting down = %d, want 503", got)
+	}
+	close(release)
+	if got := <-slow; got != http.StatusOK {
+		t.Errorf("in-flight request = %d, want 200", got)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Serve() = %v, want nil", err)
+	}
+}
+
+func TestServeDrainTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	addrc := make(chan net.Addr, 1)
+	done := make(chan error, 1)
+	stuck := make(chan struct{})
+	defer close(stuck)
+	go func() {
+		done <- Serve(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-stuck }), ServeOptions{
+			Addr:         "127.0.0.1:0",
+			DrainTimeout: 50 * time.Millisecond,
+			OnListen:     func(a net.Addr) { addrc <- a },
+		})
+	}()
+	addr := <-addrc
+	go http.Get("http://" + addr.String() + "/")
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	if err := <-done; err != nil || time.Since(start) > time.Second {
+		t.Errorf("Serve() = %v after %v, want nil shortly after the drain timeout", err, time.Since(start))
+	}
+}
+
+func TestServeListenError(t *testing.T) {
+	if err := Serve(context.Background(), http.NotFoundHandler(), ServeOptions{Addr: "256.0.0.1:0"}); err == nil {
+		t.Error("Serve(bad address) error = nil")
+	}
+}
@@ -0,0 +1,102 @@
+package goutils
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TraceContext identifies the trace and span a request belongs to.
+type TraceContext struct {
+	// TraceID is the 32 hex character trace ID.
+	TraceID string
+	// SpanID is the 16 hex character span ID, if known.
+	SpanID  string
+	Sampled bool
+}
+
+type traceContextKey struct{}
+
+// WithTraceContext returns a context carrying tc.
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFrom returns the trace context of ctx, if any.
+func TraceContextFrom(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// TraceMiddleware reads the trace context of incoming requests from the
+// W3C traceparent header, or else from Google's X-Cloud-Trace-Context
+// header, and stores it in the request context. Loggers created with
+// NewCloudLoggingHandler add it to every record logged with that context.
+func TraceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tc, ok := ParseTraceHeaders(r.Header); ok {
+			r = r.WithContext(WithTraceContext(r.Context(), tc))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ParseTraceHeaders reads the trace context from traceparent or
+// X-Cloud-Trace-Context, preferring traceparent.
+func ParseTraceHeaders(h http.Header) (TraceContext, bool) {
+	if tc, ok := parseTraceparent(h.Get("traceparent")); ok {
+		return tc, true
+	}
+	return parseCloudTraceContext(h.Get("X-Cloud-Trace-Context"))
+}
+
+// parseTraceparent parses a W3C traceparent: 00-<trace-id>-<span-id>-<flags>.
+func parseTraceparent(v string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return TraceContext{}, false
+	}
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if len(traceID) != 32 || !isHex(traceID) || allZero(traceID) ||
+		len(spanID) != 16 || !isHex(spanID) || allZero(spanID) ||
+		len(flags) != 2 || !isHex(flags) {
+		return TraceContext{}, false
+	}
+	f, _ := strconv.ParseUint(flags, 16, 8)
+	return TraceContext{TraceID: traceID, SpanID: spanID, Sampled: f&1 == 1}, true
+}
+
+// parseCloudTraceContext parses X-Cloud-Trace-Context: TRACE_ID/SPAN_ID;o=OPTIONS,
+// where SPAN_ID is decimal and both it and the options are optional.
+func parseCloudTraceContext(v string) (TraceContext, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return TraceContext{}, false
+	}
+	v, options, _ := strings.Cut(v, ";")
+	traceID, span, _ := strings.Cut(v, "/")
+	if len(traceID) != 32 || !isHex(traceID) {
+		return TraceContext{}, false
+	}
+	tc := TraceContext{TraceID: strings.ToLower(traceID), Sampled: options == "o=1"}
+	if id, err := strconv.ParseUint(span, 10, 64); err == nil && id != 0 {
+		// Cloud Logging expects span IDs as 16 hex characters.
+		tc.SpanID = strconv.FormatUint(id, 16)
+		tc.SpanID = strings.Repeat("0", 16-len(tc.SpanID)) + tc.SpanID
+	}
+	return tc, true
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func allZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
@@ -4,7 +4,7 @@ go 1.21.0
 
 require (
 	cloud.google.com/go/firestore v1.18.0
-	github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils v0.0.0-20240627225710-1acab1fc3d9f
+	github.com/mlarkin00/mslarkin/go-mslarkin-utils/goutils v0.0.0-20261018223156-33f459b92bf0
 	google.golang.org/api v0.214.0
 )
 
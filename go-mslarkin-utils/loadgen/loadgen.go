@@ -70,11 +70,23 @@ func CpuLoadGen(ctx context.Context, targetPct float64, showLogs bool) {
 	}
 }
 
+// cpuLoadParams returns the targetCpuPct and durationS parameters of r,
+// or the ParamErrors of the invalid ones.
+func cpuLoadParams(r *http.Request) (float64, int, error) {
+	var errs goutils.ParamErrors
+	targetCpuPct, err := goutils.Param(r, "targetCpuPct", 5.0, goutils.Range(0.0, 100.0))
+	errs.Add(err)
+	durationS, err := goutils.Param(r, "durationS", 1, goutils.Range(1, 3600))
+	errs.Add(err)
+	return targetCpuPct, durationS, errs.Err()
+}
+
 // ////////////////////////////////////////////////////
 // Trigger time-bound load with request
 // Request params
-// targetCpuPct - the % load to generate
-// durationS - the duration of the load
+// targetCpuPct - the % load to generate (0-100)
+// durationS - the duration of the load (1-3600)
+// Invalid params are answered with a 400
 // Env Var
 // NUM_CPU - the number of available/configured CPUs
 // /////////////////////////////////////////////////////
@@ -83,8 +95,11 @@ func CpuLoadHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 	r = r.WithContext(ctx)
 
-	targetCpuPct, _ := strconv.ParseFloat(goutils.GetParam(r, "targetCpuPct", "5"), 64)
-	durationS, _ := strconv.Atoi(goutils.GetParam(r, "durationS", "1"))
+	targetCpuPct, durationS, err := cpuLoadParams(r)
+	if err != nil {
+		goutils.WriteParamError(w, err)
+		return
+	}
 	// configCpus, _ := strconv.Atoi(goutils.GetEnv("NUM_CPU", "1"))
 	configCpus := getCpus()
 
@@ -103,8 +118,11 @@ func AsyncCpuLoadHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 	r = r.WithContext(ctx)
 
-	targetCpuPct, _ := strconv.ParseFloat(goutils.GetParam(r, "targetCpuPct", "5"), 64)
-	durationS, _ := strconv.Atoi(goutils.GetParam(r, "durationS", "1"))
+	targetCpuPct, durationS, err := cpuLoadParams(r)
+	if err != nil {
+		goutils.WriteParamError(w, err)
+		return
+	}
 	// configCpus, _ := strconv.Atoi(goutils.GetEnv("NUM_CPU", "1"))
 	configCpus := getCpus()
 
@@ -3,12 +3,19 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
     gke "k8s-status-backend/pkg/gke"
     k8s "k8s-status-backend/pkg/k8s"
+    "k8s-status-backend/pkg/scope"
+    "k8s-status-backend/pkg/source"
     status "k8s-status-backend/pkg/status"
 )
 
@@ -19,15 +26,99 @@ func main() {
         port = "8080"
     }
 
-    // Initialize Services
-    discovery, err := gke.NewDiscoveryClient(ctx)
+    // Projects, namespaces and workloads to monitor, from SCOPE_CONFIG
+    // and SCOPE_* variables
+    scopeConfig, err := scope.Load()
     if err != nil {
-        log.Fatalf("Failed to init GKE discovery: %v", err)
+        log.Fatalf("Failed to load scope: %v", err)
     }
-    defer discovery.Close()
 
-    clientManager := k8s.NewClientManager()
-    aggregator := status.NewAggregator(clientManager)
+    // Client-side rate limit for each cluster's API server
+    clientOpts := k8s.ClientOptions{}
+    if raw := os.Getenv("K8S_CLIENT_QPS"); raw != "" {
+        qps, err := strconv.ParseFloat(raw, 32)
+        if err != nil {
+            log.Fatalf("Invalid K8S_CLIENT_QPS %q", raw)
+        }
+        clientOpts.RateLimit.QPS = float32(qps)
+    }
+    if raw := os.Getenv("K8S_CLIENT_BURST"); raw != "" {
+        burst, err := strconv.Atoi(raw)
+        if err != nil {
+            log.Fatalf("Invalid K8S_CLIENT_BURST %q", raw)
+        }
+        clientOpts.RateLimit.Burst = burst
+    }
+    // Per-cluster overrides, as project/location/name=QPS:BURST,...
+    if raw := os.Getenv("K8S_CLIENT_CLUSTER_LIMITS"); raw != "" {
+        limits, err := k8s.ParseRateLimits(raw)
+        if err != nil {
+            log.Fatalf("Invalid K8S_CLIENT_CLUSTER_LIMITS: %v", err)
+        }
+        clientOpts.ClusterRateLimits = limits
+    }
+
+    // Clusters come from GKE discovery by default, or from kubeconfig
+    // contexts or the cluster the backend runs in, e.g. for local
+    // development against kind or minikube.
+    var clusterSource source.ClusterSource
+    switch kind := os.Getenv("CLUSTER_SOURCE"); kind {
+    case "", "gke":
+        discovery, err := gke.NewDiscoveryClient(ctx)
+        if err != nil {
+            log.Fatalf("Failed to init GKE discovery: %v", err)
+        }
+        defer discovery.Close()
+
+        var projects gke.ProjectSource = gke.StaticProjects(scopeConfig.Projects)
+        if scopeConfig.Discovery.Enabled() {
+            projects, err = gke.NewProjectSearch(ctx, scopeConfig.Discovery.Folders, scopeConfig.Discovery.Labels, scopeConfig.Projects)
+            if err != nil {
+                log.Fatalf("Failed to init project discovery: %v", err)
+            }
+        }
+
+        // Cluster inventory, refreshed in the background so requests don't
+        // each list clusters from the GKE API.
+        refreshInterval := 5 * time.Minute
+        if raw := os.Getenv("CLUSTER_REFRESH_INTERVAL"); raw != "" {
+            d, err := time.ParseDuration(raw)
+            if err != nil || d <= 0 {
+                log.Fatalf("Invalid CLUSTER_REFRESH_INTERVAL %q", raw)
+            }
+            refreshInterval = d
+        }
+        inventory := gke.NewInventoryFromSource(discovery, projects, refreshInterval)
+        go inventory.Run(ctx)
+
+        clientManager := k8s.NewClientManagerWithOptions(clientOpts)
+        defer clientManager.Close()
+        clusterSource = source.NewGKE(inventory, clientManager)
+    case "kubeconfig":
+        // Every context of $KUBECONFIG or ~/.kube/config
+        clusterSource = source.NewKubeconfig("", clientOpts.RateLimit)
+    case "in-cluster":
+        clusterSource = source.NewInCluster(gke.ClusterInfo{
+            ProjectID: os.Getenv("CLUSTER_PROJECT"),
+            Location:  os.Getenv("CLUSTER_LOCATION"),
+            Name:      os.Getenv("CLUSTER_NAME"),
+        }, clientOpts.RateLimit)
+    default:
+        log.Fatalf("Invalid CLUSTER_SOURCE %q, want gke, kubeconfig or in-cluster", kind)
+    }
+    aggregator := status.NewAggregatorWithScope(clusterSource, scopeConfig)
+
+    // In watch mode, cluster status is kept up to date by informers and
+    // streamed to clients, instead of being listed on every request.
+    var watcher *status.Watcher
+    switch mode := os.Getenv("STATUS_MODE"); mode {
+    case "", "poll":
+    case "watch":
+        watcher = status.NewWatcherWithScope(clusterSource, scopeConfig)
+        defer watcher.Close()
+    default:
+        log.Fatalf("Invalid STATUS_MODE %q, want poll or watch", mode)
+    }
 
     // Handlers
     mux := http.NewServeMux()
@@ -36,8 +127,9 @@ func main() {
     enableCORS := func(h http.HandlerFunc) http.HandlerFunc {
         return func(w http.ResponseWriter, r *http.Request) {
             w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all for demo
-            w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
             w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+            w.Header().Set("Access-Control-Expose-Headers", "X-Inventory-Refreshed-At, X-Inventory-Age-Seconds, X-Log-Limit-Reached")
             if r.Method == "OPTIONS" {
                 return
             }
@@ -45,63 +137,416 @@ func main() {
         }
     }
 
-    mux.HandleFunc("GET /api/status", enableCORS(func(w http.ResponseWriter, r *http.Request) {
-        // Projects to monitor
-        projects := []string{"mslarkin-ext", "mslarkin-demo"}
+    // setInventoryHeaders reports how fresh the cluster inventory is.
+    setInventoryHeaders := func(w http.ResponseWriter, snap gke.InventorySnapshot) {
+        if snap.RefreshedAt.IsZero() {
+            return
+        }
+        w.Header().Set("X-Inventory-Refreshed-At", snap.RefreshedAt.UTC().Format(time.RFC3339))
+        w.Header().Set("X-Inventory-Age-Seconds", strconv.Itoa(int(snap.Age(time.Now()).Seconds())))
+    }
 
-        clusters, err := discovery.ListClusters(r.Context(), projects)
-        if err != nil {
-            http.Error(w, "Failed to list clusters: "+err.Error(), http.StatusInternalServerError)
+    // statusResponse is every cluster's status, and the projects whose
+    // clusters could not be listed, so the UI can show them unavailable
+    // while rendering the others.
+    type statusResponse struct {
+        Clusters      []status.ClusterStatus `json:"clusters"`
+        ProjectErrors []gke.ProjectError     `json:"project_errors,omitempty"`
+    }
+
+    // inventoryClusters returns the source's clusters, writing the error
+    // response if there are none because discovery failed. Projects that
+    // could not be listed are left for the response to report.
+    inventoryClusters := func(w http.ResponseWriter, r *http.Request) (gke.InventorySnapshot, bool) {
+        snap, err := clusterSource.Clusters(r.Context())
+        if err != nil && len(snap.ProjectErrors) == 0 {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return snap, false
+        }
+        setInventoryHeaders(w, snap)
+        return snap, true
+    }
+
+    mux.HandleFunc("GET /api/status", enableCORS(func(w http.ResponseWriter, r *http.Request) {
+        snap, ok := inventoryClusters(w, r)
+        if !ok {
             return
         }
 
-        data := aggregator.FetchAll(r.Context(), clusters)
+        data := statusResponse{ProjectErrors: snap.ProjectErrors}
+        if watcher != nil {
+            data.Clusters = watcher.Status(r.Context(), snap.Clusters)
+        } else {
+            data.Clusters = aggregator.FetchAll(r.Context(), snap.Clusters)
+        }
 
         w.Header().Set("Content-Type", "application/json")
         json.NewEncoder(w).Encode(data)
     }))
 
+    // Stream status changes as Server-Sent Events: a "snapshot" event with
+    // every cluster's status, then one event per change. A cluster still
+    // syncing is sent whole in a "cluster_synced" event once it has.
+    mux.HandleFunc("GET /api/status/stream", enableCORS(func(w http.ResponseWriter, r *http.Request) {
+        if watcher == nil {
+            http.Error(w, "Status streaming requires STATUS_MODE=watch", http.StatusNotImplemented)
+            return
+        }
+        flusher, ok := w.(http.Flusher)
+        if !ok {
+            http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+            return
+        }
+
+        // Subscribe before the snapshot so no change is missed.
+        events, unsubscribe := watcher.Subscribe()
+        defer unsubscribe()
+
+        snap, ok := inventoryClusters(w, r)
+        if !ok {
+            return
+        }
+
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        w.Header().Set("Connection", "keep-alive")
+
+        writeEvent := func(event string, data any) error {
+            payload, err := json.Marshal(data)
+            if err != nil {
+                return err
+            }
+            if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+                return err
+            }
+            flusher.Flush()
+            return nil
+        }
+        snapshot := statusResponse{
+            Clusters:      watcher.Status(r.Context(), snap.Clusters),
+            ProjectErrors: snap.ProjectErrors,
+        }
+        if err := writeEvent("snapshot", snapshot); err != nil {
+            return
+        }
+
+        heartbeat := time.NewTicker(15 * time.Second)
+        defer heartbeat.Stop()
+        for {
+            select {
+            case <-r.Context().Done():
+                return
+            case e, ok := <-events:
+                if !ok {
+                    // Fell behind; the client reconnects and reloads.
+                    return
+                }
+                if err := writeEvent(e.Type, e); err != nil {
+                    return
+                }
+            case <-heartbeat.C:
+                if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+                    return
+                }
+                flusher.Flush()
+            }
+        }
+    }))
+
+    // Scope of the status: the configuration, the projects monitored, and
+    // the namespaces in scope of each cluster, for the frontend's pickers.
+    mux.HandleFunc("GET /api/scope", enableCORS(func(w http.ResponseWriter, r *http.Request) {
+        snap, ok := inventoryClusters(w, r)
+        if !ok {
+            return
+        }
+
+        resp := struct {
+            Projects      []string              `json:"projects"`
+            Config        *scope.Config         `json:"config"`
+            Clusters      []status.ClusterScope `json:"clusters"`
+            ProjectErrors []gke.ProjectError    `json:"project_errors,omitempty"`
+        }{
+            Projects:      snap.Projects,
+            Config:        scopeConfig,
+            Clusters:      aggregator.ClusterScopes(r.Context(), snap.Clusters),
+            ProjectErrors: snap.ProjectErrors,
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(resp)
+    }))
+
+    // lookupCluster looks up the cluster's Endpoint and CaCert in the
+    // source, writing the error response if it fails. Only clusters the
+    // source tracks and namespaces in their scope are served.
+    lookupCluster := func(w http.ResponseWriter, r *http.Request, project, location, clusterName, namespace string) (gke.ClusterInfo, bool) {
+        cluster, err := clusterSource.Lookup(r.Context(), project, location, clusterName)
+        if errors.Is(err, gke.ErrClusterNotFound) {
+            http.Error(w, "Cluster not found", http.StatusNotFound)
+            return gke.ClusterInfo{}, false
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return gke.ClusterInfo{}, false
+        }
+        if !scopeConfig.Filter(cluster).IncludesNamespace(namespace) {
+            http.Error(w, "Namespace not in scope", http.StatusForbidden)
+            return gke.ClusterInfo{}, false
+        }
+        setInventoryHeaders(w, clusterSource.Snapshot())
+        return cluster, true
+    }
+
     mux.HandleFunc("GET /api/pods", enableCORS(func(w http.ResponseWriter, r *http.Request) {
         // Required params: project, location, cluster, namespace, workload
+        // Optional: kind of the workload, Deployment by default
         project := r.URL.Query().Get("project")
         location := r.URL.Query().Get("location")
         clusterName := r.URL.Query().Get("cluster")
         namespace := r.URL.Query().Get("namespace")
         workload := r.URL.Query().Get("workload")
+        kind := r.URL.Query().Get("kind")
+        if kind == "" {
+            kind = "Deployment"
+        }
 
         if project == "" || location == "" || clusterName == "" || namespace == "" || workload == "" {
             http.Error(w, "Missing required parameters", http.StatusBadRequest)
             return
         }
 
-        // Fetch full cluster info to get Endpoint and CaCert
-        clusters, err := discovery.ListClusters(r.Context(), []string{project})
+        targetCluster, ok := lookupCluster(w, r, project, location, clusterName, namespace)
+        if !ok {
+            return
+        }
+
+        pods, err := aggregator.GetWorkloadPods(r.Context(), targetCluster, namespace, kind, workload)
         if err != nil {
-             http.Error(w, "Failed to list clusters: "+err.Error(), http.StatusInternalServerError)
-             return
+            http.Error(w, "Failed to get pods: "+err.Error(), http.StatusInternalServerError)
+            return
         }
 
-        var targetCluster *gke.ClusterInfo
-        for _, c := range clusters {
-            if c.Name == clusterName && c.Location == location {
-                targetCluster = &c
-                break
-            }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(pods)
+    }))
+
+    mux.HandleFunc("GET /api/events", enableCORS(func(w http.ResponseWriter, r *http.Request) {
+        // Required params: project, location, cluster, namespace, name
+        // Optional: kind of the workload, Deployment by default
+        project := r.URL.Query().Get("project")
+        location := r.URL.Query().Get("location")
+        clusterName := r.URL.Query().Get("cluster")
+        namespace := r.URL.Query().Get("namespace")
+        name := r.URL.Query().Get("name")
+        kind := r.URL.Query().Get("kind")
+        if kind == "" {
+            kind = "Deployment"
         }
 
-        if targetCluster == nil {
-            http.Error(w, "Cluster not found", http.StatusNotFound)
+        if project == "" || location == "" || clusterName == "" || namespace == "" || name == "" {
+            http.Error(w, "Missing required parameters", http.StatusBadRequest)
             return
         }
 
-        pods, err := aggregator.GetWorkloadPods(r.Context(), *targetCluster, namespace, workload)
+        targetCluster, ok := lookupCluster(w, r, project, location, clusterName, namespace)
+        if !ok {
+            return
+        }
+
+        events, err := aggregator.GetWorkloadEvents(r.Context(), targetCluster, namespace, kind, name)
         if err != nil {
-            http.Error(w, "Failed to get pods: "+err.Error(), http.StatusInternalServerError)
+            http.Error(w, "Failed to get events: "+err.Error(), http.StatusInternalServerError)
             return
         }
 
         w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(pods)
+        json.NewEncoder(w).Encode(events)
+    }))
+
+    mux.HandleFunc("GET /api/logs", enableCORS(func(w http.ResponseWriter, r *http.Request) {
+        // Required params: project, location, cluster, namespace, and pod
+        // or workload (with kind, Deployment by default) to aggregate the
+        // logs of every pod of the workload.
+        // Optional: container, previous, tailLines, sinceSeconds, follow
+        q := r.URL.Query()
+        project := q.Get("project")
+        location := q.Get("location")
+        clusterName := q.Get("cluster")
+        namespace := q.Get("namespace")
+        opts := status.LogOptions{
+            Pod:       q.Get("pod"),
+            Kind:      q.Get("kind"),
+            Workload:  q.Get("workload"),
+            Container: q.Get("container"),
+        }
+        if opts.Kind == "" {
+            opts.Kind = "Deployment"
+        }
+
+        if project == "" || location == "" || clusterName == "" || namespace == "" || (opts.Pod == "") == (opts.Workload == "") {
+            http.Error(w, "Missing required parameters: project, location, cluster, namespace and one of pod or workload", http.StatusBadRequest)
+            return
+        }
+        var err error
+        if raw := q.Get("previous"); raw != "" {
+            if opts.Previous, err = strconv.ParseBool(raw); err != nil {
+                http.Error(w, "previous must be true or false", http.StatusBadRequest)
+                return
+            }
+        }
+        if raw := q.Get("follow"); raw != "" {
+            if opts.Follow, err = strconv.ParseBool(raw); err != nil {
+                http.Error(w, "follow must be true or false", http.StatusBadRequest)
+                return
+            }
+        }
+        if raw := q.Get("tailLines"); raw != "" {
+            opts.TailLines, err = strconv.ParseInt(raw, 10, 64)
+            if err != nil || opts.TailLines < 1 || opts.TailLines > status.MaxTailLines {
+                http.Error(w, fmt.Sprintf("tailLines must be between 1 and %d", status.MaxTailLines), http.StatusBadRequest)
+                return
+            }
+        }
+        if raw := q.Get("sinceSeconds"); raw != "" {
+            opts.SinceSeconds, err = strconv.ParseInt(raw, 10, 64)
+            if err != nil || opts.SinceSeconds < 1 {
+                http.Error(w, "sinceSeconds must be a positive integer", http.StatusBadRequest)
+                return
+            }
+        }
+
+        targetCluster, ok := lookupCluster(w, r, project, location, clusterName, namespace)
+        if !ok {
+            return
+        }
+
+        // Aggregated logs are prefixed with the name of their pod.
+        format := func(l status.LogLine) string {
+            text := l.Line
+            if l.Error != "" {
+                text = "error: " + l.Error
+            }
+            if l.Truncated {
+                text += " [truncated]"
+            }
+            if opts.Pod == "" {
+                text = "[" + l.Pod + "] " + text
+            }
+            return text
+        }
+
+        if !opts.Follow {
+            // Logs are bounded by the limits, so they are buffered and
+            // failures are reported with a proper status.
+            var buf strings.Builder
+            err := aggregator.StreamLogs(r.Context(), targetCluster, namespace, opts, func(l status.LogLine) error {
+                buf.WriteString(format(l))
+                buf.WriteByte('\n')
+                return nil
+            })
+            if errors.Is(err, status.ErrLogLimit) {
+                w.Header().Set("X-Log-Limit-Reached", "true")
+            } else if err != nil {
+                http.Error(w, "Failed to get logs: "+err.Error(), http.StatusInternalServerError)
+                return
+            }
+            w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+            fmt.Fprint(w, buf.String())
+            return
+        }
+
+        flusher, ok := w.(http.Flusher)
+        if !ok {
+            http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        w.Header().Set("Connection", "keep-alive")
+        flusher.Flush()
+
+        lines := make(chan status.LogLine, 64)
+        done := make(chan error, 1)
+        go func() {
+            done <- aggregator.StreamLogs(r.Context(), targetCluster, namespace, opts, func(l status.LogLine) error {
+                select {
+                case lines <- l:
+                    return nil
+                case <-r.Context().Done():
+                    return r.Context().Err()
+                }
+            })
+        }()
+
+        // Log lines are sent as default events, and pods whose logs could
+        // not be read as error events. Limit and end events tell the client
+        // why the stream ended so it does not reconnect.
+        writeLine := func(l status.LogLine) error {
+            event := ""
+            if l.Error != "" {
+                event = "event: error\n"
+            }
+            _, err := fmt.Fprintf(w, "%sdata: %s\n\n", event, format(l))
+            return err
+        }
+        heartbeat := time.NewTicker(15 * time.Second)
+        defer heartbeat.Stop()
+        for {
+            select {
+            case <-r.Context().Done():
+                return
+            case l := <-lines:
+                if err := writeLine(l); err != nil {
+                    return
+                }
+                flusher.Flush()
+            case err := <-done:
+                // Send what was emitted before the stream ended.
+                for len(lines) > 0 {
+                    writeLine(<-lines)
+                }
+                switch {
+                case errors.Is(err, status.ErrLogLimit):
+                    fmt.Fprintf(w, "event: limit\ndata: %s\n\n", err)
+                case err != nil:
+                    fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+                }
+                fmt.Fprint(w, "event: end\ndata: \n\n")
+                flusher.Flush()
+                return
+            case <-heartbeat.C:
+                if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+                    return
+                }
+                flusher.Flush()
+            }
+        }
+    }))
+
+    // Force an inventory refresh, e.g. after creating or deleting a cluster
+    mux.HandleFunc("POST /api/clusters/refresh", enableCORS(func(w http.ResponseWriter, r *http.Request) {
+        refreshErr := clusterSource.Refresh(r.Context())
+        snap := clusterSource.Snapshot()
+        setInventoryHeaders(w, snap)
+
+        resp := struct {
+            Clusters      int                `json:"clusters"`
+            RefreshedAt   time.Time          `json:"refreshed_at"`
+            AgeSeconds    int                `json:"age_seconds"`
+            Error         string             `json:"error,omitempty"`
+            ProjectErrors []gke.ProjectError `json:"project_errors,omitempty"`
+        }{
+            Clusters:      len(snap.Clusters),
+            RefreshedAt:   snap.RefreshedAt,
+            AgeSeconds:    int(snap.Age(time.Now()).Seconds()),
+            ProjectErrors: snap.ProjectErrors,
+        }
+        w.Header().Set("Content-Type", "application/json")
+        if refreshErr != nil {
+            resp.Error = "Failed to refresh clusters: " + refreshErr.Error()
+            w.WriteHeader(http.StatusBadGateway)
+        }
+        json.NewEncoder(w).Encode(resp)
     }))
 
     mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -54,28 +54,6 @@ const (
 	CacheTTL       = 30 * time.Second
 )
 
-// IDTokenTransport injects an OIDC ID token.
-type IDTokenTransport struct {
-	Base     http.RoundTripper
-	Audience string
-}
-
-func (t *IDTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	token, err := gcputils.GetIDToken(req.Context(), t.Audience)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ID token: %w", err)
-	}
-
-	req2 := req.Clone(req.Context())
-	req2.Header.Set("Authorization", "Bearer "+token)
-
-	base := t.Base
-	if base == nil {
-		base = http.DefaultTransport
-	}
-	return base.RoundTrip(req2)
-}
-
 // AccessTokenTransport injects an OAuth2 access token.
 type AccessTokenTransport struct {
 	Base http.RoundTripper
@@ -136,7 +114,8 @@ func NewMCPClient(ctx context.Context) (*MCPClient, error) {
 	ossTrans := &mcp.StreamableClientTransport{
 		Endpoint: OSSMCPEndpoint,
 		HTTPClient: &http.Client{
-			Transport: &IDTokenTransport{Audience: ossMcpIAPClientID},
+			// The transport caches the ID token until shortly before it expires.
+			Transport: gcputils.NewIDTokenTransport(ossMcpIAPClientID, nil),
 			Timeout:   120 * time.Second,
 		},
 	}
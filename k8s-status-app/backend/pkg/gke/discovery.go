@@ -2,10 +2,8 @@ package gke
 
 import (
 	"context"
-	"fmt"
 
-	container "cloud.google.com/go/container/apiv1"
-	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"github.com/mlarkin00/mslarkin/go-mslarkin-utils/gkeutils"
 )
 
 // ClusterInfo contains minimal info needed to connect to a cluster
@@ -19,55 +17,73 @@ type ClusterInfo struct {
     CaCert    string `json:"-"`
 }
 
+// DiscoveryClient lists the clusters of projects through the shared
+// gkeutils inventory, which lists projects in parallel.
 type DiscoveryClient struct {
-	client *container.ClusterManagerClient
+	inv *gkeutils.Inventory
 }
 
+// NewDiscoveryClient returns a DiscoveryClient backed by a new GKE cluster
+// manager client. Close it when done.
 func NewDiscoveryClient(ctx context.Context) (*DiscoveryClient, error) {
-	c, err := container.NewClusterManagerClient(ctx)
+	inv, err := gkeutils.New(ctx, discoveryOptions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cluster manager client: %w", err)
+		return nil, err
 	}
-	return &DiscoveryClient{client: c}, nil
+	return &DiscoveryClient{inv: inv}, nil
 }
 
-func (d *DiscoveryClient) Close() error {
-	return d.client.Close()
+// NewDiscoveryClientWithLister returns a DiscoveryClient backed by lister,
+// which the caller owns.
+func NewDiscoveryClientWithLister(lister gkeutils.ClusterLister) *DiscoveryClient {
+	return &DiscoveryClient{inv: gkeutils.NewWithLister(lister, discoveryOptions)}
 }
 
-// ListClusters returns a list of clusters for the given project IDs
-func (d *DiscoveryClient) ListClusters(ctx context.Context, projectIDs []string) ([]ClusterInfo, error) {
-	var clusters []ClusterInfo
+// discoveryOptions disables the gkeutils cache: Inventory decides when
+// clusters are listed again.
+var discoveryOptions = gkeutils.Options{TTL: -1}
 
-	for _, pid := range projectIDs {
-		// List clusters in the project (all locations)
-		parent := fmt.Sprintf("projects/%s/locations/-", pid)
-		req := &containerpb.ListClustersRequest{
-			Parent: parent,
-		}
+func (d *DiscoveryClient) Close() error {
+	return d.inv.Close()
+}
 
-		resp, err := d.client.ListClusters(ctx, req)
-		if err != nil {
-			// Return error for now, maybe log and continue in future if partial success needed
-			return nil, fmt.Errorf("failed to list clusters in project %s: %w", pid, err)
-		}
+// ProjectError is a project whose clusters could not be listed, e.g. for
+// lack of permission.
+type ProjectError struct {
+	ProjectID string `json:"project_id"`
+	Error     string `json:"error"`
+}
 
-		for _, c := range resp.Clusters {
-            caCert := ""
-            if c.MasterAuth != nil {
-                caCert = c.MasterAuth.ClusterCaCertificate
-            }
+// ListClusters returns the clusters of the given project IDs, listing the
+// projects in parallel. A project that fails does not fail the others: its
+// error is returned, in project order, with the clusters of the rest. A
+// project listed only partly, e.g. with a zone unavailable, fails too.
+func (d *DiscoveryClient) ListClusters(ctx context.Context, projectIDs []string) ([]ClusterInfo, []ProjectError) {
+	res := d.inv.List(ctx, projectIDs)
 
-			clusters = append(clusters, ClusterInfo{
-				Name:      c.Name,
-				Location:  c.Location,
-				Endpoint:  c.Endpoint,
-				ProjectID: pid,
-				Status:    c.Status.String(),
-                CaCert:    caCert,
-			})
+	var projectErrs []ProjectError
+	for _, pid := range projectIDs {
+		if err, ok := res.Errors[pid]; ok {
+			projectErrs = append(projectErrs, ProjectError{ProjectID: pid, Error: err.Error()})
 		}
 	}
+	var clusters []ClusterInfo
+	for _, c := range res.Clusters {
+		if _, failed := res.Errors[c.ProjectID]; !failed {
+			clusters = append(clusters, newClusterInfo(c))
+		}
+	}
+	return clusters, projectErrs
+}
 
-	return clusters, nil
+// newClusterInfo converts a cluster of the gkeutils inventory.
+func newClusterInfo(c gkeutils.Cluster) ClusterInfo {
+	return ClusterInfo{
+		Name:      c.Name,
+		Location:  c.Location,
+		Endpoint:  c.Endpoint,
+		ProjectID: c.ProjectID,
+		Status:    c.Status,
+		CaCert:    c.CACertificate,
+	}
 }
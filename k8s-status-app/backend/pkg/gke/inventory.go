@@ -0,0 +1,291 @@
+package gke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClusterNotFound is returned by Inventory.Lookup for unknown clusters.
+var ErrClusterNotFound = errors.New("cluster not found")
+
+// ClusterLister lists the clusters of projects; *DiscoveryClient is one.
+// It returns the clusters of the projects it could list, and an error for
+// each project it could not.
+type ClusterLister interface {
+	ListClusters(ctx context.Context, projectIDs []string) ([]ClusterInfo, []ProjectError)
+}
+
+// lookupRefreshGap is the minimum age of the inventory before a lookup miss
+// triggers a refresh, so that requests for unknown clusters can't hammer
+// the GKE API.
+const lookupRefreshGap = 30 * time.Second
+
+// Until the inventory has been refreshed successfully, requests list the
+// clusters themselves. After a failure they wait minRetryBackoff before
+// trying again, doubling up to maxRetryBackoff, so an outage of the GKE API
+// doesn't turn every request into a GKE API call.
+const (
+	minRetryBackoff = 5 * time.Second
+	maxRetryBackoff = 5 * time.Minute
+)
+
+// Inventory caches the clusters of the projects of a ProjectSource and
+// refreshes them in the background, so that requests are served without
+// calling the GKE API.
+type Inventory struct {
+	lister   ClusterLister
+	source   ProjectSource
+	interval time.Duration
+	now      func() time.Time
+
+	// refreshMu serializes refreshes, and guards lastAttempt and failures.
+	refreshMu sync.Mutex
+	// lastAttempt is when the last refresh started, and failures how many
+	// refreshes in a row failed.
+	lastAttempt time.Time
+	failures    int
+
+	mu sync.RWMutex
+	// projects are those listed by the last successful refresh.
+	projects    []string
+	clusters    []ClusterInfo
+	refreshedAt time.Time
+	err         error
+	// projectErrors are the projects the last refresh could not list.
+	projectErrors []ProjectError
+}
+
+// InventorySnapshot is the state of the inventory after its last refresh.
+type InventorySnapshot struct {
+	// Projects are the projects tracked, including those without clusters.
+	Projects []string
+	Clusters []ClusterInfo
+	// RefreshedAt is when the clusters were last listed successfully.
+	RefreshedAt time.Time
+	// Err is the error of the last refresh, if it failed. Clusters are then
+	// those of the last successful refresh.
+	Err error
+	// ProjectErrors are the projects whose clusters the last refresh could
+	// not list. Their clusters are those last listed.
+	ProjectErrors []ProjectError
+}
+
+// Age returns how old the snapshot is at now.
+func (s InventorySnapshot) Age(now time.Time) time.Duration {
+	if s.RefreshedAt.IsZero() {
+		return 0
+	}
+	return now.Sub(s.RefreshedAt)
+}
+
+// NewInventory returns an inventory of the clusters in projects, refreshed
+// every interval once Run is called.
+func NewInventory(lister ClusterLister, projects []string, interval time.Duration) *Inventory {
+	inv := NewInventoryFromSource(lister, StaticProjects(projects), interval)
+	inv.projects = projects
+	return inv
+}
+
+// NewInventoryFromSource returns an inventory of the clusters in the
+// projects of source, which are listed again on every refresh.
+func NewInventoryFromSource(lister ClusterLister, source ProjectSource, interval time.Duration) *Inventory {
+	return &Inventory{
+		lister:   lister,
+		source:   source,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Projects returns the projects the inventory tracks.
+func (inv *Inventory) Projects() []string {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	return append([]string(nil), inv.projects...)
+}
+
+// Run refreshes the inventory now and then every interval until ctx is
+// done. Refresh errors are logged and the previous clusters kept.
+func (inv *Inventory) Run(ctx context.Context) {
+	ticker := time.NewTicker(inv.interval)
+	defer ticker.Stop()
+	for {
+		if err := inv.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Cluster inventory refresh failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh lists the projects and the clusters of every project now.
+// Projects that fail are reported in snapshots' ProjectErrors and keep the
+// clusters last listed. Refresh fails, keeping the previous projects and
+// clusters, if the projects cannot be listed or none of them can.
+func (inv *Inventory) Refresh(ctx context.Context) error {
+	inv.refreshMu.Lock()
+	defer inv.refreshMu.Unlock()
+	return inv.refreshLocked(ctx)
+}
+
+// refreshLocked refreshes the inventory, with refreshMu held.
+func (inv *Inventory) refreshLocked(ctx context.Context) error {
+	inv.lastAttempt = inv.now()
+	inv.failures++
+	projects, err := inv.source.ListProjects(ctx)
+	var clusters []ClusterInfo
+	var projectErrs []ProjectError
+	if err == nil {
+		clusters, projectErrs = inv.lister.ListClusters(ctx, projects)
+		if len(projectErrs) > 0 && len(projectErrs) == len(projects) {
+			err = projectsError(projectErrs)
+		}
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.err = err
+	inv.projectErrors = projectErrs
+	if err != nil {
+		return err
+	}
+	failed := map[string]bool{}
+	for _, pe := range projectErrs {
+		failed[pe.ProjectID] = true
+	}
+	for _, c := range inv.clusters {
+		if failed[c.ProjectID] {
+			clusters = append(clusters, c)
+		}
+	}
+	inv.projects = projects
+	inv.clusters = clusters
+	inv.refreshedAt = inv.now()
+	inv.failures = 0
+	return nil
+}
+
+// retryBackoff returns how long after a failed refresh requests wait
+// before refreshing again, with refreshMu held.
+func (inv *Inventory) retryBackoff() time.Duration {
+	if inv.failures == 0 {
+		return 0
+	}
+	backoff := minRetryBackoff
+	for i := 1; i < inv.failures && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryBackoff)
+}
+
+// projectsError summarizes the errors of projects in one error.
+func projectsError(errs []ProjectError) error {
+	msgs := make([]string, len(errs))
+	for i, pe := range errs {
+		msgs[i] = pe.Error
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// Snapshot returns the cached clusters without refreshing.
+func (inv *Inventory) Snapshot() InventorySnapshot {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	return InventorySnapshot{
+		Projects:      append([]string(nil), inv.projects...),
+		Clusters:      append([]ClusterInfo(nil), inv.clusters...),
+		RefreshedAt:   inv.refreshedAt,
+		Err:           inv.err,
+		ProjectErrors: append([]ProjectError(nil), inv.projectErrors...),
+	}
+}
+
+// Clusters returns the cached clusters, listing them first if the
+// inventory has never been refreshed successfully. After a failure,
+// callers get its error until the retry backoff has passed.
+func (inv *Inventory) Clusters(ctx context.Context) (InventorySnapshot, error) {
+	snap := inv.Snapshot()
+	if !snap.RefreshedAt.IsZero() {
+		return snap, nil
+	}
+
+	inv.refreshMu.Lock()
+	// Another request may have refreshed, or failed to, while this one
+	// waited for the lock.
+	if inv.Snapshot().RefreshedAt.IsZero() && inv.now().Sub(inv.lastAttempt) >= inv.retryBackoff() {
+		inv.refreshLocked(ctx)
+	}
+	inv.refreshMu.Unlock()
+
+	snap = inv.Snapshot()
+	if snap.RefreshedAt.IsZero() {
+		return snap, fmt.Errorf("failed to list clusters: %w", snap.Err)
+	}
+	return snap, nil
+}
+
+// Lookup returns the cluster with the given project, location and name.
+// A miss on a tracked project refreshes the inventory, at most once per
+// lookupRefreshGap and not within the retry backoff of a failed refresh,
+// in case the cluster was created since. Clusters of projects the
+// inventory does not track are not found.
+func (inv *Inventory) Lookup(ctx context.Context, project, location, name string) (ClusterInfo, error) {
+	// The tracked projects are only known once the inventory has been
+	// refreshed when they come from a ProjectSource.
+	snap, err := inv.Clusters(ctx)
+	if err != nil {
+		return ClusterInfo{}, err
+	}
+	if !tracks(snap.Projects, project) {
+		return ClusterInfo{}, ErrClusterNotFound
+	}
+	if c, ok := findCluster(snap.Clusters, project, location, name); ok {
+		return c, nil
+	}
+
+	inv.refreshMu.Lock()
+	defer inv.refreshMu.Unlock()
+	// Another lookup may have refreshed, or failed to, while this one
+	// waited for the lock.
+	snap = inv.Snapshot()
+	if c, ok := findCluster(snap.Clusters, project, location, name); ok {
+		return c, nil
+	}
+	now := inv.now()
+	if snap.Age(now) < lookupRefreshGap || now.Sub(inv.lastAttempt) < inv.retryBackoff() {
+		return ClusterInfo{}, ErrClusterNotFound
+	}
+	if err := inv.refreshLocked(ctx); err != nil {
+		return ClusterInfo{}, fmt.Errorf("failed to list clusters: %w", err)
+	}
+	if c, ok := findCluster(inv.Snapshot().Clusters, project, location, name); ok {
+		return c, nil
+	}
+	return ClusterInfo{}, ErrClusterNotFound
+}
+
+func tracks(projects []string, project string) bool {
+	for _, p := range projects {
+		if p == project {
+			return true
+		}
+	}
+	return false
+}
+
+func findCluster(clusters []ClusterInfo, project, location, name string) (ClusterInfo, bool) {
+	for _, c := range clusters {
+		if c.ProjectID == project && c.Location == location && c.Name == name {
+			return c, true
+		}
+	}
+	return ClusterInfo{}, false
+}
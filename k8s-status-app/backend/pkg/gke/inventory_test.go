@@ -0,0 +1,75 @@
+package gke
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingLister lists the clusters of every project until err is set.
+type failingLister struct {
+	mu       sync.Mutex
+	clusters []ClusterInfo
+	err      error
+	calls    int
+}
+
+func (l *failingLister) ListClusters(ctx context.Context, projectIDs []string) ([]ClusterInfo, []ProjectError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if l.err == nil {
+		return l.clusters, nil
+	}
+	var errs []ProjectError
+	for _, pid := range projectIDs {
+		errs = append(errs, ProjectError{ProjectID: pid, Error: l.err.Error()})
+	}
+	return nil, errs
+}
+
+func TestInventory_LookupBacksOffAfterFailure(t *testing.T) {
+	lister := &failingLister{clusters: []ClusterInfo{{Name: "ext", Location: "us-central1", ProjectID: "mslarkin-ext"}}}
+	inv := NewInventory(lister, []string{"mslarkin-ext"}, time.Hour)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	inv.now = func() time.Time { return now }
+	ctx := context.Background()
+	if err := inv.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	// Once the inventory is older than the gap, a miss refreshes it, and
+	// the failure is reported.
+	lister.err = errors.New("unavailable")
+	now = now.Add(lookupRefreshGap)
+	if _, err := inv.Lookup(ctx, "mslarkin-ext", "us-central1", "new"); err == nil || errors.Is(err, ErrClusterNotFound) {
+		t.Fatalf("Lookup(new) error = %v, want the refresh error", err)
+	}
+	if lister.calls != 2 {
+		t.Fatalf("lister called %d times, want 2", lister.calls)
+	}
+
+	// Further misses within the retry backoff don't list the clusters.
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Second)
+		if _, err := inv.Lookup(ctx, "mslarkin-ext", "us-central1", "new"); !errors.Is(err, ErrClusterNotFound) {
+			t.Errorf("Lookup(new) error = %v, want ErrClusterNotFound", err)
+		}
+	}
+	if lister.calls != 2 {
+		t.Errorf("lister called %d times within the backoff, want 2", lister.calls)
+	}
+
+	// After the backoff, a miss refreshes again.
+	lister.err = nil
+	lister.clusters = append(lister.clusters, ClusterInfo{Name: "new", Location: "us-central1", ProjectID: "mslarkin-ext"})
+	now = now.Add(minRetryBackoff)
+	if c, err := inv.Lookup(ctx, "mslarkin-ext", "us-central1", "new"); err != nil || c.Name != "new" {
+		t.Errorf("Lookup(new) = %+v, %v", c, err)
+	}
+	if lister.calls != 3 {
+		t.Errorf("lister called %d times, want 3", lister.calls)
+	}
+}
@@ -0,0 +1,95 @@
+package gke
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v3"
+)
+
+// ProjectSource lists the projects whose clusters are monitored.
+type ProjectSource interface {
+	ListProjects(ctx context.Context) ([]string, error)
+}
+
+// StaticProjects is a fixed list of projects.
+type StaticProjects []string
+
+// ListProjects returns the projects.
+func (p StaticProjects) ListProjects(context.Context) ([]string, error) {
+	return append([]string(nil), p...), nil
+}
+
+// ProjectSearch discovers projects through the Resource Manager API: the
+// active projects under any of Folders (or anywhere, if none are given)
+// that have all of Labels, plus the explicit Projects.
+type ProjectSearch struct {
+	Folders  []string
+	Labels   map[string]string
+	Projects []string
+
+	service *cloudresourcemanager.Service
+}
+
+// NewProjectSearch returns a ProjectSearch using application default
+// credentials, which need resourcemanager.projects.get on the projects to
+// find.
+func NewProjectSearch(ctx context.Context, folders []string, labels map[string]string, projects []string) (*ProjectSearch, error) {
+	svc, err := cloudresourcemanager.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager client: %w", err)
+	}
+	return &ProjectSearch{Folders: folders, Labels: labels, Projects: projects, service: svc}, nil
+}
+
+// ListProjects returns the IDs of the projects found and the explicit
+// projects, sorted and without duplicates.
+func (s *ProjectSearch) ListProjects(ctx context.Context) ([]string, error) {
+	found := map[string]bool{}
+	for _, p := range s.Projects {
+		found[p] = true
+	}
+	for _, query := range s.queries() {
+		err := s.service.Projects.Search().Query(query).Pages(ctx, func(resp *cloudresourcemanager.SearchProjectsResponse) error {
+			for _, p := range resp.Projects {
+				found[p.ProjectId] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to search projects (%s): %w", query, err)
+		}
+	}
+
+	projects := make([]string, 0, len(found))
+	for p := range found {
+		projects = append(projects, p)
+	}
+	sort.Strings(projects)
+	return projects, nil
+}
+
+// queries returns one search query per folder, since terms of a query are
+// all required.
+func (s *ProjectSearch) queries() []string {
+	terms := []string{"state:ACTIVE"}
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		terms = append(terms, fmt.Sprintf("labels.%s:%s", k, s.Labels[k]))
+	}
+	base := strings.Join(terms, " ")
+	if len(s.Folders) == 0 {
+		return []string{base}
+	}
+	queries := make([]string, 0, len(s.Folders))
+	for _, f := range s.Folders {
+		queries = append(queries, "parent:folders/"+strings.TrimPrefix(f, "folders/")+" "+base)
+	}
+	return queries
+}
@@ -4,49 +4,334 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-    "net/http"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
 
 	"golang.org/x/oauth2"
-    "golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/google"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-    gke "k8s-status-backend/pkg/gke"
 )
 
+// Defaults for ClientOptions.
+const (
+	DefaultQPS         = 20
+	DefaultBurst       = 40
+	DefaultIdleTimeout = 15 * time.Minute
+)
+
+// RateLimit is the client-side request rate limit for a cluster.
+type RateLimit struct {
+	QPS   float32
+	Burst int
+}
+
+// ClientOptions configures a ClientManager.
+type ClientOptions struct {
+	// RateLimit applies to every cluster without an override; DefaultQPS
+	// and DefaultBurst if zero.
+	RateLimit RateLimit
+	// ClusterRateLimits overrides RateLimit per cluster, keyed by
+	// ClusterKey ("project/location/name"); see ParseRateLimits.
+	ClusterRateLimits map[string]RateLimit
+	// IdleTimeout evicts clients unused for this long, closing their
+	// connections; DefaultIdleTimeout if zero.
+	IdleTimeout time.Duration
+	// TokenSource authenticates to clusters; Application Default
+	// Credentials if nil.
+	TokenSource oauth2.TokenSource
+}
+
+// ClientManager pools Kubernetes clientsets per cluster endpoint, so that
+// polls reuse connections instead of opening new TLS connections every
+// time. All clients share one caching token source. Clients are built
+// outside the pool's lock, so a slow cluster doesn't hold up the others.
 type ClientManager struct {
+	opts ClientOptions
+
+	// tokenMu guards tokenSource, which is resolved on first use.
+	tokenMu     sync.Mutex
+	tokenSource oauth2.TokenSource
+
+	mu sync.Mutex
+	// clients are keyed by endpoint.
+	clients map[string]*pooledClient
+	// endpoints are the endpoints clusters, by ClusterKey, were last seen
+	// at, so a cluster's old client is dropped when it moves.
+	endpoints map[string]string
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// pooledClient is a clientset and what it was built from. clientset,
+// httpClient and err are set before ready is closed.
+type pooledClient struct {
+	ready      chan struct{}
+	clientset  *kubernetes.Clientset
+	httpClient *http.Client
+	err        error
+	caCert     string
+	lastUsed   time.Time
 }
 
+// built reports whether the client has been built, successfully or not.
+func (pc *pooledClient) built() bool {
+	select {
+	case <-pc.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes the idle connections of a built client.
+func (pc *pooledClient) close() {
+	if pc.built() && pc.httpClient != nil {
+		pc.httpClient.CloseIdleConnections()
+	}
+}
+
+// NewClientManager returns a ClientManager with default options.
 func NewClientManager() *ClientManager {
-    return &ClientManager{}
-}
-
-// GetClient returns a kubernetes clientset for the given cluster
-func (m *ClientManager) GetClient(ctx context.Context, cluster gke.ClusterInfo) (*kubernetes.Clientset, error) {
-    // Get credentials (ADC)
-    ts, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/cloud-platform")
-    if err != nil {
-        return nil, fmt.Errorf("failed to get google token source: %w", err)
-    }
-
-    host := fmt.Sprintf("https://%s", cluster.Endpoint)
-
-    caData, err := base64.StdEncoding.DecodeString(cluster.CaCert)
-    if err != nil {
-        return nil, fmt.Errorf("failed to decode ca cert: %w", err)
-    }
-
-    config := &rest.Config{
-        Host: host,
-        TLSClientConfig: rest.TLSClientConfig{
-            CAData: caData,
-        },
-        WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
-            return &oauth2.Transport{
-                Source: ts,
-                Base:   rt,
-            }
-        },
-    }
-
-    return kubernetes.NewForConfig(config)
+	return NewClientManagerWithOptions(ClientOptions{})
+}
+
+// NewClientManagerWithOptions returns a ClientManager configured by opts.
+// Idle clients are evicted in the background until Close.
+func NewClientManagerWithOptions(opts ClientOptions) *ClientManager {
+	if opts.RateLimit.QPS <= 0 {
+		opts.RateLimit.QPS = DefaultQPS
+	}
+	if opts.RateLimit.Burst <= 0 {
+		opts.RateLimit.Burst = DefaultBurst
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	m := &ClientManager{
+		opts:        opts,
+		tokenSource: opts.TokenSource,
+		clients:     map[string]*pooledClient{},
+		endpoints:   map[string]string{},
+		done:        make(chan struct{}),
+	}
+	go m.evictLoop()
+	return m
+}
+
+// ClusterKey identifies a cluster in ClusterRateLimits.
+func ClusterKey(cluster gke.ClusterInfo) string {
+	return cluster.ProjectID + "/" + cluster.Location + "/" + cluster.Name
+}
+
+// ParseRateLimits parses per-cluster rate limits written as
+// "project/location/name=QPS:BURST", comma separated, e.g.
+// "mslarkin-ext/us-central1/ai-auto-cluster=50:100".
+func ParseRateLimits(s string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, limit, ok := strings.Cut(entry, "=")
+		qps, burst, ok2 := strings.Cut(limit, ":")
+		if !ok || !ok2 || strings.Count(key, "/") != 2 {
+			return nil, fmt.Errorf("invalid rate limit %q, want project/location/name=QPS:BURST", entry)
+		}
+		q, err := strconv.ParseFloat(qps, 32)
+		if err != nil || q <= 0 {
+			return nil, fmt.Errorf("invalid QPS in rate limit %q", entry)
+		}
+		b, err := strconv.Atoi(burst)
+		if err != nil || b <= 0 {
+			return nil, fmt.Errorf("invalid burst in rate limit %q", entry)
+		}
+		limits[key] = RateLimit{QPS: float32(q), Burst: b}
+	}
+	return limits, nil
+}
+
+// GetClient returns a kubernetes clientset for the given cluster, reusing
+// the pooled one unless the cluster's endpoint or CA has changed. Callers
+// asking for a client being built wait for it.
+func (m *ClientManager) GetClient(ctx context.Context, cluster gke.ClusterInfo) (kubernetes.Interface, error) {
+	if cluster.Endpoint == "" {
+		return nil, fmt.Errorf("cluster %s has no endpoint", cluster.Name)
+	}
+	endpoint := cluster.Endpoint
+
+	m.mu.Lock()
+	now := time.Now()
+	m.evictIdleLocked(now)
+
+	// The cluster moved; drop its old client.
+	key := ClusterKey(cluster)
+	if prev, ok := m.endpoints[key]; ok && prev != endpoint {
+		m.dropLocked(prev)
+	}
+	m.endpoints[key] = endpoint
+
+	pc, ok := m.clients[endpoint]
+	if ok && pc.caCert != cluster.CaCert {
+		// The cluster rotated its CA; rebuild.
+		m.dropLocked(endpoint)
+		ok = false
+	}
+	if ok {
+		pc.lastUsed = now
+		m.mu.Unlock()
+		select {
+		case <-pc.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if pc.err != nil {
+			return nil, pc.err
+		}
+		return pc.clientset, nil
+	}
+
+	pc = &pooledClient{ready: make(chan struct{}), caCert: cluster.CaCert, lastUsed: now}
+	m.clients[endpoint] = pc
+	m.mu.Unlock()
+
+	pc.clientset, pc.httpClient, pc.err = m.newClient(ctx, cluster)
+	if pc.err != nil {
+		// Don't cache failures; the next call tries again.
+		m.mu.Lock()
+		if m.clients[endpoint] == pc {
+			delete(m.clients, endpoint)
+		}
+		m.mu.Unlock()
+	}
+	close(pc.ready)
+	if pc.err != nil {
+		return nil, pc.err
+	}
+	return pc.clientset, nil
+}
+
+// Len returns the number of pooled clients.
+func (m *ClientManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.clients)
+}
+
+// Close stops idle eviction, closes the connections of every pooled client
+// and empties the pool.
+func (m *ClientManager) Close() {
+	m.closeOnce.Do(func() { close(m.done) })
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for endpoint := range m.clients {
+		m.dropLocked(endpoint)
+	}
+}
+
+// evictLoop evicts idle clients until Close, so that clusters no longer
+// asked for don't keep their connections open.
+func (m *ClientManager) evictLoop() {
+	ticker := time.NewTicker(m.opts.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			m.evictIdleLocked(now)
+			m.mu.Unlock()
+		}
+	}
+}
+
+// evictIdleLocked drops built clients unused for longer than IdleTimeout.
+func (m *ClientManager) evictIdleLocked(now time.Time) {
+	for endpoint, pc := range m.clients {
+		if pc.built() && now.Sub(pc.lastUsed) > m.opts.IdleTimeout {
+			m.dropLocked(endpoint)
+		}
+	}
+}
+
+// dropLocked removes the client of endpoint from the pool, closing its
+// connections if it has been built.
+func (m *ClientManager) dropLocked(endpoint string) {
+	if pc, ok := m.clients[endpoint]; ok {
+		pc.close()
+		delete(m.clients, endpoint)
+	}
+	for key, e := range m.endpoints {
+		if e == endpoint {
+			delete(m.endpoints, key)
+		}
+	}
+}
+
+// getTokenSource returns the shared token source, resolving Application
+// Default Credentials on first use. The token source keeps the context it
+// is created with to refresh tokens, so it must outlive the request.
+func (m *ClientManager) getTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	m.tokenMu.Lock()
+	defer m.tokenMu.Unlock()
+	if m.tokenSource == nil {
+		ts, err := google.DefaultTokenSource(context.WithoutCancel(ctx), "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get google token source: %w", err)
+		}
+		m.tokenSource = ts
+	}
+	return m.tokenSource, nil
+}
+
+// newClient builds a clientset for cluster.
+func (m *ClientManager) newClient(ctx context.Context, cluster gke.ClusterInfo) (*kubernetes.Clientset, *http.Client, error) {
+	caData, err := base64.StdEncoding.DecodeString(cluster.CaCert)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode ca cert: %w", err)
+	}
+
+	// The token source caches tokens and is shared by every cluster.
+	ts, err := m.getTokenSource(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limit := m.opts.RateLimit
+	if l, ok := m.opts.ClusterRateLimits[ClusterKey(cluster)]; ok {
+		limit = l
+	}
+
+	config := &rest.Config{
+		Host: fmt.Sprintf("https://%s", cluster.Endpoint),
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData: caData,
+		},
+		QPS:   limit.QPS,
+		Burst: limit.Burst,
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return &oauth2.Transport{
+				Source: ts,
+				Base:   rt,
+			}
+		},
+	}
+
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create http client: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfigAndClient(config, httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientset, httpClient, nil
 }
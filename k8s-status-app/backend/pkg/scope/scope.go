@@ -0,0 +1,254 @@
+// Package scope configures which projects, namespaces and workloads the
+// backend reports.
+package scope
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	gke "k8s-status-backend/pkg/gke"
+
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DefaultProjects are monitored when the scope names no projects and
+// discovers none.
+var DefaultProjects = []string{"mslarkin-ext", "mslarkin-demo"}
+
+// Config is the scope of the backend:
+//
+//	projects: [mslarkin-ext]
+//	discovery:
+//	  folders: ["123456789"]
+//	  labels: {env: demo}
+//	namespaces:
+//	  include: ["*"]
+//	  exclude: ["kube-*", "gke-*"]
+//	labelSelector: app.kubernetes.io/part-of=online-boutique
+//	clusters:
+//	  - match: mslarkin-demo/*/*
+//	    namespaces: {include: [onlineboutique]}
+type Config struct {
+	// Projects are monitored explicitly.
+	Projects []string `yaml:"projects" json:"projects,omitempty"`
+	// Discovery finds more projects through Resource Manager.
+	Discovery Discovery `yaml:"discovery" json:"discovery"`
+	// Namespaces and LabelSelector apply to every cluster unless a
+	// cluster override sets them.
+	Namespaces    NamespaceFilter `yaml:"namespaces" json:"namespaces"`
+	LabelSelector string          `yaml:"labelSelector" json:"label_selector,omitempty"`
+	// Clusters override Namespaces and LabelSelector per cluster; the
+	// first match applies.
+	Clusters []ClusterOverride `yaml:"clusters" json:"clusters,omitempty"`
+}
+
+// Discovery finds the active projects under any of Folders that have all
+// of Labels. Either may be empty.
+type Discovery struct {
+	Folders []string          `yaml:"folders" json:"folders,omitempty"`
+	Labels  map[string]string `yaml:"labels" json:"labels,omitempty"`
+}
+
+// Enabled reports whether projects are discovered.
+func (d Discovery) Enabled() bool {
+	return len(d.Folders) > 0 || len(d.Labels) > 0
+}
+
+// NamespaceFilter selects namespaces by glob (path.Match syntax). A
+// namespace is included if it matches one of Include, or Include is
+// empty, and matches none of Exclude.
+type NamespaceFilter struct {
+	Include []string `yaml:"include" json:"include,omitempty"`
+	Exclude []string `yaml:"exclude" json:"exclude,omitempty"`
+}
+
+// ClusterOverride sets the scope of the clusters whose
+// "project/location/name" matches the Match glob.
+type ClusterOverride struct {
+	Match         string           `yaml:"match" json:"match"`
+	Namespaces    *NamespaceFilter `yaml:"namespaces" json:"namespaces,omitempty"`
+	LabelSelector *string          `yaml:"labelSelector" json:"label_selector,omitempty"`
+}
+
+// Default returns the scope used without configuration: the default
+// projects, without system namespaces.
+func Default() *Config {
+	return &Config{
+		Projects:   append([]string(nil), DefaultProjects...),
+		Namespaces: NamespaceFilter{Exclude: []string{"kube-*"}},
+	}
+}
+
+// Load reads the scope from the YAML (or JSON) file named by SCOPE_CONFIG,
+// if set, then applies the SCOPE_* environment variables:
+//
+//	SCOPE_PROJECTS            comma-separated project IDs
+//	SCOPE_FOLDERS             comma-separated folder IDs to discover projects in
+//	SCOPE_PROJECT_LABELS      comma-separated key=value labels of discovered projects
+//	SCOPE_NAMESPACES_INCLUDE  comma-separated namespace globs
+//	SCOPE_NAMESPACES_EXCLUDE  comma-separated namespace globs
+//	SCOPE_LABEL_SELECTOR      Kubernetes label selector of workloads
+//
+// Settings missing from both keep their defaults; projects default to
+// DefaultProjects unless projects are discovered.
+func Load() (*Config, error) {
+	cfg := Default()
+	cfg.Projects = nil
+	if file := os.Getenv("SCOPE_CONFIG"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scope config: %w", err)
+		}
+		// Keys missing from the file keep the defaults.
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse scope config %s: %w", file, err)
+		}
+	}
+
+	if raw, ok := os.LookupEnv("SCOPE_PROJECTS"); ok {
+		cfg.Projects = splitList(raw)
+	}
+	if raw, ok := os.LookupEnv("SCOPE_FOLDERS"); ok {
+		cfg.Discovery.Folders = splitList(raw)
+	}
+	if raw, ok := os.LookupEnv("SCOPE_PROJECT_LABELS"); ok {
+		cfg.Discovery.Labels = map[string]string{}
+		for _, pair := range splitList(raw) {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid SCOPE_PROJECT_LABELS %q, want key=value pairs", raw)
+			}
+			cfg.Discovery.Labels[k] = v
+		}
+	}
+	if raw, ok := os.LookupEnv("SCOPE_NAMESPACES_INCLUDE"); ok {
+		cfg.Namespaces.Include = splitList(raw)
+	}
+	if raw, ok := os.LookupEnv("SCOPE_NAMESPACES_EXCLUDE"); ok {
+		cfg.Namespaces.Exclude = splitList(raw)
+	}
+	if raw, ok := os.LookupEnv("SCOPE_LABEL_SELECTOR"); ok {
+		cfg.LabelSelector = raw
+	}
+
+	if len(cfg.Projects) == 0 && !cfg.Discovery.Enabled() {
+		cfg.Projects = append([]string(nil), DefaultProjects...)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the globs and label selectors of the scope.
+func (c *Config) Validate() error {
+	var errs []error
+	if len(c.Projects) == 0 && !c.Discovery.Enabled() {
+		errs = append(errs, errors.New("no projects: set projects or discovery"))
+	}
+	errs = append(errs, c.Namespaces.validate("namespaces")...)
+	if _, err := labels.Parse(c.LabelSelector); err != nil {
+		errs = append(errs, fmt.Errorf("labelSelector: %w", err))
+	}
+	for i, o := range c.Clusters {
+		field := fmt.Sprintf("clusters[%d]", i)
+		if _, err := path.Match(o.Match, ""); err != nil || o.Match == "" {
+			errs = append(errs, fmt.Errorf("%s.match: invalid glob %q", field, o.Match))
+		}
+		if o.Namespaces != nil {
+			errs = append(errs, o.Namespaces.validate(field+".namespaces")...)
+		}
+		if o.LabelSelector != nil {
+			if _, err := labels.Parse(*o.LabelSelector); err != nil {
+				errs = append(errs, fmt.Errorf("%s.labelSelector: %w", field, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid scope: %w", err)
+	}
+	return nil
+}
+
+func (f NamespaceFilter) validate(field string) []error {
+	var errs []error
+	for _, globs := range [][]string{f.Include, f.Exclude} {
+		for _, g := range globs {
+			if _, err := path.Match(g, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid glob %q", field, g))
+			}
+		}
+	}
+	return errs
+}
+
+// Filter returns the namespaces and workloads in scope on cluster.
+func (c *Config) Filter(cluster gke.ClusterInfo) Filter {
+	f := Filter{Namespaces: c.Namespaces, LabelSelector: c.LabelSelector}
+	key := cluster.ProjectID + "/" + cluster.Location + "/" + cluster.Name
+	for _, o := range c.Clusters {
+		if ok, _ := path.Match(o.Match, key); !ok {
+			continue
+		}
+		if o.Namespaces != nil {
+			f.Namespaces = *o.Namespaces
+		}
+		if o.LabelSelector != nil {
+			f.LabelSelector = *o.LabelSelector
+		}
+		break
+	}
+	// Validate has checked the selector.
+	f.selector, _ = labels.Parse(f.LabelSelector)
+	return f
+}
+
+// Filter decides which namespaces and workloads of a cluster are in scope.
+type Filter struct {
+	Namespaces    NamespaceFilter `json:"namespaces"`
+	LabelSelector string          `json:"label_selector,omitempty"`
+
+	selector labels.Selector
+}
+
+// IncludesNamespace reports whether namespace ns is in scope.
+func (f Filter) IncludesNamespace(ns string) bool {
+	for _, g := range f.Namespaces.Exclude {
+		if ok, _ := path.Match(g, ns); ok {
+			return false
+		}
+	}
+	if len(f.Namespaces.Include) == 0 {
+		return true
+	}
+	for _, g := range f.Namespaces.Include {
+		if ok, _ := path.Match(g, ns); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Includes reports whether a workload is in scope: in a namespace in scope
+// and matching the label selector.
+func (f Filter) Includes(obj metav1.Object) bool {
+	if !f.IncludesNamespace(obj.GetNamespace()) {
+		return false
+	}
+	return f.selector == nil || f.selector.Matches(labels.Set(obj.GetLabels()))
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,50 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+
+	gke "k8s-status-backend/pkg/gke"
+	"k8s-status-backend/pkg/k8s"
+
+	"k8s.io/client-go/rest"
+)
+
+// Defaults for the cluster of an InCluster source.
+const (
+	InClusterProject  = "in-cluster"
+	InClusterLocation = "local"
+	InClusterName     = "in-cluster"
+)
+
+// InCluster is the source of the cluster the backend runs in, connected to
+// with the pod's service account.
+type InCluster struct {
+	*restSource
+}
+
+// NewInCluster returns a source of the cluster the backend runs in,
+// reported with cluster's ProjectID, Location and Name, which default to
+// InClusterProject, InClusterLocation and InClusterName. limit is the
+// client-side rate limit of the cluster.
+func NewInCluster(cluster gke.ClusterInfo, limit k8s.RateLimit) *InCluster {
+	if cluster.ProjectID == "" {
+		cluster.ProjectID = InClusterProject
+	}
+	if cluster.Location == "" {
+		cluster.Location = InClusterLocation
+	}
+	if cluster.Name == "" {
+		cluster.Name = InClusterName
+	}
+	load := func() ([]restCluster, error) {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+		}
+		info := cluster
+		info.Endpoint = strings.TrimPrefix(config.Host, "https://")
+		return []restCluster{{info: info, config: config}}, nil
+	}
+	return &InCluster{newRestSource(load, limit)}
+}
@@ -0,0 +1,71 @@
+package source
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	gke "k8s-status-backend/pkg/gke"
+	"k8s-status-backend/pkg/k8s"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Clusters of kubeconfig contexts are reported in project KubeconfigProject
+// and location KubeconfigLocation, named after their context.
+const (
+	KubeconfigProject  = "kubeconfig"
+	KubeconfigLocation = "local"
+)
+
+// Kubeconfig is the source of the clusters of the contexts of a
+// kubeconfig, e.g. kind or minikube clusters for local development. The
+// kubeconfig is read again on every Refresh.
+type Kubeconfig struct {
+	*restSource
+}
+
+// NewKubeconfig returns a source of the contexts of the kubeconfig at
+// path, or if empty of the files in $KUBECONFIG or ~/.kube/config, like
+// kubectl. limit is the client-side rate limit of every cluster.
+func NewKubeconfig(path string, limit k8s.RateLimit) *Kubeconfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path != "" {
+		rules.ExplicitPath = path
+	}
+	load := func() ([]restCluster, error) {
+		config, err := rules.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+		}
+
+		names := make([]string, 0, len(config.Contexts))
+		for name := range config.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		clusters := make([]restCluster, 0, len(names))
+		for _, name := range names {
+			c := restCluster{info: gke.ClusterInfo{
+				Name:      name,
+				Location:  KubeconfigLocation,
+				ProjectID: KubeconfigProject,
+			}}
+			// The endpoint and CA tell the watcher when to reconnect.
+			if cluster, ok := config.Clusters[config.Contexts[name].Cluster]; ok {
+				c.info.Endpoint = strings.TrimPrefix(cluster.Server, "https://")
+				c.info.CaCert = base64.StdEncoding.EncodeToString(cluster.CertificateAuthorityData)
+			}
+			// A broken context fails its cluster only.
+			c.config, c.err = clientcmd.NewNonInteractiveClientConfig(*config, name, &clientcmd.ConfigOverrides{}, rules).ClientConfig()
+			if c.err != nil {
+				c.err = fmt.Errorf("invalid kubeconfig context %s: %w", name, c.err)
+			}
+			clusters = append(clusters, c)
+		}
+		return clusters, nil
+	}
+	return &Kubeconfig{newRestSource(load, limit)}
+}
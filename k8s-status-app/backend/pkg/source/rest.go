@@ -0,0 +1,145 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	"k8s-status-backend/pkg/k8s"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// restCluster is a cluster and how to connect to it, or why it cannot be.
+type restCluster struct {
+	info   gke.ClusterInfo
+	config *rest.Config
+	err    error
+}
+
+// restSource is a source of clusters connected to through client-go rest
+// configs, which load lists. Clients are built once per load.
+type restSource struct {
+	load  func() ([]restCluster, error)
+	limit k8s.RateLimit
+	now   func() time.Time
+
+	refreshMu sync.Mutex
+
+	mu          sync.Mutex
+	clusters    []restCluster
+	clients     map[string]kubernetes.Interface
+	refreshedAt time.Time
+	err         error
+}
+
+func newRestSource(load func() ([]restCluster, error), limit k8s.RateLimit) *restSource {
+	if limit.QPS <= 0 {
+		limit.QPS = k8s.DefaultQPS
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = k8s.DefaultBurst
+	}
+	return &restSource{load: load, limit: limit, now: time.Now, clients: map[string]kubernetes.Interface{}}
+}
+
+// Refresh loads the clusters again, dropping the clients of the previous
+// load. On failure the previous clusters are kept.
+func (s *restSource) Refresh(ctx context.Context) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	clusters, err := s.load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	if err != nil {
+		return err
+	}
+	s.clusters = clusters
+	s.clients = map[string]kubernetes.Interface{}
+	s.refreshedAt = s.now()
+	return nil
+}
+
+// Snapshot returns the loaded clusters.
+func (s *restSource) Snapshot() gke.InventorySnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := gke.InventorySnapshot{RefreshedAt: s.refreshedAt, Err: s.err}
+	projects := map[string]bool{}
+	for _, c := range s.clusters {
+		snap.Clusters = append(snap.Clusters, c.info)
+		if !projects[c.info.ProjectID] {
+			projects[c.info.ProjectID] = true
+			snap.Projects = append(snap.Projects, c.info.ProjectID)
+		}
+	}
+	sort.Strings(snap.Projects)
+	return snap
+}
+
+// Clusters returns the loaded clusters, loading them first if they never
+// were.
+func (s *restSource) Clusters(ctx context.Context) (gke.InventorySnapshot, error) {
+	snap := s.Snapshot()
+	if !snap.RefreshedAt.IsZero() {
+		return snap, nil
+	}
+	if err := s.Refresh(ctx); err != nil {
+		return s.Snapshot(), fmt.Errorf("failed to list clusters: %w", err)
+	}
+	return s.Snapshot(), nil
+}
+
+// Lookup returns the loaded cluster with the given project, location and
+// name.
+func (s *restSource) Lookup(ctx context.Context, project, location, name string) (gke.ClusterInfo, error) {
+	snap, err := s.Clusters(ctx)
+	if err != nil {
+		return gke.ClusterInfo{}, err
+	}
+	for _, c := range snap.Clusters {
+		if c.ProjectID == project && c.Location == location && c.Name == name {
+			return c, nil
+		}
+	}
+	return gke.ClusterInfo{}, gke.ErrClusterNotFound
+}
+
+// GetClient returns the clientset of a loaded cluster.
+func (s *restSource) GetClient(ctx context.Context, cluster gke.ClusterInfo) (kubernetes.Interface, error) {
+	if _, err := s.Clusters(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := k8s.ClusterKey(cluster)
+	if client, ok := s.clients[key]; ok {
+		return client, nil
+	}
+	for _, c := range s.clusters {
+		if k8s.ClusterKey(c.info) != key {
+			continue
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+		config := rest.CopyConfig(c.config)
+		config.QPS = s.limit.QPS
+		config.Burst = s.limit.Burst
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for %s: %w", key, err)
+		}
+		s.clients[key] = client
+		return client, nil
+	}
+	return nil, fmt.Errorf("cluster %s: %w", key, gke.ErrClusterNotFound)
+}
@@ -0,0 +1,42 @@
+// Package source provides the clusters the backend reports: GKE clusters
+// discovered through the Container API, the contexts of a kubeconfig, or
+// the cluster the backend runs in.
+package source
+
+import (
+	"context"
+
+	gke "k8s-status-backend/pkg/gke"
+	"k8s-status-backend/pkg/k8s"
+	status "k8s-status-backend/pkg/status"
+)
+
+// ClusterSource lists the clusters to report and connects to them. The
+// Aggregator and Watcher get their clients from it.
+type ClusterSource interface {
+	status.ClientProvider
+
+	// Clusters returns the cached clusters, listing them first if they
+	// have never been listed successfully.
+	Clusters(ctx context.Context) (gke.InventorySnapshot, error)
+	// Snapshot returns the cached clusters without listing them.
+	Snapshot() gke.InventorySnapshot
+	// Refresh lists the clusters now.
+	Refresh(ctx context.Context) error
+	// Lookup returns the cluster with the given project, location and
+	// name, or gke.ErrClusterNotFound.
+	Lookup(ctx context.Context, project, location, name string) (gke.ClusterInfo, error)
+}
+
+// GKE is the source of the clusters of GKE projects, listed by an
+// inventory and connected to with pooled clients.
+type GKE struct {
+	*gke.Inventory
+	*k8s.ClientManager
+}
+
+// NewGKE returns a source of the clusters of inventory, connected to
+// through clients.
+func NewGKE(inventory *gke.Inventory, clients *k8s.ClientManager) *GKE {
+	return &GKE{Inventory: inventory, ClientManager: clients}
+}
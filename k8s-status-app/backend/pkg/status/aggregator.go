@@ -4,12 +4,13 @@ import (
 	"context"
 	"fmt"
     "sync"
-    "strings"
     "time"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
     gke "k8s-status-backend/pkg/gke"
-    "k8s-status-backend/pkg/k8s"
+    "k8s-status-backend/pkg/scope"
+    "k8s.io/client-go/kubernetes"
 )
 
 type WorkloadStatus struct {
@@ -19,8 +20,27 @@ type WorkloadStatus struct {
     Desired   int32  `json:"desired"`
     Ready     int32  `json:"ready"`
     Status    string `json:"status"` // "Healthy", "Degraded", "Progressing"
+    Reason    string `json:"reason,omitempty"` // Reason* code when not healthy
     Message   string `json:"message"`
     Age       string `json:"age"`
+
+    // CronJob only: when the last job was scheduled and when the next one
+    // will be (RFC 3339). NextRun is empty while suspended.
+    LastRun string `json:"last_run,omitempty"`
+    NextRun string `json:"next_run,omitempty"`
+
+    // HorizontalPodAutoscaler only. Ready and Desired are its current and
+    // desired replicas; ScalingLimited is the reason of a true
+    // ScalingLimited condition, e.g. "TooManyReplicas".
+    MinReplicas    int32  `json:"min_replicas,omitempty"`
+    MaxReplicas    int32  `json:"max_replicas,omitempty"`
+    ScalingLimited string `json:"scaling_limited,omitempty"`
+
+    // The most recent Warning event of the workload, its ReplicaSets or
+    // Jobs, or its pods, as "Reason: message", and when it was last seen
+    // (RFC 3339).
+    LastWarning   string `json:"last_warning,omitempty"`
+    LastWarningAt string `json:"last_warning_at,omitempty"`
 }
 
 type ClusterStatus struct {
@@ -33,12 +53,35 @@ type ClusterStatus struct {
     Error       string           `json:"error,omitempty"`
 }
 
+// addError appends a failure to the status's Error.
+func (s *ClusterStatus) addError(format string, args ...any) {
+    msg := fmt.Sprintf(format, args...)
+    if s.Error == "" {
+        s.Error = msg
+    } else {
+        s.Error += "; " + msg
+    }
+}
+
+// ClientProvider returns a clientset for a cluster; *k8s.ClientManager is one.
+type ClientProvider interface {
+    GetClient(ctx context.Context, cluster gke.ClusterInfo) (kubernetes.Interface, error)
+}
+
 type Aggregator struct {
-    clients *k8s.ClientManager
+    clients ClientProvider
+    scope   *scope.Config
 }
 
-func NewAggregator(cm *k8s.ClientManager) *Aggregator {
-    return &Aggregator{clients: cm}
+// NewAggregator returns an Aggregator reporting the default scope.
+func NewAggregator(clients ClientProvider) *Aggregator {
+    return NewAggregatorWithScope(clients, scope.Default())
+}
+
+// NewAggregatorWithScope returns an Aggregator reporting only the
+// namespaces and workloads in cfg's scope.
+func NewAggregatorWithScope(clients ClientProvider, cfg *scope.Config) *Aggregator {
+    return &Aggregator{clients: clients, scope: cfg}
 }
 
 func (a *Aggregator) FetchAll(ctx context.Context, clusters []gke.ClusterInfo) []ClusterStatus {
@@ -92,137 +135,189 @@ func (a *Aggregator) GetClusterStatus(ctx context.Context, cluster gke.ClusterIn
         status.Error = fmt.Sprintf("Failed to list nodes: %v", err)
         return status
     }
+    filter := a.scope.Filter(cluster)
     status.NodeCount = len(nodes.Items)
-    for _, node := range nodes.Items {
-        for _, cond := range node.Status.Conditions {
-            if cond.Type == "Ready" && cond.Status == "True" {
-                status.NodesReady++
-                break
-            }
+    for i := range nodes.Items {
+        if nodeReady(&nodes.Items[i]) {
+            status.NodesReady++
         }
     }
 
     // Get Deployments
     deps, err := client.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
     if err == nil {
-        for _, d := range deps.Items {
-            // Simple status check
-            s := "Healthy"
-            msg := ""
-            if d.Status.Replicas != d.Status.ReadyReplicas {
-                s = "Degraded"
-                msg = fmt.Sprintf("%d/%d ready", d.Status.ReadyReplicas, d.Status.Replicas)
-            }
-            // Filter system namespaces if desired (currently keeping all as per logic)
-            if strings.HasPrefix(d.Namespace, "kube-") {
+        for i := range deps.Items {
+            // Filter namespaces and labels out of scope
+            if !filter.Includes(&deps.Items[i]) {
                continue
             }
-
-            status.Workloads = append(status.Workloads, WorkloadStatus{
-                Name:      d.Name,
-                Namespace: d.Namespace,
-                Kind:      "Deployment",
-                Desired:   d.Status.Replicas,
-                Ready:     d.Status.ReadyReplicas,
-                Status:    s,
-                Message:   msg,
-                Age:       formatAge(d.CreationTimestamp),
-            })
+            status.Workloads = append(status.Workloads, deploymentStatus(&deps.Items[i]))
         }
     } else {
-        if status.Error == "" {
-             status.Error = fmt.Sprintf("Failed to list deployments: %v", err)
-        } else {
-             status.Error += fmt.Sprintf("; Failed to list deployments: %v", err)
-        }
+        status.addError("Failed to list deployments: %v", err)
     }
 
     // Get Services
     svcs, err := client.CoreV1().Services("").List(ctx, metav1.ListOptions{})
     if err == nil {
-        for _, svc := range svcs.Items {
-             // Filter system namespaces
-             if strings.HasPrefix(svc.Namespace, "kube-") {
+        for i := range svcs.Items {
+             // Filter namespaces and labels out of scope
+             if !filter.Includes(&svcs.Items[i]) {
                 continue
              }
+             status.Workloads = append(status.Workloads, serviceStatus(&svcs.Items[i]))
+        }
+    } else {
+        status.addError("Failed to list services: %v", err)
+    }
 
-             s := "Healthy"
-             msg := string(svc.Spec.Type)
-             // Simple service status check? (maybe LoadBalancer IP pending?)
-             if svc.Spec.Type == "LoadBalancer" {
-                 if len(svc.Status.LoadBalancer.Ingress) == 0 {
-                     s = "Progressing"
-                     msg += " (Pending IP)"
-                 } else {
-                     msg += fmt.Sprintf(" (%s)", svc.Status.LoadBalancer.Ingress[0].IP)
-                 }
-             }
+    // Get StatefulSets
+    sets, err := client.AppsV1().StatefulSets("").List(ctx, metav1.ListOptions{})
+    if err == nil {
+        for i := range sets.Items {
+            if !filter.Includes(&sets.Items[i]) {
+                continue
+            }
+            status.Workloads = append(status.Workloads, statefulSetStatus(&sets.Items[i]))
+        }
+    } else {
+        status.addError("Failed to list statefulsets: %v", err)
+    }
 
-             status.Workloads = append(status.Workloads, WorkloadStatus{
-                Name:      svc.Name,
-                Namespace: svc.Namespace,
-                Kind:      "Service",
-                Desired:   1, // Service is always 1 logical entity?
-                Ready:     1,
-                Status:    s,
-                Message:   msg,
-                Age:       formatAge(svc.CreationTimestamp),
-            })
+    // Get DaemonSets
+    daemons, err := client.AppsV1().DaemonSets("").List(ctx, metav1.ListOptions{})
+    if err == nil {
+        for i := range daemons.Items {
+            if !filter.Includes(&daemons.Items[i]) {
+                continue
+            }
+            status.Workloads = append(status.Workloads, daemonSetStatus(&daemons.Items[i]))
         }
     } else {
-        if status.Error == "" {
-             status.Error = fmt.Sprintf("Failed to list services: %v", err)
-        } else {
-             status.Error += fmt.Sprintf("; Failed to list services: %v", err)
+        status.addError("Failed to list daemonsets: %v", err)
+    }
+
+    // Get Jobs; those run by a CronJob are reported through it
+    jobs, err := client.BatchV1().Jobs("").List(ctx, metav1.ListOptions{})
+    if err == nil {
+        for i := range jobs.Items {
+            if !filter.Includes(&jobs.Items[i]) || ownedByCronJob(&jobs.Items[i]) {
+                continue
+            }
+            status.Workloads = append(status.Workloads, jobStatus(&jobs.Items[i]))
         }
+    } else {
+        status.addError("Failed to list jobs: %v", err)
+    }
+
+    // Get CronJobs
+    cronJobs, err := client.BatchV1().CronJobs("").List(ctx, metav1.ListOptions{})
+    if err == nil {
+        now := time.Now()
+        for i := range cronJobs.Items {
+            if !filter.Includes(&cronJobs.Items[i]) {
+                continue
+            }
+            status.Workloads = append(status.Workloads, cronJobStatus(&cronJobs.Items[i], now))
+        }
+    } else {
+        status.addError("Failed to list cronjobs: %v", err)
+    }
+
+    // Get HorizontalPodAutoscalers
+    hpas, err := client.AutoscalingV2().HorizontalPodAutoscalers("").List(ctx, metav1.ListOptions{})
+    if err == nil {
+        for i := range hpas.Items {
+            if !filter.Includes(&hpas.Items[i]) {
+                continue
+            }
+            status.Workloads = append(status.Workloads, hpaStatus(&hpas.Items[i]))
+        }
+    } else {
+        status.addError("Failed to list horizontalpodautoscalers: %v", err)
+    }
+
+    // Explain workloads by the problems of their pods
+    lookup := &listedControllers{client: client}
+    podsByKey := map[string]*corev1.Pod{}
+    pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+    if err == nil {
+        var included []*corev1.Pod
+        for i := range pods.Items {
+            if filter.IncludesNamespace(pods.Items[i].Namespace) {
+                included = append(included, &pods.Items[i])
+                podsByKey[pods.Items[i].Namespace+"/"+pods.Items[i].Name] = &pods.Items[i]
+            }
+        }
+        byWorkload, err := podHealthByWorkload(ctx, included, lookup.controllerOf, time.Now())
+        if err != nil {
+            status.addError("Failed to resolve pod owners: %v", err)
+        }
+        for i, ws := range status.Workloads {
+            status.Workloads[i] = applyPodHealth(ws, byWorkload[workloadKey(ws)])
+        }
+    } else {
+        status.addError("Failed to list pods: %v", err)
+    }
+
+    // Get the most recent warning of each workload
+    events, err := client.CoreV1().Events("").List(ctx, metav1.ListOptions{FieldSelector: "type=Warning"})
+    if err == nil {
+        var included []*corev1.Event
+        for i := range events.Items {
+            if filter.IncludesNamespace(events.Items[i].Namespace) {
+                included = append(included, &events.Items[i])
+            }
+        }
+        pod := func(namespace, name string) *corev1.Pod { return podsByKey[namespace+"/"+name] }
+        warnings := latestWarnings(ctx, included, pod, lookup.controllerOf)
+        for i, ws := range status.Workloads {
+            status.Workloads[i] = applyWarning(ws, warnings)
+        }
+    } else {
+        status.addError("Failed to list events: %v", err)
     }
 
     return status
 }
 
 type PodStatus struct {
-	Name   string `json:"name"`
-	Phase  string `json:"phase"`
-	IP     string `json:"pod_ip"`
-	Node   string `json:"node_name"`
-	Age    string `json:"age"`
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	IP       string `json:"pod_ip"`
+	Node     string `json:"node_name"`
+	Age      string `json:"age"`
+	Restarts int32  `json:"restarts"`
+	Reason   string `json:"reason,omitempty"` // Reason* code when unhealthy
+	Message  string `json:"message,omitempty"`
 }
 
-func (a *Aggregator) GetWorkloadPods(ctx context.Context, cluster gke.ClusterInfo, namespace, workloadName string) ([]PodStatus, error) {
+// GetWorkloadPods returns the pods of a workload of any kind, resolved
+// through the owner-reference chain (see workloadPods).
+func (a *Aggregator) GetWorkloadPods(ctx context.Context, cluster gke.ClusterInfo, namespace, kind, workloadName string) ([]PodStatus, error) {
 	client, err := a.clients.GetClient(ctx, cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %v", err)
 	}
 
-	// 1. Get Deployment to find selector
-	// Note: We are assuming Deployment for now as per previous logic.
-	// If we support Services/StatefulSets later we need generic logic or a switch.
-	dep, err := client.AppsV1().Deployments(namespace).Get(ctx, workloadName, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get deployment %s: %v", workloadName, err)
-	}
-
-	// 2. List Pods using selector
-	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
-	if err != nil {
-		return nil, fmt.Errorf("invalid selector: %v", err)
-	}
-
-	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: selector.String(),
-	})
+	pods, err := workloadPods(ctx, client, namespace, kind, workloadName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list pods: %v", err)
+		return nil, err
 	}
 
+	now := time.Now()
 	var results []PodStatus
-	for _, p := range pods.Items {
+	for i, p := range pods {
+		health := podHealth(&pods[i], now)
 		results = append(results, PodStatus{
-			Name:  p.Name,
-			Phase: string(p.Status.Phase),
-			IP:    p.Status.PodIP,
-			Node:  p.Spec.NodeName,
-			Age:   formatAge(p.CreationTimestamp),
+			Name:     p.Name,
+			Phase:    string(p.Status.Phase),
+			IP:       p.Status.PodIP,
+			Node:     p.Spec.NodeName,
+			Age:      formatAge(p.CreationTimestamp),
+			Restarts: health.Restarts,
+			Reason:   health.Reason,
+			Message:  health.Message,
 		})
 	}
 
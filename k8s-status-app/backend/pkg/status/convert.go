@@ -0,0 +1,233 @@
+package status
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The conversions below turn Kubernetes objects into WorkloadStatus. They
+// are shared by the polling Aggregator and the informer-based Watcher.
+
+// nodeReady reports whether a node's Ready condition is True.
+func nodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// replicaHealth is the status of a workload with desired and ready
+// replicas: degraded unless all are ready.
+func replicaHealth(desired, ready int32) (status, reason, message string) {
+	if desired != ready {
+		return "Degraded", ReasonReplicasUnavailable, fmt.Sprintf("%d/%d ready", ready, desired)
+	}
+	return "Healthy", "", ""
+}
+
+func deploymentStatus(d *appsv1.Deployment) WorkloadStatus {
+	s, reason, msg := deploymentHealth(d)
+	return WorkloadStatus{
+		Name:      d.Name,
+		Namespace: d.Namespace,
+		Kind:      "Deployment",
+		Desired:   d.Status.Replicas,
+		Ready:     d.Status.ReadyReplicas,
+		Status:    s,
+		Reason:    reason,
+		Message:   msg,
+		Age:       formatAge(d.CreationTimestamp),
+	}
+}
+
+func serviceStatus(svc *corev1.Service) WorkloadStatus {
+	s := "Healthy"
+	msg := string(svc.Spec.Type)
+	// A LoadBalancer is progressing until it has an ingress IP
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		if len(svc.Status.LoadBalancer.Ingress) == 0 {
+			s = "Progressing"
+			msg += " (Pending IP)"
+		} else {
+			msg += fmt.Sprintf(" (%s)", svc.Status.LoadBalancer.Ingress[0].IP)
+		}
+	}
+	return WorkloadStatus{
+		Name:      svc.Name,
+		Namespace: svc.Namespace,
+		Kind:      "Service",
+		Desired:   1, // A Service is a single logical entity
+		Ready:     1,
+		Status:    s,
+		Message:   msg,
+		Age:       formatAge(svc.CreationTimestamp),
+	}
+}
+
+func statefulSetStatus(ss *appsv1.StatefulSet) WorkloadStatus {
+	s, reason, msg := replicaHealth(ss.Status.Replicas, ss.Status.ReadyReplicas)
+	return WorkloadStatus{
+		Name:      ss.Name,
+		Namespace: ss.Namespace,
+		Kind:      "StatefulSet",
+		Desired:   ss.Status.Replicas,
+		Ready:     ss.Status.ReadyReplicas,
+		Status:    s,
+		Reason:    reason,
+		Message:   msg,
+		Age:       formatAge(ss.CreationTimestamp),
+	}
+}
+
+func daemonSetStatus(ds *appsv1.DaemonSet) WorkloadStatus {
+	s, reason, msg := replicaHealth(ds.Status.DesiredNumberScheduled, ds.Status.NumberReady)
+	return WorkloadStatus{
+		Name:      ds.Name,
+		Namespace: ds.Namespace,
+		Kind:      "DaemonSet",
+		Desired:   ds.Status.DesiredNumberScheduled,
+		Ready:     ds.Status.NumberReady,
+		Status:    s,
+		Reason:    reason,
+		Message:   msg,
+		Age:       formatAge(ds.CreationTimestamp),
+	}
+}
+
+// ownedByCronJob reports whether a job was created by a CronJob.
+func ownedByCronJob(j *batchv1.Job) bool {
+	owner := metav1.GetControllerOf(j)
+	return owner != nil && owner.Kind == "CronJob"
+}
+
+// jobStatus reports a job's succeeded pods against its completions. It is
+// healthy once complete, degraded once failed and progressing until then.
+func jobStatus(j *batchv1.Job) WorkloadStatus {
+	completions := int32(1)
+	if j.Spec.Completions != nil {
+		completions = *j.Spec.Completions
+	}
+	s, reason := "Progressing", ""
+	msg := fmt.Sprintf("%d active", j.Status.Active)
+	for _, cond := range j.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			s, reason, msg = "Healthy", "", "Complete"
+		case batchv1.JobFailed:
+			s, reason, msg = "Degraded", ReasonJobFailed, "Failed: "+cond.Reason
+			if cond.Message != "" {
+				msg += " (" + cond.Message + ")"
+			}
+		case batchv1.JobSuspended:
+			s, reason, msg = "Healthy", "", "Suspended"
+		}
+	}
+	return WorkloadStatus{
+		Name:      j.Name,
+		Namespace: j.Namespace,
+		Kind:      "Job",
+		Desired:   completions,
+		Ready:     j.Status.Succeeded,
+		Status:    s,
+		Reason:    reason,
+		Message:   msg,
+		Age:       formatAge(j.CreationTimestamp),
+	}
+}
+
+// cronJobStatus reports a CronJob's active jobs and its last and next runs,
+// computed at now. Schedules without a time zone are in UTC, as on GKE.
+func cronJobStatus(cj *batchv1.CronJob, now time.Time) WorkloadStatus {
+	active := int32(len(cj.Status.Active))
+	ws := WorkloadStatus{
+		Name:      cj.Name,
+		Namespace: cj.Namespace,
+		Kind:      "CronJob",
+		Desired:   active,
+		Ready:     active,
+		Status:    "Healthy",
+		Message:   cj.Spec.Schedule,
+		Age:       formatAge(cj.CreationTimestamp),
+	}
+	if cj.Status.LastScheduleTime != nil {
+		ws.LastRun = cj.Status.LastScheduleTime.UTC().Format(time.RFC3339)
+	}
+	if cj.Spec.Suspend != nil && *cj.Spec.Suspend {
+		ws.Message += " (Suspended)"
+		return ws
+	}
+
+	// Parse the schedule the way the CronJob controller does.
+	spec := cj.Spec.Schedule
+	if cj.Spec.TimeZone != nil {
+		spec = fmt.Sprintf("TZ=%s %s", *cj.Spec.TimeZone, spec)
+	}
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		ws.Status = "Degraded"
+		ws.Reason = ReasonInvalidSchedule
+		ws.Message = fmt.Sprintf("Invalid schedule %q: %v", cj.Spec.Schedule, err)
+		return ws
+	}
+	ws.NextRun = sched.Next(now.UTC()).UTC().Format(time.RFC3339)
+	return ws
+}
+
+// hpaStatus reports an autoscaler's current and desired replicas. It is
+// degraded when it cannot scale or is held at its maximum, and progressing
+// while scaling.
+func hpaStatus(h *autoscalingv2.HorizontalPodAutoscaler) WorkloadStatus {
+	minReplicas := int32(1)
+	if h.Spec.MinReplicas != nil {
+		minReplicas = *h.Spec.MinReplicas
+	}
+	ws := WorkloadStatus{
+		Name:        h.Name,
+		Namespace:   h.Namespace,
+		Kind:        "HorizontalPodAutoscaler",
+		Desired:     h.Status.DesiredReplicas,
+		Ready:       h.Status.CurrentReplicas,
+		Status:      "Healthy",
+		Message:     h.Spec.ScaleTargetRef.Kind + "/" + h.Spec.ScaleTargetRef.Name,
+		Age:         formatAge(h.CreationTimestamp),
+		MinReplicas: minReplicas,
+		MaxReplicas: h.Spec.MaxReplicas,
+	}
+	if h.Status.CurrentReplicas != h.Status.DesiredReplicas {
+		ws.Status = "Progressing"
+		ws.Message += fmt.Sprintf(" (scaling %d to %d)", h.Status.CurrentReplicas, h.Status.DesiredReplicas)
+	}
+
+	for _, cond := range h.Status.Conditions {
+		switch {
+		case cond.Type == autoscalingv2.ScalingLimited && cond.Status == corev1.ConditionTrue:
+			ws.ScalingLimited = cond.Reason
+			// Being held at the minimum is normal; at the maximum the
+			// target needs more replicas than allowed.
+			if cond.Reason == "TooManyReplicas" {
+				ws.Status = "Degraded"
+				ws.Reason = ReasonScalingLimited
+				ws.Message += fmt.Sprintf(" (at max %d replicas)", h.Spec.MaxReplicas)
+			}
+		case cond.Type == autoscalingv2.AbleToScale && cond.Status == corev1.ConditionFalse,
+			// A target scaled to zero disables scaling on purpose.
+			cond.Type == autoscalingv2.ScalingActive && cond.Status == corev1.ConditionFalse && cond.Reason != "ScalingDisabled":
+			ws.Status = "Degraded"
+			ws.Reason = ReasonScalingInactive
+			ws.Message += fmt.Sprintf(" (%s: %s)", cond.Reason, cond.Message)
+		}
+	}
+	return ws
+}
@@ -0,0 +1,232 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EventSummary is a Kubernetes event, with repeats of it merged.
+type EventSummary struct {
+	Type      string `json:"type"` // "Warning" or "Normal"
+	Reason    string `json:"reason"`
+	Message   string `json:"message"`
+	Object    string `json:"object"` // Kind/name of the object involved
+	Count     int32  `json:"count"`
+	FirstSeen string `json:"first_seen"` // RFC 3339
+	LastSeen  string `json:"last_seen"`
+
+	first, last time.Time
+}
+
+// GetWorkloadEvents returns the events of a workload, of the ReplicaSets
+// or Jobs it owns and of its pods, most recent first. Repeated events are
+// merged with their counts added up.
+func (a *Aggregator) GetWorkloadEvents(ctx context.Context, cluster gke.ClusterInfo, namespace, kind, name string) ([]EventSummary, error) {
+	client, err := a.clients.GetClient(ctx, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+
+	involved := map[string]bool{kind + "/" + name: true}
+	owned, err := ownedIntermediates(ctx, client, namespace, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range owned {
+		involved[o] = true
+	}
+	pods, err := workloadPods(ctx, client, namespace, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pods {
+		involved["Pod/"+p.Name] = true
+	}
+
+	events, err := client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %v", err)
+	}
+	var matched []*corev1.Event
+	for i := range events.Items {
+		ref := events.Items[i].InvolvedObject
+		if involved[ref.Kind+"/"+ref.Name] {
+			matched = append(matched, &events.Items[i])
+		}
+	}
+	return summarizeEvents(matched), nil
+}
+
+// ownedIntermediates returns the Kind/name of the ReplicaSets of a
+// Deployment or the Jobs of a CronJob, whose events explain it too.
+func ownedIntermediates(ctx context.Context, client kubernetes.Interface, namespace, kind, name string) ([]string, error) {
+	var objs []metav1.Object
+	switch kind {
+	case "Deployment":
+		rss, err := client.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list replicasets: %v", err)
+		}
+		for i := range rss.Items {
+			objs = append(objs, &rss.Items[i])
+		}
+	case "CronJob":
+		jobs, err := client.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list jobs: %v", err)
+		}
+		for i := range jobs.Items {
+			objs = append(objs, &jobs.Items[i])
+		}
+	default:
+		return nil, nil
+	}
+
+	var owned []string
+	for _, obj := range objs {
+		if c := metav1.GetControllerOf(obj); c != nil && c.Kind == kind && c.Name == name {
+			owned = append(owned, ownedKind(kind)+"/"+obj.GetName())
+		}
+	}
+	return owned, nil
+}
+
+func ownedKind(kind string) string {
+	if kind == "CronJob" {
+		return "Job"
+	}
+	return "ReplicaSet"
+}
+
+// summarizeEvents merges repeats of the same event of the same object and
+// sorts them by when they were last seen, most recent first.
+func summarizeEvents(events []*corev1.Event) []EventSummary {
+	byKey := map[string]*EventSummary{}
+	var order []string
+	for _, ev := range events {
+		s := eventSummary(ev)
+		key := s.Object + "\x00" + s.Type + "\x00" + s.Reason + "\x00" + s.Message
+		prev, ok := byKey[key]
+		if !ok {
+			byKey[key] = &s
+			order = append(order, key)
+			continue
+		}
+		prev.Count += s.Count
+		if s.first.Before(prev.first) {
+			prev.first = s.first
+		}
+		if s.last.After(prev.last) {
+			prev.last = s.last
+		}
+	}
+
+	summaries := make([]EventSummary, 0, len(order))
+	for _, key := range order {
+		s := byKey[key]
+		s.FirstSeen = s.first.UTC().Format(time.RFC3339)
+		s.LastSeen = s.last.UTC().Format(time.RFC3339)
+		summaries = append(summaries, *s)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].last.After(summaries[j].last)
+	})
+	return summaries
+}
+
+// eventSummary converts one event, which the API server may already have
+// counted repeats of, either in Count or in its Series.
+func eventSummary(ev *corev1.Event) EventSummary {
+	s := EventSummary{
+		Type:    corev1.EventTypeNormal,
+		Reason:  ev.Reason,
+		Message: ev.Message,
+		Object:  ev.InvolvedObject.Kind + "/" + ev.InvolvedObject.Name,
+		Count:   1,
+	}
+	if ev.Type == corev1.EventTypeWarning {
+		s.Type = corev1.EventTypeWarning
+	}
+
+	s.first = ev.FirstTimestamp.Time
+	if s.first.IsZero() {
+		s.first = ev.EventTime.Time
+	}
+	if s.first.IsZero() {
+		s.first = ev.CreationTimestamp.Time
+	}
+	s.last = ev.LastTimestamp.Time
+	switch {
+	case ev.Series != nil:
+		s.Count = ev.Series.Count
+		if s.last.IsZero() {
+			s.last = ev.Series.LastObservedTime.Time
+		}
+	case ev.Count > 0:
+		s.Count = ev.Count
+	}
+	if s.last.IsZero() {
+		s.last = s.first
+	}
+	return s
+}
+
+// latestWarnings returns the most recent Warning event of each workload,
+// keyed like workloadKey, among the events of the workload, of its
+// ReplicaSets or Jobs and of its pods. pod returns the pod with the given
+// namespace and name, or nil if it is unknown.
+func latestWarnings(ctx context.Context, events []*corev1.Event, pod func(namespace, name string) *corev1.Pod, lookup controllerLookup) map[string]EventSummary {
+	latest := map[string]EventSummary{}
+	for _, ev := range events {
+		if ev.Type != corev1.EventTypeWarning {
+			continue
+		}
+		s := eventSummary(ev)
+		for _, key := range eventOwners(ctx, ev, pod, lookup) {
+			if prev, ok := latest[key]; !ok || s.last.After(prev.last) {
+				latest[key] = s
+			}
+		}
+	}
+	return latest
+}
+
+// eventOwners returns the keys, like workloadKey, of the object an event
+// is about and of its controllers, following pods, ReplicaSets and Jobs.
+// It is empty for events about pods that pod does not know.
+func eventOwners(ctx context.Context, ev *corev1.Event, pod func(namespace, name string) *corev1.Pod, lookup controllerLookup) []string {
+	ns := ev.InvolvedObject.Namespace
+	if ns == "" {
+		ns = ev.Namespace
+	}
+	ref := &metav1.OwnerReference{Kind: ev.InvolvedObject.Kind, Name: ev.InvolvedObject.Name}
+	var chain []*metav1.OwnerReference
+	if ref.Kind == "Pod" {
+		p := pod(ns, ref.Name)
+		if p == nil {
+			return nil
+		}
+		// Errors leave the chain as far as it was resolved.
+		chain, _ = ancestors(ctx, p, lookup)
+	} else {
+		chain, _ = chainFrom(ctx, ns, ref, lookup)
+	}
+	return chainKeys(ns, chain)
+}
+
+// applyWarning records the workload's most recent warning in its status.
+func applyWarning(ws WorkloadStatus, warnings map[string]EventSummary) WorkloadStatus {
+	if w, ok := warnings[workloadKey(ws)]; ok {
+		ws.LastWarning = w.Reason + ": " + w.Message
+		ws.LastWarningAt = w.last.UTC().Format(time.RFC3339)
+	}
+	return ws
+}
@@ -0,0 +1,255 @@
+package status
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reason codes explaining why a workload or pod is not healthy, set in
+// WorkloadStatus.Reason and PodStatus.Reason. They are empty when healthy.
+const (
+	// Workload reasons.
+	ReasonRollingOut               = "RollingOut"
+	ReasonReplicasUnavailable      = "ReplicasUnavailable"
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	ReasonReplicaFailure           = "ReplicaFailure"
+	ReasonQuotaExceeded            = "QuotaExceeded"
+	ReasonJobFailed                = "JobFailed"
+	ReasonInvalidSchedule          = "InvalidSchedule"
+	ReasonScalingLimited           = "ScalingLimited"
+	ReasonScalingInactive          = "ScalingInactive"
+
+	// Pod reasons, which also explain the workloads owning the pod.
+	ReasonImagePullBackOff           = "ImagePullBackOff"
+	ReasonCreateContainerConfigError = "CreateContainerConfigError"
+	ReasonOOMKilled                  = "OOMKilled"
+	ReasonCrashLoopBackOff           = "CrashLoopBackOff"
+	ReasonUnschedulable              = "Unschedulable"
+	ReasonHighRestarts               = "HighRestarts"
+)
+
+// podReasonRank orders pod reasons from most to least severe; the worst
+// pod of a workload explains it.
+var podReasonRank = map[string]int{
+	ReasonImagePullBackOff:           1,
+	ReasonCreateContainerConfigError: 2,
+	ReasonOOMKilled:                  3,
+	ReasonCrashLoopBackOff:           4,
+	ReasonUnschedulable:              5,
+	ReasonHighRestarts:               6,
+}
+
+const (
+	// restartThreshold is how many restarts make a pod unhealthy, if its
+	// last restart was within restartWindow.
+	restartThreshold = 5
+	restartWindow    = time.Hour
+	// schedulingGrace is how long a pod may be unschedulable before it is
+	// unhealthy, leaving time for the cluster autoscaler to add nodes.
+	schedulingGrace = 5 * time.Minute
+)
+
+// PodHealth is why a pod is unhealthy, if it is.
+type PodHealth struct {
+	Name     string
+	Reason   string
+	Message  string
+	Restarts int32
+}
+
+// worse reports whether h is a more severe problem than other.
+func (h PodHealth) worse(other PodHealth) bool {
+	if h.Reason == "" {
+		return false
+	}
+	if other.Reason == "" {
+		return true
+	}
+	return podReasonRank[h.Reason] < podReasonRank[other.Reason]
+}
+
+// podHealth evaluates a pod at now from its scheduling condition and
+// container states. Pods that have finished are not evaluated.
+func podHealth(pod *corev1.Pod, now time.Time) PodHealth {
+	h := PodHealth{Name: pod.Name}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return h
+	}
+
+	var worst PodHealth
+	statuses := append(append([]corev1.ContainerStatus(nil), pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		h.Restarts += cs.RestartCount
+		if c := containerHealth(cs, now); c.worse(worst) {
+			worst = c
+		}
+	}
+
+	if pod.Status.Phase == corev1.PodPending {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse &&
+				cond.Reason == corev1.PodReasonUnschedulable && now.Sub(cond.LastTransitionTime.Time) > schedulingGrace {
+				c := PodHealth{Reason: ReasonUnschedulable, Message: cond.Message}
+				if c.worse(worst) {
+					worst = c
+				}
+			}
+		}
+	}
+
+	h.Reason, h.Message = worst.Reason, worst.Message
+	return h
+}
+
+// containerHealth evaluates one container of a pod.
+func containerHealth(cs corev1.ContainerStatus, now time.Time) PodHealth {
+	last := cs.LastTerminationState.Terminated
+	if w := cs.State.Waiting; w != nil {
+		switch w.Reason {
+		case "ImagePullBackOff", "ErrImagePull", "InvalidImageName":
+			return PodHealth{Reason: ReasonImagePullBackOff, Message: fmt.Sprintf("container %s: %s", cs.Name, waitingMessage(w))}
+		case "CreateContainerConfigError", "CreateContainerError":
+			return PodHealth{Reason: ReasonCreateContainerConfigError, Message: fmt.Sprintf("container %s: %s", cs.Name, waitingMessage(w))}
+		case "CrashLoopBackOff":
+			// A container killed for memory crash loops too; OOMKilled is the
+			// root cause.
+			if last != nil && last.Reason == "OOMKilled" {
+				return PodHealth{Reason: ReasonOOMKilled, Message: fmt.Sprintf("container %s was OOMKilled (%d restarts)", cs.Name, cs.RestartCount)}
+			}
+			msg := fmt.Sprintf("container %s is crash looping (%d restarts)", cs.Name, cs.RestartCount)
+			if last != nil {
+				msg += fmt.Sprintf(", last exit code %d", last.ExitCode)
+			}
+			return PodHealth{Reason: ReasonCrashLoopBackOff, Message: msg}
+		}
+	}
+
+	recent := last != nil && now.Sub(last.FinishedAt.Time) < restartWindow
+	if t := cs.State.Terminated; t != nil && t.Reason == "OOMKilled" {
+		return PodHealth{Reason: ReasonOOMKilled, Message: fmt.Sprintf("container %s was OOMKilled", cs.Name)}
+	}
+	if recent && last.Reason == "OOMKilled" {
+		return PodHealth{Reason: ReasonOOMKilled, Message: fmt.Sprintf("container %s was OOMKilled %s ago", cs.Name, now.Sub(last.FinishedAt.Time).Round(time.Second))}
+	}
+	if recent && cs.RestartCount >= restartThreshold {
+		return PodHealth{Reason: ReasonHighRestarts, Message: fmt.Sprintf("container %s restarted %d times", cs.Name, cs.RestartCount)}
+	}
+	return PodHealth{}
+}
+
+// healthChangesAt returns the next time after now at which podHealth of
+// pod changes without the pod changing: when its scheduling grace ends or
+// a restart leaves the restart window. It is zero if there is none.
+func healthChangesAt(pod *corev1.Pod, now time.Time) time.Time {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return time.Time{}
+	}
+	var next time.Time
+	consider := func(t time.Time) {
+		if t.After(now) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if pod.Status.Phase == corev1.PodPending {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && cond.Reason == corev1.PodReasonUnschedulable {
+				consider(cond.LastTransitionTime.Add(schedulingGrace))
+			}
+		}
+	}
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, cs := range statuses {
+			if last := cs.LastTerminationState.Terminated; last != nil {
+				consider(last.FinishedAt.Add(restartWindow))
+			}
+		}
+	}
+	return next
+}
+
+func waitingMessage(w *corev1.ContainerStateWaiting) string {
+	if w.Message != "" {
+		return w.Message
+	}
+	return w.Reason
+}
+
+// deploymentHealth explains a deployment's status from its conditions,
+// telling a rollout in progress from a stuck or failing one.
+func deploymentHealth(d *appsv1.Deployment) (status, reason, message string) {
+	var progressing, failure *appsv1.DeploymentCondition
+	for i, cond := range d.Status.Conditions {
+		switch cond.Type {
+		case appsv1.DeploymentProgressing:
+			progressing = &d.Status.Conditions[i]
+		case appsv1.DeploymentReplicaFailure:
+			failure = &d.Status.Conditions[i]
+		}
+	}
+
+	// A replica failure is why a rollout misses its deadline, so it wins.
+	if failure != nil && failure.Status == corev1.ConditionTrue {
+		// Pods rejected by a ResourceQuota are never created.
+		if strings.Contains(failure.Message, "exceeded quota") {
+			return "Degraded", ReasonQuotaExceeded, failure.Message
+		}
+		return "Degraded", ReasonReplicaFailure, failure.Message
+	}
+	if progressing != nil && progressing.Status == corev1.ConditionFalse && progressing.Reason == "ProgressDeadlineExceeded" {
+		return "Degraded", ReasonProgressDeadlineExceeded, progressing.Message
+	}
+
+	if d.Status.Replicas == d.Status.ReadyReplicas {
+		return "Healthy", "", ""
+	}
+	// Until the new ReplicaSet is available the deployment is rolling out.
+	if progressing != nil && progressing.Status == corev1.ConditionTrue && progressing.Reason != "NewReplicaSetAvailable" {
+		return "Progressing", ReasonRollingOut, fmt.Sprintf("%d/%d updated, %d/%d ready",
+			d.Status.UpdatedReplicas, d.Status.Replicas, d.Status.ReadyReplicas, d.Status.Replicas)
+	}
+	return "Degraded", ReasonReplicasUnavailable, fmt.Sprintf("%d/%d ready", d.Status.ReadyReplicas, d.Status.Replicas)
+}
+
+// ownsPods reports whether workloads of kind own pods, and so are explained
+// by them.
+func ownsPods(kind string) bool {
+	switch kind {
+	case "Deployment", "StatefulSet", "DaemonSet", "Job", "CronJob":
+		return true
+	}
+	return false
+}
+
+// applyPodHealth explains ws by the worst problem of its pods: a workload
+// whose pods are failing is degraded, with the pod's reason, even while it
+// is rolling out.
+func applyPodHealth(ws WorkloadStatus, pods []PodHealth) WorkloadStatus {
+	if !ownsPods(ws.Kind) {
+		return ws
+	}
+	var worst PodHealth
+	failing := 0
+	for _, p := range pods {
+		if p.Reason == "" {
+			continue
+		}
+		failing++
+		if p.worse(worst) {
+			worst = p
+		}
+	}
+	if failing == 0 {
+		return ws
+	}
+	ws.Status = "Degraded"
+	ws.Reason = worst.Reason
+	ws.Message = fmt.Sprintf("pod %s: %s", worst.Name, worst.Message)
+	if failing > 1 {
+		ws.Message += fmt.Sprintf(" (and %d more)", failing-1)
+	}
+	return ws
+}
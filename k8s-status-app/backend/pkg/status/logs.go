@@ -0,0 +1,320 @@
+package status
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	gke "k8s-status-backend/pkg/gke"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Limits on the logs of one request, so that one request cannot exhaust
+// the backend.
+const (
+	// DefaultTailLines is how many lines of each container are read when
+	// LogOptions.TailLines is zero; MaxTailLines is the most allowed.
+	DefaultTailLines = 100
+	MaxTailLines     = 5000
+	// MaxLogLines and MaxLogBytes bound everything one request returns,
+	// across pods and while following. Bytes are those the API server
+	// sends, as its LimitBytes counts them: each line's newline, and the
+	// whole of lines MaxLogLineBytes truncates.
+	MaxLogLines = 20000
+	MaxLogBytes = 4 << 20
+	// MaxLogLineBytes truncates longer lines.
+	MaxLogLineBytes = 16 << 10
+	// MaxLogPods is the most pods whose logs one request aggregates.
+	MaxLogPods = 20
+)
+
+// ErrLogLimit is returned by StreamLogs when MaxLogLines or MaxLogBytes cut
+// the logs short.
+var ErrLogLimit = errors.New("log limit reached")
+
+// LogOptions selects logs: those of Pod, or of every pod of the workload
+// Kind/Workload.
+type LogOptions struct {
+	Pod      string
+	Kind     string
+	Workload string
+
+	// Container defaults to the pod's default container.
+	Container string
+	// Previous reads the previous instance of the container, e.g. one that
+	// crash looped.
+	Previous     bool
+	TailLines    int64
+	SinceSeconds int64
+	Follow       bool
+
+	// MaxLines and MaxBytes lower MaxLogLines and MaxLogBytes.
+	MaxLines int
+	MaxBytes int
+}
+
+// LogLine is a line of a container's log. Error is set instead of Line
+// when the logs of a pod of an aggregated request could not be read.
+type LogLine struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Line      string `json:"line,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// StreamLogs calls emit with the log lines selected by opts, until the logs
+// end or, when following, ctx is done. Aggregated logs are read pod after
+// pod, in name order, or concurrently when following. It returns
+// ErrLogLimit if the limits cut the logs short.
+func (a *Aggregator) StreamLogs(ctx context.Context, cluster gke.ClusterInfo, namespace string, opts LogOptions, emit func(LogLine) error) error {
+	client, err := a.clients.GetClient(ctx, cluster)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+
+	var pods []corev1.Pod
+	if opts.Pod != "" {
+		pod, err := client.CoreV1().Pods(namespace).Get(ctx, opts.Pod, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get pod %s: %v", opts.Pod, err)
+		}
+		pods = []corev1.Pod{*pod}
+	} else {
+		pods, err = workloadPods(ctx, client, namespace, opts.Kind, opts.Workload)
+		if err != nil {
+			return err
+		}
+		if len(pods) > MaxLogPods {
+			return fmt.Errorf("%s %s has %d pods, logs of at most %d can be aggregated", opts.Kind, opts.Workload, len(pods), MaxLogPods)
+		}
+		sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	}
+
+	if opts.TailLines <= 0 {
+		opts.TailLines = DefaultTailLines
+	}
+	budget := &logBudget{lines: MaxLogLines, bytes: MaxLogBytes, emit: emit}
+	if opts.MaxLines > 0 && opts.MaxLines < budget.lines {
+		budget.lines = opts.MaxLines
+	}
+	if opts.MaxBytes > 0 && opts.MaxBytes < budget.bytes {
+		budget.bytes = opts.MaxBytes
+	}
+
+	// The logs of a single pod fail the request; those of one pod of a
+	// workload are reported as an error line.
+	read := func(ctx context.Context, pod *corev1.Pod) error {
+		err := podLogs(ctx, client, pod, opts, budget.remainingBytes(), budget.send)
+		if err == nil || opts.Pod != "" || errors.Is(err, ErrLogLimit) || ctx.Err() != nil {
+			return err
+		}
+		return budget.sendError(LogLine{Pod: pod.Name, Container: opts.Container, Error: err.Error()})
+	}
+
+	if !opts.Follow {
+		for i := range pods {
+			if err := read(ctx, &pods[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Follow every pod at once; the first error stops them all.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := range pods {
+		wg.Add(1)
+		go func(pod *corev1.Pod) {
+			defer wg.Done()
+			if err := read(ctx, pod); err != nil && ctx.Err() == nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(&pods[i])
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// podLogs reads the log of one container of pod, asking the API server for
+// one byte more than limitBytes so that a log cut by the limit can be told
+// from one that ends within it. emit is passed each line with the bytes it
+// took in the stream.
+func podLogs(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod, opts LogOptions, limitBytes int64, emit func(LogLine, int) error) error {
+	container := opts.Container
+	if container == "" {
+		container = defaultContainer(pod)
+	} else if !hasContainer(pod, container) {
+		return fmt.Errorf("pod %s has no container %s", pod.Name, container)
+	}
+	// The API server rejects a zero limit.
+	if limitBytes <= 0 {
+		return ErrLogLimit
+	}
+
+	requestBytes := limitBytes + 1
+	logOpts := &corev1.PodLogOptions{
+		Container:  container,
+		Previous:   opts.Previous,
+		Follow:     opts.Follow,
+		TailLines:  &opts.TailLines,
+		LimitBytes: &requestBytes,
+	}
+	if opts.SinceSeconds > 0 {
+		logOpts.SinceSeconds = &opts.SinceSeconds
+	}
+	stream, err := client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, logOpts).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get logs of pod %s: %v", pod.Name, err)
+	}
+	defer stream.Close()
+
+	counter := &countingReader{r: stream}
+	r := bufio.NewReader(counter)
+	for {
+		line, size, truncated, err := readLine(r, MaxLogLineBytes)
+		if err == io.EOF {
+			// The API server ends the stream cleanly at LimitBytes.
+			if counter.n > limitBytes {
+				return ErrLogLimit
+			}
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read logs of pod %s: %v", pod.Name, err)
+		}
+		if err := emit(LogLine{Pod: pod.Name, Container: container, Line: line, Truncated: truncated}, size); err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads a line of at most max bytes, discarding the rest of
+// longer lines. It also returns the size of the whole line and its newline.
+func readLine(r *bufio.Reader, max int) (string, int, bool, error) {
+	var buf []byte
+	size, truncated := 1, false
+	for {
+		frag, isPrefix, err := r.ReadLine()
+		if err != nil {
+			if err == io.EOF && size > 1 {
+				return string(buf), size, truncated, nil
+			}
+			return "", 0, false, err
+		}
+		size += len(frag)
+		if room := max - len(buf); len(frag) > room {
+			frag = frag[:room]
+			truncated = true
+		}
+		buf = append(buf, frag...)
+		if !isPrefix {
+			return string(buf), size, truncated, nil
+		}
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// defaultContainer returns the container kubectl reads by default: the one
+// named by the default-container annotation, or the first.
+func defaultContainer(pod *corev1.Pod) string {
+	if name := pod.Annotations["kubectl.kubernetes.io/default-container"]; hasContainer(pod, name) {
+		return name
+	}
+	if len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Name
+	}
+	return ""
+}
+
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == name {
+			return true
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// logBudget counts down the lines and bytes a request may still return,
+// and serializes emits from concurrently followed pods.
+type logBudget struct {
+	mu    sync.Mutex
+	lines int
+	bytes int
+	emit  func(LogLine) error
+}
+
+// send emits l, counting size bytes for it. A line the remaining bytes
+// cannot hold is emitted cut to them, marked truncated, and ends the logs
+// with ErrLogLimit.
+func (b *logBudget) send(l LogLine, size int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.lines <= 0 || b.bytes <= 0 {
+		return ErrLogLimit
+	}
+	if size <= b.bytes {
+		b.lines--
+		b.bytes -= size
+		return b.emit(l)
+	}
+	if len(l.Line) > b.bytes {
+		l.Line = l.Line[:b.bytes]
+		l.Truncated = true
+	}
+	b.lines--
+	b.bytes = 0
+	if err := b.emit(l); err != nil {
+		return err
+	}
+	return ErrLogLimit
+}
+
+// remainingBytes returns the bytes the request may still return, which also
+// bound what the API server sends for the next pod.
+func (b *logBudget) remainingBytes() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(b.bytes)
+}
+
+// sendError emits an error line, which does not count against the budget.
+func (b *logBudget) sendError(l LogLine) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.emit(l)
+}
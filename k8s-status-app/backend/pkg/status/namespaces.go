@@ -0,0 +1,70 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	gke "k8s-status-backend/pkg/gke"
+	"k8s-status-backend/pkg/scope"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterScope is what is reported of a cluster: the namespaces in scope,
+// and the filter selecting them and the workloads in them.
+type ClusterScope struct {
+	ClusterName string `json:"cluster_name"`
+	ProjectID   string `json:"project_id"`
+	Location    string `json:"location"`
+	// Namespaces are the namespaces of the cluster in scope, sorted.
+	Namespaces []string     `json:"namespaces"`
+	Filter     scope.Filter `json:"filter"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// ClusterScopes returns the scope of every cluster, listing their
+// namespaces concurrently.
+func (a *Aggregator) ClusterScopes(ctx context.Context, clusters []gke.ClusterInfo) []ClusterScope {
+	var wg sync.WaitGroup
+	results := make([]ClusterScope, len(clusters))
+	for i, c := range clusters {
+		wg.Add(1)
+		go func(idx int, cluster gke.ClusterInfo) {
+			defer wg.Done()
+			results[idx] = a.clusterScope(ctx, cluster)
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+func (a *Aggregator) clusterScope(ctx context.Context, cluster gke.ClusterInfo) ClusterScope {
+	filter := a.scope.Filter(cluster)
+	cs := ClusterScope{
+		ClusterName: cluster.Name,
+		ProjectID:   cluster.ProjectID,
+		Location:    cluster.Location,
+		Namespaces:  []string{},
+		Filter:      filter,
+	}
+
+	client, err := a.clients.GetClient(ctx, cluster)
+	if err != nil {
+		cs.Error = fmt.Sprintf("Failed to create client: %v", err)
+		return cs
+	}
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		cs.Error = fmt.Sprintf("Failed to list namespaces: %v", err)
+		return cs
+	}
+	for _, ns := range namespaces.Items {
+		if filter.IncludesNamespace(ns.Name) {
+			cs.Namespaces = append(cs.Namespaces, ns.Name)
+		}
+	}
+	sort.Strings(cs.Namespaces)
+	return cs
+}
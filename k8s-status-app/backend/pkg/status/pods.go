@@ -0,0 +1,180 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxOwnerDepth bounds how far the owner chain of a pod is followed, e.g.
+// Pod -> Job -> CronJob.
+const maxOwnerDepth = 4
+
+// workloadPods returns the pods of the workload of the given kind and name.
+// Services select their pods by label and autoscalers resolve to their
+// scale target; for every other kind the pods are those the workload owns,
+// directly or through ReplicaSets and Jobs.
+func workloadPods(ctx context.Context, client kubernetes.Interface, namespace, kind, name string) ([]corev1.Pod, error) {
+	switch kind {
+	case "Service":
+		svc, err := client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get service %s: %v", name, err)
+		}
+		// A Service without a selector has manually managed endpoints.
+		if len(svc.Spec.Selector) == 0 {
+			return nil, nil
+		}
+		return listPods(ctx, client, namespace, labels.SelectorFromSet(svc.Spec.Selector).String())
+	case "HorizontalPodAutoscaler":
+		hpa, err := client.AutoscalingV2().HorizontalPodAutoscalers(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get horizontalpodautoscaler %s: %v", name, err)
+		}
+		target := hpa.Spec.ScaleTargetRef
+		if target.Kind == kind {
+			return nil, fmt.Errorf("horizontalpodautoscaler %s targets an autoscaler", name)
+		}
+		return workloadPods(ctx, client, namespace, target.Kind, target.Name)
+	}
+
+	pods, err := listPods(ctx, client, namespace, "")
+	if err != nil {
+		return nil, err
+	}
+	lookup := &listedControllers{client: client, namespace: namespace}
+	var owned []corev1.Pod
+	for i := range pods {
+		chain, err := ancestors(ctx, &pods[i], lookup.controllerOf)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range chain {
+			if ref.Kind == kind && ref.Name == name {
+				owned = append(owned, pods[i])
+				break
+			}
+		}
+	}
+	return owned, nil
+}
+
+func listPods(ctx context.Context, client kubernetes.Interface, namespace, selector string) ([]corev1.Pod, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	return pods.Items, nil
+}
+
+// controllerLookup returns the controller of the ReplicaSet or Job with
+// the given kind and name in namespace, or nil if it has none.
+type controllerLookup func(ctx context.Context, namespace, kind, name string) (*metav1.OwnerReference, error)
+
+// ancestors returns the controllers of obj, nearest first, following
+// intermediate owners (ReplicaSets and Jobs) through lookup, e.g.
+// ReplicaSet then Deployment for a pod.
+func ancestors(ctx context.Context, obj metav1.Object, lookup controllerLookup) ([]*metav1.OwnerReference, error) {
+	return chainFrom(ctx, obj.GetNamespace(), metav1.GetControllerOf(obj), lookup)
+}
+
+// chainFrom returns ref followed by its controllers, following
+// intermediate owners through lookup.
+func chainFrom(ctx context.Context, namespace string, ref *metav1.OwnerReference, lookup controllerLookup) ([]*metav1.OwnerReference, error) {
+	var chain []*metav1.OwnerReference
+	for depth := 0; ref != nil && depth < maxOwnerDepth; depth++ {
+		chain = append(chain, ref)
+		if ref.Kind != "ReplicaSet" && ref.Kind != "Job" {
+			break
+		}
+		next, err := lookup(ctx, namespace, ref.Kind, ref.Name)
+		if err != nil {
+			return chain, err
+		}
+		ref = next
+	}
+	return chain, nil
+}
+
+// chainKeys returns the keys, like workloadKey, of the owners in chain.
+func chainKeys(namespace string, chain []*metav1.OwnerReference) []string {
+	keys := make([]string, len(chain))
+	for i, ref := range chain {
+		keys[i] = namespace + "/" + ref.Kind + "/" + ref.Name
+	}
+	return keys
+}
+
+// listedControllers looks up intermediate owners by listing them, once per
+// kind on first use, in namespace or in all namespaces if it is empty.
+type listedControllers struct {
+	client    kubernetes.Interface
+	namespace string
+
+	// listed records the kinds listed so far; controllers maps the
+	// "namespace/Kind/name" of their objects to the objects' controllers.
+	listed      map[string]bool
+	controllers map[string]*metav1.OwnerReference
+}
+
+func (l *listedControllers) controllerOf(ctx context.Context, namespace, kind, name string) (*metav1.OwnerReference, error) {
+	if l.listed == nil {
+		l.listed = map[string]bool{}
+		l.controllers = map[string]*metav1.OwnerReference{}
+	}
+	if !l.listed[kind] {
+		var objs []metav1.Object
+		switch kind {
+		case "ReplicaSet":
+			rss, err := l.client.AppsV1().ReplicaSets(l.namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list replicasets: %v", err)
+			}
+			for i := range rss.Items {
+				objs = append(objs, &rss.Items[i])
+			}
+		case "Job":
+			jobs, err := l.client.BatchV1().Jobs(l.namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list jobs: %v", err)
+			}
+			for i := range jobs.Items {
+				objs = append(objs, &jobs.Items[i])
+			}
+		default:
+			return nil, nil
+		}
+		for _, obj := range objs {
+			if c := metav1.GetControllerOf(obj); c != nil {
+				l.controllers[obj.GetNamespace()+"/"+kind+"/"+obj.GetName()] = c
+			}
+		}
+		l.listed[kind] = true
+	}
+	return l.controllers[namespace+"/"+kind+"/"+name], nil
+}
+
+// podHealthByWorkload evaluates pods and groups their health by the
+// workloads owning them, keyed like workloadKey.
+func podHealthByWorkload(ctx context.Context, pods []*corev1.Pod, lookup controllerLookup, now time.Time) (map[string][]PodHealth, error) {
+	byWorkload := map[string][]PodHealth{}
+	for _, pod := range pods {
+		health := podHealth(pod, now)
+		if health.Reason == "" {
+			continue
+		}
+		chain, err := ancestors(ctx, pod, lookup)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range chainKeys(pod.Namespace, chain) {
+			byWorkload[key] = append(byWorkload[key], health)
+		}
+	}
+	return byWorkload, nil
+}
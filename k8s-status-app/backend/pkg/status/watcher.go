@@ -0,0 +1,1069 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	"k8s-status-backend/pkg/k8s"
+	"k8s-status-backend/pkg/scope"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Status event types sent to Watcher subscribers.
+const (
+	// EventWorkloadUpdated carries a workload that was added or changed.
+	EventWorkloadUpdated = "workload_updated"
+	// EventWorkloadDeleted carries a workload that was removed.
+	EventWorkloadDeleted = "workload_deleted"
+	// EventClusterUpdated carries a cluster's node counts or error, without
+	// its workloads.
+	EventClusterUpdated = "cluster_updated"
+	// EventClusterSynced carries the whole status of a cluster, workloads
+	// included, once its informers first sync. Changes made before are not
+	// sent.
+	EventClusterSynced = "cluster_synced"
+)
+
+// StatusEvent is an incremental change to the status of a cluster.
+type StatusEvent struct {
+	Type        string          `json:"type"`
+	ProjectID   string          `json:"project_id"`
+	Location    string          `json:"location"`
+	ClusterName string          `json:"cluster_name"`
+	Workload    *WorkloadStatus `json:"workload,omitempty"`
+	Cluster     *ClusterStatus  `json:"cluster,omitempty"`
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped.
+const subscriberBuffer = 256
+
+// DefaultSyncTimeout bounds how long Status waits for a new cluster's
+// informers to sync.
+const DefaultSyncTimeout = 10 * time.Second
+
+const (
+	// DefaultRetryBackoff is how long a watch waits before getting a client
+	// again after failing to, doubling on every failure up to
+	// maxRetryBackoff.
+	DefaultRetryBackoff = 5 * time.Second
+	maxRetryBackoff     = 5 * time.Minute
+	// syncPollInterval is how often informers are checked for sync.
+	syncPollInterval = 100 * time.Millisecond
+	// healthCheckDelay is how long after a pod's health changes with time
+	// it is evaluated again, so that the change has happened.
+	healthCheckDelay = 100 * time.Millisecond
+)
+
+// WatcherOptions configures a Watcher. Zero fields take their defaults.
+type WatcherOptions struct {
+	// Scope is the namespaces and workloads reported; scope.Default() if
+	// nil.
+	Scope *scope.Config
+	// SyncTimeout bounds how long a new cluster's informers may take to
+	// sync before the cluster is served without those that have not.
+	SyncTimeout time.Duration
+	// RetryBackoff is the wait before the first retry of a cluster whose
+	// client could not be created.
+	RetryBackoff time.Duration
+}
+
+// Watcher keeps the status of clusters up to date with shared informers
+// instead of listing every resource on each request. Subscribers receive
+// each change as a StatusEvent.
+type Watcher struct {
+	clients      ClientProvider
+	scope        *scope.Config
+	syncTimeout  time.Duration
+	retryBackoff time.Duration
+
+	mu       sync.Mutex
+	clusters map[string]*clusterWatch
+
+	subsMu sync.Mutex
+	subs   map[chan StatusEvent]struct{}
+}
+
+// NewWatcher returns a Watcher getting clients from clients, watching the
+// default scope.
+func NewWatcher(clients ClientProvider) *Watcher {
+	return NewWatcherWithScope(clients, scope.Default())
+}
+
+// NewWatcherWithScope returns a Watcher reporting only the namespaces and
+// workloads in cfg's scope.
+func NewWatcherWithScope(clients ClientProvider, cfg *scope.Config) *Watcher {
+	return NewWatcherWithOptions(clients, WatcherOptions{Scope: cfg})
+}
+
+// NewWatcherWithOptions returns a Watcher configured by opts.
+func NewWatcherWithOptions(clients ClientProvider, opts WatcherOptions) *Watcher {
+	if opts.Scope == nil {
+		opts.Scope = scope.Default()
+	}
+	if opts.SyncTimeout <= 0 {
+		opts.SyncTimeout = DefaultSyncTimeout
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+	return &Watcher{
+		clients:      clients,
+		scope:        opts.Scope,
+		syncTimeout:  opts.SyncTimeout,
+		retryBackoff: opts.RetryBackoff,
+		clusters:     map[string]*clusterWatch{},
+		subs:         map[chan StatusEvent]struct{}{},
+	}
+}
+
+// Status returns the status of clusters from the watch model. Clusters not
+// yet watched start being watched; clusters no longer listed stop. A
+// cluster whose informers have not all synced within the sync timeout is
+// reported from those that have, with an error naming the others.
+func (w *Watcher) Status(ctx context.Context, clusters []gke.ClusterInfo) []ClusterStatus {
+	watches := w.sync(clusters)
+
+	// Watches mark themselves synced by the sync timeout, so this only
+	// expires for clusters whose client is still being created.
+	ctx, cancel := context.WithTimeout(ctx, w.syncTimeout+time.Second)
+	defer cancel()
+	results := make([]ClusterStatus, len(watches))
+	for i, cw := range watches {
+		select {
+		case <-cw.synced:
+			results[i] = cw.snapshot()
+		case <-ctx.Done():
+			results[i] = cw.snapshot()
+			results[i].addError("Waiting for initial sync")
+		}
+	}
+	return results
+}
+
+// Subscribe returns a channel receiving every status change, and a function
+// to unsubscribe. A subscriber that falls too far behind has its channel
+// closed; it should resubscribe and reload the full status.
+func (w *Watcher) Subscribe() (<-chan StatusEvent, func()) {
+	ch := make(chan StatusEvent, subscriberBuffer)
+	w.subsMu.Lock()
+	w.subs[ch] = struct{}{}
+	w.subsMu.Unlock()
+
+	return ch, func() {
+		w.subsMu.Lock()
+		defer w.subsMu.Unlock()
+		if _, ok := w.subs[ch]; ok {
+			delete(w.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close stops watching every cluster and closes all subscriptions.
+func (w *Watcher) Close() {
+	w.mu.Lock()
+	for key, cw := range w.clusters {
+		cw.cancel()
+		delete(w.clusters, key)
+	}
+	w.mu.Unlock()
+
+	w.subsMu.Lock()
+	defer w.subsMu.Unlock()
+	for ch := range w.subs {
+		delete(w.subs, ch)
+		close(ch)
+	}
+}
+
+// publish sends e to every subscriber, dropping those that are full.
+func (w *Watcher) publish(e StatusEvent) {
+	w.subsMu.Lock()
+	defer w.subsMu.Unlock()
+	for ch := range w.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(w.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// sync starts watching new clusters, restarts watches of clusters whose
+// endpoint or CA changed, and stops watching clusters not in the list. It
+// returns the watches in the order of clusters.
+func (w *Watcher) sync(clusters []gke.ClusterInfo) []*clusterWatch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watches := make([]*clusterWatch, len(clusters))
+	keep := map[string]bool{}
+	for i, c := range clusters {
+		key := k8s.ClusterKey(c)
+		keep[key] = true
+		cw, ok := w.clusters[key]
+		if ok && (cw.info.Endpoint != c.Endpoint || cw.info.CaCert != c.CaCert) {
+			cw.cancel()
+			ok = false
+		}
+		if !ok {
+			cw = w.startWatch(c)
+			w.clusters[key] = cw
+		}
+		watches[i] = cw
+	}
+	for key, cw := range w.clusters {
+		if !keep[key] {
+			cw.cancel()
+			delete(w.clusters, key)
+		}
+	}
+	return watches
+}
+
+// clusterWatch is the live status of one cluster.
+type clusterWatch struct {
+	info    gke.ClusterInfo
+	filter  scope.Filter
+	watcher *Watcher
+	cancel  context.CancelFunc
+	// synced is closed once the informers have synced or the sync timeout
+	// has passed, or getting a client failed.
+	synced     chan struct{}
+	syncedOnce sync.Once
+	// live is set once the informers first sync, or the sync timeout has
+	// passed. Changes are published only from then on: the initial listing
+	// would overflow subscribers, and EventClusterSynced carries it.
+	live atomic.Bool
+
+	// lookup resolves the owners of pods from the informer caches, and
+	// podStore is the cache of pods.
+	lookup   controllerLookup
+	podStore cache.Store
+
+	mu        sync.RWMutex
+	nodeReady map[string]bool
+	workloads map[string]watchedWorkload
+	// pods are the pods that were unhealthy when last seen or whose health
+	// changes with time, by namespace/name. healthTimer evaluates them
+	// again when it does, until stopped.
+	pods        map[string]trackedPod
+	healthTimer *time.Timer
+	stopped     bool
+	// warnings are the Warning events, by namespace/name.
+	warnings map[string]trackedWarning
+	// podsOf and warningsOf are the keys of the pods and warnings of each
+	// workload, by workload key, so that a change to one re-explains only
+	// the workloads owning it.
+	podsOf     map[string]map[string]bool
+	warningsOf map[string]map[string]bool
+	// podWarnings are the keys of the warnings about each pod not yet seen,
+	// by pod key, resolved once it is.
+	podWarnings map[string]map[string]bool
+	err         string
+	// unsynced are the informers that had not synced by the sync timeout
+	// and still have not.
+	unsynced []string
+}
+
+// trackedPod is a pod with the keys of its owners, nearest first, and the
+// next time its health changes with time, if any.
+type trackedPod struct {
+	pod       *corev1.Pod
+	owners    []string
+	changesAt time.Time
+}
+
+// trackedWarning is a Warning event with the keys of the object it is
+// about and of its owners.
+type trackedWarning struct {
+	event   *corev1.Event
+	summary EventSummary
+	owners  []string
+}
+
+// watchedWorkload is a workload's status without its age, which is
+// computed when read so that it does not go stale. effective is status
+// explained by the health of the workload's pods and its most recent
+// warning, as published.
+type watchedWorkload struct {
+	status    WorkloadStatus
+	effective WorkloadStatus
+	created   metav1.Time
+}
+
+// startWatch starts watching a cluster.
+func (w *Watcher) startWatch(cluster gke.ClusterInfo) *clusterWatch {
+	ctx, cancel := context.WithCancel(context.Background())
+	cw := &clusterWatch{
+		info:        cluster,
+		filter:      w.scope.Filter(cluster),
+		watcher:     w,
+		cancel:      cancel,
+		synced:      make(chan struct{}),
+		nodeReady:   map[string]bool{},
+		workloads:   map[string]watchedWorkload{},
+		pods:        map[string]trackedPod{},
+		warnings:    map[string]trackedWarning{},
+		podsOf:      map[string]map[string]bool{},
+		warningsOf:  map[string]map[string]bool{},
+		podWarnings: map[string]map[string]bool{},
+	}
+	go cw.run(ctx)
+	return cw
+}
+
+// run gets a client for the cluster, retrying with backoff until ctx is
+// done, and starts its informers. A failure is reported in the cluster's
+// error rather than waited for.
+func (cw *clusterWatch) run(ctx context.Context) {
+	backoff := cw.watcher.retryBackoff
+	for {
+		client, err := cw.watcher.clients.GetClient(ctx, cw.info)
+		if err == nil {
+			cw.setError("")
+			cw.startInformers(ctx, client)
+			return
+		}
+		cw.setError(fmt.Sprintf("Failed to create client: %v", err))
+		cw.markSynced()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// markSynced unblocks requests waiting for the cluster's status.
+func (cw *clusterWatch) markSynced() {
+	cw.syncedOnce.Do(func() { close(cw.synced) })
+}
+
+// markLive starts publishing the cluster's changes, first with an
+// EventClusterSynced carrying its whole status, and marks it synced.
+func (cw *clusterWatch) markLive() {
+	if cw.live.CompareAndSwap(false, true) {
+		s := cw.snapshot()
+		cw.publish(StatusEvent{Type: EventClusterSynced, Cluster: &s})
+	}
+	cw.markSynced()
+}
+
+// startInformers starts the informers of a cluster.
+func (cw *clusterWatch) startInformers(ctx context.Context, client kubernetes.Interface) {
+	// No resync: informers deliver every change as it happens.
+	factory := informers.NewSharedInformerFactory(client, 0)
+	nodes := factory.Core().V1().Nodes().Informer()
+	nodes.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { cw.updateNode(obj, false) },
+		UpdateFunc: func(_, obj any) { cw.updateNode(obj, false) },
+		DeleteFunc: func(obj any) { cw.updateNode(obj, true) },
+	})
+	deployments := factory.Apps().V1().Deployments().Informer()
+	cw.watchWorkloads(deployments, func(obj any) (WorkloadStatus, metav1.Object, bool) {
+		d, ok := obj.(*appsv1.Deployment)
+		if !ok {
+			return WorkloadStatus{}, nil, false
+		}
+		return deploymentStatus(d), d, true
+	})
+	services := factory.Core().V1().Services().Informer()
+	cw.watchWorkloads(services, func(obj any) (WorkloadStatus, metav1.Object, bool) {
+		svc, ok := obj.(*corev1.Service)
+		if !ok {
+			return WorkloadStatus{}, nil, false
+		}
+		return serviceStatus(svc), svc, true
+	})
+
+	statefulSets := factory.Apps().V1().StatefulSets().Informer()
+	cw.watchWorkloads(statefulSets, func(obj any) (WorkloadStatus, metav1.Object, bool) {
+		ss, ok := obj.(*appsv1.StatefulSet)
+		if !ok {
+			return WorkloadStatus{}, nil, false
+		}
+		return statefulSetStatus(ss), ss, true
+	})
+	daemonSets := factory.Apps().V1().DaemonSets().Informer()
+	cw.watchWorkloads(daemonSets, func(obj any) (WorkloadStatus, metav1.Object, bool) {
+		ds, ok := obj.(*appsv1.DaemonSet)
+		if !ok {
+			return WorkloadStatus{}, nil, false
+		}
+		return daemonSetStatus(ds), ds, true
+	})
+	jobs := factory.Batch().V1().Jobs().Informer()
+	cw.watchWorkloads(jobs, func(obj any) (WorkloadStatus, metav1.Object, bool) {
+		j, ok := obj.(*batchv1.Job)
+		if !ok || ownedByCronJob(j) {
+			return WorkloadStatus{}, nil, false
+		}
+		return jobStatus(j), j, true
+	})
+	// A CronJob's next run is computed when it changes, which it does on
+	// every run as its last schedule time is updated.
+	cronJobs := factory.Batch().V1().CronJobs().Informer()
+	cw.watchWorkloads(cronJobs, func(obj any) (WorkloadStatus, metav1.Object, bool) {
+		cj, ok := obj.(*batchv1.CronJob)
+		if !ok {
+			return WorkloadStatus{}, nil, false
+		}
+		return cronJobStatus(cj, time.Now()), cj, true
+	})
+	hpas := factory.Autoscaling().V2().HorizontalPodAutoscalers().Informer()
+	cw.watchWorkloads(hpas, func(obj any) (WorkloadStatus, metav1.Object, bool) {
+		h, ok := obj.(*autoscalingv2.HorizontalPodAutoscaler)
+		if !ok {
+			return WorkloadStatus{}, nil, false
+		}
+		return hpaStatus(h), h, true
+	})
+
+	// Pods explain the workloads owning them, through ReplicaSets and Jobs.
+	pods := factory.Core().V1().Pods().Informer()
+	pods.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { cw.updatePod(obj, false) },
+		UpdateFunc: func(_, obj any) { cw.updatePod(obj, false) },
+		DeleteFunc: func(obj any) { cw.updatePod(obj, true) },
+	})
+	replicaSets := factory.Apps().V1().ReplicaSets().Informer()
+	cw.lookup = informerLookup(replicaSets, jobs)
+	cw.podStore = pods.GetStore()
+	// A pod may be seen before its owners; resolve it again once they are.
+	for _, inf := range []cache.SharedIndexInformer{replicaSets, jobs} {
+		inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: cw.updateIntermediate,
+		})
+	}
+
+	// Only Warning events are watched, with their own factory since the
+	// field selector applies to every informer of a factory.
+	eventFactory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = "type=" + corev1.EventTypeWarning
+		}))
+	events := eventFactory.Core().V1().Events().Informer()
+	events.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { cw.updateEvent(obj, false) },
+		UpdateFunc: func(_, obj any) { cw.updateEvent(obj, false) },
+		DeleteFunc: func(obj any) { cw.updateEvent(obj, true) },
+	})
+
+	all := []namedInformer{
+		{"nodes", nodes}, {"deployments", deployments}, {"services", services},
+		{"statefulsets", statefulSets}, {"daemonsets", daemonSets}, {"jobs", jobs},
+		{"cronjobs", cronJobs}, {"horizontalpodautoscalers", hpas}, {"pods", pods},
+		{"replicasets", replicaSets}, {"events", events},
+	}
+	for _, inf := range all {
+		inf.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			cw.setError(watchErrorPrefix + inf.name + ": " + err.Error())
+		})
+	}
+
+	factory.Start(ctx.Done())
+	eventFactory.Start(ctx.Done())
+	go cw.waitForSync(ctx, all)
+	go func() {
+		<-ctx.Done()
+		cw.mu.Lock()
+		cw.stopped = true
+		if cw.healthTimer != nil {
+			cw.healthTimer.Stop()
+		}
+		cw.mu.Unlock()
+		factory.Shutdown()
+		eventFactory.Shutdown()
+	}()
+}
+
+// namedInformer is an informer with the resource it watches, to report it.
+type namedInformer struct {
+	name string
+	cache.SharedIndexInformer
+}
+
+// waitForSync marks the cluster synced once every informer has synced. If
+// some have not by the sync timeout, e.g. because RBAC forbids listing
+// them, the cluster is marked synced anyway and they are reported in its
+// status until they sync.
+func (cw *clusterWatch) waitForSync(ctx context.Context, all []namedInformer) {
+	deadline := time.After(cw.watcher.syncTimeout)
+	tick := time.NewTicker(syncPollInterval)
+	defer tick.Stop()
+	timedOut := false
+	for {
+		var unsynced []string
+		for _, inf := range all {
+			if !inf.HasSynced() {
+				unsynced = append(unsynced, inf.name)
+			}
+		}
+		if len(unsynced) == 0 {
+			// Events and pods may have been seen before what they resolve to.
+			cw.refreshExplanations()
+			cw.setUnsynced(nil)
+			cw.setError("")
+			cw.markLive()
+			return
+		}
+		if timedOut {
+			cw.setUnsynced(unsynced)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			timedOut = true
+			cw.setUnsynced(unsynced)
+			cw.refreshExplanations()
+			cw.markLive()
+		case <-tick.C:
+		}
+	}
+}
+
+// watchWorkloads keeps the workloads of informer up to date in the model.
+// convert returns the status of a workload and its object, or false for
+// objects that are not workloads.
+func (cw *clusterWatch) watchWorkloads(informer cache.SharedIndexInformer, convert func(obj any) (WorkloadStatus, metav1.Object, bool)) {
+	remove := func(ws WorkloadStatus) {
+		ws.Age = ""
+		key := workloadKey(ws)
+
+		cw.mu.Lock()
+		_, existed := cw.workloads[key]
+		delete(cw.workloads, key)
+		cw.mu.Unlock()
+
+		if existed {
+			cw.publish(StatusEvent{Type: EventWorkloadDeleted, Workload: &ws})
+		}
+	}
+	update := func(obj any) {
+		ws, o, ok := convert(obj)
+		if !ok {
+			return
+		}
+		// A workload relabeled out of scope is reported as deleted.
+		if !cw.filter.Includes(o) {
+			remove(ws)
+			return
+		}
+		created := o.GetCreationTimestamp()
+		ws.Age = ""
+		key := workloadKey(ws)
+
+		cw.mu.Lock()
+		old, existed := cw.workloads[key]
+		effective := cw.explainLocked(ws, time.Now())
+		cw.workloads[key] = watchedWorkload{status: ws, effective: effective, created: created}
+		recovered := cw.clearWatchErrorLocked()
+		cw.mu.Unlock()
+
+		if recovered {
+			cw.publishCluster()
+		}
+		if !existed || old.effective != effective {
+			effective.Age = formatAge(created)
+			cw.publish(StatusEvent{Type: EventWorkloadUpdated, Workload: &effective})
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj any) { update(obj) },
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if ws, _, ok := convert(obj); ok {
+				remove(ws)
+			}
+		},
+	})
+}
+
+// updatePod records whether a pod is unhealthy and publishes the workloads
+// owning it whose status that changes. Pods whose health changes with time,
+// e.g. pending ones or ones that restarted recently, are kept to be
+// evaluated again.
+func (cw *clusterWatch) updatePod(obj any, deleted bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || !cw.filter.IncludesNamespace(pod.Namespace) {
+		return
+	}
+	key := pod.Namespace + "/" + pod.Name
+	now := time.Now()
+
+	cw.mu.Lock()
+	affected := cw.setPodLocked(key, pod, deleted, now)
+	// Warnings about the pod seen before it can be resolved now.
+	if !deleted {
+		for _, evKey := range keysOf(cw.podWarnings[key]) {
+			affected = append(affected, cw.setWarningLocked(evKey, cw.warnings[evKey].event)...)
+		}
+	}
+	changed := cw.explainKeysLocked(affected, now)
+	cw.scheduleHealthCheckLocked(now)
+	cw.mu.Unlock()
+
+	cw.publishWorkloads(changed)
+}
+
+// setPodLocked records a pod, or forgets it if it is deleted or healthy
+// for good, and returns the keys of the workloads owning it before and
+// after.
+func (cw *clusterWatch) setPodLocked(key string, pod *corev1.Pod, deleted bool, now time.Time) []string {
+	old, was := cw.pods[key]
+	if was {
+		for _, owner := range old.owners {
+			removeKey(cw.podsOf, owner, key)
+		}
+		delete(cw.pods, key)
+	}
+	affected := old.owners
+	if deleted {
+		return affected
+	}
+	tp := trackedPod{pod: pod, changesAt: healthChangesAt(pod, now)}
+	if podHealth(pod, now).Reason == "" && tp.changesAt.IsZero() {
+		return affected
+	}
+	// Lookups read the informer caches and cannot fail.
+	chain, _ := ancestors(context.Background(), pod, cw.lookup)
+	tp.owners = chainKeys(pod.Namespace, chain)
+	for _, owner := range tp.owners {
+		addKey(cw.podsOf, owner, key)
+	}
+	cw.pods[key] = tp
+	return append(affected, tp.owners...)
+}
+
+// scheduleHealthCheckLocked sets the health timer to the next time the
+// health of a kept pod changes.
+func (cw *clusterWatch) scheduleHealthCheckLocked(now time.Time) {
+	if cw.stopped {
+		return
+	}
+	var next time.Time
+	for _, tp := range cw.pods {
+		if t := tp.changesAt; !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if next.IsZero() {
+		if cw.healthTimer != nil {
+			cw.healthTimer.Stop()
+		}
+		return
+	}
+	delay := next.Sub(now) + healthCheckDelay
+	if cw.healthTimer == nil {
+		cw.healthTimer = time.AfterFunc(delay, cw.checkHealth)
+	} else {
+		cw.healthTimer.Reset(delay)
+	}
+}
+
+// checkHealth evaluates the kept pods whose health has changed with time
+// again, and publishes the workloads whose status that changes.
+func (cw *clusterWatch) checkHealth() {
+	now := time.Now()
+	cw.mu.Lock()
+	if cw.stopped {
+		cw.mu.Unlock()
+		return
+	}
+	var affected []string
+	for _, key := range keysOf(cw.pods) {
+		if tp := cw.pods[key]; !tp.changesAt.IsZero() && !tp.changesAt.After(now) {
+			affected = append(affected, cw.setPodLocked(key, tp.pod, false, now)...)
+		}
+	}
+	changed := cw.explainKeysLocked(affected, now)
+	cw.scheduleHealthCheckLocked(now)
+	cw.mu.Unlock()
+
+	cw.publishWorkloads(changed)
+}
+
+// updateEvent records a Warning event and publishes the workloads it
+// concerns whose most recent warning that changes.
+func (cw *clusterWatch) updateEvent(obj any, deleted bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ev, ok := obj.(*corev1.Event)
+	if !ok || !cw.filter.IncludesNamespace(ev.Namespace) {
+		return
+	}
+	key := ev.Namespace + "/" + ev.Name
+	if deleted || ev.Type != corev1.EventTypeWarning {
+		ev = nil
+	}
+
+	cw.mu.Lock()
+	changed := cw.explainKeysLocked(cw.setWarningLocked(key, ev), time.Now())
+	cw.mu.Unlock()
+
+	cw.publishWorkloads(changed)
+}
+
+// setWarningLocked records a Warning event, or forgets it if ev is nil,
+// and returns the keys of the workloads it concerned before and after.
+func (cw *clusterWatch) setWarningLocked(key string, ev *corev1.Event) []string {
+	old, was := cw.warnings[key]
+	if was {
+		for _, owner := range old.owners {
+			removeKey(cw.warningsOf, owner, key)
+		}
+		if p := old.podKey(); p != "" {
+			removeKey(cw.podWarnings, p, key)
+		}
+		delete(cw.warnings, key)
+	}
+	affected := old.owners
+	if ev == nil {
+		return affected
+	}
+	tw := trackedWarning{
+		event:   ev,
+		summary: eventSummary(ev),
+		owners:  eventOwners(context.Background(), ev, cw.pod, cw.lookup),
+	}
+	for _, owner := range tw.owners {
+		addKey(cw.warningsOf, owner, key)
+	}
+	if p := tw.podKey(); p != "" {
+		addKey(cw.podWarnings, p, key)
+	}
+	cw.warnings[key] = tw
+	return append(affected, tw.owners...)
+}
+
+// podKey returns the key of the pod a warning is about if it was not seen
+// when the warning was, or "".
+func (tw trackedWarning) podKey() string {
+	if len(tw.owners) > 0 || tw.event.InvolvedObject.Kind != "Pod" {
+		return ""
+	}
+	ns := tw.event.InvolvedObject.Namespace
+	if ns == "" {
+		ns = tw.event.Namespace
+	}
+	return ns + "/" + tw.event.InvolvedObject.Name
+}
+
+// updateIntermediate resolves again the pods and warnings whose owners
+// stopped at a ReplicaSet or Job not yet seen, and publishes the workloads
+// whose status that changes.
+func (cw *clusterWatch) updateIntermediate(obj any) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	var kind string
+	switch obj.(type) {
+	case *appsv1.ReplicaSet:
+		kind = "ReplicaSet"
+	case *batchv1.Job:
+		kind = "Job"
+	default:
+		return
+	}
+	owner := m.GetNamespace() + "/" + kind + "/" + m.GetName()
+	now := time.Now()
+
+	cw.mu.Lock()
+	var affected []string
+	// Only pods and warnings whose last owner it is may resolve further.
+	for _, key := range keysOf(cw.podsOf[owner]) {
+		if tp := cw.pods[key]; tp.owners[len(tp.owners)-1] == owner {
+			affected = append(affected, cw.setPodLocked(key, tp.pod, false, now)...)
+		}
+	}
+	for _, key := range keysOf(cw.warningsOf[owner]) {
+		if tw := cw.warnings[key]; tw.owners[len(tw.owners)-1] == owner {
+			affected = append(affected, cw.setWarningLocked(key, tw.event)...)
+		}
+	}
+	changed := cw.explainKeysLocked(affected, now)
+	cw.mu.Unlock()
+
+	cw.publishWorkloads(changed)
+}
+
+// refreshExplanations resolves every kept pod and warning again and
+// explains every workload, e.g. once informers have synced, since pods and
+// events may have been seen before what they resolve to. It publishes the
+// workloads whose status changed.
+func (cw *clusterWatch) refreshExplanations() {
+	now := time.Now()
+	cw.mu.Lock()
+	for _, key := range keysOf(cw.pods) {
+		cw.setPodLocked(key, cw.pods[key].pod, false, now)
+	}
+	for _, key := range keysOf(cw.warnings) {
+		cw.setWarningLocked(key, cw.warnings[key].event)
+	}
+	changed := cw.explainKeysLocked(keysOf(cw.workloads), now)
+	cw.scheduleHealthCheckLocked(now)
+	cw.mu.Unlock()
+
+	cw.publishWorkloads(changed)
+}
+
+// explainKeysLocked explains the workloads with the given keys again, and
+// returns those whose effective status changed. Keys of owners that are
+// not workloads, e.g. ReplicaSets, are skipped.
+func (cw *clusterWatch) explainKeysLocked(keys []string, now time.Time) []watchedWorkload {
+	var changed []watchedWorkload
+	seen := map[string]bool{}
+	for _, key := range keys {
+		wl, ok := cw.workloads[key]
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		effective := cw.explainLocked(wl.status, now)
+		if effective != wl.effective {
+			wl.effective = effective
+			cw.workloads[key] = wl
+			changed = append(changed, wl)
+		}
+	}
+	return changed
+}
+
+// explainLocked explains a workload's status by the health of its pods,
+// evaluated at now, and its most recent warning.
+func (cw *clusterWatch) explainLocked(ws WorkloadStatus, now time.Time) WorkloadStatus {
+	key := workloadKey(ws)
+	var health []PodHealth
+	for podKey := range cw.podsOf[key] {
+		if h := podHealth(cw.pods[podKey].pod, now); h.Reason != "" {
+			health = append(health, h)
+		}
+	}
+	// Pods are kept in a map; order them so the worst pod of equally bad
+	// ones does not change between evaluations.
+	sort.Slice(health, func(i, j int) bool { return health[i].Name < health[j].Name })
+
+	var latest map[string]EventSummary
+	for evKey := range cw.warningsOf[key] {
+		s := cw.warnings[evKey].summary
+		if prev, ok := latest[key]; !ok || s.last.After(prev.last) {
+			latest = map[string]EventSummary{key: s}
+		}
+	}
+	return applyWarning(applyPodHealth(ws, health), latest)
+}
+
+// keysOf returns the keys of m, which may then be changed while they are
+// iterated over.
+func keysOf[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// addKey adds key to the set of index[owner].
+func addKey(index map[string]map[string]bool, owner, key string) {
+	if index[owner] == nil {
+		index[owner] = map[string]bool{}
+	}
+	index[owner][key] = true
+}
+
+// removeKey removes key from the set of index[owner].
+func removeKey(index map[string]map[string]bool, owner, key string) {
+	delete(index[owner], key)
+	if len(index[owner]) == 0 {
+		delete(index, owner)
+	}
+}
+
+// pod returns a pod from the informer cache, or nil.
+func (cw *clusterWatch) pod(namespace, name string) *corev1.Pod {
+	obj, ok, err := cw.podStore.GetByKey(namespace + "/" + name)
+	if err != nil || !ok {
+		return nil
+	}
+	pod, _ := obj.(*corev1.Pod)
+	return pod
+}
+
+func (cw *clusterWatch) publishWorkloads(workloads []watchedWorkload) {
+	for _, wl := range workloads {
+		ws := wl.effective
+		ws.Age = formatAge(wl.created)
+		cw.publish(StatusEvent{Type: EventWorkloadUpdated, Workload: &ws})
+	}
+}
+
+// informerLookup looks up the controllers of ReplicaSets and Jobs in the
+// caches of their informers.
+func informerLookup(replicaSets, jobs cache.SharedIndexInformer) controllerLookup {
+	return func(_ context.Context, namespace, kind, name string) (*metav1.OwnerReference, error) {
+		var store cache.Store
+		switch kind {
+		case "ReplicaSet":
+			store = replicaSets.GetStore()
+		case "Job":
+			store = jobs.GetStore()
+		default:
+			return nil, nil
+		}
+		obj, ok, err := store.GetByKey(namespace + "/" + name)
+		if err != nil || !ok {
+			return nil, err
+		}
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		return metav1.GetControllerOf(m), nil
+	}
+}
+
+// updateNode records a node's readiness and publishes changed counts.
+func (cw *clusterWatch) updateNode(obj any, deleted bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+
+	cw.mu.Lock()
+	wasReady, existed := cw.nodeReady[node.Name]
+	changed := existed
+	if deleted {
+		delete(cw.nodeReady, node.Name)
+	} else {
+		ready := nodeReady(node)
+		cw.nodeReady[node.Name] = ready
+		changed = !existed || ready != wasReady
+	}
+	changed = cw.clearWatchErrorLocked() || changed
+	cw.mu.Unlock()
+
+	if changed {
+		cw.publishCluster()
+	}
+}
+
+// watchErrorPrefix starts the error recorded when a watch fails.
+const watchErrorPrefix = "Watch failed: "
+
+// clearWatchErrorLocked clears a watch failure, since informers deliver
+// events again once they have relisted. It reports whether there was one.
+func (cw *clusterWatch) clearWatchErrorLocked() bool {
+	if !strings.HasPrefix(cw.err, watchErrorPrefix) {
+		return false
+	}
+	cw.err = ""
+	return true
+}
+
+// setUnsynced records the informers not synced and publishes the cluster
+// if they changed.
+func (cw *clusterWatch) setUnsynced(names []string) {
+	cw.mu.Lock()
+	changed := strings.Join(cw.unsynced, ",") != strings.Join(names, ",")
+	cw.unsynced = names
+	cw.mu.Unlock()
+	if changed {
+		cw.publishCluster()
+	}
+}
+
+// setError records the cluster's error and publishes it if it changed.
+func (cw *clusterWatch) setError(msg string) {
+	cw.mu.Lock()
+	changed := cw.err != msg
+	cw.err = msg
+	cw.mu.Unlock()
+	if changed {
+		cw.publishCluster()
+	}
+}
+
+func (cw *clusterWatch) publishCluster() {
+	s := cw.snapshot()
+	s.Workloads = nil
+	cw.publish(StatusEvent{Type: EventClusterUpdated, Cluster: &s})
+}
+
+func (cw *clusterWatch) publish(e StatusEvent) {
+	if !cw.live.Load() {
+		return
+	}
+	e.ProjectID = cw.info.ProjectID
+	e.Location = cw.info.Location
+	e.ClusterName = cw.info.Name
+	cw.watcher.publish(e)
+}
+
+// snapshot returns the current status of the cluster, with workloads
+// ordered by namespace, kind and name.
+func (cw *clusterWatch) snapshot() ClusterStatus {
+	cw.mu.RLock()
+	defer cw.mu.RUnlock()
+
+	s := ClusterStatus{
+		ClusterName: cw.info.Name,
+		ProjectID:   cw.info.ProjectID,
+		Location:    cw.info.Location,
+		NodeCount:   len(cw.nodeReady),
+		Error:       cw.err,
+	}
+	if len(cw.unsynced) > 0 {
+		s.addError("Not synced: %s", strings.Join(cw.unsynced, ", "))
+	}
+	for _, ready := range cw.nodeReady {
+		if ready {
+			s.NodesReady++
+		}
+	}
+	for _, wl := range cw.workloads {
+		ws := wl.effective
+		ws.Age = formatAge(wl.created)
+		s.Workloads = append(s.Workloads, ws)
+	}
+	sort.Slice(s.Workloads, func(i, j int) bool {
+		return workloadKey(s.Workloads[i]) < workloadKey(s.Workloads[j])
+	})
+	return s
+}
+
+func workloadKey(ws WorkloadStatus) string {
+	return ws.Namespace + "/" + ws.Kind + "/" + ws.Name
+}
@@ -0,0 +1,218 @@
+package integration
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	k8s "k8s-status-backend/pkg/k8s"
+
+	"golang.org/x/oauth2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// newTestCluster starts a TLS API server answering node lists and returns
+// it as a cluster, with the bearer tokens it has seen.
+func newTestCluster(t *testing.T, name string) (gke.ClusterInfo, *[]string) {
+	t.Helper()
+	var tokens []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"NodeList","apiVersion":"v1","items":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	return gke.ClusterInfo{
+		Name:      name,
+		Location:  "us-central1",
+		ProjectID: "mslarkin-ext",
+		Endpoint:  strings.TrimPrefix(srv.URL, "https://"),
+		CaCert:    base64.StdEncoding.EncodeToString(ca),
+	}, &tokens
+}
+
+func TestClientManager_PoolsClients(t *testing.T) {
+	cluster, tokens := newTestCluster(t, "ai-auto-cluster")
+	cm := k8s.NewClientManagerWithOptions(k8s.ClientOptions{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}),
+		RateLimit:   k8s.RateLimit{QPS: 7, Burst: 9},
+		ClusterRateLimits: map[string]k8s.RateLimit{
+			"mslarkin-ext/us-central1/busy": {QPS: 100, Burst: 200},
+		},
+	})
+	defer cm.Close()
+	ctx := context.Background()
+
+	first, err := cm.GetClient(ctx, cluster)
+	if err != nil {
+		t.Fatalf("GetClient() error = %v", err)
+	}
+	if _, err := first.CoreV1().Nodes().List(ctx, metav1.ListOptions{}); err != nil {
+		t.Fatalf("List(nodes) error = %v", err)
+	}
+	if len(*tokens) != 1 || (*tokens)[0] != "Bearer tok" {
+		t.Errorf("Authorization headers = %v, want [Bearer tok]", *tokens)
+	}
+	if qps := first.CoreV1().RESTClient().GetRateLimiter().QPS(); qps != 7 {
+		t.Errorf("QPS = %v, want 7", qps)
+	}
+
+	second, _ := cm.GetClient(ctx, cluster)
+	if second != first || cm.Len() != 1 {
+		t.Errorf("second GetClient() returned a new client; pool has %d", cm.Len())
+	}
+
+	// A new endpoint rebuilds the client.
+	busy, _ := newTestCluster(t, "busy")
+	moved := cluster
+	moved.Endpoint = busy.Endpoint
+	third, err := cm.GetClient(ctx, moved)
+	if err != nil || third == first {
+		t.Errorf("GetClient() after endpoint change = %p, %v; want a new client", third, err)
+	}
+	if cm.Len() != 1 {
+		t.Errorf("pool has %d clients after rebuild, want 1", cm.Len())
+	}
+
+	// So does a rotated CA; this one is invalid, so the stale client is
+	// dropped rather than reused.
+	rotated := moved
+	rotated.CaCert = base64.StdEncoding.EncodeToString([]byte("not a certificate"))
+	if _, err := cm.GetClient(ctx, rotated); err == nil {
+		t.Error("GetClient() with an invalid CA error = nil")
+	}
+	if cm.Len() != 0 {
+		t.Errorf("pool has %d clients after failed rebuild, want 0", cm.Len())
+	}
+
+	client, err := cm.GetClient(ctx, busy)
+	if err != nil {
+		t.Fatalf("GetClient(busy) error = %v", err)
+	}
+	if qps := client.CoreV1().RESTClient().GetRateLimiter().QPS(); qps != 100 {
+		t.Errorf("busy cluster QPS = %v, want the override 100", qps)
+	}
+}
+
+func TestClientManager_EvictsIdleClients(t *testing.T) {
+	a, _ := newTestCluster(t, "a")
+	b, _ := newTestCluster(t, "b")
+	cm := k8s.NewClientManagerWithOptions(k8s.ClientOptions{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}),
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	if _, err := cm.GetClient(ctx, a); err != nil {
+		t.Fatalf("GetClient(a) error = %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := cm.GetClient(ctx, b); err != nil {
+		t.Fatalf("GetClient(b) error = %v", err)
+	}
+	if cm.Len() != 1 {
+		t.Errorf("pool has %d clients, want 1 after evicting the idle one", cm.Len())
+	}
+	cm.Close()
+	if cm.Len() != 0 {
+		t.Errorf("pool has %d clients after Close, want 0", cm.Len())
+	}
+
+	if _, err := cm.GetClient(ctx, gke.ClusterInfo{Name: "no-endpoint"}); err == nil {
+		t.Error("GetClient(no endpoint) error = nil")
+	}
+}
+
+func TestClientManager_EvictsInBackground(t *testing.T) {
+	a, _ := newTestCluster(t, "a")
+	cm := k8s.NewClientManagerWithOptions(k8s.ClientOptions{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}),
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	defer cm.Close()
+
+	if _, err := cm.GetClient(context.Background(), a); err != nil {
+		t.Fatalf("GetClient(a) error = %v", err)
+	}
+	// No further GetClient call is needed to evict the idle client.
+	deadline := time.Now().Add(time.Second)
+	for cm.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cm.Len() != 0 {
+		t.Errorf("pool has %d clients, want the idle one evicted", cm.Len())
+	}
+}
+
+func TestClientManager_ConcurrentGetClient(t *testing.T) {
+	a, _ := newTestCluster(t, "a")
+	b, _ := newTestCluster(t, "b")
+	cm := k8s.NewClientManagerWithOptions(k8s.ClientOptions{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}),
+	})
+	defer cm.Close()
+	ctx := context.Background()
+
+	clients := make([]kubernetes.Interface, 20)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cluster := a
+			if i%2 == 1 {
+				cluster = b
+			}
+			c, err := cm.GetClient(ctx, cluster)
+			if err != nil {
+				t.Errorf("GetClient() error = %v", err)
+			}
+			clients[i] = c
+		}(i)
+	}
+	wg.Wait()
+	// Every caller of a cluster shares the client built once.
+	for i := 2; i < len(clients); i++ {
+		if clients[i] != clients[i%2] {
+			t.Fatalf("GetClient() call %d returned another client", i)
+		}
+	}
+	if clients[0] == clients[1] || cm.Len() != 2 {
+		t.Errorf("want a client per cluster; pool has %d", cm.Len())
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := k8s.ParseRateLimits("mslarkin-ext/us-central1/ai-auto-cluster=50:100, mslarkin-demo/us-west1/demo=2.5:5")
+	if err != nil {
+		t.Fatalf("ParseRateLimits() error = %v", err)
+	}
+	want := map[string]k8s.RateLimit{
+		"mslarkin-ext/us-central1/ai-auto-cluster": {QPS: 50, Burst: 100},
+		"mslarkin-demo/us-west1/demo":              {QPS: 2.5, Burst: 5},
+	}
+	if len(limits) != len(want) {
+		t.Fatalf("ParseRateLimits() = %v, want %v", limits, want)
+	}
+	for k, v := range want {
+		if limits[k] != v {
+			t.Errorf("limits[%s] = %v, want %v", k, limits[k], v)
+		}
+	}
+
+	for _, bad := range []string{"ai-auto-cluster=50:100", "a/b/c=50", "a/b/c=x:1", "a/b/c=5:0"} {
+		if _, err := k8s.ParseRateLimits(bad); err == nil {
+			t.Errorf("ParseRateLimits(%q) error = nil", bad)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	status "k8s-status-backend/pkg/status"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var eventBase = time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+
+// event returns an event of the object kind/name in "default", last seen
+// minutes after eventBase.
+func event(name, eventType, kind, object, reason, message string, count int32, minutes int) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Name: object, Namespace: "default"},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Count:          count,
+		FirstTimestamp: metav1.NewTime(eventBase),
+		LastTimestamp:  metav1.NewTime(eventBase.Add(time.Duration(minutes) * time.Minute)),
+	}
+}
+
+func eventObjects() []runtime.Object {
+	return []runtime.Object{
+		deployment("default", "frontend", 1, 1),
+		&appsv1.ReplicaSet{ObjectMeta: ownerMeta("default", "frontend-abc", "Deployment", "frontend")},
+		pod("default", "frontend-abc-1", "ReplicaSet", "frontend-abc", nil),
+		deployment("default", "cart", 1, 1),
+		event("e1", corev1.EventTypeNormal, "Deployment", "frontend", "ScalingReplicaSet", "Scaled up replica set frontend-abc to 1", 1, 0),
+		event("e2", corev1.EventTypeNormal, "ReplicaSet", "frontend-abc", "SuccessfulCreate", "Created pod: frontend-abc-1", 1, 1),
+		event("e3", corev1.EventTypeWarning, "Pod", "frontend-abc-1", "BackOff", "Back-off restarting failed container", 3, 5),
+		event("e4", corev1.EventTypeWarning, "Pod", "frontend-abc-1", "BackOff", "Back-off restarting failed container", 2, 9),
+		event("e5", "", "Pod", "frontend-abc-1", "Pulled", "Container image already present", 1, 7),
+		event("e6", corev1.EventTypeWarning, "Deployment", "cart", "FailedCreate", "unrelated", 1, 8),
+	}
+}
+
+func TestAggregator_GetWorkloadEvents(t *testing.T) {
+	client := fake.NewSimpleClientset(eventObjects()...)
+	aggregator := status.NewAggregator(FakeClients{"c": client})
+
+	events, err := aggregator.GetWorkloadEvents(context.Background(), gke.ClusterInfo{Name: "c"}, "default", "Deployment", "frontend")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []struct {
+		object, eventType, reason string
+		count                     int32
+	}{
+		{"Pod/frontend-abc-1", "Warning", "BackOff", 5},
+		{"Pod/frontend-abc-1", "Normal", "Pulled", 1},
+		{"ReplicaSet/frontend-abc", "Normal", "SuccessfulCreate", 1},
+		{"Deployment/frontend", "Normal", "ScalingReplicaSet", 1},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d: %+v", len(want), len(events), events)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Object != w.object || e.Type != w.eventType || e.Reason != w.reason || e.Count != w.count {
+			t.Errorf("event %d: expected %+v, got %+v", i, w, e)
+		}
+	}
+	if e := events[0]; e.FirstSeen != "2026-01-02T03:00:00Z" || e.LastSeen != "2026-01-02T03:09:00Z" {
+		t.Errorf("expected merged first and last seen, got %s and %s", e.FirstSeen, e.LastSeen)
+	}
+}
+
+func TestAggregator_LastWarning(t *testing.T) {
+	client := fake.NewSimpleClientset(eventObjects()...)
+	aggregator := status.NewAggregator(FakeClients{"c": client})
+
+	cs := aggregator.GetClusterStatus(context.Background(), gke.ClusterInfo{Name: "c"})
+	byName := map[string]status.WorkloadStatus{}
+	for _, w := range cs.Workloads {
+		byName[w.Name] = w
+	}
+	if w := byName["frontend"]; w.LastWarning != "BackOff: Back-off restarting failed container" || w.LastWarningAt != "2026-01-02T03:09:00Z" {
+		t.Errorf("unexpected last warning of frontend: %q at %q", w.LastWarning, w.LastWarningAt)
+	}
+	if w := byName["cart"]; w.LastWarning != "FailedCreate: unrelated" {
+		t.Errorf("unexpected last warning of cart: %q", w.LastWarning)
+	}
+}
+
+func TestWatcher_LastWarning(t *testing.T) {
+	objects := eventObjects()[:3]
+	client := fake.NewSimpleClientset(objects...)
+	watcher := status.NewWatcher(FakeClients{"c": client})
+	defer watcher.Close()
+	events, unsubscribe := watcher.Subscribe()
+	defer unsubscribe()
+
+	ctx := context.Background()
+	watcher.Status(ctx, []gke.ClusterInfo{{Name: "c"}})
+
+	ev := event("e3", corev1.EventTypeWarning, "Pod", "frontend-abc-1", "BackOff", "Back-off restarting failed container", 1, 5)
+	if _, err := client.CoreV1().Events("default").Create(ctx, ev, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	e := nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventWorkloadUpdated && e.Workload.LastWarning != ""
+	})
+	if e.Workload.Name != "frontend" || e.Workload.LastWarning != "BackOff: Back-off restarting failed container" {
+		t.Errorf("unexpected workload: %+v", e.Workload)
+	}
+}
+
+func TestWatcher_WarningResolvedLater(t *testing.T) {
+	// The deployment's pod is created after a warning about it is seen.
+	client := fake.NewSimpleClientset(eventObjects()[:2]...)
+	watcher := status.NewWatcher(FakeClients{"c": client})
+	defer watcher.Close()
+	events, unsubscribe := watcher.Subscribe()
+	defer unsubscribe()
+
+	ctx := context.Background()
+	watcher.Status(ctx, []gke.ClusterInfo{{Name: "c"}})
+
+	ev := event("e3", corev1.EventTypeWarning, "Pod", "frontend-abc-1", "FailedMount", "volume not found", 1, 5)
+	if _, err := client.CoreV1().Events("default").Create(ctx, ev, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().Pods("default").Create(ctx, pod("default", "frontend-abc-1", "ReplicaSet", "frontend-abc", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventWorkloadUpdated && e.Workload.Name == "frontend" && e.Workload.LastWarning == "FailedMount: volume not found"
+	})
+
+	// Deleting the warning clears it.
+	if err := client.CoreV1().Events("default").Delete(ctx, "e3", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventWorkloadUpdated && e.Workload.Name == "frontend" && e.Workload.LastWarning == ""
+	})
+}
@@ -0,0 +1,284 @@
+package integration
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	status "k8s-status-backend/pkg/status"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// brokenDeployment returns a deployment with one ReplicaSet and one pod with
+// the given status, as the online-shop demo's failure injection leaves them.
+func brokenDeployment(name string, conditions []appsv1.DeploymentCondition, podStatus corev1.PodStatus) []runtime.Object {
+	d := deployment("default", name, 1, 0)
+	d.Status.Conditions = conditions
+	p := pod("default", name+"-abc-1", "ReplicaSet", name+"-abc", nil)
+	p.Status = podStatus
+	return []runtime.Object{
+		d,
+		&appsv1.ReplicaSet{ObjectMeta: ownerMeta("default", name+"-abc", "Deployment", name)},
+		p,
+	}
+}
+
+// waiting returns the status of a running pod whose container is waiting.
+func waiting(reason string, restarts int32, last *corev1.ContainerStateTerminated) corev1.PodStatus {
+	cs := corev1.ContainerStatus{
+		Name:         "server",
+		RestartCount: restarts,
+		State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+	}
+	if last != nil {
+		cs.LastTerminationState.Terminated = last
+	}
+	return corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{cs}}
+}
+
+func TestAggregator_HealthReasons(t *testing.T) {
+	recently := metav1.NewTime(time.Now().Add(-time.Minute))
+	longAgo := metav1.NewTime(time.Now().Add(-time.Hour))
+	rollingOut := []appsv1.DeploymentCondition{
+		{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "ReplicaSetUpdated"},
+	}
+
+	tests := []struct {
+		name       string
+		conditions []appsv1.DeploymentCondition
+		pod        corev1.PodStatus
+		status     string
+		reason     string
+	}{
+		{
+			name:       "rollout",
+			conditions: rollingOut,
+			pod:        corev1.PodStatus{Phase: corev1.PodPending},
+			status:     "Progressing",
+			reason:     status.ReasonRollingOut,
+		},
+		{
+			name:       "crashloop",
+			conditions: rollingOut,
+			pod:        waiting("CrashLoopBackOff", 4, &corev1.ContainerStateTerminated{ExitCode: 1, FinishedAt: recently}),
+			status:     "Degraded",
+			reason:     status.ReasonCrashLoopBackOff,
+		},
+		{
+			name:   "imagepull",
+			pod:    waiting("ImagePullBackOff", 0, nil),
+			status: "Degraded",
+			reason: status.ReasonImagePullBackOff,
+		},
+		{
+			name:   "oom",
+			pod:    waiting("CrashLoopBackOff", 3, &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137, FinishedAt: recently}),
+			status: "Degraded",
+			reason: status.ReasonOOMKilled,
+		},
+		{
+			name:   "config",
+			pod:    waiting("CreateContainerConfigError", 0, nil),
+			status: "Degraded",
+			reason: status.ReasonCreateContainerConfigError,
+		},
+		{
+			name: "unschedulable",
+			pod: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{{
+					Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable,
+					Message: "0/3 nodes are available: 3 Insufficient cpu.", LastTransitionTime: longAgo,
+				}},
+			},
+			status: "Degraded",
+			reason: status.ReasonUnschedulable,
+		},
+		{
+			name: "scaling-up",
+			pod: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{{
+					Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable,
+					LastTransitionTime: recently,
+				}},
+			},
+			status: "Degraded",
+			reason: status.ReasonReplicasUnavailable,
+		},
+		{
+			name: "restarts",
+			pod: corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{{
+				Name: "server", RestartCount: 12, Ready: true,
+				State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 2, FinishedAt: recently}},
+			}}},
+			status: "Degraded",
+			reason: status.ReasonHighRestarts,
+		},
+		{
+			name: "quota",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+				{Type: appsv1.DeploymentReplicaFailure, Status: corev1.ConditionTrue, Reason: "FailedCreate",
+					Message: `pods "quota-abc-1" is forbidden: exceeded quota: compute, requested: cpu=2, used: cpu=4, limited: cpu=4`},
+			},
+			pod:    corev1.PodStatus{Phase: corev1.PodSucceeded},
+			status: "Degraded",
+			reason: status.ReasonQuotaExceeded,
+		},
+		{
+			name: "deadline",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded",
+					Message: `ReplicaSet "deadline-abc" has timed out progressing.`},
+			},
+			pod:    corev1.PodStatus{Phase: corev1.PodPending},
+			status: "Degraded",
+			reason: status.ReasonProgressDeadlineExceeded,
+		},
+	}
+
+	var objects []runtime.Object
+	for _, tt := range tests {
+		objects = append(objects, brokenDeployment(tt.name, tt.conditions, tt.pod)...)
+	}
+	client := fake.NewSimpleClientset(objects...)
+	aggregator := status.NewAggregator(FakeClients{"c": client})
+	cs := aggregator.GetClusterStatus(context.Background(), gke.ClusterInfo{Name: "c"})
+	if cs.Error != "" {
+		t.Fatalf("unexpected error: %s", cs.Error)
+	}
+	byName := map[string]status.WorkloadStatus{}
+	for _, w := range cs.Workloads {
+		byName[w.Name] = w
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := byName[tt.name]
+			if w.Status != tt.status || w.Reason != tt.reason {
+				t.Errorf("expected %s/%s, got %s/%s (%s)", tt.status, tt.reason, w.Status, w.Reason, w.Message)
+			}
+			if w.Message == "" {
+				t.Error("expected a message")
+			}
+		})
+	}
+
+	if msg := byName["crashloop"].Message; !strings.Contains(msg, "crashloop-abc-1") {
+		t.Errorf("expected the message to name the pod, got %q", msg)
+	}
+}
+
+func TestAggregator_PodHealth(t *testing.T) {
+	objects := brokenDeployment("frontend", nil, waiting("CrashLoopBackOff", 7, &corev1.ContainerStateTerminated{ExitCode: 1}))
+	client := fake.NewSimpleClientset(objects...)
+	aggregator := status.NewAggregator(FakeClients{"c": client})
+
+	pods, err := aggregator.GetWorkloadPods(context.Background(), gke.ClusterInfo{Name: "c"}, "default", "Deployment", "frontend")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pods) != 1 {
+		t.Fatalf("expected 1 pod, got %d", len(pods))
+	}
+	if p := pods[0]; p.Reason != status.ReasonCrashLoopBackOff || p.Restarts != 7 || p.Message == "" {
+		t.Errorf("unexpected pod health: %+v", p)
+	}
+}
+
+func TestWatcher_PodHealth(t *testing.T) {
+	objects := brokenDeployment("frontend", nil, corev1.PodStatus{Phase: corev1.PodRunning})
+	objects[0].(*appsv1.Deployment).Status.ReadyReplicas = 1
+	client := fake.NewSimpleClientset(objects...)
+	watcher := status.NewWatcher(FakeClients{"c": client})
+	defer watcher.Close()
+	events, unsubscribe := watcher.Subscribe()
+	defer unsubscribe()
+
+	ctx := context.Background()
+	statuses := watcher.Status(ctx, []gke.ClusterInfo{{Name: "c"}})
+	if w := statuses[0].Workloads; len(w) != 1 || w[0].Status != "Healthy" {
+		t.Fatalf("expected a healthy deployment, got %+v", w)
+	}
+
+	// The pod starts crash looping.
+	p := objects[2].(*corev1.Pod).DeepCopy()
+	p.Status = waiting("CrashLoopBackOff", 5, nil)
+	if _, err := client.CoreV1().Pods("default").UpdateStatus(ctx, p, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	e := nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventWorkloadUpdated && e.Workload.Reason == status.ReasonCrashLoopBackOff
+	})
+	if e.Workload.Name != "frontend" || e.Workload.Status != "Degraded" {
+		t.Errorf("unexpected workload: %+v", e.Workload)
+	}
+
+	// Deleting it clears the reason.
+	if err := client.CoreV1().Pods("default").Delete(ctx, p.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventWorkloadUpdated && e.Workload.Name == "frontend" && e.Workload.Status == "Healthy"
+	})
+}
+
+func TestWatcher_PodHealthChangesWithTime(t *testing.T) {
+	// A pod about to exceed the scheduling grace, and one whose OOM kill is
+	// about to leave the restart window.
+	soon := 300 * time.Millisecond
+	pending := brokenDeployment("pending", nil, corev1.PodStatus{
+		Phase: corev1.PodPending,
+		Conditions: []corev1.PodCondition{{
+			Type:               corev1.PodScheduled,
+			Status:             corev1.ConditionFalse,
+			Reason:             corev1.PodReasonUnschedulable,
+			Message:            "0/3 nodes are available",
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-5*time.Minute + soon)),
+		}},
+	})
+	oom := brokenDeployment("oom", nil, corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name:         "server",
+			RestartCount: 1,
+			State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				Reason:     "OOMKilled",
+				FinishedAt: metav1.NewTime(time.Now().Add(-time.Hour + soon)),
+			}},
+		}},
+	})
+	oom[0].(*appsv1.Deployment).Status.ReadyReplicas = 1
+	client := fake.NewSimpleClientset(append(pending, oom...)...)
+	watcher := status.NewWatcher(FakeClients{"c": client})
+	defer watcher.Close()
+	events, unsubscribe := watcher.Subscribe()
+	defer unsubscribe()
+
+	statuses := watcher.Status(context.Background(), []gke.ClusterInfo{{Name: "c"}})
+	for _, w := range statuses[0].Workloads {
+		if w.Name == "pending" && w.Reason == status.ReasonUnschedulable || w.Name == "oom" && w.Reason != status.ReasonOOMKilled {
+			t.Errorf("unexpected initial status: %+v", w)
+		}
+	}
+
+	// Both change without the pods changing, in either order.
+	unschedulable, recovered := false, false
+	nextEvent(t, events, func(e status.StatusEvent) bool {
+		if e.Type == status.EventWorkloadUpdated {
+			unschedulable = unschedulable || e.Workload.Name == "pending" && e.Workload.Reason == status.ReasonUnschedulable
+			recovered = recovered || e.Workload.Name == "oom" && e.Workload.Status == "Healthy"
+		}
+		return unschedulable && recovered
+	})
+}
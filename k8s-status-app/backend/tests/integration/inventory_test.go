@@ -0,0 +1,271 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// MockClusterLister implements gke.ClusterLister. Err fails every project,
+// and ProjectErrs the given ones.
+type MockClusterLister struct {
+	mu          sync.Mutex
+	Clusters    []gke.ClusterInfo
+	Err         error
+	ProjectErrs map[string]error
+	Calls       int
+}
+
+func (m *MockClusterLister) ListClusters(ctx context.Context, projectIDs []string) ([]gke.ClusterInfo, []gke.ProjectError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Calls++
+	var clusters []gke.ClusterInfo
+	var errs []gke.ProjectError
+	for _, pid := range projectIDs {
+		err := m.Err
+		if err == nil {
+			err = m.ProjectErrs[pid]
+		}
+		if err != nil {
+			errs = append(errs, gke.ProjectError{ProjectID: pid, Error: err.Error()})
+			continue
+		}
+		for _, c := range m.Clusters {
+			if c.ProjectID == pid {
+				clusters = append(clusters, c)
+			}
+		}
+	}
+	return clusters, errs
+}
+
+func (m *MockClusterLister) calls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Calls
+}
+
+func TestInventory_CachesAndLooksUp(t *testing.T) {
+	lister := &MockClusterLister{Clusters: []gke.ClusterInfo{
+		{Name: "ai-auto-cluster", Location: "us-central1", ProjectID: "mslarkin-ext", Endpoint: "10.0.0.1"},
+		{Name: "demo", Location: "us-west1", ProjectID: "mslarkin-demo", Endpoint: "10.0.0.2"},
+		{Name: "other", Location: "us-east1", ProjectID: "other-project", Endpoint: "10.0.0.3"},
+	}}
+	inv := gke.NewInventory(lister, []string{"mslarkin-ext", "mslarkin-demo"}, time.Hour)
+	ctx := context.Background()
+
+	snap, err := inv.Clusters(ctx)
+	if err != nil {
+		t.Fatalf("Clusters() error = %v", err)
+	}
+	if len(snap.Clusters) != 2 || snap.RefreshedAt.IsZero() {
+		t.Errorf("snapshot = %+v, want 2 clusters", snap)
+	}
+
+	c, err := inv.Lookup(ctx, "mslarkin-demo", "us-west1", "demo")
+	if err != nil || c.Endpoint != "10.0.0.2" {
+		t.Errorf("Lookup(demo) = %+v, %v", c, err)
+	}
+	if lister.calls() != 1 {
+		t.Errorf("lister called %d times, want 1 (cached)", lister.calls())
+	}
+
+	// A fresh inventory doesn't refresh on a miss.
+	if _, err := inv.Lookup(ctx, "mslarkin-demo", "us-west1", "missing"); !errors.Is(err, gke.ErrClusterNotFound) {
+		t.Errorf("Lookup(missing) error = %v, want ErrClusterNotFound", err)
+	}
+	if lister.calls() != 1 {
+		t.Errorf("lister called %d times after a miss, want 1", lister.calls())
+	}
+
+	// Clusters of untracked projects are not found, nor listed.
+	if _, err := inv.Lookup(ctx, "other-project", "us-east1", "other"); !errors.Is(err, gke.ErrClusterNotFound) {
+		t.Errorf("Lookup(other) error = %v, want ErrClusterNotFound", err)
+	}
+	if lister.calls() != 1 {
+		t.Errorf("lister called %d times after an untracked lookup, want 1", lister.calls())
+	}
+}
+
+func TestInventory_RefreshKeepsClustersOnError(t *testing.T) {
+	lister := &MockClusterLister{Clusters: []gke.ClusterInfo{
+		{Name: "ai-auto-cluster", Location: "us-central1", ProjectID: "mslarkin-ext"},
+	}}
+	inv := gke.NewInventory(lister, []string{"mslarkin-ext"}, time.Hour)
+	ctx := context.Background()
+	if err := inv.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	refreshedAt := inv.Snapshot().RefreshedAt
+
+	lister.mu.Lock()
+	lister.Err = errors.New("quota exceeded")
+	lister.mu.Unlock()
+	if err := inv.Refresh(ctx); err == nil {
+		t.Fatal("Refresh() error = nil")
+	}
+	snap := inv.Snapshot()
+	if len(snap.Clusters) != 1 || snap.Err == nil || !snap.RefreshedAt.Equal(refreshedAt) {
+		t.Errorf("snapshot after failed refresh = %+v, want the previous clusters and the error", snap)
+	}
+}
+
+func TestInventory_RunRefreshesInBackground(t *testing.T) {
+	lister := &MockClusterLister{}
+	inv := gke.NewInventory(lister, []string{"mslarkin-ext"}, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		inv.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for lister.calls() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if lister.calls() < 3 {
+		t.Errorf("lister called %d times, want at least 3 background refreshes", lister.calls())
+	}
+}
+
+func TestInventory_PartialFailure(t *testing.T) {
+	lister := &MockClusterLister{Clusters: []gke.ClusterInfo{
+		{Name: "ext", Location: "us-central1", ProjectID: "mslarkin-ext"},
+		{Name: "demo", Location: "us-west1", ProjectID: "mslarkin-demo"},
+	}}
+	inv := gke.NewInventory(lister, []string{"mslarkin-ext", "mslarkin-demo"}, time.Hour)
+	ctx := context.Background()
+	if err := inv.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	// A project that fails keeps its clusters and is reported; the others
+	// are refreshed.
+	lister.mu.Lock()
+	lister.ProjectErrs = map[string]error{"mslarkin-demo": errors.New("permission denied")}
+	lister.Clusters = append(lister.Clusters, gke.ClusterInfo{Name: "ext-2", Location: "us-east1", ProjectID: "mslarkin-ext"})
+	lister.mu.Unlock()
+	if err := inv.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() with a failing project error = %v", err)
+	}
+	snap := inv.Snapshot()
+	if len(snap.Clusters) != 3 || snap.Err != nil {
+		t.Errorf("snapshot = %+v, want the clusters of both projects", snap)
+	}
+	if len(snap.ProjectErrors) != 1 || snap.ProjectErrors[0].ProjectID != "mslarkin-demo" {
+		t.Errorf("ProjectErrors = %+v, want mslarkin-demo", snap.ProjectErrors)
+	}
+
+	// Every project failing fails the refresh.
+	lister.mu.Lock()
+	lister.Err = errors.New("unavailable")
+	lister.mu.Unlock()
+	if err := inv.Refresh(ctx); err == nil {
+		t.Fatal("expected an error when every project fails")
+	}
+	if snap := inv.Snapshot(); len(snap.Clusters) != 3 || len(snap.ProjectErrors) != 2 {
+		t.Errorf("snapshot = %+v, want the previous clusters and both project errors", snap)
+	}
+
+	// Recovery clears the project errors.
+	lister.mu.Lock()
+	lister.Err = nil
+	lister.ProjectErrs = nil
+	lister.mu.Unlock()
+	if err := inv.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if snap := inv.Snapshot(); len(snap.ProjectErrors) != 0 || snap.Err != nil {
+		t.Errorf("snapshot = %+v, want no errors", snap)
+	}
+}
+
+func TestInventory_ClustersBacksOffAfterFailure(t *testing.T) {
+	lister := &MockClusterLister{
+		Clusters: []gke.ClusterInfo{{Name: "ext", Location: "us-central1", ProjectID: "mslarkin-ext"}},
+		Err:      errors.New("unavailable"),
+	}
+	inv := gke.NewInventory(lister, []string{"mslarkin-ext"}, time.Hour)
+	ctx := context.Background()
+
+	// Requests before the first successful refresh list the clusters, but
+	// not again right after a failure.
+	for i := 0; i < 3; i++ {
+		if _, err := inv.Clusters(ctx); err == nil {
+			t.Fatal("Clusters() error = nil")
+		}
+	}
+	if lister.calls() != 1 {
+		t.Errorf("lister called %d times, want 1 within the backoff", lister.calls())
+	}
+
+	// A forced refresh ignores the backoff.
+	lister.mu.Lock()
+	lister.Err = nil
+	lister.mu.Unlock()
+	if err := inv.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if snap, err := inv.Clusters(ctx); err != nil || len(snap.Clusters) != 1 {
+		t.Errorf("Clusters() = %+v, %v", snap, err)
+	}
+}
+
+// fakeGKE implements gkeutils.ClusterLister, answering with the clusters of
+// the project of the request.
+type fakeGKE struct {
+	responses map[string]*containerpb.ListClustersResponse
+	errs      map[string]error
+}
+
+func (f *fakeGKE) ListClusters(_ context.Context, req *containerpb.ListClustersRequest, _ ...gax.CallOption) (*containerpb.ListClustersResponse, error) {
+	project := strings.Split(req.Parent, "/")[1]
+	if err := f.errs[project]; err != nil {
+		return nil, err
+	}
+	if resp, ok := f.responses[project]; ok {
+		return resp, nil
+	}
+	return &containerpb.ListClustersResponse{}, nil
+}
+
+func TestDiscoveryClient_ListClusters(t *testing.T) {
+	discovery := gke.NewDiscoveryClientWithLister(&fakeGKE{
+		responses: map[string]*containerpb.ListClustersResponse{
+			"mslarkin-ext": {Clusters: []*containerpb.Cluster{{
+				Name:       "ai-auto-cluster",
+				Location:   "us-central1",
+				Endpoint:   "10.0.0.1",
+				Status:     containerpb.Cluster_RUNNING,
+				MasterAuth: &containerpb.MasterAuth{ClusterCaCertificate: "Y2E="},
+			}}},
+			// A project listed only partly fails.
+			"partial": {
+				Clusters:     []*containerpb.Cluster{{Name: "half", Location: "us-east1"}},
+				MissingZones: []string{"us-west1-a"},
+			},
+		},
+		errs: map[string]error{"mslarkin-demo": errors.New("permission denied")},
+	})
+
+	clusters, projectErrs := discovery.ListClusters(context.Background(), []string{"mslarkin-demo", "mslarkin-ext", "partial"})
+	want := gke.ClusterInfo{Name: "ai-auto-cluster", Location: "us-central1", Endpoint: "10.0.0.1", ProjectID: "mslarkin-ext", Status: "RUNNING", CaCert: "Y2E="}
+	if len(clusters) != 1 || clusters[0] != want {
+		t.Errorf("clusters = %+v, want %+v", clusters, want)
+	}
+	if len(projectErrs) != 2 || projectErrs[0].ProjectID != "mslarkin-demo" || projectErrs[1].ProjectID != "partial" {
+		t.Errorf("project errors = %+v, want mslarkin-demo and partial in order", projectErrs)
+	}
+}
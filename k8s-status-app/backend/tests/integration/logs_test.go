@@ -0,0 +1,250 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	gke "k8s-status-backend/pkg/gke"
+	status "k8s-status-backend/pkg/status"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+// logPod returns a pod of the frontend ReplicaSet with the given containers.
+func logPod(name string, containers ...string) *corev1.Pod {
+	p := pod("default", name, "ReplicaSet", "frontend-abc", nil)
+	for _, c := range containers {
+		p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: c})
+	}
+	return p
+}
+
+func logObjects() []runtime.Object {
+	withDefault := logPod("frontend-abc-2", "istio-proxy", "server")
+	withDefault.Annotations = map[string]string{"kubectl.kubernetes.io/default-container": "server"}
+	return []runtime.Object{
+		deployment("default", "frontend", 3, 3),
+		&appsv1.ReplicaSet{ObjectMeta: ownerMeta("default", "frontend-abc", "Deployment", "frontend")},
+		logPod("frontend-abc-3", "server"),
+		logPod("frontend-abc-1", "server", "sidecar"),
+		withDefault,
+	}
+}
+
+// limitedLogsClients serves log for every container and, unlike the fake
+// clientset, ends it at the requested LimitBytes as the API server does.
+type limitedLogsClients struct {
+	client *fake.Clientset
+	log    string
+}
+
+func (c limitedLogsClients) GetClient(ctx context.Context, cluster gke.ClusterInfo) (kubernetes.Interface, error) {
+	return limitedLogsClientset{c.client, c.log}, nil
+}
+
+type limitedLogsClientset struct {
+	*fake.Clientset
+	log string
+}
+
+func (c limitedLogsClientset) CoreV1() corev1client.CoreV1Interface {
+	return limitedLogsCoreV1{c.Clientset.CoreV1(), c.log}
+}
+
+type limitedLogsCoreV1 struct {
+	corev1client.CoreV1Interface
+	log string
+}
+
+func (c limitedLogsCoreV1) Pods(namespace string) corev1client.PodInterface {
+	return limitedLogsPods{c.CoreV1Interface.Pods(namespace), namespace, c.log}
+}
+
+type limitedLogsPods struct {
+	corev1client.PodInterface
+	namespace string
+	log       string
+}
+
+func (p limitedLogsPods) GetLogs(name string, opts *corev1.PodLogOptions) *restclient.Request {
+	log := p.log
+	if opts.LimitBytes != nil && int(*opts.LimitBytes) < len(log) {
+		log = log[:*opts.LimitBytes]
+	}
+	client := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(log))}, nil
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		VersionedAPIPath:     fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", p.namespace, name),
+	}
+	return client.Request()
+}
+
+// collectLogs returns the lines StreamLogs emits and its error.
+func collectLogs(t *testing.T, opts status.LogOptions) ([]status.LogLine, error) {
+	t.Helper()
+	client := fake.NewSimpleClientset(logObjects()...)
+	aggregator := status.NewAggregator(FakeClients{"c": client})
+	var mu sync.Mutex
+	var lines []status.LogLine
+	err := aggregator.StreamLogs(context.Background(), gke.ClusterInfo{Name: "c"}, "default", opts, func(l status.LogLine) error {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, l)
+		return nil
+	})
+	return lines, err
+}
+
+func TestAggregator_StreamLogs_Pod(t *testing.T) {
+	lines, err := collectLogs(t, status.LogOptions{Pod: "frontend-abc-2", Previous: true, TailLines: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The fake clientset serves "fake logs" for every container.
+	if len(lines) != 1 || lines[0].Line != "fake logs" || lines[0].Pod != "frontend-abc-2" {
+		t.Fatalf("unexpected lines: %+v", lines)
+	}
+	if lines[0].Container != "server" {
+		t.Errorf("expected the annotated default container, got %q", lines[0].Container)
+	}
+
+	if _, err := collectLogs(t, status.LogOptions{Pod: "frontend-abc-1", Container: "missing"}); err == nil {
+		t.Error("expected an error for a missing container")
+	}
+	if _, err := collectLogs(t, status.LogOptions{Pod: "missing"}); err == nil {
+		t.Error("expected an error for a missing pod")
+	}
+}
+
+func TestAggregator_StreamLogs_Workload(t *testing.T) {
+	lines, err := collectLogs(t, status.LogOptions{Kind: "Deployment", Workload: "frontend"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var pods []string
+	for _, l := range lines {
+		pods = append(pods, l.Pod+"/"+l.Container)
+	}
+	want := []string{"frontend-abc-1/server", "frontend-abc-2/server", "frontend-abc-3/server"}
+	if len(pods) != len(want) {
+		t.Fatalf("expected lines of %v, got %v", want, pods)
+	}
+	for i := range want {
+		if pods[i] != want[i] {
+			t.Fatalf("expected lines of %v in order, got %v", want, pods)
+		}
+	}
+
+	// A container only some pods have fails the others with an error line.
+	lines, err = collectLogs(t, status.LogOptions{Kind: "Deployment", Workload: "frontend", Container: "sidecar"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	errs := 0
+	for _, l := range lines {
+		if l.Error != "" {
+			errs++
+		}
+	}
+	if len(lines) != 3 || errs != 2 {
+		t.Errorf("expected 1 line and 2 errors, got %+v", lines)
+	}
+}
+
+func TestAggregator_StreamLogs_Limit(t *testing.T) {
+	lines, err := collectLogs(t, status.LogOptions{Kind: "Deployment", Workload: "frontend", MaxLines: 2})
+	if !errors.Is(err, status.ErrLogLimit) {
+		t.Fatalf("expected ErrLogLimit, got %v", err)
+	}
+	if len(lines) != 2 {
+		t.Errorf("expected 2 lines, got %d", len(lines))
+	}
+
+	_, err = collectLogs(t, status.LogOptions{Pod: "frontend-abc-1", MaxBytes: 4})
+	if !errors.Is(err, status.ErrLogLimit) {
+		t.Errorf("expected ErrLogLimit for the byte limit, got %v", err)
+	}
+}
+
+func TestAggregator_StreamLogs_ByteLimit(t *testing.T) {
+	stream := func(log string, opts status.LogOptions) ([]status.LogLine, error) {
+		client := fake.NewSimpleClientset(logObjects()...)
+		aggregator := status.NewAggregator(limitedLogsClients{client, log})
+		var lines []status.LogLine
+		err := aggregator.StreamLogs(context.Background(), gke.ClusterInfo{Name: "c"}, "default", opts, func(l status.LogLine) error {
+			lines = append(lines, l)
+			return nil
+		})
+		return lines, err
+	}
+
+	// The limit ends the stream in the middle of "second".
+	lines, err := stream("first\nsecond\nthird\n", status.LogOptions{Pod: "frontend-abc-1", MaxBytes: 9})
+	if !errors.Is(err, status.ErrLogLimit) {
+		t.Fatalf("expected ErrLogLimit, got %v", err)
+	}
+	if len(lines) != 2 || lines[0].Line != "first" || lines[0].Truncated || lines[1].Line != "sec" || !lines[1].Truncated {
+		t.Errorf("expected \"first\" and a truncated \"sec\", got %+v", lines)
+	}
+
+	// A log that fits the limit exactly is not cut.
+	lines, err = stream("first\nsecond\nthird\n", status.LogOptions{Pod: "frontend-abc-1", MaxBytes: 19})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(lines) != 3 || lines[2].Line != "third" || lines[2].Truncated {
+		t.Errorf("expected the whole log, got %+v", lines)
+	}
+
+	// The limit is shared by the pods of a workload.
+	lines, err = stream("first\nsecond\nthird\n", status.LogOptions{Kind: "Deployment", Workload: "frontend", MaxBytes: 25})
+	if !errors.Is(err, status.ErrLogLimit) {
+		t.Fatalf("expected ErrLogLimit, got %v", err)
+	}
+	if last := lines[len(lines)-1]; len(lines) != 4 || last.Pod != "frontend-abc-2" || last.Line != "first" || last.Truncated {
+		t.Errorf("expected the first pod's log and one line of the second, got %+v", lines)
+	}
+
+	// A line cut to MaxLogLineBytes counts all the bytes the API server
+	// sent for it, leaving 6 for the second pod.
+	long := strings.Repeat("x", status.MaxLogLineBytes+100) + "\n"
+	lines, err = stream(long, status.LogOptions{Kind: "Deployment", Workload: "frontend", MaxBytes: len(long) + 6})
+	if !errors.Is(err, status.ErrLogLimit) {
+		t.Fatalf("expected ErrLogLimit, got %v", err)
+	}
+	if len(lines) != 2 || len(lines[0].Line) != status.MaxLogLineBytes || !lines[0].Truncated || lines[1].Line != "xxxxxx" || !lines[1].Truncated {
+		t.Errorf("expected a truncated line of each pod, the second cut to 6 bytes, got %d lines", len(lines))
+	}
+}
+
+func TestAggregator_StreamLogs_Follow(t *testing.T) {
+	lines, err := collectLogs(t, status.LogOptions{Kind: "Deployment", Workload: "frontend", Follow: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var pods []string
+	for _, l := range lines {
+		pods = append(pods, l.Pod)
+	}
+	sort.Strings(pods)
+	if len(pods) != 3 || pods[0] != "frontend-abc-1" || pods[2] != "frontend-abc-3" {
+		t.Errorf("expected a line of every pod, got %v", pods)
+	}
+}
@@ -0,0 +1,205 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	"k8s-status-backend/pkg/scope"
+	status "k8s-status-backend/pkg/status"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const scopeFile = `
+projects: [mslarkin-ext]
+namespaces:
+  exclude: ["kube-*", "gke-*"]
+labelSelector: tier in (frontend,backend)
+clusters:
+  - match: mslarkin-demo/*/*
+    namespaces: {include: ["shop-*"]}
+`
+
+func TestScope_Load(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "scope.yaml")
+	if err := os.WriteFile(file, []byte(scopeFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SCOPE_CONFIG", file)
+	t.Setenv("SCOPE_PROJECT_LABELS", "env=demo, team=k8s")
+
+	cfg, err := scope.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.Projects) != 1 || cfg.Projects[0] != "mslarkin-ext" {
+		t.Errorf("Projects = %v", cfg.Projects)
+	}
+	if !cfg.Discovery.Enabled() || cfg.Discovery.Labels["team"] != "k8s" {
+		t.Errorf("Discovery = %+v, want labels from the environment", cfg.Discovery)
+	}
+
+	ext := cfg.Filter(gke.ClusterInfo{ProjectID: "mslarkin-ext", Location: "us-central1", Name: "a"})
+	if ext.IncludesNamespace("gke-managed-system") || !ext.IncludesNamespace("default") {
+		t.Errorf("unexpected namespaces in scope of mslarkin-ext: %+v", ext.Namespaces)
+	}
+	demo := cfg.Filter(gke.ClusterInfo{ProjectID: "mslarkin-demo", Location: "us-west1", Name: "b"})
+	if demo.IncludesNamespace("default") || !demo.IncludesNamespace("shop-eu") {
+		t.Errorf("expected the cluster override, got %+v", demo.Namespaces)
+	}
+	if demo.LabelSelector != "tier in (frontend,backend)" {
+		t.Errorf("expected the global label selector, got %q", demo.LabelSelector)
+	}
+}
+
+func TestScope_LoadDefaultsAndErrors(t *testing.T) {
+	cfg, err := scope.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.Projects) != len(scope.DefaultProjects) {
+		t.Errorf("Projects = %v, want the defaults", cfg.Projects)
+	}
+	if cfg.Filter(gke.ClusterInfo{}).IncludesNamespace("kube-system") {
+		t.Error("kube-system is in scope by default")
+	}
+
+	t.Setenv("SCOPE_NAMESPACES_EXCLUDE", "[")
+	if _, err := scope.Load(); err == nil {
+		t.Error("expected an error for an invalid glob")
+	}
+	t.Setenv("SCOPE_NAMESPACES_EXCLUDE", "")
+	t.Setenv("SCOPE_LABEL_SELECTOR", "a in (")
+	if _, err := scope.Load(); err == nil {
+		t.Error("expected an error for an invalid label selector")
+	}
+}
+
+func TestAggregator_Scope(t *testing.T) {
+	labeled := deployment("shop", "frontend", 1, 1)
+	labeled.Labels = map[string]string{"tier": "frontend"}
+	client := fake.NewSimpleClientset(
+		readyNode("n1"),
+		labeled,
+		deployment("shop", "unlabeled", 1, 1),
+		deployment("other", "frontend", 1, 1),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+	)
+	cfg := &scope.Config{
+		Projects:      []string{"p"},
+		Namespaces:    scope.NamespaceFilter{Exclude: []string{"kube-*", "other"}},
+		LabelSelector: "tier=frontend",
+	}
+	aggregator := status.NewAggregatorWithScope(FakeClients{"c": client}, cfg)
+	ctx := context.Background()
+
+	cs := aggregator.GetClusterStatus(ctx, gke.ClusterInfo{Name: "c"})
+	if len(cs.Workloads) != 1 || cs.Workloads[0].Namespace != "shop" || cs.Workloads[0].Name != "frontend" {
+		t.Errorf("expected only shop/frontend, got %+v", cs.Workloads)
+	}
+
+	scopes := aggregator.ClusterScopes(ctx, []gke.ClusterInfo{{Name: "c"}})
+	if len(scopes) != 1 || len(scopes[0].Namespaces) != 1 || scopes[0].Namespaces[0] != "shop" {
+		t.Errorf("expected namespace shop in scope, got %+v", scopes)
+	}
+}
+
+func TestWatcher_ScopeRelabel(t *testing.T) {
+	labeled := deployment("default", "frontend", 1, 1)
+	labeled.Labels = map[string]string{"tier": "frontend"}
+	client := fake.NewSimpleClientset(readyNode("n1"), labeled)
+	cfg := scope.Default()
+	cfg.LabelSelector = "tier=frontend"
+	watcher := status.NewWatcherWithScope(FakeClients{"c": client}, cfg)
+	defer watcher.Close()
+	events, unsubscribe := watcher.Subscribe()
+	defer unsubscribe()
+
+	ctx := context.Background()
+	if cs := watcher.Status(ctx, []gke.ClusterInfo{{Name: "c"}}); len(cs[0].Workloads) != 1 {
+		t.Fatalf("expected 1 workload, got %+v", cs[0].Workloads)
+	}
+
+	// Relabeling the deployment takes it out of scope.
+	labeled.Labels = map[string]string{"tier": "backend"}
+	if _, err := client.AppsV1().Deployments("default").Update(ctx, labeled, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	e := nextEvent(t, events, func(e status.StatusEvent) bool { return e.Type == status.EventWorkloadDeleted })
+	if e.Workload.Name != "frontend" {
+		t.Errorf("unexpected deleted workload: %+v", e.Workload)
+	}
+}
+
+// fakeProjects implements gke.ProjectSource.
+type fakeProjects struct {
+	projects []string
+	err      error
+}
+
+func (f *fakeProjects) ListProjects(context.Context) ([]string, error) {
+	return f.projects, f.err
+}
+
+func TestInventory_ProjectSource(t *testing.T) {
+	lister := &MockClusterLister{Clusters: []gke.ClusterInfo{
+		{Name: "a", Location: "us-central1", ProjectID: "found-1"},
+		{Name: "b", Location: "us-west1", ProjectID: "found-2"},
+	}}
+	source := &fakeProjects{projects: []string{"found-1"}}
+	inv := gke.NewInventoryFromSource(lister, source, time.Hour)
+	ctx := context.Background()
+
+	if err := inv.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if snap := inv.Snapshot(); len(snap.Clusters) != 1 || snap.Clusters[0].Name != "a" {
+		t.Errorf("snapshot = %+v, want cluster a", snap)
+	}
+
+	// Projects are listed again on every refresh.
+	source.projects = []string{"found-1", "found-2"}
+	if err := inv.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got := inv.Projects(); len(got) != 2 {
+		t.Errorf("Projects() = %v, want both", got)
+	}
+
+	// A failed project listing keeps the previous projects and clusters.
+	source.err = errors.New("permission denied")
+	if err := inv.Refresh(ctx); err == nil {
+		t.Fatal("expected the project listing error")
+	}
+	if snap := inv.Snapshot(); len(snap.Clusters) != 2 || snap.Err == nil || len(inv.Projects()) != 2 {
+		t.Errorf("snapshot = %+v, want the previous clusters and the error", snap)
+	}
+}
+
+func TestInventory_LookupFromProjectSource(t *testing.T) {
+	lister := &MockClusterLister{Clusters: []gke.ClusterInfo{
+		{Name: "a", Location: "us-central1", ProjectID: "found-1"},
+		{Name: "b", Location: "us-west1", ProjectID: "found-2"},
+	}}
+	source := &fakeProjects{projects: []string{"found-1"}}
+	inv := gke.NewInventoryFromSource(lister, source, time.Hour)
+	ctx := context.Background()
+
+	// The first lookup lists the projects before checking them.
+	c, err := inv.Lookup(ctx, "found-1", "us-central1", "a")
+	if err != nil || c.Name != "a" {
+		t.Errorf("Lookup(a) = %+v, %v; want cluster a", c, err)
+	}
+	if _, err := inv.Lookup(ctx, "found-2", "us-west1", "b"); !errors.Is(err, gke.ErrClusterNotFound) {
+		t.Errorf("Lookup(b) error = %v, want ErrClusterNotFound for an untracked project", err)
+	}
+}
@@ -0,0 +1,204 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	"k8s-status-backend/pkg/k8s"
+	"k8s-status-backend/pkg/scope"
+	"k8s-status-backend/pkg/source"
+	status "k8s-status-backend/pkg/status"
+
+	"golang.org/x/oauth2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeSource implements source.ClusterSource with fake clientsets.
+type fakeSource struct {
+	FakeClients
+	clusters []gke.ClusterInfo
+}
+
+var _ source.ClusterSource = (*fakeSource)(nil)
+
+func (f *fakeSource) Clusters(context.Context) (gke.InventorySnapshot, error) {
+	return f.Snapshot(), nil
+}
+
+func (f *fakeSource) Snapshot() gke.InventorySnapshot {
+	return gke.InventorySnapshot{Projects: []string{"fake"}, Clusters: f.clusters, RefreshedAt: eventBase}
+}
+
+func (f *fakeSource) Refresh(context.Context) error { return nil }
+
+func (f *fakeSource) Lookup(_ context.Context, project, location, name string) (gke.ClusterInfo, error) {
+	for _, c := range f.clusters {
+		if c.ProjectID == project && c.Location == location && c.Name == name {
+			return c, nil
+		}
+	}
+	return gke.ClusterInfo{}, gke.ErrClusterNotFound
+}
+
+func TestAggregator_ClusterSource(t *testing.T) {
+	src := &fakeSource{
+		FakeClients: FakeClients{
+			"a": fake.NewSimpleClientset(readyNode("n1"), deployment("default", "frontend", 2, 2)),
+			"b": fake.NewSimpleClientset(readyNode("n1"), readyNode("n2"), deployment("shop", "cart", 1, 0)),
+		},
+		clusters: []gke.ClusterInfo{
+			{Name: "a", Location: "local", ProjectID: "fake"},
+			{Name: "b", Location: "local", ProjectID: "fake"},
+		},
+	}
+	aggregator := status.NewAggregatorWithScope(src, scope.Default())
+	ctx := context.Background()
+
+	snap, err := src.Clusters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := aggregator.FetchAll(ctx, snap.Clusters)
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 clusters, got %d", len(statuses))
+	}
+	if s := statuses[0]; s.ClusterName != "a" || s.NodesReady != 1 || len(s.Workloads) != 1 || s.Workloads[0].Status != "Healthy" {
+		t.Errorf("unexpected status of a: %+v", s)
+	}
+	if s := statuses[1]; s.ClusterName != "b" || s.NodesReady != 2 || len(s.Workloads) != 1 || s.Workloads[0].Status != "Degraded" {
+		t.Errorf("unexpected status of b: %+v", s)
+	}
+
+	cluster, err := src.Lookup(ctx, "fake", "local", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aggregator.GetWorkloadPods(ctx, cluster, "shop", "Deployment", "cart"); err != nil {
+		t.Errorf("GetWorkloadPods() error = %v", err)
+	}
+}
+
+const kubeconfigTemplate = `apiVersion: v1
+kind: Config
+clusters:
+- name: kind
+  cluster:
+    server: https://%s
+    certificate-authority-data: %s
+users:
+- name: kind
+  user:
+    token: kind-token
+contexts:
+- name: kind-kind
+  context: {cluster: kind, user: kind}
+- name: broken
+  context: {cluster: missing, user: kind}
+current-context: kind-kind
+`
+
+func TestKubeconfigSource(t *testing.T) {
+	cluster, tokens := newTestCluster(t, "kind")
+	path := filepath.Join(t.TempDir(), "config")
+	config := fmt.Sprintf(kubeconfigTemplate, cluster.Endpoint, cluster.CaCert)
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	src := source.NewKubeconfig(path, k8s.RateLimit{})
+	ctx := context.Background()
+
+	snap, err := src.Clusters(ctx)
+	if err != nil {
+		t.Fatalf("Clusters() error = %v", err)
+	}
+	if len(snap.Clusters) != 2 || snap.Clusters[0].Name != "broken" || snap.Clusters[1].Name != "kind-kind" {
+		t.Fatalf("expected a cluster per context, got %+v", snap.Clusters)
+	}
+	if len(snap.Projects) != 1 || snap.Projects[0] != source.KubeconfigProject {
+		t.Errorf("Projects = %v", snap.Projects)
+	}
+
+	kind, err := src.Lookup(ctx, source.KubeconfigProject, source.KubeconfigLocation, "kind-kind")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if kind.Endpoint != cluster.Endpoint {
+		t.Errorf("Endpoint = %q, want %q", kind.Endpoint, cluster.Endpoint)
+	}
+	client, err := src.GetClient(ctx, kind)
+	if err != nil {
+		t.Fatalf("GetClient() error = %v", err)
+	}
+	if _, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{}); err != nil {
+		t.Fatalf("listing nodes failed: %v", err)
+	}
+	if len(*tokens) != 1 || (*tokens)[0] != "Bearer kind-token" {
+		t.Errorf("expected the context's token, got %v", *tokens)
+	}
+	if again, _ := src.GetClient(ctx, kind); again != client {
+		t.Error("expected the client to be reused")
+	}
+
+	// A broken context fails its cluster only.
+	broken, _ := src.Lookup(ctx, source.KubeconfigProject, source.KubeconfigLocation, "broken")
+	if _, err := src.GetClient(ctx, broken); err == nil {
+		t.Error("expected an error for the broken context")
+	}
+	if _, err := src.Lookup(ctx, source.KubeconfigProject, source.KubeconfigLocation, "missing"); !errors.Is(err, gke.ErrClusterNotFound) {
+		t.Errorf("Lookup(missing) error = %v, want ErrClusterNotFound", err)
+	}
+
+	// Refresh reads the kubeconfig again, keeping it on failure.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Refresh(ctx); err == nil {
+		t.Error("expected an error for a missing kubeconfig")
+	}
+	if snap := src.Snapshot(); len(snap.Clusters) != 2 || snap.Err == nil {
+		t.Errorf("snapshot = %+v, want the previous clusters and the error", snap)
+	}
+}
+
+func TestInClusterSource_OutsideCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	src := source.NewInCluster(gke.ClusterInfo{Name: "dev"}, k8s.RateLimit{})
+	if _, err := src.Clusters(context.Background()); err == nil {
+		t.Fatal("expected an error outside a cluster")
+	}
+	if _, err := src.GetClient(context.Background(), gke.ClusterInfo{Name: "dev"}); err == nil {
+		t.Error("expected an error outside a cluster")
+	}
+}
+
+func TestGKESource(t *testing.T) {
+	cluster, _ := newTestCluster(t, "gke")
+	lister := &MockClusterLister{Clusters: []gke.ClusterInfo{cluster}}
+	inv := gke.NewInventory(lister, []string{cluster.ProjectID}, time.Hour)
+	clients := k8s.NewClientManagerWithOptions(k8s.ClientOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"})})
+	defer clients.Close()
+	var src source.ClusterSource = source.NewGKE(inv, clients)
+	ctx := context.Background()
+
+	found, err := src.Lookup(ctx, cluster.ProjectID, cluster.Location, cluster.Name)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	client, err := src.GetClient(ctx, found)
+	if err != nil {
+		t.Fatalf("GetClient() error = %v", err)
+	}
+	if _, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{}); err != nil {
+		t.Errorf("listing nodes failed: %v", err)
+	}
+	if snap := src.Snapshot(); len(snap.Projects) != 1 || snap.Projects[0] != cluster.ProjectID {
+		t.Errorf("Projects = %v", snap.Projects)
+	}
+}
@@ -0,0 +1,230 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	status "k8s-status-backend/pkg/status"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// FakeClients implements status.ClientProvider with fake clientsets.
+type FakeClients map[string]*fake.Clientset
+
+func (f FakeClients) GetClient(ctx context.Context, cluster gke.ClusterInfo) (kubernetes.Interface, error) {
+	return f[cluster.Name], nil
+}
+
+func readyNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+		}},
+	}
+}
+
+func deployment(ns, name string, replicas, ready int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, CreationTimestamp: metav1.Now()},
+		Status:     appsv1.DeploymentStatus{Replicas: replicas, ReadyReplicas: ready},
+	}
+}
+
+// nextEvent waits for the next event matching want.
+func nextEvent(t *testing.T, events <-chan status.StatusEvent, want func(status.StatusEvent) bool) status.StatusEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("event channel closed")
+			}
+			if want(e) {
+				return e
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for event")
+		}
+	}
+}
+
+func TestWatcher_StatusAndEvents(t *testing.T) {
+	client := fake.NewSimpleClientset([]runtime.Object{
+		readyNode("node-1"),
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+		deployment("default", "frontend", 3, 3),
+		deployment("kube-system", "kube-dns", 2, 2),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+		},
+	}...)
+	watcher := status.NewWatcher(FakeClients{"ai-auto-cluster": client})
+	defer watcher.Close()
+	events, unsubscribe := watcher.Subscribe()
+	defer unsubscribe()
+
+	cluster := gke.ClusterInfo{Name: "ai-auto-cluster", Location: "us-central1", ProjectID: "mslarkin-ext"}
+	ctx := context.Background()
+	statuses := watcher.Status(ctx, []gke.ClusterInfo{cluster})
+	if len(statuses) != 1 {
+		t.Fatalf("got %d statuses, want 1", len(statuses))
+	}
+	s := statuses[0]
+	if s.Error != "" || s.NodeCount != 2 || s.NodesReady != 1 {
+		t.Errorf("status = %+v, want 2 nodes with 1 ready", s)
+	}
+	// kube-system is filtered out; workloads are ordered by namespace, kind and name.
+	if len(s.Workloads) != 2 || s.Workloads[0].Kind != "Deployment" || s.Workloads[1].Kind != "Service" || s.Workloads[0].Age == "" {
+		t.Errorf("workloads = %+v", s.Workloads)
+	}
+
+	// A scale-down shows up as an incremental event.
+	if _, err := client.AppsV1().Deployments("default").UpdateStatus(ctx, deployment("default", "frontend", 3, 1), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	e := nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventWorkloadUpdated && e.Workload.Kind == "Deployment" && e.Workload.Ready == 1
+	})
+	if e.ClusterName != "ai-auto-cluster" || e.Workload.Status != "Degraded" {
+		t.Errorf("event = %+v, workload = %+v", e, e.Workload)
+	}
+
+	if err := client.CoreV1().Services("default").Delete(ctx, "frontend", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventWorkloadDeleted && e.Workload.Kind == "Service"
+	})
+
+	if _, err := client.CoreV1().Nodes().Create(ctx, readyNode("node-3"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	e = nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventClusterUpdated && e.Cluster.NodeCount == 3
+	})
+	if e.Cluster.NodesReady != 2 || e.Cluster.Workloads != nil {
+		t.Errorf("cluster event = %+v", e.Cluster)
+	}
+
+	// The model reflects the changes.
+	s = watcher.Status(ctx, []gke.ClusterInfo{cluster})[0]
+	if s.NodeCount != 3 || len(s.Workloads) != 1 || s.Workloads[0].Ready != 1 {
+		t.Errorf("status after changes = %+v", s)
+	}
+}
+
+func TestWatcher_ClientError(t *testing.T) {
+	// A cluster without a fake clientset fails to watch.
+	watcher := status.NewWatcher(failingClients{})
+	defer watcher.Close()
+	s := watcher.Status(context.Background(), []gke.ClusterInfo{{Name: "broken"}})[0]
+	if s.Error == "" {
+		t.Errorf("status = %+v, want an error", s)
+	}
+}
+
+type failingClients struct{}
+
+func (failingClients) GetClient(ctx context.Context, cluster gke.ClusterInfo) (kubernetes.Interface, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func TestWatcher_PartialSync(t *testing.T) {
+	client := fake.NewSimpleClientset(readyNode("node-1"), deployment("default", "frontend", 1, 1))
+	// RBAC forbids listing events.
+	client.PrependReactor("list", "events", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "events"}, "", errors.New("rbac"))
+	})
+	watcher := status.NewWatcherWithOptions(FakeClients{"a": client}, status.WatcherOptions{SyncTimeout: 100 * time.Millisecond})
+	defer watcher.Close()
+
+	cluster := gke.ClusterInfo{Name: "a"}
+	s := watcher.Status(context.Background(), []gke.ClusterInfo{cluster})[0]
+	if s.NodeCount != 1 || len(s.Workloads) != 1 || !strings.Contains(s.Error, "Not synced: events") {
+		t.Errorf("status = %+v, want the synced resources and the events reported", s)
+	}
+
+	// Later requests don't wait for the informer again.
+	start := time.Now()
+	s = watcher.Status(context.Background(), []gke.ClusterInfo{cluster})[0]
+	if time.Since(start) > 50*time.Millisecond || !strings.Contains(s.Error, "Not synced: events") {
+		t.Errorf("second Status() took %v with error %q", time.Since(start), s.Error)
+	}
+}
+
+// flakyClients fails to create a client the first failures times.
+type flakyClients struct {
+	FakeClients
+	mu       sync.Mutex
+	failures int
+}
+
+func (f *flakyClients) GetClient(ctx context.Context, cluster gke.ClusterInfo) (kubernetes.Interface, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("no credentials")
+	}
+	return f.FakeClients.GetClient(ctx, cluster)
+}
+
+func TestWatcher_RetriesClient(t *testing.T) {
+	clients := &flakyClients{
+		FakeClients: FakeClients{"a": fake.NewSimpleClientset(readyNode("node-1"))},
+		failures:    2,
+	}
+	watcher := status.NewWatcherWithOptions(clients, status.WatcherOptions{RetryBackoff: 10 * time.Millisecond})
+	defer watcher.Close()
+	events, unsubscribe := watcher.Subscribe()
+	defer unsubscribe()
+
+	cluster := gke.ClusterInfo{Name: "a"}
+	if s := watcher.Status(context.Background(), []gke.ClusterInfo{cluster})[0]; !strings.Contains(s.Error, "Failed to create client") {
+		t.Errorf("status = %+v, want the client error", s)
+	}
+	// The watch recovers without another request.
+	nextEvent(t, events, func(e status.StatusEvent) bool {
+		return e.Type == status.EventClusterSynced && e.Cluster.Error == "" && e.Cluster.NodeCount == 1
+	})
+}
+
+func TestWatcher_InitialSyncEvent(t *testing.T) {
+	// More workloads than a subscriber may fall behind by.
+	var objects []runtime.Object
+	for i := 0; i < 300; i++ {
+		objects = append(objects, deployment("default", fmt.Sprintf("app-%d", i), 1, 1))
+	}
+	watcher := status.NewWatcher(FakeClients{"a": fake.NewSimpleClientset(objects...)})
+	defer watcher.Close()
+	events, unsubscribe := watcher.Subscribe()
+	defer unsubscribe()
+
+	watcher.Status(context.Background(), []gke.ClusterInfo{{Name: "a"}})
+	// The initial listing comes as one event rather than one per workload.
+	e := nextEvent(t, events, func(status.StatusEvent) bool { return true })
+	if e.Type != status.EventClusterSynced {
+		t.Fatalf("first event = %s, want %s", e.Type, status.EventClusterSynced)
+	}
+	if len(e.Cluster.Workloads) != 300 {
+		t.Errorf("synced event has %d workloads, want 300", len(e.Cluster.Workloads))
+	}
+}
@@ -0,0 +1,231 @@
+package integration
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	gke "k8s-status-backend/pkg/gke"
+	status "k8s-status-backend/pkg/status"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// ownerMeta returns object metadata controlled by the owner kind/name.
+func ownerMeta(ns, name, ownerKind, ownerName string) metav1.ObjectMeta {
+	controller := true
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: ns,
+		UID:       types.UID(ns + "/" + name),
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: ownerKind, Name: ownerName, Controller: &controller},
+		},
+	}
+}
+
+func pod(ns, name, ownerKind, ownerName string, labels map[string]string) *corev1.Pod {
+	meta := ownerMeta(ns, name, ownerKind, ownerName)
+	meta.Labels = labels
+	return &corev1.Pod{ObjectMeta: meta, Status: corev1.PodStatus{Phase: corev1.PodRunning}}
+}
+
+// workloadObjects is one workload of every supported kind in "default".
+func workloadObjects() []runtime.Object {
+	int32p := func(n int32) *int32 { return &n }
+	lastRun := metav1.NewTime(time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC))
+	return []runtime.Object{
+		deployment("default", "frontend", 2, 2),
+		&appsv1.ReplicaSet{ObjectMeta: ownerMeta("default", "frontend-abc", "Deployment", "frontend")},
+		pod("default", "frontend-abc-1", "ReplicaSet", "frontend-abc", map[string]string{"app": "frontend"}),
+		pod("default", "frontend-abc-2", "ReplicaSet", "frontend-abc", map[string]string{"app": "frontend"}),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+			Spec: corev1.ServiceSpec{
+				Type:     corev1.ServiceTypeClusterIP,
+				Selector: map[string]string{"app": "frontend"},
+			},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+			Status:     appsv1.StatefulSetStatus{Replicas: 3, ReadyReplicas: 2},
+		},
+		pod("default", "db-0", "StatefulSet", "db", nil),
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+			Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, NumberReady: 2},
+		},
+		pod("default", "agent-x", "DaemonSet", "agent", nil),
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "default"},
+			Spec:       batchv1.JobSpec{Completions: int32p(1)},
+			Status: batchv1.JobStatus{
+				Failed: 1,
+				Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+				},
+			},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "default"},
+			Spec:       batchv1.CronJobSpec{Schedule: "0 * * * *"},
+			Status:     batchv1.CronJobStatus{LastScheduleTime: &lastRun},
+		},
+		&batchv1.Job{ObjectMeta: ownerMeta("default", "report-123", "CronJob", "report")},
+		pod("default", "report-123-x", "Job", "report-123", nil),
+		&autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{Kind: "Deployment", Name: "frontend"},
+				MinReplicas:    int32p(1),
+				MaxReplicas:    2,
+			},
+			Status: autoscalingv2.HorizontalPodAutoscalerStatus{
+				CurrentReplicas: 2,
+				DesiredReplicas: 2,
+				Conditions: []autoscalingv2.HorizontalPodAutoscalerCondition{
+					{Type: autoscalingv2.ScalingLimited, Status: corev1.ConditionTrue, Reason: "TooManyReplicas"},
+				},
+			},
+		},
+	}
+}
+
+func TestAggregator_WorkloadKinds(t *testing.T) {
+	client := fake.NewSimpleClientset(workloadObjects()...)
+	aggregator := status.NewAggregator(FakeClients{"ai-auto-cluster": client})
+	cluster := gke.ClusterInfo{Name: "ai-auto-cluster", Location: "us-central1", ProjectID: "mslarkin-ext"}
+
+	cs := aggregator.GetClusterStatus(context.Background(), cluster)
+	if cs.Error != "" {
+		t.Fatalf("unexpected error: %s", cs.Error)
+	}
+	byKind := map[string]status.WorkloadStatus{}
+	for _, w := range cs.Workloads {
+		byKind[w.Kind] = w
+	}
+	// The CronJob's job is reported through the CronJob only.
+	if len(cs.Workloads) != 7 {
+		t.Fatalf("expected 7 workloads, got %d: %+v", len(cs.Workloads), cs.Workloads)
+	}
+
+	if w := byKind["StatefulSet"]; w.Status != "Degraded" || w.Ready != 2 || w.Desired != 3 {
+		t.Errorf("unexpected statefulset status: %+v", w)
+	}
+	if w := byKind["DaemonSet"]; w.Status != "Healthy" || w.Ready != 2 {
+		t.Errorf("unexpected daemonset status: %+v", w)
+	}
+	if w := byKind["Job"]; w.Name != "migrate" || w.Status != "Degraded" {
+		t.Errorf("unexpected job status: %+v", w)
+	}
+
+	cj := byKind["CronJob"]
+	if cj.LastRun != "2026-01-02T03:00:00Z" {
+		t.Errorf("expected last run 2026-01-02T03:00:00Z, got %q", cj.LastRun)
+	}
+	next, err := time.Parse(time.RFC3339, cj.NextRun)
+	if err != nil {
+		t.Fatalf("invalid next run %q: %v", cj.NextRun, err)
+	}
+	if next.Minute() != 0 || next.Second() != 0 || time.Until(next) > time.Hour {
+		t.Errorf("expected next run at the top of the next hour, got %s", next)
+	}
+
+	hpa := byKind["HorizontalPodAutoscaler"]
+	if hpa.MinReplicas != 1 || hpa.MaxReplicas != 2 || hpa.Ready != 2 || hpa.Desired != 2 {
+		t.Errorf("unexpected autoscaler replicas: %+v", hpa)
+	}
+	if hpa.ScalingLimited != "TooManyReplicas" || hpa.Status != "Degraded" {
+		t.Errorf("expected autoscaler limited at max, got %+v", hpa)
+	}
+}
+
+func TestAggregator_CronJobInvalidSchedule(t *testing.T) {
+	client := fake.NewSimpleClientset(&batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken", Namespace: "default"},
+		Spec:       batchv1.CronJobSpec{Schedule: "not a schedule"},
+	})
+	aggregator := status.NewAggregator(FakeClients{"c": client})
+
+	cs := aggregator.GetClusterStatus(context.Background(), gke.ClusterInfo{Name: "c"})
+	if len(cs.Workloads) != 1 || cs.Workloads[0].Status != "Degraded" || cs.Workloads[0].NextRun != "" {
+		t.Errorf("expected a degraded cronjob without next run, got %+v", cs.Workloads)
+	}
+}
+
+func TestAggregator_GetWorkloadPods(t *testing.T) {
+	client := fake.NewSimpleClientset(workloadObjects()...)
+	aggregator := status.NewAggregator(FakeClients{"ai-auto-cluster": client})
+	cluster := gke.ClusterInfo{Name: "ai-auto-cluster"}
+
+	tests := []struct {
+		kind, name string
+		want       []string
+	}{
+		{"Deployment", "frontend", []string{"frontend-abc-1", "frontend-abc-2"}},
+		{"ReplicaSet", "frontend-abc", []string{"frontend-abc-1", "frontend-abc-2"}},
+		{"Service", "frontend", []string{"frontend-abc-1", "frontend-abc-2"}},
+		{"HorizontalPodAutoscaler", "frontend", []string{"frontend-abc-1", "frontend-abc-2"}},
+		{"StatefulSet", "db", []string{"db-0"}},
+		{"DaemonSet", "agent", []string{"agent-x"}},
+		{"CronJob", "report", []string{"report-123-x"}},
+		{"Job", "report-123", []string{"report-123-x"}},
+		{"Job", "migrate", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind+"/"+tt.name, func(t *testing.T) {
+			pods, err := aggregator.GetWorkloadPods(context.Background(), cluster, "default", tt.kind, tt.name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, p := range pods {
+				names = append(names, p.Name)
+			}
+			sort.Strings(names)
+			if len(names) != len(tt.want) {
+				t.Fatalf("expected pods %v, got %v", tt.want, names)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Fatalf("expected pods %v, got %v", tt.want, names)
+				}
+			}
+		})
+	}
+
+	if _, err := aggregator.GetWorkloadPods(context.Background(), cluster, "default", "Service", "missing"); err == nil {
+		t.Error("expected an error for a missing service")
+	}
+}
+
+func TestWatcher_WorkloadKinds(t *testing.T) {
+	client := fake.NewSimpleClientset(workloadObjects()...)
+	watcher := status.NewWatcher(FakeClients{"ai-auto-cluster": client})
+	defer watcher.Close()
+
+	statuses := watcher.Status(context.Background(), []gke.ClusterInfo{{Name: "ai-auto-cluster"}})
+	if len(statuses) != 1 || statuses[0].Error != "" {
+		t.Fatalf("unexpected status: %+v", statuses)
+	}
+	kinds := map[string]bool{}
+	for _, w := range statuses[0].Workloads {
+		kinds[w.Kind] = true
+	}
+	for _, kind := range []string{"Deployment", "Service", "StatefulSet", "DaemonSet", "Job", "CronJob", "HorizontalPodAutoscaler"} {
+		if !kinds[kind] {
+			t.Errorf("expected a %s workload, got %+v", kind, statuses[0].Workloads)
+		}
+	}
+	if len(statuses[0].Workloads) != 7 {
+		t.Errorf("expected 7 workloads, got %d", len(statuses[0].Workloads))
+	}
+}
@@ -12,9 +12,23 @@ export default {
   getStatus() {
     return apiClient.get("/status");
   },
-  getPods(project, location, cluster, namespace, workload) {
+  getScope() {
+    return apiClient.get("/scope");
+  },
+  getPods(project, location, cluster, namespace, workload, kind) {
     return apiClient.get("/pods", {
-      params: { project, location, cluster, namespace, workload },
+      params: { project, location, cluster, namespace, workload, kind },
+    });
+  },
+  getEvents(project, location, cluster, namespace, name, kind) {
+    return apiClient.get("/events", {
+      params: { project, location, cluster, namespace, name, kind },
+    });
+  },
+  getLogs(project, location, cluster, namespace, pod, options = {}) {
+    return apiClient.get("/logs", {
+      params: { project, location, cluster, namespace, pod, ...options },
+      responseType: "text",
     });
   },
 };